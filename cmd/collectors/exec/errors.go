@@ -0,0 +1,10 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package exec
+
+const (
+	PARSE   = "parse command output"
+	COMMAND = "command failed"
+)
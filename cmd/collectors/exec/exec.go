@@ -0,0 +1,294 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package exec feeds the output of scripts and other commands into Harvest.
+// Each object of the collector runs a command on its schedule and parses
+// stdout in one of the formats "prometheus" (text exposition format),
+// "influx" (line protocol), "json" (one JSON object per line) or "keyvalue"
+// (key=value pairs, one instance per line). Samples with the same labels
+// are one instance.
+//
+// The exit code and runtime of the command are recorded in the collector
+// metadata. Output of commands that exit with non-zero code is still parsed,
+// so that checks can report partial results.
+package exec
+
+import (
+	"bytes"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"os"
+	osexec "os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// stderr of failed commands is logged up to this length
+const maxStderr = 512
+
+type Exec struct {
+	*collector.AbstractCollector
+	command     string
+	args        []string
+	env         []string
+	timeout     time.Duration
+	parse       parser
+	forceLabels map[string]bool
+}
+
+func init() {
+	plugin.RegisterModule(Exec{})
+}
+
+func (Exec) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.exec",
+		New: func() plugin.Module { return new(Exec) },
+	}
+}
+
+// Init - initialize the collector
+func (me *Exec) Init(a *collector.AbstractCollector) error {
+
+	var err error
+
+	me.AbstractCollector = a
+
+	if err = me.loadSubTemplate(); err != nil {
+		return err
+	}
+
+	if err = collector.Init(me); err != nil {
+		return err
+	}
+
+	if object := me.Params.GetChildContentS("object"); object != "" {
+		me.Matrix.Object = object
+	}
+
+	// command and arguments, either as one string or with args as list
+	fields := strings.Fields(me.Params.GetChildContentS("command"))
+	if len(fields) == 0 {
+		return errors.New(errors.MISSING_PARAM, "command")
+	}
+	me.command, me.args = fields[0], fields[1:]
+	if args := me.Params.GetChildS("args"); args != nil {
+		me.args = append(me.args, args.GetAllChildContentS()...)
+	}
+
+	// relative paths are relative to the harvest home, commands without path are looked up in PATH
+	if strings.Contains(me.command, "/") && !path.IsAbs(me.command) {
+		me.command = path.Join(me.Options.HomePath, me.command)
+	}
+
+	format := me.Params.GetChildContentS("format")
+	if format == "" {
+		format = Prometheus
+	}
+	if me.parse = parsers[format]; me.parse == nil {
+		return errors.New(errors.INVALID_PARAM, "format: "+format)
+	}
+
+	me.timeout = defaultTimeout
+	if x := me.Params.GetChildContentS("timeout"); x != "" {
+		if me.timeout, err = parseTimeout(x); err != nil {
+			return errors.New(errors.INVALID_PARAM, "timeout: "+x)
+		}
+	}
+
+	me.forceLabels = make(map[string]bool)
+	if labels := me.Params.GetChildS("labels"); labels != nil {
+		for _, label := range labels.GetAllChildContentS() {
+			me.forceLabels[label] = true
+		}
+	}
+
+	// the command can tell which poller runs it
+	me.env = append(os.Environ(),
+		"HARVEST_POLLER="+me.Options.Poller,
+		"HARVEST_ADDR="+me.Params.GetChildContentS("addr"),
+		"HARVEST_OBJECT="+me.Matrix.Object,
+	)
+
+	me.Metadata.NewMetricInt64("exit_code")
+	me.Metadata.NewMetricInt64("runtime")
+
+	me.Matrix.SetGlobalLabel("hostname", me.Options.Hostname)
+
+	me.Logger.Debug().Msgf("initialized command (%s) with args %v, format: %s, timeout: %s", me.command, me.args, format, me.timeout)
+	return nil
+}
+
+// loadSubTemplate - merge the subtemplate of our object into the parameters
+func (me *Exec) loadSubTemplate() error {
+
+	objects := me.Params.GetChildS("objects")
+	if objects == nil {
+		return nil
+	}
+
+	fn := objects.GetChildContentS(me.Object)
+	if fn == "" {
+		return errors.New(errors.MISSING_PARAM, "subtemplate of object "+me.Object)
+	}
+
+	template, err := collector.ImportTemplate(me.Options.HomePath, fn, me.Name)
+	if err != nil {
+		me.Logger.Error().Stack().Err(err).Msgf("Error importing subtemplate: %s", fn)
+		return err
+	}
+	me.Params.Union(template)
+	return nil
+}
+
+// parseTimeout - duration (e.g. "30s") or number of seconds
+func parseTimeout(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// PollData - run the command and rebuild the data cache from its output
+func (me *Exec) PollData() (*matrix.Matrix, error) {
+
+	var (
+		count uint64
+		keys  []string
+	)
+
+	output, err := me.run()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	samples, err := me.parse(bytes.NewReader(output), me.forceLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	// instances and metrics might change with every run
+	me.Matrix = me.Matrix.Clone(false, false, false)
+
+	for _, s := range samples {
+
+		key := instanceKey(s.Labels)
+		instance := me.Matrix.GetInstance(key)
+		if instance == nil {
+			if instance, err = me.Matrix.NewInstance(key); err != nil {
+				return nil, err
+			}
+			for k, v := range s.Labels {
+				instance.SetLabel(k, v)
+			}
+		}
+
+		metric := me.Matrix.GetMetric(s.Name)
+		if metric == nil {
+			if metric, err = me.Matrix.NewMetricFloat64(s.Name); err != nil {
+				return nil, err
+			}
+		}
+
+		if err = metric.SetValueFloat64(instance, s.Value); err != nil {
+			me.Logger.Error().Stack().Err(err).Msgf("set metric (%s) of [%s]", s.Name, key)
+			continue
+		}
+		count++
+	}
+	parseT := time.Since(start)
+
+	if len(me.Matrix.GetInstances()) == 0 {
+		return nil, errors.New(errors.ERR_NO_INSTANCE, "no samples in output of "+me.command)
+	}
+
+	for key := range me.Matrix.GetMetrics() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	me.Logger.Debug().Msgf("collected %d data points of %d metrics: %v", count, len(keys), keys)
+
+	me.Metadata.LazySetValueInt64("parse_time", "data", parseT.Microseconds())
+	me.Metadata.LazySetValueUint64("count", "data", count)
+	me.AddCollectCount(count)
+
+	return me.Matrix, nil
+}
+
+// run - run the command and return its stdout, exit code and runtime
+// (in microseconds) are recorded in metadata. Output of commands that exit
+// with non-zero code is returned, unless it's empty.
+func (me *Exec) run() ([]byte, error) {
+
+	var (
+		stdout, stderr bytes.Buffer
+		timedOut       int32
+	)
+
+	cmd := osexec.Command(me.command, me.args...)
+	cmd.Env = me.env
+	cmd.Dir = me.Options.HomePath
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		// e.g. command not found or not executable
+		return nil, errors.New(COMMAND, err.Error())
+	}
+
+	timer := time.AfterFunc(me.timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		_ = killProcessGroup(cmd)
+	})
+	err := cmd.Wait()
+	timer.Stop()
+	runtime := time.Since(start)
+
+	exitCode := cmd.ProcessState.ExitCode()
+
+	me.Metadata.LazySetValueInt64("exit_code", "data", int64(exitCode))
+	me.Metadata.LazySetValueInt64("runtime", "data", runtime.Microseconds())
+	me.Metadata.LazySetValueInt64("api_time", "data", runtime.Microseconds())
+
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return nil, errors.New(COMMAND, me.command+": timed out after "+me.timeout.String())
+	}
+
+	if err != nil {
+		if _, ok := err.(*osexec.ExitError); !ok {
+			return nil, errors.New(COMMAND, err.Error())
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxStderr {
+			msg = msg[:maxStderr] + "..."
+		}
+		if stdout.Len() == 0 {
+			return nil, errors.New(COMMAND, me.command+": exit code "+strconv.Itoa(exitCode)+": "+msg)
+		}
+		me.Logger.Warn().Msgf("command (%s) exit code %d: %s", me.command, exitCode, msg)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// instanceKey - unique key for a set of labels
+func instanceKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package exec
+
+import (
+	osexec "os/exec"
+)
+
+// setProcessGroup - process groups are not supported on this platform
+func setProcessGroup(cmd *osexec.Cmd) {}
+
+// killProcessGroup - only cmd is killed, children of scripts might
+// keep running after timeout
+func killProcessGroup(cmd *osexec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,113 @@
+package exec
+
+import (
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree"
+	"testing"
+)
+
+const template = `
+schedule:
+  - data: 60s
+object: ndmp_job
+command: testdata/jobs.sh
+timeout: 2
+`
+
+func newExec(t *testing.T, args ...string) *Exec {
+
+	params, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := params.NewChildS("args", "")
+	for _, arg := range args {
+		a.NewChildS("", arg)
+	}
+
+	c := &Exec{AbstractCollector: collector.New("Exec", "Jobs", &options.Options{Poller: "test"}, params)}
+	if err = c.Init(c.AbstractCollector); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func findInstance(data *matrix.Matrix, job string) *matrix.Instance {
+	for _, instance := range data.GetInstances() {
+		if instance.GetLabel("job") == job {
+			return instance
+		}
+	}
+	return nil
+}
+
+func TestPollData(t *testing.T) {
+
+	tests := []struct {
+		format string
+		bytes  string // name of the metric
+	}{
+		{Prometheus, "ndmp_bytes"},
+		{Influx, "ndmp_bytes"},
+		{JsonLines, "bytes"},
+		{KeyValue, "bytes"},
+	}
+
+	for _, tt := range tests {
+
+		c := newExec(t, tt.format)
+		c.parse = parsers[tt.format]
+
+		// output is parsed even though the script exits with code 2
+		data, err := c.PollData()
+		if err != nil {
+			t.Errorf("%s: %v", tt.format, err)
+			continue
+		}
+
+		if n := len(data.GetInstances()); n != 2 {
+			t.Errorf("%s: got %d instances, want 2", tt.format, n)
+			continue
+		}
+
+		vol1 := findInstance(data, "backup_vol1")
+		vol2 := findInstance(data, "backup vol2")
+		if vol1 == nil || vol2 == nil || vol1.GetLabel("node") != "cluster-01" {
+			t.Errorf("%s: labels of instances: %v, %v", tt.format, vol1, vol2)
+			continue
+		}
+
+		if bytes, ok := data.GetMetric(tt.bytes).GetValueFloat64(vol1); !ok || bytes != 1048576 {
+			t.Errorf("%s: %s of vol1 = %v (%v)", tt.format, tt.bytes, bytes, ok)
+		}
+		if bytes, ok := data.GetMetric(tt.bytes).GetValueFloat64(vol2); !ok || bytes != 0 {
+			t.Errorf("%s: %s of vol2 = %v (%v)", tt.format, tt.bytes, bytes, ok)
+		}
+
+		if code, ok := c.Metadata.LazyGetValueInt64("exit_code", "data"); !ok || code != 2 {
+			t.Errorf("%s: exit_code = %d (%v), want 2", tt.format, code, ok)
+		}
+	}
+}
+
+func TestPollErrors(t *testing.T) {
+
+	// no output
+	if _, err := newExec(t, "none").PollData(); !errors.IsErr(err, COMMAND) {
+		t.Errorf("no output: expected command error, got %v", err)
+	}
+
+	// killed after timeout of 2 seconds
+	if _, err := newExec(t, "sleep").PollData(); !errors.IsErr(err, COMMAND) {
+		t.Errorf("sleep: expected command error, got %v", err)
+	}
+
+	c := newExec(t)
+	c.command = "testdata/missing.sh"
+	if _, err := c.PollData(); !errors.IsErr(err, COMMAND) {
+		t.Errorf("missing: expected command error, got %v", err)
+	}
+}
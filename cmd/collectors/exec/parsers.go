@@ -0,0 +1,321 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"goharvest2/cmd/collectors/promscrape"
+	"goharvest2/pkg/errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// output formats of commands
+const (
+	Prometheus = "prometheus"
+	Influx     = "influx"
+	JsonLines  = "json"
+	KeyValue   = "keyvalue"
+)
+
+// parser - parse output into samples, keys in forceLabels are labels even
+// if their values are numeric (only json and keyvalue don't know the difference)
+type parser func(r io.Reader, forceLabels map[string]bool) ([]promscrape.Sample, error)
+
+var parsers = map[string]parser{
+	Prometheus: parsePrometheus,
+	Influx:     parseInflux,
+	JsonLines:  parseJsonLines,
+	KeyValue:   parseKeyValue,
+}
+
+func parsePrometheus(r io.Reader, _ map[string]bool) ([]promscrape.Sample, error) {
+	samples, err := promscrape.Parse(r)
+	if err != nil {
+		return nil, errors.New(PARSE, err.Error())
+	}
+	return samples, nil
+}
+
+// parseInflux - parse InfluxDB line protocol, e.g.:
+//
+//	quota,volume=vol1,tree=q1 used=1024i,limit=4096i,soft_exceeded=false 1622548800000000000
+//
+// each field is a sample named "<measurement>_<field>", tags are labels. String fields and
+// timestamps are ignored, booleans are 1 or 0.
+//
+// See: https://docs.influxdata.com/influxdb/v1.8/write_protocols/line_protocol_reference/
+func parseInflux(r io.Reader, _ map[string]bool) ([]promscrape.Sample, error) {
+
+	var samples []promscrape.Sample
+
+	err := eachLine(r, func(line string) error {
+
+		parts := splitEscaped(line, ' ')
+		if len(parts) < 2 || len(parts) > 3 {
+			return errors.New(PARSE, "expected measurement, fields and optional timestamp")
+		}
+
+		tags := splitEscaped(parts[0], ',')
+		measurement := unescape(tags[0])
+		if measurement == "" {
+			return errors.New(PARSE, "missing measurement")
+		}
+
+		labels := make(map[string]string, len(tags)-1)
+		for _, tag := range tags[1:] {
+			kv := splitEscaped(tag, '=')
+			if len(kv) != 2 {
+				return errors.New(PARSE, "invalid tag: "+tag)
+			}
+			labels[unescape(kv[0])] = unescape(kv[1])
+		}
+
+		for _, field := range splitEscaped(parts[1], ',') {
+			kv := splitEscaped(field, '=')
+			if len(kv) != 2 {
+				return errors.New(PARSE, "invalid field: "+field)
+			}
+			value, ok, err := influxValue(kv[1])
+			if err != nil {
+				return errors.New(PARSE, "field "+kv[0]+": "+err.Error())
+			}
+			if ok {
+				samples = append(samples, promscrape.Sample{Name: measurement + "_" + unescape(kv[0]), Labels: labels, Value: value})
+			}
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// influxValue - numeric value of a field, false if it's a string
+func influxValue(s string) (float64, bool, error) {
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	if strings.HasPrefix(s, "\"") {
+		return 0, false, nil
+	}
+	s = strings.TrimRight(s, "iu")
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil, err
+}
+
+// parseJsonLines - parse one flat JSON object per line, e.g.:
+//
+//	{"job": "backup_vol1", "status": "running", "bytes": 1024, "failed": false}
+//
+// numbers and booleans are samples (same labels), strings are labels, other values are ignored
+func parseJsonLines(r io.Reader, forceLabels map[string]bool) ([]promscrape.Sample, error) {
+
+	var samples []promscrape.Sample
+
+	err := eachLine(r, func(line string) error {
+
+		var record map[string]interface{}
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&record); err != nil {
+			return errors.New(PARSE, "invalid json: "+err.Error())
+		}
+
+		values := make(map[string]string)
+		asLabels := forceLabels
+		for k, v := range record {
+			switch x := v.(type) {
+			case json.Number:
+				values[k] = x.String()
+			case bool:
+				if x {
+					values[k] = "1"
+				} else {
+					values[k] = "0"
+				}
+			case string:
+				values[k] = x
+				asLabels = withLabel(asLabels, k)
+			}
+		}
+		samples = append(samples, toSamples(values, asLabels)...)
+		return nil
+	})
+	return samples, err
+}
+
+// parseKeyValue - parse space-separated key=value pairs, one instance per line, e.g.:
+//
+//	job=backup_vol1 status="in progress" bytes=1024
+//
+// numeric values are samples, others are labels. Values with spaces are quoted.
+func parseKeyValue(r io.Reader, forceLabels map[string]bool) ([]promscrape.Sample, error) {
+
+	var samples []promscrape.Sample
+
+	err := eachLine(r, func(line string) error {
+
+		values := make(map[string]string)
+		asLabels := forceLabels
+
+		for rest := line; rest != ""; rest = strings.TrimLeft(rest, " \t") {
+
+			eq := strings.IndexByte(rest, '=')
+			if eq <= 0 {
+				return errors.New(PARSE, "expected key=value: "+rest)
+			}
+			key := rest[:eq]
+			if strings.ContainsAny(key, " \t") {
+				return errors.New(PARSE, "expected key=value: "+rest)
+			}
+			rest = rest[eq+1:]
+
+			if strings.HasPrefix(rest, "\"") {
+				end := closingQuote(rest)
+				if end == -1 {
+					return errors.New(PARSE, "value of "+key+" not terminated")
+				}
+				value, err := strconv.Unquote(rest[:end+1])
+				if err != nil {
+					return errors.New(PARSE, "value of "+key+": "+err.Error())
+				}
+				values[key] = value
+				rest = rest[end+1:]
+				asLabels = withLabel(asLabels, key)
+				continue
+			}
+
+			end := strings.IndexAny(rest, " \t")
+			if end == -1 {
+				end = len(rest)
+			}
+			values[key], rest = rest[:end], rest[end:]
+		}
+
+		samples = append(samples, toSamples(values, asLabels)...)
+		return nil
+	})
+	return samples, err
+}
+
+// closingQuote - index of the quote that ends the string that s starts with, -1 if none
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// toSamples - samples of the numeric values, the other values are labels
+func toSamples(values map[string]string, forceLabels map[string]bool) []promscrape.Sample {
+
+	var samples []promscrape.Sample
+
+	labels := make(map[string]string)
+	numbers := make(map[string]float64)
+
+	for k, v := range values {
+		if !forceLabels[k] {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+				numbers[k] = f
+				continue
+			}
+		}
+		labels[k] = v
+	}
+
+	for k, f := range numbers {
+		samples = append(samples, promscrape.Sample{Name: k, Labels: labels, Value: f})
+	}
+	return samples
+}
+
+// withLabel - copy of labels with key added, the original is used for all lines
+func withLabel(labels map[string]bool, key string) map[string]bool {
+	if labels[key] {
+		return labels
+	}
+	copied := make(map[string]bool, len(labels)+1)
+	for k := range labels {
+		copied[k] = true
+	}
+	copied[key] = true
+	return copied
+}
+
+// eachLine - call parse with each line that is not empty or a comment,
+// errors are prefixed with the line number
+func eachLine(r io.Reader, parse func(string) error) error {
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(line); err != nil {
+			return errors.New(PARSE, "line "+strconv.Itoa(lineNo)+": "+strings.TrimPrefix(err.Error(), PARSE+" => "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return errors.New(PARSE, err.Error())
+	}
+	return nil
+}
+
+// splitEscaped - split s at sep, except if sep is escaped with a
+// backslash or inside a double-quoted string
+func splitEscaped(s string, sep byte) []string {
+
+	var (
+		parts  []string
+		quoted bool
+	)
+
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescape - remove backslashes from escaped commas, spaces and equal signs
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package exec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseInflux(t *testing.T) {
+
+	input := `cpu,host=server\ 1,region=eu\,west usage=0.5,idle=99.5,up=t,name="a, b" 1622548800000000000
+disk free=10u`
+
+	samples, err := parseInflux(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{"cpu_usage": 0.5, "cpu_idle": 99.5, "cpu_up": 1, "disk_free": 10}
+	if len(samples) != len(want) {
+		t.Fatalf("got %d samples, want %d: %v", len(samples), len(want), samples)
+	}
+	for _, s := range samples {
+		if v, ok := want[s.Name]; !ok || v != s.Value {
+			t.Errorf("sample %s = %v", s.Name, s.Value)
+		}
+		if s.Name == "cpu_usage" && (s.Labels["host"] != "server 1" || s.Labels["region"] != "eu,west") {
+			t.Errorf("labels of cpu_usage: %v", s.Labels)
+		}
+	}
+}
+
+func TestParseKeyValue(t *testing.T) {
+
+	// id is numeric, but a label
+	samples, err := parseKeyValue(strings.NewReader(`id=12 msg="say \"hi\"" count=3`), map[string]bool{"id": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 || samples[0].Name != "count" || samples[0].Value != 3 {
+		t.Fatalf("got %v", samples)
+	}
+	if labels := samples[0].Labels; labels["id"] != "12" || labels["msg"] != `say "hi"` {
+		t.Errorf("labels: %v", labels)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+
+	tests := []struct {
+		format string
+		input  string
+	}{
+		{Influx, "cpu"},
+		{Influx, "cpu usage=high"},
+		{Influx, "cpu,host usage=1"},
+		{JsonLines, `{"a": 1`},
+		{JsonLines, `[1, 2]`},
+		{KeyValue, "count"},
+		{KeyValue, `msg="open count=1`},
+		{Prometheus, `up{job="x" 1`},
+	}
+
+	for _, tt := range tests {
+		if _, err := parsers[tt.format](strings.NewReader(tt.input), nil); err == nil {
+			t.Errorf("%s %q: expected error", tt.format, tt.input)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+#!/bin/sh
+# prints NDMP jobs in the format given as first argument, exits with code 2 (warning)
+case "$1" in
+  influx)
+    echo 'ndmp,job=backup_vol1,node=cluster-01 bytes=1048576i,errors=0i,status="in progress" 1622548800000000000'
+    echo 'ndmp,job=backup\ vol2,node=cluster-02 bytes=0i,errors=3i,failed=true'
+    ;;
+  json)
+    echo '{"job": "backup_vol1", "node": "cluster-01", "status": "in progress", "bytes": 1048576, "errors": 0}'
+    echo '{"job": "backup vol2", "node": "cluster-02", "status": "failed", "bytes": 0, "errors": 3, "tags": ["x"]}'
+    ;;
+  keyvalue)
+    echo '# comment'
+    echo 'job=backup_vol1 node=cluster-01 status="in progress" bytes=1048576 errors=0'
+    echo 'job="backup vol2" node=cluster-02 status=failed bytes=0 errors=3'
+    ;;
+  prometheus)
+    echo '# TYPE ndmp_bytes gauge'
+    echo 'ndmp_bytes{job="backup_vol1",node="cluster-01"} 1048576'
+    echo 'ndmp_bytes{job="backup vol2",node="cluster-02"} 0'
+    ;;
+  sleep)
+    sleep 5
+    ;;
+esac
+echo "poller=$HARVEST_POLLER" >&2
+exit 2
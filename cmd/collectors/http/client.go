@@ -0,0 +1,120 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package http
+
+import (
+	"crypto/tls"
+	"goharvest2/pkg/api/httpclient"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"net/http"
+)
+
+// Client - sends GET requests to a JSON API
+type Client struct {
+	client  *http.Client
+	headers map[string]string
+	auth    func(*http.Request)
+}
+
+// NewClient - create client from the parameters of the poller and template: auth_style
+// (basic_auth, certificate_auth, bearer_auth or none), username, password, ssl_cert,
+// ssl_key, token, additional headers and the parameters of httpclient.New
+func NewClient(params *node.Node) (*Client, error) {
+
+	var err error
+
+	c := &Client{headers: make(map[string]string)}
+	tlsConfig := &tls.Config{}
+
+	authStyle := params.GetChildContentS("auth_style")
+	if authStyle == "" {
+		if params.GetChildContentS("username") != "" {
+			authStyle = "basic_auth"
+		} else {
+			authStyle = "none"
+		}
+	}
+
+	switch authStyle {
+	case "basic_auth":
+		username := params.GetChildContentS("username")
+		password := params.GetChildContentS("password")
+		if username == "" {
+			return nil, errors.New(errors.MISSING_PARAM, "username")
+		}
+		c.auth = func(r *http.Request) { r.SetBasicAuth(username, password) }
+	case "bearer_auth":
+		token := params.GetChildContentS("token")
+		if token == "" {
+			return nil, errors.New(errors.MISSING_PARAM, "token")
+		}
+		c.auth = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	case "certificate_auth":
+		certPath := params.GetChildContentS("ssl_cert")
+		keyPath := params.GetChildContentS("ssl_key")
+		if certPath == "" {
+			return nil, errors.New(errors.MISSING_PARAM, "ssl_cert")
+		} else if keyPath == "" {
+			return nil, errors.New(errors.MISSING_PARAM, "ssl_key")
+		}
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, errors.New(errors.INVALID_PARAM, "ssl_cert: "+err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case "none":
+	default:
+		return nil, errors.New(errors.INVALID_PARAM, "auth_style: "+authStyle)
+	}
+
+	if headers := params.GetChildS("headers"); headers != nil {
+		for _, h := range headers.GetChildren() {
+			c.headers[h.GetNameS()] = h.GetContentS()
+		}
+	}
+
+	if c.client, err = httpclient.New(params, tlsConfig); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get - send request to url and return the response body
+func (c *Client) Get(url string) ([]byte, error) {
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.New(errors.INVALID_PARAM, "url: "+err.Error())
+	}
+
+	request.Header.Set("Accept", "application/json")
+	for k, v := range c.headers {
+		request.Header.Set(k, v)
+	}
+	if c.auth != nil {
+		c.auth(request)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+
+	switch {
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+		return nil, errors.New(errors.API_INSUF_PRIV, url+": "+response.Status)
+	case response.StatusCode != http.StatusOK:
+		return nil, errors.New(errors.API_RESPONSE, url+": "+response.Status)
+	}
+	return body, nil
+}
@@ -0,0 +1,369 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package http is a generic collector for systems with a JSON API. Each
+// object is a subtemplate that declares the URL, the pagination style, the
+// path to the list of records in the response and the counters of each
+// record. Each record is an instance, counters are instance keys, labels
+// or metrics, with the same syntax as the Zapi collector:
+//
+//	counters:
+//	  - ^^id               => uuid
+//	  - ^status.state      => state
+//	  - space.used         => used_bytes
+//	  - online (bool)
+//
+// Responses are parsed with pkg/tree/json, so records are searched with the
+// same node helpers as ZAPI responses.
+package http
+
+import (
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/api/httpclient"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree/json"
+	"goharvest2/pkg/tree/node"
+	"strings"
+	"time"
+)
+
+type Http struct {
+	*collector.AbstractCollector
+	client     *Client
+	url        string
+	pagination *pagination
+	records    []string   // path to the records in the response
+	prefix     []string   // name of record elements
+	keyPaths   [][]string // paths of instance keys, relative to prefix
+	counters   []*counter
+}
+
+// counter - instance key, label or metric, path is relative to
+// the record element and key is the dotted path
+type counter struct {
+	path    []string
+	key     string
+	display string
+	isLabel bool
+	isKey   bool
+	dtype   string
+}
+
+func init() {
+	plugin.RegisterModule(Http{})
+}
+
+func (Http) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.http",
+		New: func() plugin.Module { return new(Http) },
+	}
+}
+
+// Init - initialize the collector
+func (me *Http) Init(a *collector.AbstractCollector) error {
+
+	var err error
+
+	me.AbstractCollector = a
+
+	if err = me.loadSubTemplate(); err != nil {
+		return err
+	}
+
+	if err = collector.Init(me); err != nil {
+		return err
+	}
+
+	if object := me.Params.GetChildContentS("object"); object != "" {
+		me.Matrix.Object = object
+	}
+
+	if me.client, err = NewClient(me.Params); err != nil {
+		return err
+	}
+
+	if me.url, err = me.baseUrl(); err != nil {
+		return err
+	}
+
+	if me.pagination, err = newPagination(me.Params.GetChildS("pagination")); err != nil {
+		return err
+	}
+
+	// records are the elements of the array at this path, or
+	// of the top-level array if the path is empty
+	me.records = splitPath(me.Params.GetChildContentS("records"))
+	if len(me.records) == 0 {
+		me.prefix = []string{""}
+	} else {
+		me.prefix = me.records[len(me.records)-1:]
+	}
+
+	counters := me.Params.GetChildS("counters")
+	if counters == nil {
+		return errors.New(errors.MISSING_PARAM, "counters")
+	}
+
+	if err = me.loadCounters(counters); err != nil {
+		return err
+	}
+
+	me.Logger.Debug().Msgf("initialized with url (%s), pagination (%s), %d counters and %d instance keys", me.url, me.pagination.style, len(me.counters), len(me.keyPaths))
+	return nil
+}
+
+// loadSubTemplate - merge the subtemplate of our object into the parameters
+func (me *Http) loadSubTemplate() error {
+
+	objects := me.Params.GetChildS("objects")
+	if objects == nil {
+		return nil
+	}
+
+	fn := objects.GetChildContentS(me.Object)
+	if fn == "" {
+		return errors.New(errors.MISSING_PARAM, "subtemplate of object "+me.Object)
+	}
+
+	template, err := collector.ImportTemplate(me.Options.HomePath, fn, me.Name)
+	if err != nil {
+		me.Logger.Error().Stack().Err(err).Msgf("Error importing subtemplate: %s", fn)
+		return err
+	}
+	me.Params.Union(template)
+	return nil
+}
+
+// baseUrl - URL of the API endpoint, a path is appended to the address of the poller
+func (me *Http) baseUrl() (string, error) {
+
+	url := me.Params.GetChildContentS("url")
+	if url == "" {
+		return "", errors.New(errors.MISSING_PARAM, "url")
+	}
+
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return url, nil
+	}
+
+	base, err := httpclient.BaseURL(me.Params)
+	if err != nil {
+		return "", err
+	}
+	return base + "/" + strings.TrimPrefix(url, "/"), nil
+}
+
+// loadCounters - parse counters and add metrics to the matrix
+func (me *Http) loadCounters(counters *node.Node) error {
+
+	for _, c := range counters.GetAllChildContentS() {
+
+		cnt, err := parseCounter(c)
+		if err != nil {
+			return err
+		}
+
+		if cnt.isKey {
+			me.keyPaths = append(me.keyPaths, append(me.prefix[:1:1], cnt.path...))
+		}
+
+		if !cnt.isLabel {
+			dtype := cnt.dtype
+			if dtype == "bool" {
+				dtype = "uint8"
+			}
+			metric, err := me.Matrix.NewMetricType(cnt.key, dtype)
+			if err != nil {
+				return errors.New(errors.INVALID_PARAM, "counter "+c+": "+err.Error())
+			}
+			metric.SetName(cnt.display)
+		}
+
+		me.counters = append(me.counters, cnt)
+		me.Logger.Trace().Msgf("added counter (%s) as [%s] (label=%v, key=%v, type=%s)", cnt.key, cnt.display, cnt.isLabel, cnt.isKey, cnt.dtype)
+	}
+
+	if len(me.keyPaths) == 0 {
+		return errors.New(errors.MISSING_PARAM, "no instance keys indicated")
+	}
+
+	if len(me.Matrix.GetMetrics()) == 0 && me.Params.GetChildContentS("collect_only_labels") != "true" {
+		return errors.New(errors.ERR_NO_METRIC, "failed to parse any")
+	}
+	return nil
+}
+
+// parseCounter - parse counter definition, e.g. "^^id => uuid" or
+// "space.used => used (uint64)". Display name defaults to the path
+// with underscores and the type of metrics to float64
+func parseCounter(content string) (*counter, error) {
+
+	def, err := collector.ParseMetricDef(content)
+	if err != nil {
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+err.Error())
+	}
+
+	cnt := &counter{display: def.Display, isLabel: def.IsLabel, isKey: def.IsKey, dtype: "float64"}
+
+	if def.Property != "" {
+		switch cnt.dtype = def.Property; cnt.dtype {
+		case "float64", "uint64", "int64", "bool":
+		default:
+			return nil, errors.New(errors.INVALID_PARAM, "counter "+content+": type "+cnt.dtype)
+		}
+	}
+
+	if cnt.path = splitPath(def.Name); len(cnt.path) == 0 {
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+content)
+	}
+	cnt.key = strings.Join(cnt.path, ".")
+
+	if cnt.display == "" {
+		cnt.display = strings.ReplaceAll(cnt.key, ".", "_")
+	}
+	return cnt, nil
+}
+
+// PollData - fetch all records and rebuild the data cache
+func (me *Http) PollData() (*matrix.Matrix, error) {
+
+	var (
+		count, skipped uint64
+		apiT, parseT   time.Duration
+	)
+
+	records, apiT, parseT, err := me.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+
+	me.Matrix.PurgeInstances()
+	me.Matrix.Reset()
+
+	for _, record := range records {
+
+		keys, found := record.SearchContent(me.prefix, me.keyPaths)
+		if !found {
+			me.Logger.Debug().Msg("skipping record, no instance keys found")
+			continue
+		}
+
+		key := strings.Join(keys, ".")
+		instance, err := me.Matrix.NewInstance(key)
+		if err != nil {
+			me.Logger.Warn().Msgf("add instance [%s]: %v", key, err)
+			continue
+		}
+
+		for _, cnt := range me.counters {
+
+			values, ok := record.SearchContent(me.prefix, [][]string{append(me.prefix[:1:1], cnt.path...)})
+			if !ok || values[0] == "" {
+				skipped++
+				continue
+			}
+			value := values[0]
+
+			if cnt.isLabel {
+				instance.SetLabel(cnt.display, value)
+				count++
+				continue
+			}
+
+			if cnt.dtype == "bool" {
+				switch value {
+				case "true":
+					value = "1"
+				case "false":
+					value = "0"
+				}
+			}
+
+			if err = me.Matrix.GetMetric(cnt.key).SetValueString(instance, value); err != nil {
+				me.Logger.Debug().Msgf("set metric (%s) of [%s] to (%s): %v", cnt.key, key, value, err)
+				skipped++
+			} else {
+				count++
+			}
+		}
+	}
+
+	parseT += time.Since(start)
+
+	me.Logger.Debug().Msgf("collected %d data points of %d instances (skipped %d)", count, len(me.Matrix.GetInstances()), skipped)
+
+	if len(me.Matrix.GetInstances()) == 0 {
+		return nil, errors.New(errors.ERR_NO_INSTANCE, "no records with instance keys")
+	}
+
+	me.Metadata.LazySetValueInt64("api_time", "data", apiT.Microseconds())
+	me.Metadata.LazySetValueInt64("parse_time", "data", parseT.Microseconds())
+	me.Metadata.LazySetValueUint64("count", "data", count)
+	me.AddCollectCount(count)
+
+	return me.Matrix, nil
+}
+
+// fetch - request all pages and return the records, the
+// time spent on requests and on parsing responses
+func (me *Http) fetch() ([]*node.Node, time.Duration, time.Duration, error) {
+
+	var (
+		records      []*node.Node
+		apiT, parseT time.Duration
+	)
+
+	url, err := me.pagination.first(me.url)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	for page := 0; url != ""; page++ {
+
+		if page == maxPages {
+			me.Logger.Warn().Msgf("stopped after %d pages, last url: %s", maxPages, url)
+			break
+		}
+
+		start := time.Now()
+		body, err := me.client.Get(url)
+		apiT += time.Since(start)
+		if err != nil {
+			return nil, apiT, parseT, err
+		}
+
+		start = time.Now()
+		response, err := json.Load(body)
+		if err != nil {
+			return nil, apiT, parseT, errors.New(errors.API_RESPONSE, err.Error())
+		}
+
+		var elements []*node.Node
+		if len(me.records) == 0 {
+			elements = response.GetChildren()
+		} else {
+			elements = response.SearchChildren(me.records)
+		}
+		records = append(records, elements...)
+		parseT += time.Since(start)
+
+		me.Logger.Trace().Msgf("fetched %d records from %s", len(elements), url)
+
+		next, err := me.pagination.next(url, response, len(elements))
+		if err != nil {
+			return nil, apiT, parseT, err
+		}
+		// a cursor or link that doesn't advance would request the same page until maxPages
+		if next == url {
+			return nil, apiT, parseT, errors.New(errors.API_RESPONSE, "next page has the same url: "+url)
+		}
+		url = next
+	}
+	return records, apiT, parseT, nil
+}
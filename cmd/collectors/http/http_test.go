@@ -0,0 +1,212 @@
+package http
+
+import (
+	"encoding/json"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/tree"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+const template = `
+schedule:
+  - data: 60s
+object: appliance_volume
+url: /api/volumes
+scheme: http
+auth_style: bearer_auth
+token: secret
+records: data
+counters:
+  - ^^id         => uuid
+  - ^name        => volume
+  - ^status.state => state
+  - space.used   => used (uint64)
+  - space.total  => size
+  - iops
+  - thin (bool)
+`
+
+// serve the volumes in testdata in pages of two records, in the
+// format of each pagination style
+func newServer(t *testing.T) *httptest.Server {
+
+	data, err := ioutil.ReadFile("testdata/volumes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var volumes []interface{}
+	if err = json.Unmarshal(data, &volumes); err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+		offset := 0
+		switch {
+		case query.Get("offset") != "":
+			offset, _ = strconv.Atoi(query.Get("offset"))
+		case query.Get("page") != "":
+			page, _ := strconv.Atoi(query.Get("page"))
+			offset = (page - 1) * 2
+		case query.Get("cursor") != "":
+			offset, _ = strconv.Atoi(query.Get("cursor"))
+		}
+
+		end := offset + 2
+		if end > len(volumes) {
+			end = len(volumes)
+		}
+		response := map[string]interface{}{"data": volumes[offset:end]}
+		if end < len(volumes) {
+			response["meta"] = map[string]interface{}{
+				"cursor": strconv.Itoa(end),
+				"next":   "/api/volumes?start=" + strconv.Itoa(end) + "&cursor=" + strconv.Itoa(end),
+			}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func TestPollData(t *testing.T) {
+
+	server := newServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		pagination map[string]string
+	}{
+		{"offset", map[string]string{"style": "offset", "page_size": "2"}},
+		{"page", map[string]string{"style": "page", "page_size": "2"}},
+		{"cursor", map[string]string{"style": "cursor", "cursor_path": "meta.cursor"}},
+		{"link", map[string]string{"style": "link", "next_path": "meta.next"}},
+	}
+
+	for _, tt := range tests {
+
+		params, err := tree.LoadYaml([]byte(template))
+		if err != nil {
+			t.Fatal(err)
+		}
+		params.NewChildS("addr", server.Listener.Addr().String())
+		p := params.NewChildS("pagination", "")
+		for k, v := range tt.pagination {
+			p.NewChildS(k, v)
+		}
+
+		c := &Http{AbstractCollector: collector.New("Http", "volume", &options.Options{}, params)}
+		if err = c.Init(c.AbstractCollector); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		data, err := c.PollData()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if n := len(data.GetInstances()); n != 3 {
+			t.Errorf("%s: got %d instances, want 3", tt.name, n)
+			continue
+		}
+
+		v1 := data.GetInstance("v1")
+		if v1 == nil || v1.GetLabel("volume") != "vol1" || v1.GetLabel("state") != "online" {
+			t.Errorf("%s: labels of v1: %v", tt.name, v1)
+		}
+
+		if used, ok := data.GetMetric("space.used").GetValueUint64(v1); !ok || used != 1024 {
+			t.Errorf("%s: used of v1 = %d (%v)", tt.name, used, ok)
+		}
+		if iops, ok := data.GetMetric("iops").GetValueFloat64(v1); !ok || iops != 12.5 {
+			t.Errorf("%s: iops of v1 = %v (%v)", tt.name, iops, ok)
+		}
+		if thin, ok := data.GetMetric("thin").GetValueUint8(v1); !ok || thin != 1 {
+			t.Errorf("%s: thin of v1 = %v (%v)", tt.name, thin, ok)
+		}
+		// null and missing values are skipped
+		v3 := data.GetInstance("v3")
+		if _, ok := data.GetMetric("space.total").GetValueFloat64(v3); ok {
+			t.Errorf("%s: size of v3 should be skipped", tt.name)
+		}
+		if _, ok := data.GetMetric("thin").GetValueUint8(v3); ok {
+			t.Errorf("%s: thin of v3 should be skipped", tt.name)
+		}
+	}
+}
+
+func TestPollDataStuckCursor(t *testing.T) {
+
+	// cursor of every response is the same, so pagination never advances
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"data": [{"id": "v1", "iops": 1}], "meta": {"cursor": "abc"}}`))
+	}))
+	defer server.Close()
+
+	params, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.NewChildS("addr", server.Listener.Addr().String())
+	p := params.NewChildS("pagination", "")
+	p.NewChildS("style", "cursor")
+	p.NewChildS("cursor_path", "meta.cursor")
+
+	c := &Http{AbstractCollector: collector.New("Http", "volume", &options.Options{}, params)}
+	if err = c.Init(c.AbstractCollector); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.PollData(); err == nil {
+		t.Error("expected error for cursor that doesn't advance")
+	}
+	if requests != 2 {
+		t.Errorf("sent %d requests, want 2", requests)
+	}
+}
+
+func TestParseCounter(t *testing.T) {
+
+	tests := []struct {
+		content string
+		key     string
+		display string
+		isLabel bool
+		isKey   bool
+		dtype   string
+	}{
+		{"^^id => uuid", "id", "uuid", true, true, "float64"},
+		{"^status.state", "status.state", "status_state", true, false, "float64"},
+		{"space.used => used (uint64)", "space.used", "used", false, false, "uint64"},
+		{"online (bool)", "online", "online", false, false, "bool"},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCounter(tt.content)
+		if err != nil {
+			t.Errorf("%s: %v", tt.content, err)
+			continue
+		}
+		if c.key != tt.key || c.display != tt.display || c.isLabel != tt.isLabel || c.isKey != tt.isKey || c.dtype != tt.dtype {
+			t.Errorf("%s: got %+v", tt.content, c)
+		}
+	}
+
+	for _, invalid := range []string{"", "^^", "size (string)"} {
+		if _, err := parseCounter(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
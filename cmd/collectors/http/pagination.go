@@ -0,0 +1,180 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package http
+
+import (
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/tree/node"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 100
+	maxPages        = 1000 // safeguard against APIs that never stop paging
+)
+
+// pagination - how to request the next page of records, styles are:
+//
+//	none:   all records are returned at once
+//	offset: query parameters for the number of records and the offset, e.g. ?limit=100&offset=200
+//	page:   query parameters for the number of records and the page number, e.g. ?per_page=100&page=3
+//	cursor: the response contains a cursor that is sent with the next request, e.g. ?cursor=abc
+//	link:   the response contains the URL of the next page
+type pagination struct {
+	style       string
+	pageSize    int
+	sizeParam   string   // offset, page and cursor
+	offsetParam string   // offset
+	pageParam   string   // page
+	firstPage   int      // page
+	cursorParam string   // cursor
+	cursorPath  []string // cursor
+	nextPath    []string // link
+}
+
+// newPagination - parse the "pagination" parameter of the template
+func newPagination(n *node.Node) (*pagination, error) {
+
+	p := &pagination{style: "none", pageSize: defaultPageSize, firstPage: 1}
+
+	if n == nil {
+		return p, nil
+	}
+
+	if x := n.GetChildContentS("style"); x != "" {
+		p.style = x
+	}
+
+	if x := n.GetChildContentS("page_size"); x != "" {
+		size, err := strconv.Atoi(x)
+		if err != nil || size <= 0 {
+			return nil, errors.New(errors.INVALID_PARAM, "page_size: "+x)
+		}
+		p.pageSize = size
+	}
+
+	param := func(name, value string) string {
+		if x := n.GetChildContentS(name); x != "" {
+			return x
+		}
+		return value
+	}
+
+	switch p.style {
+	case "none":
+	case "offset":
+		p.sizeParam = param("size_param", "limit")
+		p.offsetParam = param("offset_param", "offset")
+	case "page":
+		p.sizeParam = param("size_param", "per_page")
+		p.pageParam = param("page_param", "page")
+		if x := n.GetChildContentS("first_page"); x != "" {
+			first, err := strconv.Atoi(x)
+			if err != nil {
+				return nil, errors.New(errors.INVALID_PARAM, "first_page: "+x)
+			}
+			p.firstPage = first
+		}
+	case "cursor":
+		p.sizeParam = n.GetChildContentS("size_param")
+		p.cursorParam = param("cursor_param", "cursor")
+		if p.cursorPath = splitPath(n.GetChildContentS("cursor_path")); len(p.cursorPath) == 0 {
+			return nil, errors.New(errors.MISSING_PARAM, "cursor_path")
+		}
+	case "link":
+		if p.nextPath = splitPath(n.GetChildContentS("next_path")); len(p.nextPath) == 0 {
+			return nil, errors.New(errors.MISSING_PARAM, "next_path")
+		}
+	default:
+		return nil, errors.New(errors.INVALID_PARAM, "pagination style: "+p.style)
+	}
+	return p, nil
+}
+
+// first - URL of the first page
+func (p *pagination) first(base string) (string, error) {
+	switch p.style {
+	case "offset":
+		return setQuery(base, p.sizeParam, strconv.Itoa(p.pageSize), p.offsetParam, "0")
+	case "page":
+		return setQuery(base, p.sizeParam, strconv.Itoa(p.pageSize), p.pageParam, strconv.Itoa(p.firstPage))
+	case "cursor":
+		if p.sizeParam != "" {
+			return setQuery(base, p.sizeParam, strconv.Itoa(p.pageSize))
+		}
+	}
+	return base, nil
+}
+
+// next - URL of the page after current, or empty string if this was the last
+// page. Response is the parsed response of current that contained count records.
+func (p *pagination) next(current string, response *node.Node, count int) (string, error) {
+
+	switch p.style {
+	case "offset", "page":
+		if count < p.pageSize {
+			return "", nil
+		}
+		u, err := url.Parse(current)
+		if err != nil {
+			return "", err
+		}
+		if p.style == "offset" {
+			offset, _ := strconv.Atoi(u.Query().Get(p.offsetParam))
+			return setQuery(current, p.offsetParam, strconv.Itoa(offset+count))
+		}
+		page, _ := strconv.Atoi(u.Query().Get(p.pageParam))
+		return setQuery(current, p.pageParam, strconv.Itoa(page+1))
+	case "cursor":
+		if cursor := contentOf(response, p.cursorPath); cursor != "" {
+			return setQuery(current, p.cursorParam, cursor)
+		}
+	case "link":
+		if link := contentOf(response, p.nextPath); link != "" {
+			base, err := url.Parse(current)
+			if err != nil {
+				return "", err
+			}
+			ref, err := url.Parse(link)
+			if err != nil {
+				return "", errors.New(errors.API_RESPONSE, "invalid link to next page: "+link)
+			}
+			return base.ResolveReference(ref).String(), nil
+		}
+	}
+	return "", nil
+}
+
+// setQuery - set query parameters of rawurl, pairs are names and values
+func setQuery(rawurl string, pairs ...string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", errors.New(errors.INVALID_PARAM, "url: "+err.Error())
+	}
+	query := u.Query()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		query.Set(pairs[i], pairs[i+1])
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// contentOf - content of the first node at path, searched from the root of the response
+func contentOf(root *node.Node, path []string) string {
+	if values, ok := root.SearchContent(path[:1], [][]string{path}); ok {
+		return values[0]
+	}
+	return ""
+}
+
+// splitPath - split dotted path, e.g. "meta.next" => [meta next]
+func splitPath(path string) []string {
+	if path = strings.TrimSpace(path); path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
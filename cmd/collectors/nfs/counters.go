@@ -0,0 +1,195 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package nfs
+
+import (
+	"goharvest2/pkg/set"
+)
+
+// counter - metric provided by the collector. Property and base counter have the
+// same meaning as in ZapiPerf: the final value is calculated from the raw values of
+// two consecutive polls. Raw values are multiplied by scale (e.g. ms to microsec).
+type counter struct {
+	property  string // raw, delta, rate or average
+	base      string // base counter of averages
+	scale     float64
+	histogram bool // one submetric per label, e.g. for each NFS procedure
+}
+
+// counters of each object, latencies are in microseconds, the same as in ZapiPerf
+var _COUNTERS = map[string]map[string]counter{
+	"nfs_mount": {
+		"ops":               {property: "rate"},
+		"read_ops":          {property: "rate"},
+		"write_ops":         {property: "rate"},
+		"read_throughput":   {property: "rate"},
+		"write_throughput":  {property: "rate"},
+		"rtt_latency":       {property: "average", base: "ops", scale: 1000},
+		"exec_latency":      {property: "average", base: "ops", scale: 1000},
+		"read_avg_latency":  {property: "average", base: "read_ops", scale: 1000},
+		"write_avg_latency": {property: "average", base: "write_ops", scale: 1000},
+		"retrans":           {property: "rate"},
+		"timeouts":          {property: "rate"},
+		"sends":             {property: "rate"},
+		"bad_xids":          {property: "delta"},
+		"backlog":           {property: "average", base: "sends"},
+	},
+	"nfs_op": {
+		"ops":           {property: "rate"},
+		"retrans":       {property: "rate"},
+		"timeouts":      {property: "rate"},
+		"errors":        {property: "rate"},
+		"sent_bytes":    {property: "rate"},
+		"recv_bytes":    {property: "rate"},
+		"queue_latency": {property: "average", base: "ops", scale: 1000},
+		"rtt_latency":   {property: "average", base: "ops", scale: 1000},
+		"exec_latency":  {property: "average", base: "ops", scale: 1000},
+	},
+	"nfs_rpc": {
+		"calls":       {property: "rate"},
+		"retrans":     {property: "rate"},
+		"authrefresh": {property: "rate"},
+		"ops":         {property: "rate", histogram: true},
+	},
+}
+
+// sample - raw values and labels of an instance
+type sample struct {
+	labels map[string]string
+	values map[string]float64 // by counter name, "name.label" for histograms
+}
+
+func mountLabels(m *Mount) map[string]string {
+	return map[string]string{
+		"server":      m.Server,
+		"export":      m.Export,
+		"mount_point": m.MountPoint,
+		"version":     m.Version,
+		"proto":       m.Proto,
+	}
+}
+
+// mountSamples - one instance for each mount, keyed by mount point
+func mountSamples(mounts []*Mount) map[string]*sample {
+
+	samples := make(map[string]*sample)
+
+	for _, m := range mounts {
+
+		s := &sample{labels: mountLabels(m), values: make(map[string]float64)}
+
+		for _, op := range m.Ops {
+			s.values["ops"] += float64(op.Ops)
+			s.values["rtt_latency"] += float64(op.Rtt)
+			s.values["exec_latency"] += float64(op.Exec)
+			s.values["retrans"] += float64(op.Retrans())
+			s.values["timeouts"] += float64(op.Timeouts)
+		}
+
+		if op, ok := m.Ops["read"]; ok {
+			s.values["read_ops"] = float64(op.Ops)
+			s.values["read_avg_latency"] = float64(op.Rtt)
+		}
+
+		if op, ok := m.Ops["write"]; ok {
+			s.values["write_ops"] = float64(op.Ops)
+			s.values["write_avg_latency"] = float64(op.Rtt)
+		}
+
+		// bytes transferred from/to the server, unlike normal_read and
+		// direct_read, this excludes reads served from the page cache
+		if num, ok := m.Bytes["server_read"]; ok {
+			s.values["read_throughput"] = float64(num)
+		}
+		if num, ok := m.Bytes["server_write"]; ok {
+			s.values["write_throughput"] = float64(num)
+		}
+
+		for _, key := range []string{"sends", "bad_xids"} {
+			if num, ok := m.Xprt[key]; ok {
+				s.values[key] = float64(num)
+			}
+		}
+
+		// cumulative backlog queue length, divided by sends this is the average backlog
+		if num, ok := m.Xprt["backlog_u"]; ok {
+			s.values["backlog"] = float64(num)
+		}
+
+		samples[m.MountPoint] = s
+	}
+	return samples
+}
+
+// opSamples - one instance for each operation of each mount, keyed by "<mount point>:<op>".
+// If ops is empty, only operations that have been used are included.
+func opSamples(mounts []*Mount, ops *set.Set) map[string]*sample {
+
+	samples := make(map[string]*sample)
+
+	for _, m := range mounts {
+		for _, name := range m.OpNames {
+
+			op := m.Ops[name]
+
+			if ops.Size() != 0 && !ops.Has(name) {
+				continue
+			}
+
+			if ops.Size() == 0 && op.Ops == 0 {
+				continue
+			}
+
+			s := &sample{labels: mountLabels(m), values: make(map[string]float64)}
+			s.labels["op"] = name
+
+			s.values["ops"] = float64(op.Ops)
+			s.values["retrans"] = float64(op.Retrans())
+			s.values["timeouts"] = float64(op.Timeouts)
+			s.values["errors"] = float64(op.Errors)
+			s.values["sent_bytes"] = float64(op.SentBytes)
+			s.values["recv_bytes"] = float64(op.RecvBytes)
+			s.values["queue_latency"] = float64(op.QueueTime)
+			s.values["rtt_latency"] = float64(op.Rtt)
+			s.values["exec_latency"] = float64(op.Exec)
+
+			samples[m.MountPoint+":"+name] = s
+		}
+	}
+	return samples
+}
+
+// rpcSamples - one instance for each NFS version that was used, keyed by version,
+// and one instance "all" with the RPC totals of the client
+func rpcSamples(stats *RpcStats) map[string]*sample {
+
+	samples := make(map[string]*sample)
+
+	s := &sample{labels: map[string]string{"version": "all"}, values: make(map[string]float64)}
+	for key, num := range stats.Rpc {
+		s.values[key] = float64(num)
+	}
+	samples["all"] = s
+
+	for version, procs := range stats.Procs {
+
+		var total uint64
+		for _, num := range procs {
+			total += num
+		}
+
+		// version not used by any mount
+		if total == 0 {
+			continue
+		}
+
+		s = &sample{labels: map[string]string{"version": version}, values: make(map[string]float64)}
+		for name, num := range procs {
+			s.values["ops."+name] = float64(num)
+		}
+		samples[version] = s
+	}
+	return samples
+}
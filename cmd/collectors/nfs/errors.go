@@ -0,0 +1,9 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package nfs
+
+const (
+	FILE_READ = "read file"
+)
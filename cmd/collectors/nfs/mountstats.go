@@ -0,0 +1,203 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package nfs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Mount - statistics of an NFS mount, as in /proc/self/mountstats
+type Mount struct {
+	Device     string
+	Server     string
+	Export     string
+	MountPoint string
+	Fstype     string
+	Version    string
+	Proto      string
+	Bytes      map[string]uint64
+	Xprt       map[string]uint64
+	Ops        map[string]*OpStats
+	OpNames    []string // ops in the order they are listed
+}
+
+// OpStats - per-operation statistics of an NFS mount. Times are cumulative in ms.
+type OpStats struct {
+	Ops           uint64
+	Transmissions uint64
+	Timeouts      uint64
+	SentBytes     uint64
+	RecvBytes     uint64
+	QueueTime     uint64
+	Rtt           uint64
+	Exec          uint64
+	Errors        uint64
+}
+
+// Retrans - number of retransmissions of the operation
+func (op *OpStats) Retrans() uint64 {
+	if op.Transmissions > op.Ops {
+		return op.Transmissions - op.Ops
+	}
+	return 0
+}
+
+// fields of the "bytes:" line
+var bytesFields = []string{
+	"normal_read",
+	"normal_write",
+	"direct_read",
+	"direct_write",
+	"server_read",
+	"server_write",
+	"read_pages",
+	"write_pages",
+}
+
+// fields of the "xprt:" line, depending on the transport protocol
+var xprtFields = map[string][]string{
+	"tcp": {"port", "bind_count", "connect_count", "connect_time", "idle_time", "sends", "recvs", "bad_xids", "req_u", "backlog_u"},
+	"udp": {"port", "bind_count", "sends", "recvs", "bad_xids", "req_u", "backlog_u"},
+}
+
+// ParseMountstats - parse content of /proc/self/mountstats, only NFS mounts are returned.
+// Example of a mount with the lines we are interested in:
+//
+//	device 10.0.0.5:/vol/data mounted on /mnt/data with fstype nfs statvers=1.1
+//		opts:	rw,vers=3,rsize=65536,wsize=65536,proto=tcp,...
+//		bytes:	1000 2000 0 0 1100 2100 10 20
+//		xprt:	tcp 0 1 1 0 0 150 150 0 200 0 2 0 0
+//		per-op statistics
+//		        READ: 10 10 0 1600 41000 2 5 8 0
+func ParseMountstats(data []byte) []*Mount {
+
+	var (
+		mounts []*Mount
+		mount  *Mount
+		perOp  bool
+	)
+
+	for _, line := range strings.Split(string(data), "\n") {
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "device" {
+			mount = parseDevice(fields)
+			perOp = false
+			if mount != nil {
+				mounts = append(mounts, mount)
+			}
+			continue
+		}
+
+		// not an NFS mount
+		if mount == nil {
+			continue
+		}
+
+		if perOp {
+			// e.g. "READ: 10 10 0 1600 41000 2 5 8 0",
+			// older kernels don't provide the number of errors
+			if strings.HasSuffix(fields[0], ":") && len(fields) >= 9 {
+				if op := parseOp(fields[1:]); op != nil {
+					name := strings.ToLower(strings.TrimSuffix(fields[0], ":"))
+					mount.Ops[name] = op
+					mount.OpNames = append(mount.OpNames, name)
+				}
+			}
+			continue
+		}
+
+		switch fields[0] {
+		case "opts:":
+			if len(fields) == 2 {
+				for _, opt := range strings.Split(fields[1], ",") {
+					if kv := strings.SplitN(opt, "=", 2); len(kv) == 2 {
+						switch kv[0] {
+						case "vers":
+							mount.Version = kv[1]
+						case "proto":
+							mount.Proto = kv[1]
+						}
+					}
+				}
+			}
+		case "bytes:":
+			setFields(mount.Bytes, bytesFields, fields[1:])
+		case "xprt:":
+			if len(fields) > 1 {
+				setFields(mount.Xprt, xprtFields[fields[1]], fields[2:])
+			}
+		case "per-op":
+			perOp = true
+		}
+	}
+	return mounts
+}
+
+// parseDevice - parse the first line of a mount, returns nil if not an NFS mount
+func parseDevice(fields []string) *Mount {
+
+	// device <device> mounted on <mount point> with fstype <fstype> [statvers=<version>]
+	if len(fields) < 8 || fields[2] != "mounted" || fields[3] != "on" || fields[5] != "with" || fields[6] != "fstype" {
+		return nil
+	}
+
+	if fields[7] != "nfs" && fields[7] != "nfs4" {
+		return nil
+	}
+
+	m := &Mount{
+		Device:     fields[1],
+		MountPoint: fields[4],
+		Fstype:     fields[7],
+		Bytes:      make(map[string]uint64),
+		Xprt:       make(map[string]uint64),
+		Ops:        make(map[string]*OpStats),
+	}
+
+	// server is a hostname, IPv4 or IPv6 address in brackets, e.g. "[fe80::1]:/export"
+	if i := strings.Index(m.Device, ":/"); i != -1 {
+		m.Server = strings.Trim(m.Device[:i], "[]")
+		m.Export = m.Device[i+1:]
+	}
+	return m
+}
+
+func parseOp(values []string) *OpStats {
+
+	nums := make([]uint64, 9)
+	for i := 0; i < len(nums) && i < len(values); i++ {
+		num, err := strconv.ParseUint(values[i], 10, 64)
+		if err != nil {
+			return nil
+		}
+		nums[i] = num
+	}
+
+	return &OpStats{
+		Ops:           nums[0],
+		Transmissions: nums[1],
+		Timeouts:      nums[2],
+		SentBytes:     nums[3],
+		RecvBytes:     nums[4],
+		QueueTime:     nums[5],
+		Rtt:           nums[6],
+		Exec:          nums[7],
+		Errors:        nums[8],
+	}
+}
+
+func setFields(m map[string]uint64, names, values []string) {
+	for i := 0; i < len(names) && i < len(values); i++ {
+		if num, err := strconv.ParseUint(values[i], 10, 64); err == nil {
+			m[names[i]] = num
+		}
+	}
+}
@@ -0,0 +1,439 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package nfs collects client-side NFS statistics of the local system from
+// /proc/self/mountstats (objects "nfs_mount" and "nfs_op") and from
+// /proc/net/rpc/nfs (object "nfs_rpc").
+//
+// All statistics are cumulative counters, so like ZapiPerf, the collector
+// caches the raw values of the previous poll and calculates final metric
+// values from the deltas of two consecutive polls. Latencies are in
+// microseconds, so that they line up with the cluster-side NFS counters.
+package nfs
+
+import (
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/set"
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+var _MOUNT_POINT = "/proc"
+
+type Nfs struct {
+	*collector.AbstractCollector
+	counters        map[string]counter
+	histogramLabels map[string]*set.Set // wanted labels of histograms, empty means all
+	ops             *set.Set            // wanted operations of nfs_op
+	isCacheEmpty    bool
+}
+
+func init() {
+	plugin.RegisterModule(Nfs{})
+}
+
+func (Nfs) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.nfs",
+		New: func() plugin.Module { return new(Nfs) },
+	}
+}
+
+// Init - initialize the collector
+func (me *Nfs) Init(a *collector.AbstractCollector) error {
+
+	me.AbstractCollector = a
+
+	if runtime.GOOS != "linux" {
+		return errors.New(errors.ERR_IMPLEMENT, "platform not supported")
+	}
+
+	if err := me.loadSubTemplate(); err != nil {
+		return err
+	}
+
+	if err := collector.Init(me); err != nil {
+		return err
+	}
+
+	if mp := me.Params.GetChildContentS("mount_point"); mp != "" {
+		_MOUNT_POINT = mp
+	}
+
+	if _, ok := _COUNTERS[me.Object]; !ok {
+		return errors.New(errors.INVALID_PARAM, "unknown object "+me.Object)
+	}
+
+	counters := me.Params.GetChildS("counters")
+	if counters == nil {
+		return errors.New(errors.MISSING_PARAM, "counters")
+	}
+
+	if err := me.loadMetrics(counters); err != nil {
+		me.Logger.Error().Stack().Err(err).Msg("load metrics")
+		return err
+	}
+
+	me.ops = set.New()
+	if ops := me.Params.GetChildS("operations"); ops != nil {
+		for _, op := range ops.GetAllChildContentS() {
+			me.ops.Add(strings.ToLower(op))
+		}
+	}
+
+	me.isCacheEmpty = true
+	me.Matrix.SetGlobalLabel("hostname", me.Options.Hostname)
+
+	me.Logger.Debug().Msg("initialized")
+	return nil
+}
+
+// loadSubTemplate - merge the subtemplate of our object into the parameters
+func (me *Nfs) loadSubTemplate() error {
+
+	objects := me.Params.GetChildS("objects")
+	if objects == nil {
+		return nil
+	}
+
+	fn := objects.GetChildContentS(me.Object)
+	if fn == "" {
+		return errors.New(errors.MISSING_PARAM, "subtemplate of object "+me.Object)
+	}
+
+	template, err := collector.ImportTemplate(me.Options.HomePath, fn, me.Name)
+	if err != nil {
+		me.Logger.Error().Stack().Err(err).Msgf("Error importing subtemplate: %s", fn)
+		return err
+	}
+	me.Params.Union(template)
+	return nil
+}
+
+func parseMetricName(name string) (string, string) {
+	if fields := strings.Fields(name); len(fields) == 3 && fields[1] == "=>" {
+		return fields[0], fields[2]
+	}
+	return name, name
+}
+
+// loadMetrics - add metrics listed in the template and the base counters they
+// require. Submetrics of histograms are added during instance polls, when we
+// know which labels are available.
+func (me *Nfs) loadMetrics(counters *node.Node) error {
+
+	available := _COUNTERS[me.Object]
+	me.counters = make(map[string]counter)
+	me.histogramLabels = make(map[string]*set.Set)
+
+	for _, cnt := range counters.GetChildren() {
+
+		name, display := parseMetricName(cnt.GetNameS())
+		if cnt.GetNameS() == "" {
+			name, display = parseMetricName(cnt.GetContentS())
+		}
+
+		c, ok := available[name]
+		if !ok {
+			me.Logger.Warn().Msgf("(%s) skipped unknown metric", name)
+			continue
+		}
+		me.counters[name] = c
+
+		if c.histogram {
+			me.histogramLabels[name] = set.NewFrom(cnt.GetAllChildContentS())
+			me.Logger.Debug().Msgf("(%s) added histogram", name)
+			continue
+		}
+
+		metric, err := me.Matrix.NewMetricFloat64(name)
+		if err != nil {
+			return err
+		}
+		metric.SetName(display)
+		metric.SetProperty(c.property)
+		metric.SetComment(c.base)
+		me.Logger.Debug().Msgf("(%s) added metric (%s) with property (%s)", name, display, c.property)
+	}
+
+	// base counters that are not in the template are collected but not exported
+	for name, c := range me.counters {
+		if c.base != "" && me.Matrix.GetMetric(c.base) == nil {
+			metric, err := me.Matrix.NewMetricFloat64(c.base)
+			if err != nil {
+				return err
+			}
+			metric.SetProperty(available[c.base].property)
+			metric.SetExportable(false)
+			me.counters[c.base] = available[c.base]
+			me.Logger.Debug().Msgf("(%s) added base counter of (%s)", c.base, name)
+		}
+	}
+
+	if len(me.counters) == 0 {
+		return errors.New(errors.ERR_NO_METRIC, "")
+	}
+
+	// time of the poll, to calculate rates
+	timestamp, err := me.Matrix.NewMetricFloat64("timestamp")
+	if err != nil {
+		return err
+	}
+	timestamp.SetProperty("raw")
+	timestamp.SetExportable(false)
+
+	return nil
+}
+
+// load - read statistics of all instances
+func (me *Nfs) load() (map[string]*sample, error) {
+
+	if me.Object == "nfs_rpc" {
+		data, err := ioutil.ReadFile(path.Join(_MOUNT_POINT, "net", "rpc", "nfs"))
+		// file is missing if the NFS client module is not loaded
+		if os.IsNotExist(err) {
+			return nil, errors.New(errors.ERR_NO_INSTANCE, err.Error())
+		}
+		if err != nil {
+			return nil, errors.New(FILE_READ, err.Error())
+		}
+		return rpcSamples(ParseRpc(data)), nil
+	}
+
+	data, err := ioutil.ReadFile(path.Join(_MOUNT_POINT, "self", "mountstats"))
+	if err != nil {
+		return nil, errors.New(FILE_READ, err.Error())
+	}
+
+	if me.Object == "nfs_op" {
+		return opSamples(ParseMountstats(data), me.ops), nil
+	}
+	return mountSamples(ParseMountstats(data)), nil
+}
+
+// PollInstance - update instance cache with NFS mounts, operations
+// or NFS versions, and add submetrics of histograms
+func (me *Nfs) PollInstance() (*matrix.Matrix, error) {
+
+	samples, err := me.load()
+	if err != nil {
+		return nil, err
+	}
+
+	oldInstances := set.NewFrom(me.Matrix.GetInstanceKeys())
+	oldSize := oldInstances.Size()
+
+	for key, s := range samples {
+
+		instance := me.Matrix.GetInstance(key)
+		if instance == nil {
+			if instance, err = me.Matrix.NewInstance(key); err != nil {
+				return nil, err
+			}
+			me.Logger.Debug().Msgf("add instance (%s)", key)
+		} else {
+			oldInstances.Delete(key)
+		}
+
+		for label, value := range s.labels {
+			instance.SetLabel(label, value)
+		}
+
+		if err = me.addHistogramMetrics(s); err != nil {
+			return nil, err
+		}
+	}
+
+	me.removeInstances(oldInstances)
+
+	t := len(me.Matrix.GetInstances())
+	r := oldInstances.Size()
+	me.Logger.Debug().Msgf("added %d, removed %d, total instances %d", t-(oldSize-r), r, t)
+
+	if t == 0 {
+		return nil, errors.New(errors.ERR_NO_INSTANCE, "")
+	}
+	return nil, nil
+}
+
+// addHistogramMetrics - add submetrics for labels of histograms we haven't seen before
+func (me *Nfs) addHistogramMetrics(s *sample) error {
+
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+
+		x := strings.SplitN(key, ".", 2)
+		if len(x) != 2 {
+			continue
+		}
+
+		name, label := x[0], x[1]
+		wanted, ok := me.histogramLabels[name]
+		if !ok || (wanted.Size() != 0 && !wanted.Has(label)) || me.Matrix.GetMetric(key) != nil {
+			continue
+		}
+
+		metric, err := me.Matrix.NewMetricFloat64(key)
+		if err != nil {
+			return err
+		}
+		metric.SetName(name)
+		metric.SetLabel("metric", label)
+		metric.SetProperty(me.counters[name].property)
+		me.Logger.Debug().Msgf("(%s) added histogram submetric (%s)", name, label)
+	}
+	return nil
+}
+
+// removeInstances - remove instances from the cache
+func (me *Nfs) removeInstances(names *set.Set) {
+
+	if names.Size() == 0 {
+		return
+	}
+
+	for name := range names.Iter() {
+		me.Matrix.RemoveInstance(name)
+		me.Logger.Debug().Msgf("remove instance (%s)", name)
+	}
+
+	// indexes need to be rewritten since gaps were created, cached values
+	// are dropped, so rates of the next poll are skipped
+	newMatrix := me.Matrix.Clone(false, true, false)
+	for key, instance := range me.Matrix.GetInstances() {
+		if newInstance, err := newMatrix.NewInstance(key); err == nil {
+			newInstance.SetLabels(instance.GetLabels())
+		}
+	}
+	me.Matrix = newMatrix
+}
+
+// PollData - update data cache. During first poll, no data will be emitted.
+// Afterwards, final metric values will be calculated from the previous poll.
+func (me *Nfs) PollData() (*matrix.Matrix, error) {
+
+	var count uint64
+
+	samples, err := me.load()
+	if err != nil {
+		return nil, err
+	}
+
+	newData := me.Matrix.Clone(false, true, true)
+	newData.Reset()
+
+	ts := float64(time.Now().UnixNano()) / 1e9
+
+	for key, instance := range newData.GetInstances() {
+
+		// instance disappeared since last instance poll
+		s, ok := samples[key]
+		if !ok {
+			continue
+		}
+
+		newData.GetMetric("timestamp").SetValueFloat64(instance, ts)
+
+		for name, value := range s.values {
+			metric := newData.GetMetric(name)
+			if metric == nil {
+				continue
+			}
+			c := me.counters[strings.SplitN(name, ".", 2)[0]]
+			if c.scale != 0 {
+				value *= c.scale
+			}
+			if err = metric.SetValueFloat64(instance, value); err != nil {
+				me.Logger.Error().Stack().Err(err).Msgf("set metric (%s) of [%s]", name, key)
+				continue
+			}
+			count++
+		}
+	}
+
+	me.AddCollectCount(count)
+	me.Logger.Debug().Msgf("collected %d data points", count)
+
+	// skip calculating from delta if no data from previous poll
+	if me.isCacheEmpty {
+		me.Logger.Debug().Msg("skip postprocessing until next poll (previous cache empty)")
+		me.Matrix = newData
+		me.isCacheEmpty = false
+		return nil, nil
+	}
+
+	// cache raw data for next poll
+	cachedData := newData.Clone(true, true, true)
+
+	calculate(newData, me.Matrix)
+
+	me.Matrix = cachedData
+	return newData, nil
+}
+
+// calculate - final metric values from the raw values in data and
+// the previous poll in prev, depending on the property of each metric
+func calculate(data, prev *matrix.Matrix) {
+
+	instances := data.GetInstances()
+
+	// deltas of all metrics, including timestamp and base counters
+	for key, metric := range data.GetMetrics() {
+		if metric.GetProperty() == "raw" && key != "timestamp" {
+			continue
+		}
+		delta(metric, prev.GetMetric(key), instances)
+	}
+
+	// averages, divided by the delta of the base counter
+	for _, metric := range data.GetMetrics() {
+		if metric.GetProperty() == "average" {
+			if base := data.GetMetric(metric.GetComment()); base != nil {
+				_ = metric.Divide(base)
+			}
+		}
+	}
+
+	// rates, divided by elapsed time
+	timestamp := data.GetMetric("timestamp")
+	for _, metric := range data.GetMetrics() {
+		if metric.GetProperty() == "rate" {
+			_ = metric.Divide(timestamp)
+		}
+	}
+}
+
+// delta - subtract previous value of the metric. Values are skipped if there is
+// no previous value or if the counter decreased, e.g. since the NFS share was remounted.
+func delta(metric, prev matrix.Metric, instances map[string]*matrix.Instance) {
+	for _, instance := range instances {
+		value, ok := metric.GetValueFloat64(instance)
+		if !ok {
+			continue
+		}
+		if prev == nil {
+			metric.SetValueNAN(instance)
+			continue
+		}
+		if prevValue, ok := prev.GetValueFloat64(instance); ok && value >= prevValue {
+			_ = metric.SetValueFloat64(instance, value-prevValue)
+		} else {
+			metric.SetValueNAN(instance)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package nfs
+
+import (
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/set"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseMountstats(t *testing.T) {
+
+	data, err := ioutil.ReadFile("testdata/proc/self/mountstats")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mounts := ParseMountstats(data)
+	if len(mounts) != 2 {
+		t.Fatalf("expected 2 NFS mounts, got %d", len(mounts))
+	}
+
+	tests := []struct {
+		mount                                  *Mount
+		server, export, mountPoint, ver, proto string
+		ops                                    int
+	}{
+		{mounts[0], "10.0.0.5", "/vol/data", "/mnt/data", "3", "tcp", 7},
+		{mounts[1], "fd00::5", "/home", "/home", "4.2", "tcp6", 4},
+	}
+
+	for _, tt := range tests {
+		m := tt.mount
+		if m.Server != tt.server || m.Export != tt.export || m.MountPoint != tt.mountPoint || m.Version != tt.ver || m.Proto != tt.proto {
+			t.Errorf("got %s:%s on %s (vers=%s, proto=%s), want %s:%s on %s (vers=%s, proto=%s)",
+				m.Server, m.Export, m.MountPoint, m.Version, m.Proto, tt.server, tt.export, tt.mountPoint, tt.ver, tt.proto)
+		}
+		if len(m.Ops) != tt.ops {
+			t.Errorf("%s: got %d ops, want %d", m.MountPoint, len(m.Ops), tt.ops)
+		}
+	}
+
+	read := mounts[0].Ops["read"]
+	if read == nil || read.Ops != 8 || read.Retrans() != 2 || read.Timeouts != 1 || read.RecvBytes != 33792 || read.Rtt != 40 || read.Exec != 44 {
+		t.Errorf("read stats of /mnt/data: %+v", read)
+	}
+
+	if mounts[0].Bytes["server_read"] != 32768 || mounts[0].Xprt["sends"] != 160 || mounts[0].Xprt["backlog_u"] != 20 {
+		t.Errorf("bytes or xprt of /mnt/data: %v %v", mounts[0].Bytes, mounts[0].Xprt)
+	}
+
+	// all operations that have been used
+	samples := opSamples(mounts, set.New())
+	for _, key := range []string{"/mnt/data:getattr", "/mnt/data:commit", "/home:access"} {
+		if _, ok := samples[key]; !ok {
+			t.Errorf("missing instance %s", key)
+		}
+	}
+	if _, ok := samples["/mnt/data:setattr"]; ok {
+		t.Errorf("unused operation should be skipped")
+	}
+
+	// only wanted operations
+	if samples = opSamples(mounts, set.NewFrom([]string{"write"})); len(samples) != 2 {
+		t.Errorf("got %d instances, want 2", len(samples))
+	}
+
+	s := mountSamples(mounts)["/mnt/data"]
+	if s.values["ops"] != 160 || s.values["retrans"] != 2 || s.values["read_throughput"] != 32768 {
+		t.Errorf("sample of /mnt/data: %v", s.values)
+	}
+}
+
+func TestParseRpc(t *testing.T) {
+
+	data, err := ioutil.ReadFile("testdata/proc/net/rpc/nfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := ParseRpc(data)
+	if stats.Rpc["calls"] != 172 || stats.Rpc["retrans"] != 2 {
+		t.Errorf("rpc: %v", stats.Rpc)
+	}
+
+	if v3 := stats.Procs["3"]; v3["getattr"] != 100 || v3["read"] != 8 || v3["write"] != 20 || v3["commit"] != 1 {
+		t.Errorf("v3: %v", v3)
+	}
+
+	samples := rpcSamples(stats)
+	if len(samples) != 3 {
+		t.Errorf("got %d instances (%v), want all, 3 and 4", len(samples), samples)
+	}
+	if _, ok := samples["2"]; ok {
+		t.Errorf("unused version should be skipped")
+	}
+}
+
+func TestCalculate(t *testing.T) {
+
+	prev := matrix.New("nfs", "nfs_op")
+	newMetric := func(key, property, base string) {
+		m, _ := prev.NewMetricFloat64(key)
+		m.SetProperty(property)
+		m.SetComment(base)
+	}
+	newMetric("timestamp", "raw", "")
+	newMetric("ops", "rate", "")
+	newMetric("rtt_latency", "average", "ops")
+	newMetric("errors", "delta", "")
+
+	a, _ := prev.NewInstance("a")
+	prev.GetMetric("timestamp").SetValueFloat64(a, 100)
+	prev.GetMetric("ops").SetValueFloat64(a, 50)
+	prev.GetMetric("rtt_latency").SetValueFloat64(a, 1000)
+	prev.GetMetric("errors").SetValueFloat64(a, 5)
+
+	// instance added after previous poll
+	b, _ := prev.NewInstance("b")
+
+	data := prev.Clone(false, true, true)
+	data.Reset()
+
+	for _, instance := range []*matrix.Instance{a, b} {
+		data.GetMetric("timestamp").SetValueFloat64(instance, 160)
+		data.GetMetric("ops").SetValueFloat64(instance, 110)
+		data.GetMetric("rtt_latency").SetValueFloat64(instance, 4000)
+		data.GetMetric("errors").SetValueFloat64(instance, 3)
+	}
+
+	calculate(data, prev)
+
+	tests := []struct {
+		metric string
+		want   float64
+		ok     bool
+	}{
+		{"ops", 1, true},
+		{"rtt_latency", 50, true},
+		{"errors", 0, false}, // counter decreased
+	}
+
+	for _, tt := range tests {
+		if got, ok := data.GetMetric(tt.metric).GetValueFloat64(a); ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("%s = %v (%v), want %v (%v)", tt.metric, got, ok, tt.want, tt.ok)
+		}
+		if _, ok := data.GetMetric(tt.metric).GetValueFloat64(b); ok {
+			t.Errorf("%s of new instance should be skipped", tt.metric)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package nfs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// names of the procedures in /proc/net/rpc/nfs, in the order the kernel lists them
+var procNames = map[string][]string{
+	"2": {"null", "getattr", "setattr", "root", "lookup", "readlink", "read", "wrcache", "write", "create",
+		"remove", "rename", "link", "symlink", "mkdir", "rmdir", "readdir", "fsstat"},
+	"3": {"null", "getattr", "setattr", "lookup", "access", "readlink", "read", "write", "create", "mkdir",
+		"symlink", "mknod", "remove", "rmdir", "rename", "link", "readdir", "readdirplus", "fsstat", "fsinfo",
+		"pathconf", "commit"},
+	"4": {"null", "read", "write", "commit", "open", "open_confirm", "open_noattr", "open_downgrade", "close", "setattr",
+		"fsinfo", "renew", "setclientid", "setclientid_confirm", "lock", "lockt", "locku", "access", "getattr", "lookup",
+		"lookup_root", "remove", "rename", "link", "symlink", "create", "pathconf", "statfs", "readlink", "readdir",
+		"server_caps", "delegreturn", "getacl", "setacl", "fs_locations", "release_lockowner", "secinfo", "fsid_present", "exchange_id", "create_session",
+		"destroy_session", "sequence", "get_lease_time", "reclaim_complete", "layoutget", "getdeviceinfo", "layoutcommit", "layoutreturn", "secinfo_no_name", "test_stateid",
+		"free_stateid", "getdevicelist", "bind_conn_to_session", "destroy_clientid", "seek", "allocate", "deallocate", "layoutstats", "clone", "copy",
+		"offload_cancel", "lookupp", "layouterror", "copy_notify", "getxattr", "setxattr", "listxattrs", "removexattr", "read_plus"},
+}
+
+// fields of the "rpc" line
+var rpcFields = []string{"calls", "retrans", "authrefresh"}
+
+// RpcStats - client RPC statistics, as in /proc/net/rpc/nfs
+type RpcStats struct {
+	Rpc   map[string]uint64
+	Procs map[string]map[string]uint64 // calls of each procedure by NFS version
+}
+
+// ParseRpc - parse content of /proc/net/rpc/nfs, example:
+//
+//	net 0 0 0 0
+//	rpc 2345 1 2345
+//	proc3 22 0 102 0 40 30 0 90 10 ...
+//	proc4 69 0 12 4 ...
+//
+// Procedure lines start with the number of values that follow. Procedures
+// that we don't know the name of (newer kernels) are named by their index.
+func ParseRpc(data []byte) *RpcStats {
+
+	stats := &RpcStats{Rpc: make(map[string]uint64), Procs: make(map[string]map[string]uint64)}
+
+	for _, line := range strings.Split(string(data), "\n") {
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		if fields[0] == "rpc" {
+			setFields(stats.Rpc, rpcFields, fields[1:])
+			continue
+		}
+
+		if !strings.HasPrefix(fields[0], "proc") {
+			continue
+		}
+
+		version := strings.TrimPrefix(fields[0], "proc")
+		count, err := strconv.Atoi(fields[1])
+		if err != nil || count != len(fields)-2 {
+			continue
+		}
+
+		names := procNames[version]
+		procs := make(map[string]uint64)
+		for i, value := range fields[2:] {
+			num, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			if i < len(names) {
+				procs[names[i]] = num
+			} else {
+				procs["proc"+strconv.Itoa(i)] = num
+			}
+		}
+		stats.Procs[version] = procs
+	}
+	return stats
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package promscrape
+
+import (
+	"bufio"
+	"goharvest2/pkg/errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sample - a single line of the Prometheus text exposition format, e.g.:
+//
+//	node_filesystem_avail_bytes{device="/dev/sda1",fstype="ext4",mountpoint="/"} 1.2e+10
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Parse - parse metrics in the Prometheus text exposition format. Comments (including
+// HELP and TYPE) and timestamps are ignored, and so are NaN and infinite values which
+// can't be exported by all exporters. Histograms and summaries are plain samples,
+// e.g. "<name>_bucket" with label "le".
+//
+// See: https://prometheus.io/docs/instrumenting/exposition_formats/
+func Parse(r io.Reader) ([]Sample, error) {
+
+	var samples []Sample
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNo := 0
+	for scanner.Scan() {
+
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		s, err := parseLine(line)
+		if err != nil {
+			return nil, errors.New(PARSE, "line "+strconv.Itoa(lineNo)+": "+err.Error())
+		}
+
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		samples = append(samples, s)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New(PARSE, err.Error())
+	}
+	return samples, nil
+}
+
+// parseLine - parse a sample: name, optional labels in braces, value and optional timestamp
+func parseLine(line string) (Sample, error) {
+
+	s := Sample{Labels: make(map[string]string)}
+
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return s, errors.New(PARSE, "missing value")
+	}
+	s.Name = line[:i]
+	rest := line[i:]
+
+	if rest[0] == '{' {
+		n, err := parseLabels(rest[1:], s.Labels)
+		if err != nil {
+			return s, err
+		}
+		rest = rest[1+n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return s, errors.New(PARSE, "expected value and optional timestamp")
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, errors.New(PARSE, "value: "+err.Error())
+	}
+	s.Value = value
+	return s, nil
+}
+
+// parseLabels - parse label pairs after the opening brace into labels,
+// returns the number of bytes consumed, including the closing brace
+func parseLabels(text string, labels map[string]string) (int, error) {
+
+	i := 0
+	for {
+		// skip whitespace and separators
+		for i < len(text) && (text[i] == ' ' || text[i] == ',') {
+			i++
+		}
+		if i >= len(text) {
+			return 0, errors.New(PARSE, "missing closing brace")
+		}
+		if text[i] == '}' {
+			return i + 1, nil
+		}
+
+		eq := strings.IndexByte(text[i:], '=')
+		if eq == -1 {
+			return 0, errors.New(PARSE, "missing '=' in labels")
+		}
+		name := strings.TrimSpace(text[i : i+eq])
+		i += eq + 1
+
+		for i < len(text) && text[i] == ' ' {
+			i++
+		}
+		if i >= len(text) || text[i] != '"' {
+			return 0, errors.New(PARSE, "label value of "+name+" not quoted")
+		}
+		i++
+
+		// label value, escape sequences are \\, \" and \n
+		var value strings.Builder
+		closed := false
+		for i < len(text) {
+			c := text[i]
+			i++
+			if c == '"' {
+				closed = true
+				break
+			}
+			if c == '\\' && i < len(text) {
+				switch text[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(text[i])
+				}
+				i++
+				continue
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return 0, errors.New(PARSE, "label value of "+name+" not terminated")
+		}
+		labels[name] = value.String()
+	}
+}
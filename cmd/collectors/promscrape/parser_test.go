@@ -0,0 +1,69 @@
+package promscrape
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+
+	f, err := os.Open("testdata/node_exporter.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	samples, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NaN sample is skipped
+	if len(samples) != 7 {
+		t.Fatalf("got %d samples, want 7", len(samples))
+	}
+
+	tests := []struct {
+		index  int
+		name   string
+		labels map[string]string
+		value  float64
+	}{
+		{0, "node_cpu_seconds_total", map[string]string{"cpu": "0", "mode": "idle"}, 8570.65},
+		{3, "node_filesystem_avail_bytes", map[string]string{"device": "/dev/sda1", "fstype": "ext4", "mountpoint": "/"}, 1.2e+10},
+		{4, "node_filesystem_avail_bytes", map[string]string{"device": "server:/vol/a, b", "fstype": "nfs", "mountpoint": `/mnt/"a"`}, 4096},
+		{5, "node_load1", map[string]string{}, 0.5},
+	}
+
+	for _, tt := range tests {
+		s := samples[tt.index]
+		if s.Name != tt.name || s.Value != tt.value || len(s.Labels) != len(tt.labels) {
+			t.Errorf("sample %d: got %s%v %v, want %s%v %v", tt.index, s.Name, s.Labels, s.Value, tt.name, tt.labels, tt.value)
+			continue
+		}
+		for k, v := range tt.labels {
+			if s.Labels[k] != v {
+				t.Errorf("sample %d: label %s = %q, want %q", tt.index, k, s.Labels[k], v)
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+
+	tests := []string{
+		"node_load1",
+		"node_load1 high",
+		`node_cpu{cpu="0" 1`,
+		`node_cpu{cpu=0} 1`,
+		`node_cpu{cpu="0} 1`,
+		"node_load1 1 2 3",
+	}
+
+	for _, line := range tests {
+		if _, err := Parse(strings.NewReader(line)); err == nil {
+			t.Errorf("%q: expected error", line)
+		}
+	}
+}
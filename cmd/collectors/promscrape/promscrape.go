@@ -0,0 +1,292 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package promscrape relays metrics of third-party Prometheus exporters (e.g.
+// node_exporter or the SNMP exporter) into Harvest. The collector scrapes
+// the /metrics endpoints of a list of targets and parses the text exposition
+// format.
+//
+// Each object of the collector is a metric family prefix (e.g. "node_cpu")
+// and holds all metrics whose name starts with that prefix, labels become
+// instance labels. Like other Harvest collectors, each object is a separate
+// collector, but targets are scraped only once per poll and the result is
+// shared by all objects.
+package promscrape
+
+import (
+	"bytes"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/api/httpclient"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// label added to all instances, the name of the target in the template
+const targetLabel = "target"
+
+type target struct {
+	name string
+	url  string
+}
+
+type PromScrape struct {
+	*collector.AbstractCollector
+	client  *http.Client
+	targets []target
+	prefix  string
+	exclude []string // prefixes of other objects that are more specific than ours
+	maxAge  time.Duration
+}
+
+func init() {
+	plugin.RegisterModule(PromScrape{})
+}
+
+func (PromScrape) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.promscrape",
+		New: func() plugin.Module { return new(PromScrape) },
+	}
+}
+
+// Init - initialize the collector
+func (me *PromScrape) Init(a *collector.AbstractCollector) error {
+
+	me.AbstractCollector = a
+
+	if err := collector.Init(me); err != nil {
+		return err
+	}
+
+	targets := me.Params.GetChildS("targets")
+	if targets == nil || len(targets.GetChildren()) == 0 {
+		return errors.New(errors.MISSING_PARAM, "targets")
+	}
+
+	for _, t := range targets.GetChildren() {
+		url := t.GetContentS()
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			url = "http://" + url
+		}
+		me.targets = append(me.targets, target{name: t.GetNameS(), url: url})
+		me.Logger.Debug().Msgf("added target (%s) => %s", t.GetNameS(), url)
+	}
+
+	client, err := httpclient.New(me.Params, nil)
+	if err != nil {
+		return err
+	}
+	me.client = client
+
+	// name of metrics is the name of the family without the prefix
+	me.prefix = me.Object + "_"
+
+	// e.g. if objects are "node" and "node_cpu", the latter gets all "node_cpu_" metrics
+	if objects := me.Params.GetChildS("objects"); objects != nil {
+		for _, o := range objects.GetAllChildNamesS() {
+			if o != me.Object && strings.HasPrefix(o+"_", me.prefix) {
+				me.exclude = append(me.exclude, o+"_")
+			}
+		}
+	}
+
+	// objects that are polled at about the same time share the scrape
+	me.maxAge = me.Schedule.GetTask("data").GetInterval() / 2
+
+	me.Matrix.SetGlobalLabel("hostname", me.Options.Hostname)
+
+	me.Logger.Debug().Msgf("initialized with %d targets", len(me.targets))
+	return nil
+}
+
+// PollData - scrape the targets and rebuild the data cache from
+// the metrics that belong to our object
+func (me *PromScrape) PollData() (*matrix.Matrix, error) {
+
+	var (
+		count        uint64
+		apiT, parseT time.Duration
+		failed, keys []string
+	)
+
+	// instances and metrics might change with every poll
+	me.Matrix = me.Matrix.Clone(false, false, false)
+
+	for _, t := range me.targets {
+
+		samples, rd, pd, err := scrape(me.client, t.url, me.maxAge)
+		apiT += rd
+		parseT += pd
+
+		if err != nil {
+			me.Logger.Warn().Msgf("scrape target (%s): %v", t.name, err)
+			failed = append(failed, t.name)
+			continue
+		}
+
+		for _, s := range samples {
+
+			if !me.wanted(s.Name) {
+				continue
+			}
+
+			labels := make(map[string]string, len(s.Labels)+1)
+			for k, v := range s.Labels {
+				labels[k] = v
+			}
+			if _, has := labels[targetLabel]; !has {
+				labels[targetLabel] = t.name
+			}
+
+			key := instanceKey(labels)
+			instance := me.Matrix.GetInstance(key)
+			if instance == nil {
+				if instance, err = me.Matrix.NewInstance(key); err != nil {
+					return nil, err
+				}
+				for k, v := range labels {
+					instance.SetLabel(k, v)
+				}
+			}
+
+			name := strings.TrimPrefix(s.Name, me.prefix)
+			metric := me.Matrix.GetMetric(name)
+			if metric == nil {
+				if metric, err = me.Matrix.NewMetricFloat64(name); err != nil {
+					return nil, err
+				}
+			}
+
+			if err = metric.SetValueFloat64(instance, s.Value); err != nil {
+				me.Logger.Error().Stack().Err(err).Msgf("set metric (%s) of [%s]", name, key)
+				continue
+			}
+			count++
+		}
+	}
+
+	// all targets failed, most likely exporters are down
+	if len(failed) == len(me.targets) {
+		return nil, errors.New(errors.ERR_CONNECTION, strings.Join(failed, ", "))
+	}
+
+	if len(me.Matrix.GetInstances()) == 0 {
+		return nil, errors.New(errors.ERR_NO_INSTANCE, "no metrics with prefix "+me.prefix)
+	}
+
+	for key := range me.Matrix.GetMetrics() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	me.Logger.Debug().Msgf("collected %d data points of %d metrics: %v", count, len(keys), keys)
+
+	me.Metadata.LazySetValueInt64("api_time", "data", apiT.Microseconds())
+	me.Metadata.LazySetValueInt64("parse_time", "data", parseT.Microseconds())
+	me.Metadata.LazySetValueUint64("count", "data", count)
+	me.AddCollectCount(count)
+
+	return me.Matrix, nil
+}
+
+// wanted - true if metric family name belongs to our object
+func (me *PromScrape) wanted(name string) bool {
+	if !strings.HasPrefix(name, me.prefix) || len(name) == len(me.prefix) {
+		return false
+	}
+	for _, x := range me.exclude {
+		if strings.HasPrefix(name, x) {
+			return false
+		}
+	}
+	return true
+}
+
+// instanceKey - unique key for a set of labels
+func instanceKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// result of scraping a target, shared by the collectors of all objects
+type result struct {
+	sync.Mutex
+	timestamp time.Time
+	samples   []Sample
+	err       error
+}
+
+var (
+	results    = make(map[string]*result)
+	resultsMux sync.Mutex
+)
+
+// scrape - samples of the target at url, if the target was scraped less than maxAge ago
+// the previous result is returned. Returns the time of the request and of parsing.
+func scrape(client *http.Client, url string, maxAge time.Duration) ([]Sample, time.Duration, time.Duration, error) {
+
+	resultsMux.Lock()
+	r, ok := results[url]
+	if !ok {
+		r = &result{}
+		results[url] = r
+	}
+	resultsMux.Unlock()
+
+	// other collectors wait until we are done
+	r.Lock()
+	defer r.Unlock()
+
+	if time.Since(r.timestamp) < maxAge {
+		return r.samples, 0, 0, r.err
+	}
+
+	start := time.Now()
+	data, err := get(client, url)
+	apiT := time.Since(start)
+
+	var parseT time.Duration
+	if err == nil {
+		start = time.Now()
+		r.samples, err = Parse(bytes.NewReader(data))
+		parseT = time.Since(start)
+	}
+
+	r.timestamp = time.Now()
+	r.err = err
+	if err != nil {
+		r.samples = nil
+	}
+	return r.samples, apiT, parseT, err
+}
+
+func get(client *http.Client, url string) ([]byte, error) {
+
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(errors.API_RESPONSE, url+": "+response.Status)
+	}
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+	return data, nil
+}
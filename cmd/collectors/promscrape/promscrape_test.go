@@ -0,0 +1,45 @@
+package promscrape
+
+import (
+	"goharvest2/pkg/set"
+	"testing"
+)
+
+func TestWanted(t *testing.T) {
+
+	me := &PromScrape{prefix: "node_", exclude: []string{"node_cpu_"}}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"node_load1", true},
+		{"node_filesystem_avail_bytes", true},
+		{"node_cpu_seconds_total", false},
+		{"node_", false},
+		{"go_goroutines", false},
+	}
+
+	for _, tt := range tests {
+		if got := me.wanted(tt.name); got != tt.want {
+			t.Errorf("wanted(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInstanceKey(t *testing.T) {
+
+	keys := set.New()
+	for _, labels := range []map[string]string{
+		{"cpu": "0", "mode": "idle"},
+		{"mode": "idle", "cpu": "0"},
+		{"cpu": "0,mode=idle"},
+		{"cpu": "0"},
+	} {
+		keys.Add(instanceKey(labels))
+	}
+
+	if keys.Size() != 3 {
+		t.Errorf("got %d unique keys, want 3", keys.Size())
+	}
+}
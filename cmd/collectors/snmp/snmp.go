@@ -0,0 +1,440 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package snmp collects metrics of switches (and other devices) with
+// SNMPv2c or SNMPv3. Each object is a subtemplate that lists OIDs of
+// either a table (e.g. ifTable, each row is an instance) or of scalars
+// (one instance). Counters are labels or metrics:
+//
+//	counters:
+//	  - ^1.3.6.1.2.1.31.1.1.1.1   => interface
+//	  - 1.3.6.1.2.1.31.1.1.1.6    => recv_bytes (rate)
+//
+// Like ZapiPerf properties, metrics are "raw" (default), "delta" or "rate".
+// Deltas and rates are calculated from the previous poll, wraps of 32-bit
+// counters are handled.
+package snmp
+
+import (
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/api/snmp"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree/node"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// scalars are requested in batches of this size
+const getBatchSize = 20
+
+// OID of sysName, used as global label "device"
+const sysName = "1.3.6.1.2.1.1.5.0"
+
+type Snmp struct {
+	*collector.AbstractCollector
+	client   *snmp.Client
+	isTable  bool
+	counters []*counter
+	prev     *matrix.Matrix // raw values of the previous poll
+	prevTime time.Time
+}
+
+// counter - label or metric, key of metrics is the display name
+type counter struct {
+	oid      string
+	display  string
+	isLabel  bool
+	property string
+	wrap     float64 // 2^32 for Counter32, set when the first value is collected
+}
+
+func init() {
+	plugin.RegisterModule(Snmp{})
+}
+
+func (Snmp) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.snmp",
+		New: func() plugin.Module { return new(Snmp) },
+	}
+}
+
+// Init - initialize the collector
+func (me *Snmp) Init(a *collector.AbstractCollector) error {
+
+	var err error
+
+	me.AbstractCollector = a
+
+	if err = me.loadSubTemplate(); err != nil {
+		return err
+	}
+
+	if err = collector.Init(me); err != nil {
+		return err
+	}
+
+	if object := me.Params.GetChildContentS("object"); object != "" {
+		me.Matrix.Object = object
+	}
+
+	switch t := me.Params.GetChildContentS("type"); t {
+	case "", "table":
+		me.isTable = true
+	case "scalar":
+		me.isTable = false
+	default:
+		return errors.New(errors.INVALID_PARAM, "type: "+t)
+	}
+
+	counters := me.Params.GetChildS("counters")
+	if counters == nil {
+		return errors.New(errors.MISSING_PARAM, "counters")
+	}
+	if err = me.loadCounters(counters); err != nil {
+		return err
+	}
+
+	if me.client, err = snmp.New(me.Params); err != nil {
+		return err
+	}
+
+	// convert to connection error, so poller aborts
+	if err = me.client.Connect(); err != nil {
+		return errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+	me.Logger.Debug().Msgf("connected to: %s", me.client.Info())
+
+	// name of the device, if available
+	if vars, err := me.client.Get(sysName); err == nil && len(vars) == 1 && !vars[0].IsException() {
+		me.Matrix.SetGlobalLabel("device", vars[0].String())
+	} else if err != nil {
+		return errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+
+	me.Logger.Debug().Msgf("initialized with %d counters", len(me.counters))
+	return nil
+}
+
+// loadSubTemplate - merge the subtemplate of our object into the parameters
+func (me *Snmp) loadSubTemplate() error {
+
+	objects := me.Params.GetChildS("objects")
+	if objects == nil {
+		return nil
+	}
+
+	fn := objects.GetChildContentS(me.Object)
+	if fn == "" {
+		return errors.New(errors.MISSING_PARAM, "subtemplate of object "+me.Object)
+	}
+
+	template, err := collector.ImportTemplate(me.Options.HomePath, fn, me.Name)
+	if err != nil {
+		me.Logger.Error().Stack().Err(err).Msgf("Error importing subtemplate: %s", fn)
+		return err
+	}
+	me.Params.Union(template)
+	return nil
+}
+
+// loadCounters - parse counters and add metrics to the matrix
+func (me *Snmp) loadCounters(counters *node.Node) error {
+
+	for _, c := range counters.GetAllChildContentS() {
+
+		cnt, err := parseCounter(c)
+		if err != nil {
+			return err
+		}
+
+		if !cnt.isLabel {
+			metric, err := me.Matrix.NewMetricFloat64(cnt.display)
+			if err != nil {
+				return errors.New(errors.INVALID_PARAM, "counter "+c+": "+err.Error())
+			}
+			metric.SetProperty(cnt.property)
+		}
+
+		me.counters = append(me.counters, cnt)
+		me.Logger.Trace().Msgf("added counter (%s) as [%s] (label=%v, property=%s)", cnt.oid, cnt.display, cnt.isLabel, cnt.property)
+	}
+
+	if len(me.Matrix.GetMetrics()) == 0 && me.Params.GetChildContentS("collect_only_labels") != "true" {
+		return errors.New(errors.ERR_NO_METRIC, "failed to parse any")
+	}
+	return nil
+}
+
+// parseCounter - parse counter definition, e.g. "^1.3.6.1.2.1.31.1.1.1.1 => interface"
+// or "1.3.6.1.2.1.31.1.1.1.6 => recv_bytes (rate)", display name is required
+func parseCounter(content string) (*counter, error) {
+
+	def, err := collector.ParseMetricDef(content)
+	if err != nil {
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+err.Error())
+	}
+
+	cnt := &counter{display: def.Display, isLabel: def.IsLabel, property: "raw"}
+
+	if def.Property != "" {
+		switch cnt.property = def.Property; cnt.property {
+		case "raw", "delta", "rate":
+		default:
+			return nil, errors.New(errors.INVALID_PARAM, "counter "+content+": property "+cnt.property)
+		}
+	}
+
+	if cnt.display == "" {
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+content+": missing display name")
+	}
+	cnt.oid = strings.TrimPrefix(def.Name, ".")
+
+	if !snmp.ValidOID(cnt.oid) {
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+content)
+	}
+	return cnt, nil
+}
+
+// PollData - poll all counters, build instances and calculate deltas and rates from
+// the previous poll. During the first poll, deltas and rates are not exported.
+func (me *Snmp) PollData() (*matrix.Matrix, error) {
+
+	var (
+		count          uint64
+		apiT, parseT   time.Duration
+		values, labels map[*counter]map[string]snmp.Variable
+		err            error
+	)
+
+	now := time.Now()
+
+	if me.isTable {
+		values, labels, err = me.walk()
+	} else {
+		values, labels, err = me.get()
+	}
+	if err != nil {
+		return nil, err
+	}
+	apiT = time.Since(now)
+
+	start := time.Now()
+
+	me.Matrix.PurgeInstances()
+	me.Matrix.Reset()
+
+	// rows are defined by metrics, labels of other rows (e.g.
+	// entPhysicalName of entities that are not sensors) are ignored
+	rows := values
+	if len(me.Matrix.GetMetrics()) == 0 {
+		rows = labels
+	}
+	var indexes []string
+	seen := make(map[string]bool)
+	for _, vars := range rows {
+		for index := range vars {
+			if !seen[index] {
+				seen[index] = true
+				indexes = append(indexes, index)
+			}
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return snmp.CompareOID(indexes[i], indexes[j]) < 0 })
+
+	for _, index := range indexes {
+		instance, err := me.Matrix.NewInstance(index)
+		if err != nil {
+			return nil, err
+		}
+		if me.isTable {
+			instance.SetLabel("index", index)
+		}
+	}
+
+	for cnt, vars := range labels {
+		for index, v := range vars {
+			if instance := me.Matrix.GetInstance(index); instance != nil {
+				instance.SetLabel(cnt.display, v.String())
+				count++
+			}
+		}
+	}
+
+	for cnt, vars := range values {
+		metric := me.Matrix.GetMetric(cnt.display)
+		for index, v := range vars {
+			value, ok := v.Float64()
+			if !ok {
+				me.Logger.Debug().Msgf("(%s) value of [%s] is not numeric: %s", cnt.display, index, v.String())
+				continue
+			}
+			if v.Type == snmp.Counter32 {
+				cnt.wrap = math.Exp2(32)
+			}
+			if err = metric.SetValueFloat64(me.Matrix.GetInstance(index), value); err != nil {
+				me.Logger.Error().Stack().Err(err).Msgf("set metric (%s) of [%s]", cnt.display, index)
+				continue
+			}
+			count++
+		}
+	}
+
+	// cache raw data for next poll
+	cached := me.Matrix.Clone(true, true, true)
+
+	if me.prev == nil {
+		me.Logger.Debug().Msg("skip deltas and rates until next poll (previous cache empty)")
+		for _, cnt := range me.counters {
+			if !cnt.isLabel && cnt.property != "raw" {
+				for _, instance := range me.Matrix.GetInstances() {
+					me.Matrix.GetMetric(cnt.display).SetValueNAN(instance)
+				}
+			}
+		}
+	} else {
+		me.calculate(now.Sub(me.prevTime).Seconds())
+	}
+
+	me.prev = cached
+	me.prevTime = now
+	parseT = time.Since(start)
+
+	me.Logger.Debug().Msgf("collected %d data points of %d instances", count, len(me.Matrix.GetInstances()))
+
+	if len(me.Matrix.GetInstances()) == 0 {
+		return nil, errors.New(errors.ERR_NO_INSTANCE, "")
+	}
+
+	me.Metadata.LazySetValueInt64("api_time", "data", apiT.Microseconds())
+	me.Metadata.LazySetValueInt64("parse_time", "data", parseT.Microseconds())
+	me.Metadata.LazySetValueUint64("count", "data", count)
+	me.AddCollectCount(count)
+
+	return me.Matrix, nil
+}
+
+// walk - walk the column of each counter, returns variables
+// of metrics and of labels, by counter and row index
+func (me *Snmp) walk() (map[*counter]map[string]snmp.Variable, map[*counter]map[string]snmp.Variable, error) {
+
+	values := make(map[*counter]map[string]snmp.Variable)
+	labels := make(map[*counter]map[string]snmp.Variable)
+
+	for _, cnt := range me.counters {
+
+		vars, err := me.client.Walk(cnt.oid)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		column := make(map[string]snmp.Variable, len(vars))
+		for _, v := range vars {
+			column[strings.TrimPrefix(v.OID, cnt.oid+".")] = v
+		}
+
+		if cnt.isLabel {
+			labels[cnt] = column
+		} else {
+			values[cnt] = column
+		}
+		me.Logger.Trace().Msgf("(%s) walked %d rows of %s", cnt.display, len(vars), cnt.oid)
+	}
+	return values, labels, nil
+}
+
+// get - get scalars, the instance key of all variables is "0"
+func (me *Snmp) get() (map[*counter]map[string]snmp.Variable, map[*counter]map[string]snmp.Variable, error) {
+
+	values := make(map[*counter]map[string]snmp.Variable)
+	labels := make(map[*counter]map[string]snmp.Variable)
+
+	for i := 0; i < len(me.counters); i += getBatchSize {
+
+		batch := me.counters[i:]
+		if len(batch) > getBatchSize {
+			batch = batch[:getBatchSize]
+		}
+
+		oids := make([]string, len(batch))
+		for j, cnt := range batch {
+			oids[j] = cnt.oid
+		}
+
+		vars, err := me.client.Get(oids...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for j, v := range vars {
+			if j >= len(batch) || v.IsException() {
+				continue
+			}
+			cnt := batch[j]
+			if cnt.isLabel {
+				labels[cnt] = map[string]snmp.Variable{"0": v}
+			} else {
+				values[cnt] = map[string]snmp.Variable{"0": v}
+			}
+		}
+	}
+	return values, labels, nil
+}
+
+// calculate - deltas and rates from the raw values of the previous poll, matched by
+// instance key. Decreased values are skipped, unless the counter is 32-bit and wrapped.
+func (me *Snmp) calculate(seconds float64) {
+
+	for _, cnt := range me.counters {
+
+		if cnt.isLabel || cnt.property == "raw" {
+			continue
+		}
+
+		metric := me.Matrix.GetMetric(cnt.display)
+		prevMetric := me.prev.GetMetric(cnt.display)
+
+		for key, instance := range me.Matrix.GetInstances() {
+
+			value, ok := metric.GetValueFloat64(instance)
+			if !ok {
+				continue
+			}
+
+			prevInstance := me.prev.GetInstance(key)
+			if prevInstance == nil {
+				metric.SetValueNAN(instance)
+				continue
+			}
+			prevValue, ok := prevMetric.GetValueFloat64(prevInstance)
+			if !ok {
+				metric.SetValueNAN(instance)
+				continue
+			}
+
+			delta := value - prevValue
+			if delta < 0 {
+				if cnt.wrap == 0 || prevValue >= cnt.wrap {
+					me.Logger.Debug().Msgf("(%s) counter of [%s] decreased, skipping", cnt.display, key)
+					metric.SetValueNAN(instance)
+					continue
+				}
+				delta += cnt.wrap
+			}
+
+			if cnt.property == "rate" {
+				if seconds <= 0 {
+					metric.SetValueNAN(instance)
+					continue
+				}
+				delta /= seconds
+			}
+			_ = metric.SetValueFloat64(instance, delta)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package snmp
+
+import (
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/api/snmp"
+	"goharvest2/pkg/tree"
+	"testing"
+	"time"
+)
+
+const template = `
+schedule:
+  - data: 60s
+object: switch_interface
+snmp_community: secret
+client_timeout: 1
+snmp_max_repetitions: 2
+counters:
+  - ^1.3.6.1.2.1.31.1.1.1.1  => interface
+  - 1.3.6.1.2.1.2.2.1.8      => oper_status
+  - 1.3.6.1.2.1.2.2.1.10     => recv_octets (delta)
+  - 1.3.6.1.2.1.31.1.1.1.6   => recv_bytes (rate)
+`
+
+// switch with two interfaces, and a name of an entity
+// that is not an interface that should be ignored
+func newAgent(t *testing.T, octets, bytes uint64) *snmp.Agent {
+	agent, err := snmp.NewAgent("secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setCounters(agent, octets, bytes)
+	agent.Set(
+		snmp.Variable{OID: "1.3.6.1.2.1.1.5.0", Type: snmp.OctetString, Value: []byte("switch1")},
+		snmp.Variable{OID: "1.3.6.1.2.1.2.2.1.8.1", Type: snmp.Integer, Value: int64(1)},
+		snmp.Variable{OID: "1.3.6.1.2.1.2.2.1.8.2", Type: snmp.Integer, Value: int64(2)},
+		snmp.Variable{OID: "1.3.6.1.2.1.31.1.1.1.1.1", Type: snmp.OctetString, Value: []byte("eth1")},
+		snmp.Variable{OID: "1.3.6.1.2.1.31.1.1.1.1.2", Type: snmp.OctetString, Value: []byte("eth2")},
+		snmp.Variable{OID: "1.3.6.1.2.1.31.1.1.1.1.99", Type: snmp.OctetString, Value: []byte("chassis")},
+	)
+	return agent
+}
+
+func setCounters(agent *snmp.Agent, octets, bytes uint64) {
+	agent.Set(
+		snmp.Variable{OID: "1.3.6.1.2.1.2.2.1.10.1", Type: snmp.Counter32, Value: octets},
+		snmp.Variable{OID: "1.3.6.1.2.1.2.2.1.10.2", Type: snmp.Counter32, Value: uint64(0)},
+		snmp.Variable{OID: "1.3.6.1.2.1.31.1.1.1.6.1", Type: snmp.Counter64, Value: bytes},
+		snmp.Variable{OID: "1.3.6.1.2.1.31.1.1.1.6.2", Type: snmp.Counter64, Value: uint64(0)},
+	)
+}
+
+func TestPollData(t *testing.T) {
+
+	agent := newAgent(t, 4294967000, 1000)
+	addr, err := agent.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Stop()
+
+	params, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.NewChildS("addr", addr)
+
+	c := &Snmp{AbstractCollector: collector.New("Snmp", "Interface", &options.Options{}, params)}
+	if err = c.Init(c.AbstractCollector); err != nil {
+		t.Fatal(err)
+	}
+
+	if device := c.Matrix.GetGlobalLabels().Get("device"); device != "switch1" {
+		t.Errorf("device = %q, want switch1", device)
+	}
+
+	data, err := c.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(data.GetInstances()); n != 2 {
+		t.Fatalf("got %d instances, want 2", n)
+	}
+	eth1 := data.GetInstance("1")
+	if eth1 == nil || eth1.GetLabel("interface") != "eth1" || eth1.GetLabel("index") != "1" {
+		t.Fatalf("labels of eth1: %v", eth1)
+	}
+	if status, ok := data.GetMetric("oper_status").GetValueFloat64(data.GetInstance("2")); !ok || status != 2 {
+		t.Errorf("oper_status of eth2 = %v (%v)", status, ok)
+	}
+	// no deltas and rates without previous poll
+	if _, ok := data.GetMetric("recv_bytes").GetValueFloat64(eth1); ok {
+		t.Errorf("recv_bytes of first poll should be skipped")
+	}
+
+	// 32-bit counter wraps, 64-bit counter increases by 2000
+	setCounters(agent, 704, 3000)
+	c.prevTime = c.prevTime.Add(-2 * time.Second)
+
+	if data, err = c.PollData(); err != nil {
+		t.Fatal(err)
+	}
+	eth1 = data.GetInstance("1")
+
+	if delta, ok := data.GetMetric("recv_octets").GetValueFloat64(eth1); !ok || delta != 1000 {
+		t.Errorf("recv_octets of eth1 = %v (%v), want 1000", delta, ok)
+	}
+	// elapsed time is slightly more than 2 seconds
+	if rate, ok := data.GetMetric("recv_bytes").GetValueFloat64(eth1); !ok || rate > 1000 || rate < 900 {
+		t.Errorf("recv_bytes of eth1 = %v (%v), want ~1000", rate, ok)
+	}
+	if rate, ok := data.GetMetric("recv_bytes").GetValueFloat64(data.GetInstance("2")); !ok || rate != 0 {
+		t.Errorf("recv_bytes of eth2 = %v (%v), want 0", rate, ok)
+	}
+}
+
+func TestParseCounter(t *testing.T) {
+
+	tests := []struct {
+		content  string
+		oid      string
+		display  string
+		isLabel  bool
+		property string
+	}{
+		{"^1.3.6.1.2.1.31.1.1.1.1 => interface", "1.3.6.1.2.1.31.1.1.1.1", "interface", true, "raw"},
+		{".1.3.6.1.2.1.31.1.1.1.6 => recv_bytes (rate)", "1.3.6.1.2.1.31.1.1.1.6", "recv_bytes", false, "rate"},
+		{"1.3.6.1.2.1.2.2.1.10 => recv_octets (delta)", "1.3.6.1.2.1.2.2.1.10", "recv_octets", false, "delta"},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCounter(tt.content)
+		if err != nil {
+			t.Errorf("%s: %v", tt.content, err)
+			continue
+		}
+		if c.oid != tt.oid || c.display != tt.display || c.isLabel != tt.isLabel || c.property != tt.property {
+			t.Errorf("%s: got %+v", tt.content, c)
+		}
+	}
+
+	for _, invalid := range []string{"", "1.3.6.1.2.1.1.3.0", "ifName => interface", "1.3.6.1 => x (average)"} {
+		if _, err := parseCounter(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
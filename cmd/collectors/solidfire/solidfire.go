@@ -0,0 +1,494 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package solidfire collects metrics of SolidFire clusters with the Element
+// JSON-RPC API. Each object calls one method in the data poll and maps the
+// elements at the records path of the result to instances:
+//
+//	method: ListVolumeStats
+//	records: volumeStats
+//	counters:
+//	  - ^^volumeID      => volume_id
+//	  - readOps         => read_ops (rate)
+//	  - latencyUSec     => latency
+//
+// Counters have the same syntax as the Http collector and a property: raw
+// (default), delta or rate. Like ZapiPerf, delta and rate are calculated
+// from the previous poll, so cumulative counters are exported only after the
+// second poll. Optionally, the instance poll calls another method to collect
+// labels, e.g. the names of volumes:
+//
+//	instances:
+//	  method: ListVolumes
+//	  records: volumes
+//	  counters:
+//	    - ^^volumeID    => volume_id
+//	    - ^name         => volume
+package solidfire
+
+import (
+	"encoding/json"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/api/solidfire"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree/node"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SolidFire struct {
+	*collector.AbstractCollector
+	client    *solidfire.Client
+	data      *request
+	instances *request                     // optional
+	labels    map[string]map[string]string // of instances, by instance key
+	hasDeltas bool                         // true if any counter is delta or rate
+	cache     *matrix.Matrix               // raw values of the previous poll
+}
+
+// request - method and how to map its result to instances
+type request struct {
+	method   string
+	params   map[string]interface{}
+	records  []string
+	counters []*counter
+}
+
+type counter struct {
+	path     []string
+	key      string
+	display  string
+	property string
+	isLabel  bool
+	isKey    bool
+}
+
+func init() {
+	plugin.RegisterModule(SolidFire{})
+}
+
+func (SolidFire) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.solidfire",
+		New: func() plugin.Module { return new(SolidFire) },
+	}
+}
+
+// Init - initialize the collector and connect to the cluster
+func (me *SolidFire) Init(a *collector.AbstractCollector) error {
+
+	var err error
+
+	me.AbstractCollector = a
+
+	if err = me.loadSubTemplate(); err != nil {
+		return err
+	}
+
+	if err = collector.Init(me); err != nil {
+		return err
+	}
+
+	if object := me.Params.GetChildContentS("object"); object != "" {
+		me.Matrix.Object = object
+	}
+
+	if me.data, err = parseRequest(me.Params); err != nil {
+		return err
+	}
+
+	if instances := me.Params.GetChildS("instances"); instances != nil {
+		if me.instances, err = parseRequest(instances); err != nil {
+			return errors.New(errors.INVALID_PARAM, "instances: "+err.Error())
+		}
+		for _, cnt := range me.instances.counters {
+			if !cnt.isLabel {
+				return errors.New(errors.INVALID_PARAM, "instances: counter "+cnt.key+" is not a label")
+			}
+		}
+	}
+
+	for _, cnt := range me.data.counters {
+		if cnt.isLabel {
+			continue
+		}
+		metric, err := me.Matrix.NewMetricFloat64(cnt.display)
+		if err != nil {
+			return errors.New(errors.INVALID_PARAM, "counter "+cnt.key+": "+err.Error())
+		}
+		metric.SetProperty(cnt.property)
+		if cnt.property != "raw" {
+			me.hasDeltas = true
+		}
+		me.Logger.Trace().Msgf("added counter (%s) as [%s] (%s)", cnt.key, cnt.display, cnt.property)
+	}
+
+	if len(me.Matrix.GetMetrics()) == 0 && me.Params.GetChildContentS("collect_only_labels") != "true" {
+		return errors.New(errors.ERR_NO_METRIC, "failed to parse any")
+	}
+
+	// time of each instance's values, for calculating rates
+	timestamp, err := me.Matrix.NewMetricFloat64("timestamp")
+	if err != nil {
+		return err
+	}
+	timestamp.SetProperty("raw")
+	timestamp.SetExportable(false)
+
+	if me.client, err = solidfire.New(me.Params); err != nil {
+		return err
+	}
+
+	// convert to connection error, so poller aborts
+	version, err := me.client.Version()
+	if err != nil {
+		return errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+	me.Logger.Debug().Msgf("connected to Element OS %s", version)
+
+	me.Matrix.SetGlobalLabel("cluster", me.Params.GetChildContentS("addr"))
+	return nil
+}
+
+// loadSubTemplate - merge the subtemplate of our object into the parameters
+func (me *SolidFire) loadSubTemplate() error {
+
+	objects := me.Params.GetChildS("objects")
+	if objects == nil {
+		return nil
+	}
+
+	fn := objects.GetChildContentS(me.Object)
+	if fn == "" {
+		return errors.New(errors.MISSING_PARAM, "subtemplate of object "+me.Object)
+	}
+
+	template, err := collector.ImportTemplate(me.Options.HomePath, fn, me.Name)
+	if err != nil {
+		me.Logger.Error().Stack().Err(err).Msgf("Error importing subtemplate: %s", fn)
+		return err
+	}
+	me.Params.Union(template)
+	return nil
+}
+
+// parseRequest - parse method, params, records and counters
+func parseRequest(n *node.Node) (*request, error) {
+
+	r := &request{params: make(map[string]interface{})}
+
+	if r.method = n.GetChildContentS("method"); r.method == "" {
+		return nil, errors.New(errors.MISSING_PARAM, "method")
+	}
+
+	// values are JSON (numbers, true, false), or strings
+	if params := n.GetChildS("params"); params != nil {
+		for _, p := range params.GetChildren() {
+			var value interface{}
+			if err := json.Unmarshal(p.GetContent(), &value); err != nil {
+				value = p.GetContentS()
+			}
+			r.params[p.GetNameS()] = value
+		}
+	}
+
+	if records := strings.TrimSpace(n.GetChildContentS("records")); records != "" {
+		r.records = strings.Split(records, ".")
+	}
+
+	counters := n.GetChildS("counters")
+	if counters == nil {
+		return nil, errors.New(errors.MISSING_PARAM, "counters")
+	}
+
+	for _, c := range counters.GetAllChildContentS() {
+		cnt, err := parseCounter(c)
+		if err != nil {
+			return nil, err
+		}
+		r.counters = append(r.counters, cnt)
+	}
+	return r, nil
+}
+
+// parseCounter - parse counter definition, e.g. "^^volumeID => volume_id",
+// "^name => volume" or "readOps => read_ops (rate)". Display name defaults to
+// the path with underscores, property to raw.
+func parseCounter(content string) (*counter, error) {
+
+	def, err := collector.ParseMetricDef(content)
+	if err != nil {
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+err.Error())
+	}
+
+	cnt := &counter{display: def.Display, isLabel: def.IsLabel, isKey: def.IsKey, property: "raw"}
+
+	if def.Property != "" {
+		cnt.property = def.Property
+	}
+	switch cnt.property {
+	case "raw", "delta", "rate":
+	default:
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+content+": property "+cnt.property)
+	}
+
+	if cnt.isLabel && cnt.property != "raw" {
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+content)
+	}
+	cnt.path = strings.Split(def.Name, ".")
+	cnt.key = def.Name
+
+	if cnt.display == "" {
+		cnt.display = strings.ReplaceAll(cnt.key, ".", "_")
+	}
+	return cnt, nil
+}
+
+// PollInstance - update labels of instances, if the object has an instances method
+func (me *SolidFire) PollInstance() (*matrix.Matrix, error) {
+
+	if me.instances == nil {
+		return nil, nil
+	}
+
+	records, err := me.call(me.instances)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]map[string]string, len(records))
+	for i, record := range records {
+		values := make(map[string]string)
+		for _, cnt := range me.instances.counters {
+			if value := valueOf(record, cnt); value != "" {
+				values[cnt.display] = value
+			}
+		}
+		labels[instanceKey(me.instances, record, i)] = values
+	}
+
+	me.Logger.Debug().Msgf("updated labels of %d instances (had %d)", len(labels), len(me.labels))
+	me.labels = labels
+
+	if len(labels) == 0 {
+		return nil, errors.New(errors.ERR_NO_INSTANCE, "")
+	}
+	return nil, nil
+}
+
+// PollData - call the method of the object and calculate deltas and rates
+// from the previous poll
+func (me *SolidFire) PollData() (*matrix.Matrix, error) {
+
+	var count, skipped uint64
+
+	start := time.Now()
+	records, err := me.call(me.data)
+	if err != nil {
+		return nil, err
+	}
+	apiT := time.Since(start)
+
+	start = time.Now()
+
+	// new instances with each poll, deltas are calculated by instance key
+	newData := me.Matrix.Clone(false, true, false)
+	newData.Reset()
+
+	timestamp := newData.GetMetric("timestamp")
+	ts := float64(time.Now().UnixNano()) / 1e9
+
+	for i, record := range records {
+
+		key := instanceKey(me.data, record, i)
+
+		var labels map[string]string
+		if me.instances != nil {
+			var ok bool
+			if labels, ok = me.labels[key]; !ok {
+				me.Logger.Debug().Msgf("skip instance [%s], not found in instance poll", key)
+				continue
+			}
+		}
+
+		instance, err := newData.NewInstance(key)
+		if err != nil {
+			me.Logger.Warn().Msgf("add instance [%s]: %v", key, err)
+			continue
+		}
+		for k, v := range labels {
+			instance.SetLabel(k, v)
+		}
+
+		for _, cnt := range me.data.counters {
+
+			value := valueOf(record, cnt)
+			if value == "" {
+				skipped++
+				continue
+			}
+
+			if cnt.isLabel {
+				instance.SetLabel(cnt.display, value)
+				count++
+				continue
+			}
+
+			switch value {
+			case "true":
+				value = "1"
+			case "false":
+				value = "0"
+			}
+			if err = newData.GetMetric(cnt.display).SetValueString(instance, value); err != nil {
+				me.Logger.Debug().Msgf("set metric (%s) of [%s] to (%s): %v", cnt.display, key, value, err)
+				skipped++
+				continue
+			}
+			count++
+		}
+
+		if err = timestamp.SetValueFloat64(instance, ts); err != nil {
+			me.Logger.Error().Stack().Err(err).Msg("set timestamp value: ")
+		}
+	}
+
+	me.Logger.Debug().Msgf("collected %d data points of %d instances, skipped %d values", count, len(newData.GetInstances()), skipped)
+
+	me.Metadata.LazySetValueInt64("api_time", "data", apiT.Microseconds())
+	me.Metadata.LazySetValueInt64("parse_time", "data", time.Since(start).Microseconds())
+	me.Metadata.LazySetValueUint64("count", "data", count)
+	me.AddCollectCount(count)
+
+	if len(newData.GetInstances()) == 0 {
+		return nil, errors.New(errors.ERR_NO_INSTANCE, "")
+	}
+
+	me.Matrix = newData
+
+	if !me.hasDeltas {
+		return newData, nil
+	}
+
+	// cache raw data for next poll
+	cachedData := newData.Clone(true, true, true)
+	prevData := me.cache
+	me.cache = cachedData
+
+	// skip calculating from delta if no data from previous poll
+	if prevData == nil {
+		me.Logger.Debug().Msg("skip postprocessing until next poll (previous cache empty)")
+		return nil, nil
+	}
+
+	calcStart := time.Now()
+	me.calculate(newData, prevData)
+	me.Metadata.LazySetValueInt64("calc_time", "data", time.Since(calcStart).Microseconds())
+
+	return newData, nil
+}
+
+// calculate - replace values of delta and rate counters with the difference to
+// the previous poll, per second for rates. Values of new instances and counters
+// that were reset (negative delta) are skipped.
+func (me *SolidFire) calculate(newData, prevData *matrix.Matrix) {
+
+	timestamp := newData.GetMetric("timestamp")
+	prevTimestamp := prevData.GetMetric("timestamp")
+
+	for key, instance := range newData.GetInstances() {
+
+		prevInstance := prevData.GetInstance(key)
+
+		var elapsed float64
+		if prevInstance != nil {
+			ts, _ := timestamp.GetValueFloat64(instance)
+			prevTs, _ := prevTimestamp.GetValueFloat64(prevInstance)
+			elapsed = ts - prevTs
+		}
+
+		for name, metric := range newData.GetMetrics() {
+
+			property := metric.GetProperty()
+			if property == "raw" {
+				continue
+			}
+
+			value, ok := metric.GetValueFloat64(instance)
+			if !ok {
+				continue
+			}
+
+			if prevInstance == nil {
+				metric.SetValueNAN(instance)
+				continue
+			}
+
+			prev, ok := prevData.GetMetric(name).GetValueFloat64(prevInstance)
+			if !ok || value < prev {
+				if ok {
+					me.Logger.Debug().Msgf("(%s) of [%s] was reset: %f => %f", name, key, prev, value)
+				}
+				metric.SetValueNAN(instance)
+				continue
+			}
+
+			delta := value - prev
+			if property == "rate" {
+				if elapsed <= 0 {
+					metric.SetValueNAN(instance)
+					continue
+				}
+				delta /= elapsed
+			}
+
+			if err := metric.SetValueFloat64(instance, delta); err != nil {
+				me.Logger.Error().Stack().Err(err).Msgf("(%s) calculate %s: ", name, property)
+			}
+		}
+	}
+}
+
+// call - invoke method of the request and return the records
+func (me *SolidFire) call(r *request) ([]*node.Node, error) {
+
+	result, err := me.client.Call(r.method, r.params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.records) == 0 {
+		return []*node.Node{result}, nil
+	}
+	return result.SearchChildren(r.records), nil
+}
+
+// instanceKey - values of instance keys joined with ".", or the index of the
+// record if the request has no keys (e.g. GetClusterCapacity)
+func instanceKey(r *request, record *node.Node, index int) string {
+	var keys []string
+	for _, cnt := range r.counters {
+		if cnt.isKey {
+			keys = append(keys, valueOf(record, cnt))
+		}
+	}
+	if len(keys) == 0 {
+		return strconv.Itoa(index)
+	}
+	return strings.Join(keys, ".")
+}
+
+// valueOf - content of the counter in the record
+func valueOf(record *node.Node, cnt *counter) string {
+	n := record
+	for _, name := range cnt.path {
+		if n = n.GetChildS(name); n == nil {
+			return ""
+		}
+	}
+	return n.GetContentS()
+}
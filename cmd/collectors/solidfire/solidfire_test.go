@@ -0,0 +1,216 @@
+package solidfire
+
+import (
+	"encoding/json"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/tree"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+const template = `
+schedule:
+  - instance: 600s
+  - data: 60s
+scheme: http
+username: admin
+password: secret
+object: solidfire_volume
+method: ListVolumeStats
+params:
+  volumeIDs: [1, 2, 3]
+records: volumeStats
+counters:
+  - ^^volumeID      => volume_id
+  - readOps         => read_ops (rate)
+  - writeBytes      => write_data (delta)
+  - latencyUSec     => latency
+instances:
+  method: ListVolumes
+  records: volumes
+  counters:
+    - ^^volumeID    => volume_id
+    - ^name         => volume
+    - ^access
+`
+
+// cluster - fake Element API, stats of volumes are set by the test
+type cluster struct {
+	mu     sync.Mutex
+	stats  []map[string]interface{}
+	params map[string]interface{}
+}
+
+func newServer(t *testing.T, c *cluster) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" || r.URL.Path != "/json-rpc/10.0" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+			Id     int                    `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		var result interface{}
+		switch req.Method {
+		case "GetClusterVersionInfo":
+			result = map[string]interface{}{"clusterVersion": "12.3.0.958"}
+		case "ListVolumes":
+			result = map[string]interface{}{"volumes": []interface{}{
+				map[string]interface{}{"volumeID": 1, "name": "vol1", "access": "readWrite"},
+				map[string]interface{}{"volumeID": 2, "name": "vol2", "access": "readOnly"},
+			}}
+		case "ListVolumeStats":
+			c.params = req.Params
+			stats := make([]interface{}, len(c.stats))
+			for i, s := range c.stats {
+				stats[i] = s
+			}
+			result = map[string]interface{}{"volumeStats": stats}
+		default:
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":    req.Id,
+				"error": map[string]interface{}{"code": 500, "name": "xUnknownAPIMethod", "message": "Unknown method"},
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": req.Id, "result": result})
+	}))
+}
+
+func TestPollData(t *testing.T) {
+
+	c := &cluster{}
+	server := newServer(t, c)
+	defer server.Close()
+
+	params, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.NewChildS("addr", server.Listener.Addr().String())
+
+	sf := &SolidFire{AbstractCollector: collector.New("SolidFire", "Volume", &options.Options{}, params)}
+	if err = sf.Init(sf.AbstractCollector); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = sf.PollInstance(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.stats = []map[string]interface{}{
+		{"volumeID": 1, "readOps": 1000, "writeBytes": 4096, "latencyUSec": 250},
+		{"volumeID": 2, "readOps": 500, "writeBytes": 8192, "latencyUSec": 100},
+		{"volumeID": 3, "readOps": 1, "writeBytes": 1, "latencyUSec": 1}, // not in ListVolumes
+	}
+
+	// first poll only caches raw values
+	data, err := sf.PollData()
+	if err != nil || data != nil {
+		t.Fatalf("first poll: %v, %v", data, err)
+	}
+
+	if ids, ok := c.params["volumeIDs"].([]interface{}); !ok || len(ids) != 3 {
+		t.Errorf("params of ListVolumeStats: %v", c.params)
+	}
+
+	c.mu.Lock()
+	c.stats = []map[string]interface{}{
+		{"volumeID": 1, "readOps": 1300, "writeBytes": 6144, "latencyUSec": 300},
+		{"volumeID": 2, "readOps": 20, "writeBytes": 8192, "latencyUSec": 90}, // volume was moved, counters reset
+	}
+	c.mu.Unlock()
+
+	// make elapsed time predictable
+	vol1 := sf.cache.GetInstance("1")
+	if err = sf.cache.GetMetric("timestamp").SetValueFloat64(vol1, 0); err != nil {
+		t.Fatal(err)
+	}
+	vol2 := sf.cache.GetInstance("2")
+	if err = sf.cache.GetMetric("timestamp").SetValueFloat64(vol2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = sf.PollData()
+	if err != nil || data == nil {
+		t.Fatalf("second poll: %v, %v", data, err)
+	}
+
+	if n := len(data.GetInstances()); n != 2 {
+		t.Fatalf("got %d instances, want 2", n)
+	}
+
+	vol1 = data.GetInstance("1")
+	if vol1.GetLabel("volume") != "vol1" || vol1.GetLabel("access") != "readWrite" || vol1.GetLabel("volume_id") != "1" {
+		t.Errorf("labels of vol1: %v", vol1.GetLabels())
+	}
+
+	ts, _ := data.GetMetric("timestamp").GetValueFloat64(vol1)
+	if v, ok := data.GetMetric("read_ops").GetValueFloat64(vol1); !ok || v != 300/ts {
+		t.Errorf("read_ops of vol1 = %v (%v), want %v", v, ok, 300/ts)
+	}
+	if v, ok := data.GetMetric("write_data").GetValueFloat64(vol1); !ok || v != 2048 {
+		t.Errorf("write_data of vol1 = %v (%v)", v, ok)
+	}
+	if v, ok := data.GetMetric("latency").GetValueFloat64(vol1); !ok || v != 300 {
+		t.Errorf("latency of vol1 = %v (%v)", v, ok)
+	}
+
+	vol2 = data.GetInstance("2")
+	if _, ok := data.GetMetric("read_ops").GetValueFloat64(vol2); ok {
+		t.Error("read_ops of vol2 should be skipped after reset")
+	}
+	if v, ok := data.GetMetric("write_data").GetValueFloat64(vol2); !ok || v != 0 {
+		t.Errorf("write_data of vol2 = %v (%v)", v, ok)
+	}
+}
+
+func TestParseCounter(t *testing.T) {
+
+	tests := []struct {
+		content  string
+		key      string
+		display  string
+		property string
+		isLabel  bool
+		isKey    bool
+	}{
+		{"^^volumeID => volume_id", "volumeID", "volume_id", "raw", true, true},
+		{"readOps => read_ops (rate)", "readOps", "read_ops", "rate", false, false},
+		{"qos.curve.4096 (delta)", "qos.curve.4096", "qos_curve_4096", "delta", false, false},
+		{"latencyUSec", "latencyUSec", "latencyUSec", "raw", false, false},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCounter(tt.content)
+		if err != nil {
+			t.Errorf("%s: %v", tt.content, err)
+			continue
+		}
+		if c.key != tt.key || c.display != tt.display || c.property != tt.property || c.isLabel != tt.isLabel || c.isKey != tt.isKey {
+			t.Errorf("%s: got %+v", tt.content, c)
+		}
+	}
+
+	for _, invalid := range []string{"", "^^", "readOps (average)", "^name (rate)"} {
+		if _, err := parseCounter(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
@@ -0,0 +1,544 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package storagegrid collects metrics and inventory of StorageGRID with the
+// grid management API. Objects are subtemplates of one of two types:
+//
+// "prometheus" objects evaluate Prometheus queries with the metric query
+// endpoint of the grid. Each query is a metric, samples with the same
+// labels are one instance:
+//
+//	queries:
+//	  - storagegrid_node_cpu_utilization_percentage  => cpu_busy
+//
+// "rest" objects request an endpoint of the API, each element at the records
+// path is an instance. Counters have the same syntax as the Http collector,
+// "../" refers to the parent element (e.g. the site of a node in the
+// topology). With for_each_tenant, the endpoint is requested for each tenant
+// account and "@" refers to the account:
+//
+//	endpoint: grid/accounts/{tenant_id}/usage
+//	for_each_tenant: true
+//	records: data.buckets
+//	counters:
+//	  - ^^name                   => bucket
+//	  - dataBytes                => used_bytes
+//	  - @policy.quotaObjectBytes => quota_bytes
+package storagegrid
+
+import (
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/api/storagegrid"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree/node"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tenant accounts are requested in pages of this size
+const accountsPageSize = 1000
+
+type StorageGrid struct {
+	*collector.AbstractCollector
+	client        *storagegrid.Client
+	queries       []*query // prometheus objects
+	endpoint      string   // rest objects
+	forEachTenant bool
+	records       []string // path to the records in the response
+	counters      []*counter
+}
+
+type query struct {
+	expr    string
+	display string
+}
+
+// counter - instance key, label or metric of a record, path is relative
+// to the record, its parent (up levels) or the tenant account
+type counter struct {
+	path    []string
+	up      int
+	tenant  bool
+	key     string
+	display string
+	isLabel bool
+	isKey   bool
+}
+
+func init() {
+	plugin.RegisterModule(StorageGrid{})
+}
+
+func (StorageGrid) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.storagegrid",
+		New: func() plugin.Module { return new(StorageGrid) },
+	}
+}
+
+// Init - initialize the collector and log in
+func (me *StorageGrid) Init(a *collector.AbstractCollector) error {
+
+	var err error
+
+	me.AbstractCollector = a
+
+	if err = me.loadSubTemplate(); err != nil {
+		return err
+	}
+
+	if err = collector.Init(me); err != nil {
+		return err
+	}
+
+	if object := me.Params.GetChildContentS("object"); object != "" {
+		me.Matrix.Object = object
+	}
+
+	switch t := me.Params.GetChildContentS("type"); t {
+	case "prometheus":
+		err = me.loadQueries()
+	case "rest":
+		err = me.loadEndpoint()
+	default:
+		err = errors.New(errors.INVALID_PARAM, "type: "+t)
+	}
+	if err != nil {
+		return err
+	}
+
+	if me.client, err = storagegrid.New(me.Params); err != nil {
+		return err
+	}
+
+	// convert to connection error, so poller aborts
+	version, err := me.client.Version()
+	if err != nil {
+		return errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+	me.Logger.Debug().Msgf("connected to StorageGRID %s", version)
+
+	me.Matrix.SetGlobalLabel("grid", me.Params.GetChildContentS("addr"))
+	return nil
+}
+
+// loadSubTemplate - merge the subtemplate of our object into the parameters
+func (me *StorageGrid) loadSubTemplate() error {
+
+	objects := me.Params.GetChildS("objects")
+	if objects == nil {
+		return nil
+	}
+
+	fn := objects.GetChildContentS(me.Object)
+	if fn == "" {
+		return errors.New(errors.MISSING_PARAM, "subtemplate of object "+me.Object)
+	}
+
+	template, err := collector.ImportTemplate(me.Options.HomePath, fn, me.Name)
+	if err != nil {
+		me.Logger.Error().Stack().Err(err).Msgf("Error importing subtemplate: %s", fn)
+		return err
+	}
+	me.Params.Union(template)
+	return nil
+}
+
+// loadQueries - parse queries ("expr => name") and add metrics to the matrix
+func (me *StorageGrid) loadQueries() error {
+
+	queries := me.Params.GetChildS("queries")
+	if queries == nil {
+		return errors.New(errors.MISSING_PARAM, "queries")
+	}
+
+	for _, q := range queries.GetAllChildContentS() {
+
+		// expressions might contain "=>" only as part of the display name
+		i := strings.LastIndex(q, "=>")
+		if i == -1 {
+			return errors.New(errors.INVALID_PARAM, "query "+q+": missing display name")
+		}
+		qr := &query{expr: strings.TrimSpace(q[:i]), display: strings.TrimSpace(q[i+2:])}
+		if qr.expr == "" || qr.display == "" {
+			return errors.New(errors.INVALID_PARAM, "query "+q)
+		}
+
+		if _, err := me.Matrix.NewMetricFloat64(qr.display); err != nil {
+			return errors.New(errors.INVALID_PARAM, "query "+q+": "+err.Error())
+		}
+		me.queries = append(me.queries, qr)
+		me.Logger.Trace().Msgf("added query (%s) as [%s]", qr.expr, qr.display)
+	}
+
+	if len(me.queries) == 0 {
+		return errors.New(errors.ERR_NO_METRIC, "no queries")
+	}
+	return nil
+}
+
+// loadEndpoint - parse endpoint, records and counters of rest objects
+func (me *StorageGrid) loadEndpoint() error {
+
+	if me.endpoint = me.Params.GetChildContentS("endpoint"); me.endpoint == "" {
+		return errors.New(errors.MISSING_PARAM, "endpoint")
+	}
+	me.forEachTenant = me.Params.GetChildContentS("for_each_tenant") == "true"
+	if me.forEachTenant && !strings.Contains(me.endpoint, "{tenant_id}") {
+		return errors.New(errors.INVALID_PARAM, "endpoint: missing {tenant_id}")
+	}
+
+	if records := strings.TrimSpace(me.Params.GetChildContentS("records")); records != "" {
+		me.records = strings.Split(records, ".")
+	}
+
+	counters := me.Params.GetChildS("counters")
+	if counters == nil {
+		return errors.New(errors.MISSING_PARAM, "counters")
+	}
+
+	keys := 0
+	for _, c := range counters.GetAllChildContentS() {
+
+		cnt, err := parseCounter(c)
+		if err != nil {
+			return err
+		}
+
+		if cnt.isKey {
+			keys++
+		}
+
+		if !cnt.isLabel {
+			if _, err = me.Matrix.NewMetricFloat64(cnt.display); err != nil {
+				return errors.New(errors.INVALID_PARAM, "counter "+c+": "+err.Error())
+			}
+		}
+
+		me.counters = append(me.counters, cnt)
+		me.Logger.Trace().Msgf("added counter (%s) as [%s] (label=%v, key=%v)", cnt.key, cnt.display, cnt.isLabel, cnt.isKey)
+	}
+
+	// with for_each_tenant, the tenant is part of the instance key
+	if keys == 0 && !me.forEachTenant {
+		return errors.New(errors.MISSING_PARAM, "no instance keys indicated")
+	}
+
+	if len(me.Matrix.GetMetrics()) == 0 && me.Params.GetChildContentS("collect_only_labels") != "true" {
+		return errors.New(errors.ERR_NO_METRIC, "failed to parse any")
+	}
+	return nil
+}
+
+// parseCounter - parse counter definition, e.g. "^^id => node_id", "^../name => site"
+// or "@policy.quotaObjectBytes => quota_bytes". Display name defaults to the
+// path with underscores.
+func parseCounter(content string) (*counter, error) {
+
+	def, err := collector.ParseMetricDef(content)
+	if err != nil {
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+err.Error())
+	}
+
+	cnt := &counter{display: def.Display, isLabel: def.IsLabel, isKey: def.IsKey}
+	s := def.Name
+
+	if strings.HasPrefix(s, "@") {
+		cnt.tenant = true
+		s = strings.TrimPrefix(s, "@")
+	}
+	for strings.HasPrefix(s, "../") {
+		cnt.up++
+		s = strings.TrimPrefix(s, "../")
+	}
+
+	if s == "" || def.Property != "" || (cnt.tenant && cnt.up != 0) {
+		return nil, errors.New(errors.INVALID_PARAM, "counter "+content)
+	}
+	cnt.path = strings.Split(s, ".")
+	cnt.key = s
+
+	if cnt.display == "" {
+		cnt.display = strings.ReplaceAll(cnt.key, ".", "_")
+	}
+	return cnt, nil
+}
+
+// PollData - rebuild the data cache with the results of the queries or records of the endpoint
+func (me *StorageGrid) PollData() (*matrix.Matrix, error) {
+
+	var (
+		count        uint64
+		apiT, parseT time.Duration
+		err          error
+	)
+
+	me.Matrix.PurgeInstances()
+	me.Matrix.Reset()
+
+	if len(me.queries) != 0 {
+		count, apiT, parseT, err = me.pollQueries()
+	} else {
+		count, apiT, parseT, err = me.pollEndpoint()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	me.Logger.Debug().Msgf("collected %d data points of %d instances", count, len(me.Matrix.GetInstances()))
+
+	if len(me.Matrix.GetInstances()) == 0 {
+		return nil, errors.New(errors.ERR_NO_INSTANCE, "")
+	}
+
+	me.Metadata.LazySetValueInt64("api_time", "data", apiT.Microseconds())
+	me.Metadata.LazySetValueInt64("parse_time", "data", parseT.Microseconds())
+	me.Metadata.LazySetValueUint64("count", "data", count)
+	me.AddCollectCount(count)
+
+	return me.Matrix, nil
+}
+
+// pollQueries - evaluate all queries, labels of samples become instance labels
+func (me *StorageGrid) pollQueries() (uint64, time.Duration, time.Duration, error) {
+
+	var (
+		count        uint64
+		apiT, parseT time.Duration
+	)
+
+	for _, q := range me.queries {
+
+		start := time.Now()
+		response, err := me.client.Query(q.expr)
+		apiT += time.Since(start)
+		if err != nil {
+			return 0, apiT, parseT, err
+		}
+
+		start = time.Now()
+		metric := me.Matrix.GetMetric(q.display)
+
+		for _, result := range response.SearchChildren([]string{"data", "result"}) {
+
+			labels := make(map[string]string)
+			if m := result.GetChildS("metric"); m != nil {
+				for _, l := range m.GetChildren() {
+					if name := l.GetNameS(); name != "__name__" {
+						labels[name] = l.GetContentS()
+					}
+				}
+			}
+
+			// value is [timestamp, "value"]
+			var value string
+			for _, v := range result.GetChildren() {
+				if v.GetNameS() == "value" {
+					value = v.GetContentS()
+				}
+			}
+
+			key := instanceKey(labels)
+			instance := me.Matrix.GetInstance(key)
+			if instance == nil {
+				if instance, err = me.Matrix.NewInstance(key); err != nil {
+					return 0, apiT, parseT, err
+				}
+				for k, v := range labels {
+					instance.SetLabel(k, v)
+				}
+			}
+
+			// NaN values can't be exported
+			if value == "NaN" || strings.HasSuffix(value, "Inf") {
+				continue
+			}
+			if err = metric.SetValueString(instance, value); err != nil {
+				me.Logger.Debug().Msgf("set metric (%s) of [%s] to (%s): %v", q.display, key, value, err)
+				continue
+			}
+			count++
+		}
+		parseT += time.Since(start)
+	}
+	return count, apiT, parseT, nil
+}
+
+// pollEndpoint - request the endpoint (for each tenant) and add its records as instances
+func (me *StorageGrid) pollEndpoint() (uint64, time.Duration, time.Duration, error) {
+
+	var (
+		count, skipped uint64
+		apiT, parseT   time.Duration
+	)
+
+	tenants := []*node.Node{nil}
+	if me.forEachTenant {
+		var err error
+		start := time.Now()
+		tenants, err = me.accounts()
+		apiT += time.Since(start)
+		if err != nil {
+			return 0, apiT, parseT, err
+		}
+	}
+
+	for _, tenant := range tenants {
+
+		endpoint := me.endpoint
+		if tenant != nil {
+			endpoint = strings.ReplaceAll(endpoint, "{tenant_id}", tenant.GetChildContentS("id"))
+		}
+
+		start := time.Now()
+		response, err := me.client.Get(endpoint)
+		apiT += time.Since(start)
+		if err != nil {
+			return 0, apiT, parseT, err
+		}
+
+		start = time.Now()
+
+		records := response.GetChildren()
+		if len(me.records) != 0 {
+			records = response.SearchChildren(me.records)
+		}
+
+		for _, record := range records {
+			c, s := me.addRecord(record, tenant)
+			count += c
+			skipped += s
+		}
+		parseT += time.Since(start)
+	}
+
+	me.Logger.Trace().Msgf("skipped %d values", skipped)
+	return count, apiT, parseT, nil
+}
+
+// addRecord - add record as instance, returns the number of values set and skipped
+func (me *StorageGrid) addRecord(record, tenant *node.Node) (uint64, uint64) {
+
+	var (
+		count, skipped uint64
+		keys           []string
+	)
+
+	if tenant != nil {
+		keys = append(keys, tenant.GetChildContentS("id"))
+	}
+	for _, cnt := range me.counters {
+		if cnt.isKey {
+			value := valueOf(record, tenant, cnt)
+			if value == "" {
+				me.Logger.Debug().Msgf("skipping record, instance key (%s) not found", cnt.key)
+				return 0, 0
+			}
+			keys = append(keys, value)
+		}
+	}
+
+	key := strings.Join(keys, ".")
+	instance, err := me.Matrix.NewInstance(key)
+	if err != nil {
+		me.Logger.Warn().Msgf("add instance [%s]: %v", key, err)
+		return 0, 0
+	}
+
+	if tenant != nil {
+		instance.SetLabel("tenant_id", tenant.GetChildContentS("id"))
+		instance.SetLabel("tenant", tenant.GetChildContentS("name"))
+	}
+
+	for _, cnt := range me.counters {
+
+		value := valueOf(record, tenant, cnt)
+		if value == "" {
+			skipped++
+			continue
+		}
+
+		if cnt.isLabel {
+			instance.SetLabel(cnt.display, value)
+			count++
+			continue
+		}
+
+		switch value {
+		case "true":
+			value = "1"
+		case "false":
+			value = "0"
+		}
+		if err = me.Matrix.GetMetric(cnt.display).SetValueString(instance, value); err != nil {
+			me.Logger.Debug().Msgf("set metric (%s) of [%s] to (%s): %v", cnt.display, key, value, err)
+			skipped++
+			continue
+		}
+		count++
+	}
+	return count, skipped
+}
+
+// accounts - all tenant accounts, requested in pages
+func (me *StorageGrid) accounts() ([]*node.Node, error) {
+
+	var (
+		accounts []*node.Node
+		marker   string
+	)
+
+	for {
+		path := "grid/accounts?limit=" + strconv.Itoa(accountsPageSize)
+		if marker != "" {
+			path += "&marker=" + marker
+		}
+		response, err := me.client.Get(path)
+		if err != nil {
+			return nil, err
+		}
+		page := response.SearchChildren([]string{"data"})
+		accounts = append(accounts, page...)
+		if len(page) < accountsPageSize {
+			return accounts, nil
+		}
+		marker = page[len(page)-1].GetChildContentS("id")
+	}
+}
+
+// valueOf - content of the counter in the record, its parent or the tenant account
+func valueOf(record, tenant *node.Node, cnt *counter) string {
+
+	n := record
+	if cnt.tenant {
+		n = tenant
+	}
+	for i := 0; i < cnt.up && n != nil; i++ {
+		n = n.GetParent()
+	}
+	for _, name := range cnt.path {
+		if n == nil {
+			return ""
+		}
+		n = n.GetChildS(name)
+	}
+	if n == nil {
+		return ""
+	}
+	return n.GetContentS()
+}
+
+// instanceKey - unique key for a set of labels
+func instanceKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,320 @@
+package storagegrid
+
+import (
+	"encoding/json"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/tree"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const common = `
+schedule:
+  - data: 60s
+scheme: http
+username: harvest
+password: secret
+`
+
+// grid - fake grid management API, tokens expire when expire is set
+type grid struct {
+	t      *testing.T
+	mu     sync.Mutex
+	token  string
+	logins int
+	expire bool
+}
+
+func newServer(t *testing.T) (*httptest.Server, *grid) {
+
+	topology, err := ioutil.ReadFile("testdata/topology.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &grid{t: t}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v3/authorize", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != "POST" || body["username"] != "harvest" || body["password"] != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"status": "error", "message": {"text": "invalid credentials"}}`))
+			return
+		}
+		g.mu.Lock()
+		g.logins++
+		g.token = "token-" + strconv.Itoa(g.logins)
+		g.mu.Unlock()
+		_, _ = w.Write([]byte(`{"status": "success", "data": "` + g.token + `"}`))
+	})
+
+	api := func(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			g.mu.Lock()
+			valid := !g.expire && r.Header.Get("Authorization") == "Bearer "+g.token
+			g.expire = false
+			g.mu.Unlock()
+			if !valid {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"status": "error", "message": {"text": "token expired"}}`))
+				return
+			}
+			handler(w, r)
+		}
+	}
+
+	mux.HandleFunc("/api/v3/grid/config/product-version", api(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data": {"productVersion": "11.5.0"}}`))
+	}))
+
+	mux.HandleFunc("/api/v3/grid/health/topology", api(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(topology)
+	}))
+
+	mux.HandleFunc("/api/v3/grid/accounts", api(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data": [
+			{"id": "1001", "name": "finance", "policy": {"quotaObjectBytes": 5000}},
+			{"id": "1002", "name": "hr", "policy": {"quotaObjectBytes": null}}
+		]}`))
+	}))
+
+	mux.HandleFunc("/api/v3/grid/accounts/", api(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/grid/accounts/1001/usage":
+			_, _ = w.Write([]byte(`{"data": {"objectCount": 3, "dataBytes": 1200, "buckets": [
+				{"name": "invoices", "objectCount": 2, "dataBytes": 1000},
+				{"name": "reports", "objectCount": 1, "dataBytes": 200}
+			]}}`))
+		case "/api/v3/grid/accounts/1002/usage":
+			_, _ = w.Write([]byte(`{"data": {"objectCount": 1, "dataBytes": 10, "buckets": [
+				{"name": "reports", "objectCount": 1, "dataBytes": 10}
+			]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	mux.HandleFunc("/api/v3/grid/metric-query", api(func(w http.ResponseWriter, r *http.Request) {
+		var result string
+		switch q := r.URL.Query().Get("query"); {
+		case strings.HasPrefix(q, "storagegrid_node_cpu_utilization_percentage"):
+			result = `[
+				{"metric": {"__name__": "storagegrid_node_cpu_utilization_percentage", "instance": "dc1-s1", "site_name": "munich"}, "value": [1622540000.1, "12.5"]},
+				{"metric": {"__name__": "storagegrid_node_cpu_utilization_percentage", "instance": "dc2-s1", "site_name": "berlin"}, "value": [1622540000.1, "NaN"]}
+			]`
+		case strings.HasPrefix(q, "sum by (instance, site_name) (rate("):
+			result = `[
+				{"metric": {"instance": "dc1-s1", "site_name": "munich"}, "value": [1622540000.1, "3"]}
+			]`
+		default:
+			t.Errorf("unexpected query: %s", q)
+			result = `[]`
+		}
+		_, _ = w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": ` + result + `}}`))
+	}))
+
+	return httptest.NewServer(mux), g
+}
+
+func newCollector(t *testing.T, server *httptest.Server, template string) *StorageGrid {
+	params, err := tree.LoadYaml([]byte(common + template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.NewChildS("addr", server.Listener.Addr().String())
+
+	c := &StorageGrid{AbstractCollector: collector.New("StorageGrid", "test", &options.Options{}, params)}
+	if err = c.Init(c.AbstractCollector); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPollTopology(t *testing.T) {
+
+	server, g := newServer(t)
+	defer server.Close()
+
+	c := newCollector(t, server, `
+object: storagegrid_node
+type: rest
+endpoint: grid/health/topology?depth=node
+records: data.children.children
+collect_only_labels: true
+counters:
+  - ^^id       => node_id
+  - ^name      => node
+  - ^type
+  - ^state
+  - ^../name   => site
+`)
+
+	// token expired since Init, should login again
+	g.mu.Lock()
+	g.expire = true
+	g.mu.Unlock()
+
+	data, err := c.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.logins != 2 {
+		t.Errorf("logins = %d, want 2", g.logins)
+	}
+
+	if n := len(data.GetInstances()); n != 3 {
+		t.Fatalf("got %d instances, want 3", n)
+	}
+
+	tests := []struct {
+		key, node, site, state string
+	}{
+		{"node-1", "dc1-adm1", "munich", "connected"},
+		{"node-2", "dc1-s1", "munich", "connected"},
+		{"node-3", "dc2-s1", "berlin", "unknown"},
+	}
+	for _, tt := range tests {
+		instance := data.GetInstance(tt.key)
+		if instance == nil {
+			t.Errorf("missing instance %s", tt.key)
+			continue
+		}
+		if instance.GetLabel("node") != tt.node || instance.GetLabel("site") != tt.site || instance.GetLabel("state") != tt.state {
+			t.Errorf("labels of %s: %v", tt.key, instance.GetLabels())
+		}
+	}
+}
+
+func TestPollBuckets(t *testing.T) {
+
+	server, _ := newServer(t)
+	defer server.Close()
+
+	c := newCollector(t, server, `
+object: storagegrid_bucket
+type: rest
+endpoint: grid/accounts/{tenant_id}/usage
+for_each_tenant: true
+records: data.buckets
+counters:
+  - ^^name                   => bucket
+  - objectCount              => objects
+  - dataBytes                => used_bytes
+  - @policy.quotaObjectBytes => tenant_quota_bytes
+`)
+
+	data, err := c.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(data.GetInstances()); n != 3 {
+		t.Fatalf("got %d instances, want 3", n)
+	}
+
+	// same bucket name in different tenants
+	reports := data.GetInstance("1002.reports")
+	if reports == nil || reports.GetLabel("tenant") != "hr" || reports.GetLabel("tenant_id") != "1002" || reports.GetLabel("bucket") != "reports" {
+		t.Fatalf("labels of reports: %v", reports)
+	}
+	if v, ok := data.GetMetric("used_bytes").GetValueFloat64(reports); !ok || v != 10 {
+		t.Errorf("used_bytes of reports = %v (%v)", v, ok)
+	}
+	// null quota is skipped
+	if _, ok := data.GetMetric("tenant_quota_bytes").GetValueFloat64(reports); ok {
+		t.Error("quota of hr should be skipped")
+	}
+
+	invoices := data.GetInstance("1001.invoices")
+	if v, ok := data.GetMetric("tenant_quota_bytes").GetValueFloat64(invoices); !ok || v != 5000 {
+		t.Errorf("quota of invoices = %v (%v)", v, ok)
+	}
+	if v, ok := data.GetMetric("objects").GetValueFloat64(invoices); !ok || v != 2 {
+		t.Errorf("objects of invoices = %v (%v)", v, ok)
+	}
+}
+
+func TestPollQueries(t *testing.T) {
+
+	server, _ := newServer(t)
+	defer server.Close()
+
+	c := newCollector(t, server, `
+object: storagegrid_node
+type: prometheus
+queries:
+  - storagegrid_node_cpu_utilization_percentage                          => cpu_busy
+  - sum by (instance, site_name) (rate(storagegrid_s3_operations_successful[5m])) => s3_ops
+`)
+
+	data, err := c.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(data.GetInstances()); n != 2 {
+		t.Fatalf("got %d instances, want 2", n)
+	}
+
+	s1 := data.GetInstance(instanceKey(map[string]string{"instance": "dc1-s1", "site_name": "munich"}))
+	if s1 == nil || s1.GetLabel("site_name") != "munich" || s1.GetLabel("__name__") != "" {
+		t.Fatalf("labels of dc1-s1: %v", s1)
+	}
+	if v, ok := data.GetMetric("cpu_busy").GetValueFloat64(s1); !ok || v != 12.5 {
+		t.Errorf("cpu_busy of dc1-s1 = %v (%v)", v, ok)
+	}
+	if v, ok := data.GetMetric("s3_ops").GetValueFloat64(s1); !ok || v != 3 {
+		t.Errorf("s3_ops of dc1-s1 = %v (%v)", v, ok)
+	}
+
+	// NaN is skipped
+	s2 := data.GetInstance(instanceKey(map[string]string{"instance": "dc2-s1", "site_name": "berlin"}))
+	if _, ok := data.GetMetric("cpu_busy").GetValueFloat64(s2); ok {
+		t.Error("cpu_busy of dc2-s1 should be skipped")
+	}
+}
+
+func TestParseCounter(t *testing.T) {
+
+	tests := []struct {
+		content string
+		key     string
+		display string
+		up      int
+		tenant  bool
+		isLabel bool
+		isKey   bool
+	}{
+		{"^^id => node_id", "id", "node_id", 0, false, true, true},
+		{"^../../name => grid", "name", "grid", 2, false, true, false},
+		{"@policy.quotaObjectBytes", "policy.quotaObjectBytes", "policy_quotaObjectBytes", 0, true, false, false},
+		{"dataBytes => used_bytes", "dataBytes", "used_bytes", 0, false, false, false},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCounter(tt.content)
+		if err != nil {
+			t.Errorf("%s: %v", tt.content, err)
+			continue
+		}
+		if c.key != tt.key || c.display != tt.display || c.up != tt.up || c.tenant != tt.tenant || c.isLabel != tt.isLabel || c.isKey != tt.isKey {
+			t.Errorf("%s: got %+v", tt.content, c)
+		}
+	}
+
+	for _, invalid := range []string{"", "^^", "../", "@../name"} {
+		if _, err := parseCounter(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package syslog
+
+const (
+	PARSE = "parse syslog message"
+)
@@ -0,0 +1,228 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package syslog
+
+import (
+	"goharvest2/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslog severities, the names are the same as EMS severities
+var severities = []string{"emergency", "alert", "critical", "error", "warning", "notice", "informational", "debug"}
+
+// Message - EMS event received with syslog
+type Message struct {
+	Time     time.Time
+	Node     string
+	Event    string // e.g. "wafl.vol.autoSize.done"
+	Severity string
+	Source   string // e.g. "mgwd", only known with RFC5424
+	Text     string
+	Params   map[string]string // structured data of RFC5424 messages
+}
+
+// Parse - parse syslog message in RFC5424 or RFC3164 format. Messages forwarded by
+// ONTAP look like this, depending on the format of the syslog destination:
+//
+//	rfc-5424:       <PRI>1 TIMESTAMP NODE EVENT-SOURCE - EVENT-NAME - MSG
+//	legacy-netapp:  <PRI>TIMESTAMP [NODE:EVENT-NAME:SEVERITY]: MSG
+//
+// Other RFC3164 messages ("<PRI>TIMESTAMP HOST TAG: MSG") are parsed with the
+// tag as event name.
+func Parse(data []byte) (*Message, error) {
+
+	s := strings.TrimRight(string(data), "\r\n\x00")
+
+	if !strings.HasPrefix(s, "<") {
+		return nil, errors.New(PARSE, "missing priority")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return nil, errors.New(PARSE, "invalid priority")
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return nil, errors.New(PARSE, "invalid priority: "+s[1:end])
+	}
+	s = s[end+1:]
+
+	m := &Message{Severity: severities[pri%8]}
+
+	if strings.HasPrefix(s, "1 ") {
+		err = m.parse5424(s[2:])
+	} else {
+		err = m.parse3164(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Event == "" {
+		return nil, errors.New(PARSE, "missing event name")
+	}
+	return m, nil
+}
+
+// parse5424 - parse message after "<PRI>1 ", see RFC5424, section 6
+func (m *Message) parse5424(s string) error {
+
+	// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	fields := make([]string, 5)
+	for i := range fields {
+		sp := strings.IndexByte(s, ' ')
+		if sp == -1 {
+			if i < len(fields)-1 {
+				return errors.New(PARSE, "truncated header")
+			}
+			sp = len(s)
+		}
+		if fields[i] = s[:sp]; fields[i] == "-" {
+			fields[i] = ""
+		}
+		s = strings.TrimPrefix(s[sp:], " ")
+	}
+
+	if fields[0] != "" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return errors.New(PARSE, "invalid timestamp: "+fields[0])
+		}
+		m.Time = t
+	}
+	m.Node, m.Source, m.Event = fields[1], fields[2], fields[4]
+
+	// structured data, "-" or one or more [SD-ID PARAM="VALUE" ...]
+	if strings.HasPrefix(s, "-") {
+		s = strings.TrimPrefix(s[1:], " ")
+	} else {
+		for strings.HasPrefix(s, "[") {
+			n, err := m.parseElement(s)
+			if err != nil {
+				return err
+			}
+			s = s[n:]
+		}
+		s = strings.TrimPrefix(s, " ")
+	}
+
+	// MSG might start with a byte order mark
+	m.Text = strings.TrimPrefix(s, "\ufeff")
+	return nil
+}
+
+// parseElement - parse structured data element into params, returns its length
+func (m *Message) parseElement(s string) (int, error) {
+
+	if m.Params == nil {
+		m.Params = make(map[string]string)
+	}
+
+	// skip SD-ID
+	i := strings.IndexAny(s, " ]")
+	if i == -1 {
+		return 0, errors.New(PARSE, "structured data not terminated")
+	}
+
+	for i < len(s) {
+		switch s[i] {
+		case ']':
+			return i + 1, nil
+		case ' ':
+			i++
+			continue
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq == -1 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+			return 0, errors.New(PARSE, "invalid structured data")
+		}
+		name := s[i : i+eq]
+		i += eq + 2
+
+		// value, escape sequences are \", \\ and \]
+		var value strings.Builder
+		closed := false
+		for i < len(s) {
+			c := s[i]
+			i++
+			if c == '"' {
+				closed = true
+				break
+			}
+			if c == '\\' && i < len(s) && (s[i] == '"' || s[i] == '\\' || s[i] == ']') {
+				c = s[i]
+				i++
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return 0, errors.New(PARSE, "value of "+name+" not terminated")
+		}
+		m.Params[name] = value.String()
+	}
+	return 0, errors.New(PARSE, "structured data not terminated")
+}
+
+// parse3164 - parse message after "<PRI>", see RFC3164, section 4.1
+func (m *Message) parse3164(s string) error {
+
+	// timestamp, e.g. "Jun  1 10:00:00", the year is not included
+	if len(s) >= 16 && s[15] == ' ' {
+		if t, err := time.ParseInLocation(time.Stamp, s[:15], time.Local); err == nil {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			// messages of December received in January
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			m.Time = t
+			s = s[16:]
+		}
+	}
+
+	// ONTAP: [NODE:EVENT-NAME:SEVERITY]: MSG
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end == -1 {
+			return errors.New(PARSE, "missing closing bracket")
+		}
+		fields := strings.Split(s[1:end], ":")
+		if len(fields) != 3 {
+			return errors.New(PARSE, "expected [node:event:severity]")
+		}
+		m.Node, m.Event = fields[0], fields[1]
+		if fields[2] != "" {
+			m.Severity = strings.ToLower(fields[2])
+		}
+		m.Text = strings.TrimPrefix(strings.TrimPrefix(s[end+1:], ":"), " ")
+		return nil
+	}
+
+	// HOST TAG[PID]: MSG
+	sp := strings.IndexByte(s, ' ')
+	if sp == -1 {
+		return errors.New(PARSE, "missing tag")
+	}
+	m.Node, s = s[:sp], s[sp+1:]
+
+	colon := strings.Index(s, ": ")
+	if colon == -1 {
+		if !strings.HasSuffix(s, ":") {
+			return errors.New(PARSE, "missing tag")
+		}
+		colon = len(s) - 1
+	}
+	tag := s[:colon]
+	if strings.ContainsAny(tag, " \t") {
+		return errors.New(PARSE, "missing tag")
+	}
+	if i := strings.IndexByte(tag, '['); i != -1 {
+		tag = tag[:i]
+	}
+	m.Event = tag
+	m.Text = strings.TrimPrefix(s[colon+1:], " ")
+	return nil
+}
@@ -0,0 +1,79 @@
+package syslog
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		input    string
+		node     string
+		event    string
+		severity string
+		text     string
+	}{
+		{
+			"rfc-5424",
+			"<11>1 2021-06-01T10:00:00.123Z cluster1-01 wafl - wafl.vol.autoSize.done - Volume autosize: Automatic grow of volume 'vol1@vserver:svm1' by 10GB complete.\n",
+			"cluster1-01", "wafl.vol.autoSize.done", "error",
+			"Volume autosize: Automatic grow of volume 'vol1@vserver:svm1' by 10GB complete.",
+		},
+		{
+			"legacy-netapp",
+			"<13>Jun  1 10:00:00 [cluster1-02:callhome.spares.low:error]: Call home for SPARES_LOW",
+			"cluster1-02", "callhome.spares.low", "error", "Call home for SPARES_LOW",
+		},
+		{
+			"rfc-3164",
+			"<30>Jun 11 08:01:02 switch1 sshd[1234]: Accepted publickey for admin",
+			"switch1", "sshd", "informational", "Accepted publickey for admin",
+		},
+	}
+
+	for _, tt := range tests {
+		m, err := Parse([]byte(tt.input))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if m.Node != tt.node || m.Event != tt.event || m.Severity != tt.severity || m.Text != tt.text {
+			t.Errorf("%s: got %+v", tt.name, m)
+		}
+		if m.Time.IsZero() {
+			t.Errorf("%s: missing timestamp", tt.name)
+		}
+	}
+}
+
+func TestParseStructuredData(t *testing.T) {
+
+	m, err := Parse([]byte(`<13>1 - node1 - - sk.panic [ems@789 reason="Assertion \"x\" failed" node="node1"][meta seq="7"] panic`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Params["reason"] != `Assertion "x" failed` || m.Params["node"] != "node1" || m.Params["seq"] != "7" {
+		t.Errorf("params: %v", m.Params)
+	}
+	if m.Text != "panic" || !m.Time.IsZero() {
+		t.Errorf("got %+v", m)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"no priority",
+		"<999>1 - - - - event - text",
+		"<13>1 2021-06-01",
+		"<13>1 - node - - - - no event",
+		"<13>1 - node app - event [ems x=\"1 text",
+		"<13>Jun  1 10:00:00 [node:event]: missing severity",
+		"<13>Jun  1 10:00:00 host free text without tag",
+	} {
+		if m, err := Parse([]byte(input)); err == nil {
+			t.Errorf("%q: expected error, got %+v", input, m)
+		}
+	}
+}
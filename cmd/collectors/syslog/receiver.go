@@ -0,0 +1,275 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package syslog
+
+import (
+	"bufio"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/logging"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// messages longer than this are dropped
+	maxMessageSize = 64 * 1024
+	// max digits of the length of octet counted messages
+	maxLengthDigits = 10
+	// TCP connections are closed if no message is received for this long
+	tcpIdleTimeout = 5 * time.Minute
+	// further TCP connections are refused
+	maxTCPConnections = 256
+)
+
+// receiver - listens for syslog messages on one address and passes them to
+// the handlers of all collectors that subscribed, so that objects can share
+// the port. Messages that can't be parsed are dropped.
+type receiver struct {
+	sync.Mutex
+	network  string
+	udp      net.PacketConn
+	tcp      net.Listener
+	handlers []func(*Message)
+	conns    map[net.Conn]bool // open TCP connections
+	dropped  uint64
+	wg       sync.WaitGroup
+	Logger   *logging.Logger
+}
+
+var (
+	receivers    = make(map[string]*receiver)
+	receiversMux sync.Mutex
+)
+
+// subscribe - pass messages received on addr with network (udp, tcp or both) to
+// handler, starts listening if no other collector listens on addr yet. Receivers
+// on port 0 (any free port) are not shared.
+func subscribe(network, addr string, handler func(*Message)) (*receiver, error) {
+
+	receiversMux.Lock()
+	defer receiversMux.Unlock()
+
+	key := network + "://" + addr
+	shared := !strings.HasSuffix(addr, ":0")
+
+	r, ok := receivers[key]
+	if !ok || !shared {
+		var err error
+		if r, err = listen(network, addr); err != nil {
+			return nil, err
+		}
+		if shared {
+			receivers[key] = r
+		}
+	}
+
+	r.Lock()
+	r.handlers = append(r.handlers, handler)
+	r.Unlock()
+	return r, nil
+}
+
+func listen(network, addr string) (*receiver, error) {
+
+	var err error
+
+	r := &receiver{network: network, conns: make(map[net.Conn]bool), Logger: logging.SubLogger("Syslog", "Receiver")}
+
+	switch network {
+	case "udp", "tcp", "both":
+	default:
+		return nil, errors.New(errors.INVALID_PARAM, "protocol: "+network)
+	}
+
+	if network != "tcp" {
+		if r.udp, err = net.ListenPacket("udp", addr); err != nil {
+			return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+		}
+		r.wg.Add(1)
+		go r.serveUDP()
+	}
+
+	if network != "udp" {
+		if r.tcp, err = net.Listen("tcp", addr); err != nil {
+			r.close()
+			return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+		}
+		r.wg.Add(1)
+		go r.serveTCP()
+	}
+
+	r.Logger.Info().Msgf("listening for syslog messages on %s (%s)", addr, network)
+	return r, nil
+}
+
+// addrs - addresses the receiver listens on
+func (r *receiver) addrs() []string {
+	var addrs []string
+	if r.udp != nil {
+		addrs = append(addrs, "udp://"+r.udp.LocalAddr().String())
+	}
+	if r.tcp != nil {
+		addrs = append(addrs, "tcp://"+r.tcp.Addr().String())
+	}
+	return addrs
+}
+
+// close - stop listening and wait until all messages are handled
+func (r *receiver) close() {
+	if r.udp != nil {
+		r.udp.Close()
+	}
+	if r.tcp != nil {
+		r.tcp.Close()
+		r.Lock()
+		for conn := range r.conns {
+			conn.Close()
+		}
+		r.Unlock()
+	}
+	r.wg.Wait()
+}
+
+// handle - parse message and pass it to handlers
+func (r *receiver) handle(data []byte) {
+
+	m, err := Parse(data)
+
+	r.Lock()
+	defer r.Unlock()
+
+	if err != nil {
+		r.dropped++
+		r.Logger.Debug().Msgf("drop message (%v): %q", err, data)
+		return
+	}
+	for _, h := range r.handlers {
+		h(m)
+	}
+}
+
+// serveUDP - each datagram is one message
+func (r *receiver) serveUDP() {
+
+	defer r.wg.Done()
+	buffer := make([]byte, maxMessageSize)
+
+	for {
+		n, _, err := r.udp.ReadFrom(buffer)
+		if err != nil {
+			return // closed
+		}
+		r.handle(buffer[:n])
+	}
+}
+
+func (r *receiver) serveTCP() {
+
+	defer r.wg.Done()
+
+	var conns sync.WaitGroup
+	defer conns.Wait()
+
+	for {
+		conn, err := r.tcp.Accept()
+		if err != nil {
+			return // closed
+		}
+		r.Lock()
+		if len(r.conns) >= maxTCPConnections {
+			r.Unlock()
+			r.Logger.Debug().Msgf("refuse connection from %s: %d connections open", conn.RemoteAddr(), maxTCPConnections)
+			conn.Close()
+			continue
+		}
+		r.conns[conn] = true
+		r.Unlock()
+
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			defer func() {
+				r.Lock()
+				delete(r.conns, conn)
+				r.Unlock()
+				conn.Close()
+			}()
+			if err := r.readStream(conn); err != nil && err != io.EOF {
+				r.Logger.Debug().Msgf("close connection from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// readStream - read messages framed with octet counting ("LEN MSG") or
+// terminated by newline, see RFC6587, section 3.4. Connection is closed
+// if it's idle for longer than tcpIdleTimeout.
+func (r *receiver) readStream(conn net.Conn) error {
+
+	reader := bufio.NewReaderSize(conn, maxMessageSize)
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout)); err != nil {
+			return err
+		}
+
+		first, err := reader.Peek(1)
+		if err != nil {
+			return err
+		}
+
+		if first[0] >= '1' && first[0] <= '9' {
+			n, err := readLength(reader)
+			if err != nil {
+				return err
+			}
+			data := make([]byte, n)
+			if _, err = io.ReadFull(reader, data); err != nil {
+				return err
+			}
+			r.handle(data)
+			continue
+		}
+
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return errors.New(PARSE, "message too long")
+		}
+		if len(strings.TrimSpace(string(line))) != 0 {
+			r.handle(line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLength - read length of an octet counted message, i.e. digits followed
+// by space. Rejects the prefix as soon as it's longer than maxLengthDigits,
+// so peers can't make us buffer arbitrary data.
+func readLength(reader *bufio.Reader) (int, error) {
+	n := 0
+	for i := 0; ; i++ {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == ' ' && i != 0 {
+			break
+		}
+		if c < '0' || c > '9' || i == maxLengthDigits {
+			return 0, errors.New(PARSE, "invalid message length")
+		}
+		n = n*10 + int(c-'0')
+	}
+	if n > maxMessageSize {
+		return 0, errors.New(PARSE, "invalid message length: "+strconv.Itoa(n))
+	}
+	return n, nil
+}
@@ -0,0 +1,346 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package syslog receives EMS events that ONTAP forwards to a syslog destination,
+// so they don't have to be polled. Messages are received with UDP and/or TCP
+// in RFC5424 or RFC3164 format (ONTAP "rfc-5424" or "legacy-netapp").
+//
+// Events are aggregated into instances by event name, node, severity and the
+// labels that the template maps from event parameters. For each instance,
+// the number of events received since the collector started and when the
+// last one was received are exported. The template whitelists events, e.g.:
+//
+//	events:
+//	  wafl.vol.autoSize.done:
+//	    pattern: "volume '(?P<vol>[^@']+)@vserver:(?P<vserver>[^']+)'"
+//	    labels:
+//	      - vol      => volume
+//	      - vserver  => svm
+//	  callhome.*:
+//
+// Parameters are the structured data of RFC5424 messages, or extracted with
+// named groups of pattern from the message text.
+package syslog
+
+import (
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree/node"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAddr         = "0.0.0.0:1514"
+	defaultProtocol     = "both"
+	defaultMaxInstances = 10000
+)
+
+type Syslog struct {
+	*collector.AbstractCollector
+	receiver     *receiver
+	events       []*event // empty if all events are collected
+	maxInstances int
+	mu           *sync.Mutex
+	stats        map[string]*stat
+	received     uint64 // since last poll
+	ignored      uint64 // dropped, because stats were full
+}
+
+// event - whitelisted event name, or prefix if the name ends with "*"
+type event struct {
+	name    string
+	prefix  bool
+	pattern *regexp.Regexp
+	labels  []label
+}
+
+type label struct {
+	param   string
+	display string
+}
+
+// stat - aggregated events with the same labels
+type stat struct {
+	labels   map[string]string
+	count    uint64
+	lastSeen time.Time
+}
+
+func init() {
+	plugin.RegisterModule(Syslog{})
+}
+
+func (Syslog) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.syslog",
+		New: func() plugin.Module { return new(Syslog) },
+	}
+}
+
+// Init - initialize the collector and start listening
+func (me *Syslog) Init(a *collector.AbstractCollector) error {
+
+	var err error
+
+	me.AbstractCollector = a
+
+	if err = me.loadSubTemplate(); err != nil {
+		return err
+	}
+
+	if err = collector.Init(me); err != nil {
+		return err
+	}
+
+	if object := me.Params.GetChildContentS("object"); object != "" {
+		me.Matrix.Object = object
+	}
+
+	if events := me.Params.GetChildS("events"); events != nil {
+		if err = me.loadEvents(events); err != nil {
+			return err
+		}
+	}
+
+	me.maxInstances = defaultMaxInstances
+	if x := me.Params.GetChildContentS("max_instances"); x != "" {
+		if me.maxInstances, err = strconv.Atoi(x); err != nil {
+			return errors.New(errors.INVALID_PARAM, "max_instances: "+x)
+		}
+	}
+
+	if _, err = me.Matrix.NewMetricUint64("events"); err != nil {
+		return err
+	}
+	if _, err = me.Matrix.NewMetricFloat64("last_seen"); err != nil {
+		return err
+	}
+
+	me.mu = &sync.Mutex{}
+	me.stats = make(map[string]*stat)
+
+	addr := me.Params.GetChildContentS("listen_addr")
+	if addr == "" {
+		addr = defaultAddr
+	}
+	protocol := me.Params.GetChildContentS("protocol")
+	if protocol == "" {
+		protocol = defaultProtocol
+	}
+
+	if me.receiver, err = subscribe(protocol, addr, me.handle); err != nil {
+		return err
+	}
+
+	me.Logger.Debug().Msgf("initialized with %d events, listening on %v", len(me.events), me.receiver.addrs())
+	return nil
+}
+
+// loadSubTemplate - merge the subtemplate of our object into the parameters
+func (me *Syslog) loadSubTemplate() error {
+
+	objects := me.Params.GetChildS("objects")
+	if objects == nil {
+		return nil
+	}
+
+	fn := objects.GetChildContentS(me.Object)
+	if fn == "" {
+		return errors.New(errors.MISSING_PARAM, "subtemplate of object "+me.Object)
+	}
+
+	template, err := collector.ImportTemplate(me.Options.HomePath, fn, me.Name)
+	if err != nil {
+		me.Logger.Error().Stack().Err(err).Msgf("Error importing subtemplate: %s", fn)
+		return err
+	}
+	me.Params.Union(template)
+	return nil
+}
+
+// loadEvents - parse the whitelist of events, each with optional
+// pattern and labels mapped from parameters ("param => label")
+func (me *Syslog) loadEvents(events *node.Node) error {
+
+	for _, e := range events.GetChildren() {
+
+		ev := &event{name: e.GetNameS()}
+		if strings.HasSuffix(ev.name, "*") {
+			ev.prefix = true
+			ev.name = strings.TrimSuffix(ev.name, "*")
+		}
+
+		if p := unquote(e.GetChildContentS("pattern")); p != "" {
+			var err error
+			if ev.pattern, err = regexp.Compile(p); err != nil {
+				return errors.New(errors.INVALID_PARAM, "pattern of "+e.GetNameS()+": "+err.Error())
+			}
+		}
+
+		if labels := e.GetChildS("labels"); labels != nil {
+			for _, l := range labels.GetAllChildContentS() {
+				split := strings.Split(l, "=>")
+				lb := label{param: strings.TrimSpace(split[0])}
+				lb.display = lb.param
+				if len(split) == 2 {
+					lb.display = strings.TrimSpace(split[1])
+				}
+				if lb.param == "" || lb.display == "" || len(split) > 2 {
+					return errors.New(errors.INVALID_PARAM, "label of "+e.GetNameS()+": "+l)
+				}
+				ev.labels = append(ev.labels, lb)
+			}
+		}
+
+		me.events = append(me.events, ev)
+		me.Logger.Trace().Msgf("added event (%s) with %d labels", e.GetNameS(), len(ev.labels))
+	}
+	return nil
+}
+
+// unquote - strip quotes around yaml values
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// match - whitelisted event of the message, nil if not wanted.
+// Exact names take precedence over prefixes.
+func (me *Syslog) match(name string) (*event, bool) {
+
+	if len(me.events) == 0 {
+		return nil, true
+	}
+
+	var best *event
+	for _, ev := range me.events {
+		if !ev.prefix && ev.name == name {
+			return ev, true
+		}
+		if ev.prefix && strings.HasPrefix(name, ev.name) && (best == nil || len(ev.name) > len(best.name)) {
+			best = ev
+		}
+	}
+	return best, best != nil
+}
+
+// handle - count message, called by the receiver
+func (me *Syslog) handle(m *Message) {
+
+	ev, ok := me.match(m.Event)
+	if !ok {
+		return
+	}
+
+	labels := map[string]string{"event": m.Event, "node": m.Node, "severity": m.Severity}
+
+	if ev != nil && len(ev.labels) != 0 {
+		params := make(map[string]string, len(m.Params))
+		for k, v := range m.Params {
+			params[k] = v
+		}
+		if ev.pattern != nil {
+			if match := ev.pattern.FindStringSubmatch(m.Text); match != nil {
+				for i, name := range ev.pattern.SubexpNames() {
+					if name != "" {
+						params[name] = match[i]
+					}
+				}
+			}
+		}
+		for _, lb := range ev.labels {
+			labels[lb.display] = params[lb.param]
+		}
+	}
+
+	key := instanceKey(labels)
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	s, ok := me.stats[key]
+	if !ok {
+		if len(me.stats) >= me.maxInstances {
+			me.ignored++
+			return
+		}
+		s = &stat{labels: labels}
+		me.stats[key] = s
+	}
+	s.count++
+	s.lastSeen = time.Now()
+	me.received++
+}
+
+// PollData - rebuild the data cache from the events received so far
+func (me *Syslog) PollData() (*matrix.Matrix, error) {
+
+	var count uint64
+
+	start := time.Now()
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	me.Matrix.PurgeInstances()
+	me.Matrix.Reset()
+
+	events := me.Matrix.GetMetric("events")
+	lastSeen := me.Matrix.GetMetric("last_seen")
+
+	for key, s := range me.stats {
+
+		instance, err := me.Matrix.NewInstance(key)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range s.labels {
+			instance.SetLabel(k, v)
+		}
+
+		if err = events.SetValueUint64(instance, s.count); err != nil {
+			me.Logger.Error().Stack().Err(err).Msgf("set metric (events) of [%s]", key)
+		}
+		if err = lastSeen.SetValueFloat64(instance, float64(s.lastSeen.UnixNano())/1e9); err != nil {
+			me.Logger.Error().Stack().Err(err).Msgf("set metric (last_seen) of [%s]", key)
+		}
+		count += 2
+	}
+
+	me.Logger.Debug().Msgf("received %d events since last poll, %d instances", me.received, len(me.stats))
+	if me.ignored != 0 {
+		me.Logger.Warn().Msgf("ignored %d events, max_instances (%d) reached", me.ignored, me.maxInstances)
+	}
+	me.received = 0
+	me.ignored = 0
+
+	if len(me.stats) == 0 {
+		return nil, errors.New(errors.ERR_NO_INSTANCE, "no events received")
+	}
+
+	me.Metadata.LazySetValueInt64("parse_time", "data", time.Since(start).Microseconds())
+	me.Metadata.LazySetValueUint64("count", "data", count)
+	me.AddCollectCount(count)
+
+	return me.Matrix, nil
+}
+
+// instanceKey - unique key for a set of labels
+func instanceKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,188 @@
+package syslog
+
+import (
+	"fmt"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const template = `
+schedule:
+  - data: 60s
+object: ems
+listen_addr: 127.0.0.1:0
+events:
+  wafl.vol.autoSize.done:
+    pattern: "volume '(?P<vol>[^@']+)@vserver:(?P<vserver>[^']+)'"
+    labels:
+      - vol       => volume
+      - vserver   => svm
+  sk.panic:
+    labels:
+      - reason
+  callhome.*:
+`
+
+var messages = []string{
+	"<13>1 2021-06-01T10:00:00Z node1 wafl - wafl.vol.autoSize.done - Automatic grow of volume 'vol1@vserver:svm1' by 10GB complete.",
+	"<13>1 2021-06-01T10:05:00Z node1 wafl - wafl.vol.autoSize.done - Automatic grow of volume 'vol1@vserver:svm1' by 10GB complete.",
+	"<13>1 2021-06-01T10:05:00Z node2 wafl - wafl.vol.autoSize.done - Automatic grow of volume 'vol2@vserver:svm1' by 10GB complete.",
+	"<13>Jun  1 10:00:00 [node1:callhome.spares.low:error]: Call home for SPARES_LOW",
+	`<10>1 - node2 - - sk.panic [ems@789 reason="watchdog"] panic`,
+	"<13>Jun  1 10:00:00 [node1:wafl.vol.offline:notice]: not whitelisted",
+	"garbage",
+}
+
+func newSyslog(t *testing.T, protocol string) *Syslog {
+
+	params, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.NewChildS("protocol", protocol)
+
+	c := &Syslog{AbstractCollector: collector.New("Syslog", "ems", &options.Options{}, params)}
+	if err = c.Init(c.AbstractCollector); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// poll - poll until want events arrived
+func poll(t *testing.T, c *Syslog, want uint64) *matrix.Matrix {
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		received := c.received
+		c.mu.Unlock()
+		if received >= want {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	data, err := c.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func find(data *matrix.Matrix, labels map[string]string) *matrix.Instance {
+	for _, instance := range data.GetInstances() {
+		found := true
+		for k, v := range labels {
+			if instance.GetLabel(k) != v {
+				found = false
+			}
+		}
+		if found {
+			return instance
+		}
+	}
+	return nil
+}
+
+func check(t *testing.T, protocol string, data *matrix.Matrix) {
+
+	if n := len(data.GetInstances()); n != 4 {
+		t.Fatalf("%s: got %d instances, want 4", protocol, n)
+	}
+
+	tests := []struct {
+		labels map[string]string
+		count  uint64
+	}{
+		{map[string]string{"event": "wafl.vol.autoSize.done", "node": "node1", "volume": "vol1", "svm": "svm1", "severity": "notice"}, 2},
+		{map[string]string{"event": "wafl.vol.autoSize.done", "node": "node2", "volume": "vol2"}, 1},
+		{map[string]string{"event": "callhome.spares.low", "node": "node1", "severity": "error"}, 1},
+		{map[string]string{"event": "sk.panic", "reason": "watchdog", "severity": "critical"}, 1},
+	}
+
+	for _, tt := range tests {
+		instance := find(data, tt.labels)
+		if instance == nil {
+			t.Errorf("%s: no instance with labels %v", protocol, tt.labels)
+			continue
+		}
+		if count, ok := data.GetMetric("events").GetValueUint64(instance); !ok || count != tt.count {
+			t.Errorf("%s: events of %v = %d (%v), want %d", protocol, tt.labels, count, ok, tt.count)
+		}
+		if seen, ok := data.GetMetric("last_seen").GetValueFloat64(instance); !ok || time.Since(time.Unix(int64(seen), 0)) > time.Minute {
+			t.Errorf("%s: last_seen of %v = %v (%v)", protocol, tt.labels, seen, ok)
+		}
+	}
+}
+
+func TestUDP(t *testing.T) {
+
+	c := newSyslog(t, "udp")
+	defer c.receiver.close()
+
+	conn, err := net.Dial("udp", strings.TrimPrefix(c.receiver.addrs()[0], "udp://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, m := range messages {
+		if _, err = conn.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(t, "udp", poll(t, c, 5))
+}
+
+func TestTCP(t *testing.T) {
+
+	c := newSyslog(t, "tcp")
+	defer c.receiver.close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(c.receiver.addrs()[0], "tcp://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// octet counting and newline framing on the same connection
+	for i, m := range messages {
+		if i%2 == 0 {
+			_, err = fmt.Fprintf(conn, "%d %s", len(m), m)
+		} else {
+			_, err = fmt.Fprintf(conn, "%s\n", m)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(t, "tcp", poll(t, c, 5))
+}
+
+func TestTCPInvalidLength(t *testing.T) {
+
+	c := newSyslog(t, "tcp")
+	defer c.receiver.close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(c.receiver.addrs()[0], "tcp://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// length prefix without end, receiver should close connection
+	// instead of buffering it
+	if _, err = conn.Write([]byte("1" + strings.Repeat("2", 100))); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected connection closed by receiver, got %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package unix
+
+import (
+	"goharvest2/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// mount point of the cgroup filesystem
+var _CGROUP_ROOT = "/sys/fs/cgroup"
+
+// true if any cgroup metric is collected, processes only
+// load their cgroup if this is set
+var _CGROUP bool
+
+// labels of the cgroup histograms
+var cgroupLabels = map[string][]string{
+	"cgroup_cpu":      {"usage", "periods", "throttled_periods", "throttled_time"},
+	"cgroup_memory":   {"current", "max"},
+	"cgroup_pressure": {"some_avg10", "some_avg60", "some_avg300", "some_total", "full_avg10", "full_avg60", "full_avg300", "full_total"},
+	"cgroup_io":       {"read_bytes", "write_bytes", "reads", "writes"},
+}
+
+// v1 limits above this value mean "no limit"
+const cgroupUnlimited = 1 << 62
+
+var containerIdRegex = regexp.MustCompile(`[0-9a-f]{64}`)
+var containerMountRegex = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+
+// Cgroup - resource usage and limits of the control group of a process,
+// supports cgroup v2 (unified) and v1 hierarchies
+type Cgroup struct {
+	unified     bool
+	paths       map[string]string // directory of each v1 controller, or "" for v2
+	containerId string
+	cpu         map[string]float64 // seconds or count
+	pressure    map[string]float64 // percent or seconds
+	mem         map[string]uint64  // bytes
+	io          map[string]uint64  // bytes or count
+}
+
+// NewCgroup - creates an instance of Cgroup for the process with procfs directory dirpath
+func NewCgroup(dirpath string) (*Cgroup, error) {
+
+	data, err := ioutil.ReadFile(path.Join(dirpath, "cgroup"))
+	if err != nil {
+		return nil, errors.New(FILE_READ, "cgroup: "+err.Error())
+	}
+
+	c := &Cgroup{paths: make(map[string]string)}
+
+	// examples of lines in v2 and v1:
+	//	0::/system.slice/docker-<id>.scope
+	//	4:cpu,cpuacct:/docker/<id>
+	var unifiedPath string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			unifiedPath = fields[2]
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			switch controller {
+			case "cpu", "cpuacct", "memory", "blkio":
+				c.paths[controller] = cgroupDir(controller, fields[2])
+			}
+		}
+		if c.containerId == "" {
+			c.containerId = containerIdRegex.FindString(fields[2])
+		}
+	}
+
+	// hybrid systems have both hierarchies, but controllers are only in v1
+	if len(c.paths) == 0 {
+		c.unified = true
+		c.paths[""] = cgroupDir("", unifiedPath)
+	}
+
+	// in a container with its own cgroup namespace, path of cgroup is "/",
+	// docker mounts files of the container however from a directory named by the id
+	if c.containerId == "" {
+		if data, err = ioutil.ReadFile(path.Join(dirpath, "mountinfo")); err == nil {
+			if match := containerMountRegex.FindSubmatch(data); match != nil {
+				c.containerId = string(match[1])
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// cgroupDir - directory of the cgroup with path p, if it doesn't exist
+// we are probably in a cgroup namespace and the root is our cgroup
+func cgroupDir(controller, p string) string {
+	dir := path.Join(_CGROUP_ROOT, controller, p)
+	if s, err := os.Stat(dir); err == nil && s.IsDir() {
+		return dir
+	}
+	return path.Join(_CGROUP_ROOT, controller)
+}
+
+// ContainerId - ID of the container the process is running in, empty if not known
+func (c *Cgroup) ContainerId() string {
+	return c.containerId
+}
+
+// Reload - refresh metrics, files that are not available
+// (e.g. controller is disabled) are silently skipped
+func (c *Cgroup) Reload() {
+
+	c.cpu = make(map[string]float64)
+	c.pressure = make(map[string]float64)
+	c.mem = make(map[string]uint64)
+	c.io = make(map[string]uint64)
+
+	if c.unified {
+		c.loadUnified()
+	} else {
+		c.loadV1()
+	}
+}
+
+func (c *Cgroup) loadUnified() {
+
+	dir := c.paths[""]
+
+	// example: "usage_usec 1234" ... "nr_periods 10" "nr_throttled 2" "throttled_usec 300"
+	if values, ok := readKeyValues(path.Join(dir, "cpu.stat")); ok {
+		setScaled(c.cpu, "usage", values, "usage_usec", 1e6)
+		setScaled(c.cpu, "periods", values, "nr_periods", 1)
+		setScaled(c.cpu, "throttled_periods", values, "nr_throttled", 1)
+		setScaled(c.cpu, "throttled_time", values, "throttled_usec", 1e6)
+	}
+
+	if num, ok := readUint(path.Join(dir, "memory.current")); ok {
+		c.mem["current"] = num
+	}
+
+	// value is "max" if there is no limit
+	if num, ok := readUint(path.Join(dir, "memory.max")); ok {
+		c.mem["max"] = num
+	}
+
+	// example:
+	//	some avg10=0.00 avg60=0.00 avg300=0.00 total=12345
+	//	full avg10=0.00 avg60=0.00 avg300=0.00 total=6789
+	if data, err := ioutil.ReadFile(path.Join(dir, "memory.pressure")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			for _, field := range fields[1:] {
+				if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+					if num, err := strconv.ParseFloat(kv[1], 64); err == nil {
+						if kv[0] == "total" {
+							num /= 1e6
+						}
+						c.pressure[fields[0]+"_"+kv[0]] = num
+					}
+				}
+			}
+		}
+	}
+
+	// one line for each device, example:
+	// 8:0 rbytes=90430464 wbytes=299008 rios=8950 wios=30 dbytes=0 dios=0
+	if data, err := ioutil.ReadFile(path.Join(dir, "io.stat")); err == nil {
+		labels := map[string]string{"rbytes": "read_bytes", "wbytes": "write_bytes", "rios": "reads", "wios": "writes"}
+		for _, line := range strings.Split(string(data), "\n") {
+			for _, field := range strings.Fields(line) {
+				if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+					if label, ok := labels[kv[0]]; ok {
+						if num, err := strconv.ParseUint(kv[1], 10, 64); err == nil {
+							c.io[label] += num
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (c *Cgroup) loadV1() {
+
+	// throttled_time is in nanoseconds
+	if dir, ok := c.paths["cpu"]; ok {
+		if values, ok := readKeyValues(path.Join(dir, "cpu.stat")); ok {
+			setScaled(c.cpu, "periods", values, "nr_periods", 1)
+			setScaled(c.cpu, "throttled_periods", values, "nr_throttled", 1)
+			setScaled(c.cpu, "throttled_time", values, "throttled_time", 1e9)
+		}
+	}
+
+	if dir, ok := c.paths["cpuacct"]; ok {
+		if num, ok := readUint(path.Join(dir, "cpuacct.usage")); ok {
+			c.cpu["usage"] = float64(num) / 1e9
+		}
+	}
+
+	if dir, ok := c.paths["memory"]; ok {
+		if num, ok := readUint(path.Join(dir, "memory.usage_in_bytes")); ok {
+			c.mem["current"] = num
+		}
+		if num, ok := readUint(path.Join(dir, "memory.limit_in_bytes")); ok && num < cgroupUnlimited {
+			c.mem["max"] = num
+		}
+	}
+
+	// one line for each device and operation, example:
+	// 8:0 Read 90430464
+	if dir, ok := c.paths["blkio"]; ok {
+		for file, labels := range map[string][2]string{
+			"blkio.throttle.io_service_bytes": {"read_bytes", "write_bytes"},
+			"blkio.throttle.io_serviced":      {"reads", "writes"},
+		} {
+			data, err := ioutil.ReadFile(path.Join(dir, file))
+			if err != nil {
+				continue
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) != 3 {
+					continue
+				}
+				if num, err := strconv.ParseUint(fields[2], 10, 64); err == nil {
+					switch fields[1] {
+					case "Read":
+						c.io[labels[0]] += num
+					case "Write":
+						c.io[labels[1]] += num
+					}
+				}
+			}
+		}
+	}
+}
+
+// readKeyValues - parse file with lines of "key value"
+func readKeyValues(fp string) (map[string]uint64, bool) {
+	data, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, false
+	}
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			if num, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				values[fields[0]] = num
+			}
+		}
+	}
+	return values, true
+}
+
+// readUint - parse file with a single numeric value
+func readUint(fp string) (uint64, bool) {
+	data, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return 0, false
+	}
+	num, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return num, err == nil
+}
+
+func setScaled(m map[string]float64, label string, values map[string]uint64, key string, divisor float64) {
+	if num, ok := values[key]; ok {
+		m[label] = float64(num) / divisor
+	}
+}
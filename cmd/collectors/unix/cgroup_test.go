@@ -0,0 +1,82 @@
+package unix
+
+import (
+	"testing"
+)
+
+func TestCgroup(t *testing.T) {
+	defer func() { _CGROUP_ROOT = "/sys/fs/cgroup" }()
+
+	type test struct {
+		name        string
+		root        string
+		dirpath     string
+		containerId string
+		cpu         map[string]float64
+		pressure    map[string]float64
+		mem         map[string]uint64
+		io          map[string]uint64
+	}
+
+	tests := []test{
+		{
+			name:        "v2",
+			root:        "testdata/cgroup/v2",
+			dirpath:     "testdata/proc/100",
+			containerId: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			cpu:         map[string]float64{"usage": 2.5, "periods": 120, "throttled_periods": 7, "throttled_time": 0.35},
+			pressure:    map[string]float64{"some_avg10": 1.5, "some_total": 2, "full_total": 0.5},
+			mem:         map[string]uint64{"current": 104857600},
+			io:          map[string]uint64{"read_bytes": 1001, "write_bytes": 2002, "reads": 13, "writes": 24},
+		},
+		{
+			// memory controller is mounted in the namespace of the container
+			name:        "v1",
+			root:        "testdata/cgroup/v1",
+			dirpath:     "testdata/proc/200",
+			containerId: "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210",
+			cpu:         map[string]float64{"usage": 3, "periods": 50, "throttled_periods": 5, "throttled_time": 2},
+			pressure:    map[string]float64{},
+			mem:         map[string]uint64{"current": 52428800},
+			io:          map[string]uint64{"read_bytes": 4096, "write_bytes": 8192, "reads": 1, "writes": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_CGROUP_ROOT = tt.root
+			c, err := NewCgroup(tt.dirpath)
+			if err != nil {
+				t.Fatalf("NewCgroup() error = %v", err)
+			}
+			c.Reload()
+
+			if c.ContainerId() != tt.containerId {
+				t.Errorf("ContainerId() = %s, want %s", c.ContainerId(), tt.containerId)
+			}
+			for k, v := range tt.cpu {
+				if c.cpu[k] != v {
+					t.Errorf("cpu[%s] = %v, want %v", k, c.cpu[k], v)
+				}
+			}
+			for k, v := range tt.pressure {
+				if c.pressure[k] != v {
+					t.Errorf("pressure[%s] = %v, want %v", k, c.pressure[k], v)
+				}
+			}
+			if len(c.mem) != len(tt.mem) {
+				t.Errorf("mem = %v, want %v", c.mem, tt.mem)
+			}
+			for k, v := range tt.mem {
+				if c.mem[k] != v {
+					t.Errorf("mem[%s] = %v, want %v", k, c.mem[k], v)
+				}
+			}
+			for k, v := range tt.io {
+				if c.io[k] != v {
+					t.Errorf("io[%s] = %v, want %v", k, c.io[k], v)
+				}
+			}
+		})
+	}
+}
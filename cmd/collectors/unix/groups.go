@@ -0,0 +1,349 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package unix
+
+import (
+	"bytes"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/set"
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProcessGroup - a group of processes that are monitored as one instance.
+// Processes are selected by one of: name, cmdline regex, pidfile or systemd unit.
+type ProcessGroup struct {
+	name     string
+	procName string
+	cmdline  *regexp.Regexp
+	pidfile  string
+	unit     string
+	pids     []int
+}
+
+// NewProcessGroup - creates a group from its definition in the template, e.g.:
+//
+//	grafana:
+//	  cmdline: grafana-server
+func NewProcessGroup(n *node.Node) (*ProcessGroup, error) {
+
+	var err error
+
+	g := &ProcessGroup{name: n.GetNameS()}
+	g.procName = n.GetChildContentS("name")
+	g.pidfile = n.GetChildContentS("pidfile")
+	g.unit = n.GetChildContentS("systemd")
+
+	if x := n.GetChildContentS("cmdline"); x != "" {
+		if g.cmdline, err = regexp.Compile(x); err != nil {
+			return nil, errors.New(errors.INVALID_PARAM, "group "+g.name+": cmdline: "+err.Error())
+		}
+	}
+
+	if g.procName == "" && g.cmdline == nil && g.pidfile == "" && g.unit == "" {
+		return nil, errors.New(errors.MISSING_PARAM, "group "+g.name+": one of name, cmdline, pidfile or systemd")
+	}
+
+	// names in /proc/<pid>/comm are truncated to 15 characters
+	if len(g.procName) > 15 {
+		g.procName = g.procName[:15]
+	}
+
+	// allow unit names without suffix, e.g. "grafana-server"
+	if g.unit != "" && !strings.Contains(g.unit, ".") {
+		g.unit += ".service"
+	}
+	return g, nil
+}
+
+// matches - true if the process with name, cmdline and cgroup belongs to the group
+func (g *ProcessGroup) matches(name, cmdline, cgroup string) bool {
+	if g.procName != "" && g.procName != name {
+		return false
+	}
+	if g.cmdline != nil && !g.cmdline.MatchString(cmdline) {
+		return false
+	}
+	if g.unit != "" && !inUnit(cgroup, g.unit) {
+		return false
+	}
+	return true
+}
+
+// inUnit - true if the content of /proc/<pid>/cgroup places the process in the
+// systemd unit. Examples of matching lines for cgroup v2 and v1:
+//
+//	0::/system.slice/grafana-server.service
+//	1:name=systemd:/system.slice/grafana-server.service
+func inUnit(cgroup, unit string) bool {
+	for _, line := range strings.Split(cgroup, "\n") {
+		if fields := strings.SplitN(line, ":", 3); len(fields) == 3 {
+			if fields[1] == "" || fields[1] == "name=systemd" {
+				for _, p := range strings.Split(fields[2], "/") {
+					if p == unit {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// readPidfile - PID of the process in the pidfile of the group
+func (g *ProcessGroup) readPidfile() (int, error) {
+	data, err := ioutil.ReadFile(g.pidfile)
+	if err != nil {
+		return 0, errors.New(FILE_READ, err.Error())
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, errors.New(FIELD_VALUE, g.pidfile+": "+err.Error())
+	}
+	return pid, nil
+}
+
+// loadGroups - initialize process groups from the template
+func (me *Unix) loadGroups(groups *node.Node) error {
+	me.groups = make([]*ProcessGroup, 0, len(groups.GetChildren()))
+	for _, n := range groups.GetChildren() {
+		g, err := NewProcessGroup(n)
+		if err != nil {
+			return err
+		}
+		me.groups = append(me.groups, g)
+		me.Logger.Debug().Msgf("added process group (%s)", g.name)
+	}
+	if len(me.groups) == 0 {
+		return errors.New(errors.MISSING_PARAM, "groups")
+	}
+	return nil
+}
+
+// scanGroups - find the PIDs of the processes in each group
+func (me *Unix) scanGroups() error {
+
+	var (
+		scan, readCgroup bool
+		dirs             []string
+	)
+
+	for _, g := range me.groups {
+		g.pids = make([]int, 0)
+		if g.pidfile != "" {
+			if pid, err := g.readPidfile(); err == nil {
+				g.pids = append(g.pids, pid)
+			} else {
+				me.Logger.Debug().Msgf("group (%s): %v", g.name, err)
+			}
+			continue
+		}
+		scan = true
+		readCgroup = readCgroup || g.unit != ""
+	}
+
+	if !scan {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(_MOUNT_POINT)
+	if err != nil {
+		return errors.New(DIR_READ, err.Error())
+	}
+
+	for _, f := range files {
+		if _, err := strconv.Atoi(f.Name()); err == nil && f.IsDir() {
+			dirs = append(dirs, f.Name())
+		}
+	}
+
+	for _, dir := range dirs {
+
+		var name, cmdline, cgroup string
+
+		// process might have terminated in the meantime
+		data, err := ioutil.ReadFile(path.Join(_MOUNT_POINT, dir, "comm"))
+		if err != nil {
+			continue
+		}
+		name = strings.TrimSpace(string(data))
+
+		if data, err = ioutil.ReadFile(path.Join(_MOUNT_POINT, dir, "cmdline")); err != nil {
+			continue
+		}
+		cmdline = string(bytes.TrimSpace(bytes.ReplaceAll(data, []byte("\x00"), []byte(" "))))
+
+		// skip kernel threads
+		if cmdline == "" {
+			continue
+		}
+
+		if readCgroup {
+			if data, err = ioutil.ReadFile(path.Join(_MOUNT_POINT, dir, "cgroup")); err == nil {
+				cgroup = string(data)
+			}
+		}
+
+		pid, _ := strconv.Atoi(dir)
+		for _, g := range me.groups {
+			if g.pidfile == "" && g.matches(name, cmdline, cgroup) {
+				g.pids = append(g.pids, pid)
+			}
+		}
+	}
+
+	for _, g := range me.groups {
+		sort.Ints(g.pids)
+		me.Logger.Debug().Msgf("group (%s) has %d processes: %v", g.name, len(g.pids), g.pids)
+	}
+	return nil
+}
+
+// pollGroupInstance - update instance cache with process groups and
+// the PIDs of their processes
+func (me *Unix) pollGroupInstance() (*matrix.Matrix, error) {
+
+	if err := me.scanGroups(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range me.groups {
+		if me.Matrix.GetInstance(g.name) == nil {
+			instance, err := me.Matrix.NewInstance(g.name)
+			if err != nil {
+				return nil, err
+			}
+			instance.SetLabel("group", g.name)
+			me.Logger.Debug().Msgf("add instance (%s)", g.name)
+		}
+	}
+
+	// forget processes that are no longer in any group
+	pids := set.New()
+	for _, g := range me.groups {
+		for _, pid := range g.pids {
+			pids.Add(strconv.Itoa(pid))
+		}
+	}
+	for key := range me.processes {
+		if !pids.Has(key) {
+			delete(me.processes, key)
+		}
+	}
+
+	return nil, nil
+}
+
+// pollGroupData - update data cache with the sum of the
+// resource usage of the processes in each group
+func (me *Unix) pollGroupData() (*matrix.Matrix, error) {
+
+	var count uint64
+
+	me.Matrix.Reset()
+
+	if err := me.system.Reload(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range me.groups {
+
+		instance := me.Matrix.GetInstance(g.name)
+		if instance == nil {
+			continue
+		}
+
+		procs := make([]*Process, 0, len(g.pids))
+		for _, pid := range g.pids {
+			key := strconv.Itoa(pid)
+			proc, ok := me.processes[key]
+			if ok {
+				if err := proc.Reload(); err != nil {
+					delete(me.processes, key)
+					continue
+				}
+			} else {
+				var err error
+				if proc, err = NewProcess(pid); err != nil {
+					me.Logger.Debug().Msgf("group (%s), skip PID (%d): %v", g.name, pid, err)
+					continue
+				}
+				me.processes[key] = proc
+			}
+			procs = append(procs, proc)
+		}
+
+		if err := me.Matrix.LazySetValueUint64("processes", g.name, uint64(len(procs))); err != nil {
+			me.Logger.Error().Stack().Err(err).Msgf("set processes of [%s]", g.name)
+		}
+
+		// group is not running
+		if len(procs) == 0 {
+			if err := me.Matrix.LazySetValueUint8("status", g.name, 1); err != nil {
+				me.Logger.Error().Stack().Err(err).Msgf("set status of [%s]", g.name)
+			}
+			continue
+		}
+
+		if err := me.Matrix.LazySetValueUint8("status", g.name, 0); err != nil {
+			me.Logger.Error().Stack().Err(err).Msgf("set status of [%s]", g.name)
+		}
+
+		count += me.setProcessMetrics(instance, sumProcesses(procs)) + 2
+	}
+
+	me.AddCollectCount(count)
+	me.Logger.Debug().Msgf("poll complete, added %d data points", count)
+	return me.Matrix, nil
+}
+
+// sumProcesses - returns a Process with the sum of resource usage of procs.
+// Start time is that of the oldest process, network and cgroup usage that of the
+// first process, since processes in a group usually share their namespace and cgroup.
+func sumProcesses(procs []*Process) *Process {
+
+	if len(procs) == 1 {
+		return procs[0]
+	}
+
+	sum := &Process{pid: procs[0].pid, name: procs[0].name, cmdline: procs[0].cmdline, startTime: procs[0].startTime}
+	sum.cpu = make(map[string]float64)
+	sum.mem = make(map[string]uint64)
+	sum.io = make(map[string]uint64)
+	sum.net = procs[0].net
+	sum.cgroup = procs[0].cgroup
+	sum.ctx = make(map[string]uint64)
+
+	for _, p := range procs {
+		if p.startTime < sum.startTime {
+			sum.startTime = p.startTime
+		}
+		if p.elapsedTime > sum.elapsedTime {
+			sum.elapsedTime = p.elapsedTime
+		}
+		sum.numThreads += p.numThreads
+		sum.numFds += p.numFds
+		sum.cpuTotal += p.cpuTotal
+		for k, v := range p.cpu {
+			sum.cpu[k] += v
+		}
+		for k, v := range p.mem {
+			sum.mem[k] += v
+		}
+		for k, v := range p.io {
+			sum.io[k] += v
+		}
+		for k, v := range p.ctx {
+			sum.ctx[k] += v
+		}
+	}
+	return sum
+}
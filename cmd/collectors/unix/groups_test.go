@@ -0,0 +1,54 @@
+package unix
+
+import (
+	"goharvest2/pkg/tree/node"
+	"testing"
+)
+
+func TestProcessGroupMatches(t *testing.T) {
+
+	newGroup := func(key, value string) *ProcessGroup {
+		n := node.NewS("group")
+		n.NewChildS(key, value)
+		g, err := NewProcessGroup(n)
+		if err != nil {
+			t.Fatalf("NewProcessGroup() error = %v", err)
+		}
+		return g
+	}
+
+	cgroupV1 := "12:memory:/system.slice/grafana-server.service\n1:name=systemd:/system.slice/grafana-server.service\n"
+	cgroupV2 := "0::/system.slice/grafana-server.service\n"
+
+	type test struct {
+		name    string
+		group   *ProcessGroup
+		pname   string
+		cmdline string
+		cgroup  string
+		want    bool
+	}
+
+	tests := []test{
+		{"name", newGroup("name", "prometheus"), "prometheus", "/bin/prometheus --config.file=x", "", true},
+		{"name_mismatch", newGroup("name", "prometheus"), "grafana-server", "/bin/prometheus", "", false},
+		{"name_truncated", newGroup("name", "grafana-server-longname"), "grafana-server-", "", "", true},
+		{"cmdline", newGroup("cmdline", "(^|/)influxd( |$)"), "influxd", "/usr/bin/influxd -config x", "", true},
+		{"cmdline_mismatch", newGroup("cmdline", "(^|/)influxd( |$)"), "influxd-ctl", "/usr/bin/influxd-ctl", "", false},
+		{"systemd_v1", newGroup("systemd", "grafana-server"), "grafana-server", "", cgroupV1, true},
+		{"systemd_v2", newGroup("systemd", "grafana-server.service"), "grafana-server", "", cgroupV2, true},
+		{"systemd_mismatch", newGroup("systemd", "grafana"), "grafana-server", "", cgroupV2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.group.matches(tt.pname, tt.cmdline, tt.cgroup); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewProcessGroup(node.NewS("empty")); err == nil {
+		t.Errorf("NewProcessGroup() of group without selector should fail")
+	}
+}
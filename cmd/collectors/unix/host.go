@@ -0,0 +1,514 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package unix
+
+import (
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/logging"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/set"
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// hostCounter describes a counter of the host object
+type hostCounter struct {
+	kind   string   // kind of instances the counter applies to: system, fs, disk or net
+	dtype  string   // data type of the metric
+	labels []string // labels of a histogram, nil if counter is a scalar metric
+}
+
+var fsLabels = []string{"size", "used", "available", "files", "files_free"}
+
+var diskLabels = []string{
+	"reads", "reads_merged", "read_bytes", "read_time",
+	"writes", "writes_merged", "write_bytes", "write_time",
+	"io_in_progress", "io_time", "io_weighted_time",
+}
+
+var netLabels = []string{
+	"rcv_bytes", "rcv_packets", "rcv_errs", "rcv_drop", "rcv_fifo", "rcv_frame", "rcv_compressed", "rcv_multicast",
+	"sent_bytes", "sent_packets", "sent_errs", "sent_drop", "sent_fifo", "sent_colls", "sent_carrier", "sent_compressed",
+}
+
+// list of counters provided by the host object
+var _HOST_COUNTERS = map[string]hostCounter{
+	"cpu":            {"system", "float64", cpuModes},
+	"cpu_percent":    {"system", "float64", nil},
+	"load":           {"system", "float64", []string{"1m", "5m", "15m"}},
+	"memory":         {"system", "uint64", []string{"total", "free", "available", "used", "buffers", "cached"}},
+	"memory_percent": {"system", "float64", nil},
+	"swap":           {"system", "uint64", []string{"total", "free", "used"}},
+	"fs":             {"fs", "uint64", fsLabels},
+	"fs_percent":     {"fs", "float64", nil},
+	"disk":           {"disk", "uint64", diskLabels},
+	"net":            {"net", "uint64", netLabels},
+}
+
+// devices that are never reported as disks
+var skipDisks = []string{"loop", "ram", "zram"}
+
+type mount struct {
+	device string
+	fstype string
+}
+
+// Host - provides usage of filesystems, disks and network interfaces of the system
+type Host struct {
+	mounts map[string]mount             // by mount point
+	fs     map[string]map[string]uint64 // by mount point
+	disks  map[string]map[string]uint64 // by device name
+	nets   map[string]map[string]uint64 // by interface name
+}
+
+// NewHost - creates an empty instance of Host
+func NewHost() *Host {
+	h := &Host{}
+	h.mounts = make(map[string]mount)
+	h.fs = make(map[string]map[string]uint64)
+	h.disks = make(map[string]map[string]uint64)
+	h.nets = make(map[string]map[string]uint64)
+	return h
+}
+
+// Reload - refresh the stats of the requested kinds (fs, disk, net)
+func (h *Host) Reload(kinds *set.Set) error {
+
+	if kinds.Has("fs") {
+		if err := h.loadFs(); err != nil {
+			return err
+		}
+	}
+
+	if kinds.Has("disk") {
+		if err := h.loadDiskstats(); err != nil {
+			return err
+		}
+	}
+
+	if kinds.Has("net") {
+		if err := h.loadNetDev(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read mounted filesystems from /proc/mounts and get their usage with statfs,
+// filesystems not backed by a device (as listed in /proc/filesystems) are skipped
+func (h *Host) loadFs() error {
+
+	var (
+		data   []byte
+		fields []string
+		nodev  *set.Set
+		err    error
+	)
+
+	if data, err = ioutil.ReadFile(path.Join(_MOUNT_POINT, "filesystems")); err != nil {
+		return errors.New(FILE_READ, "filesystems: "+err.Error())
+	}
+
+	// example: "nodev	sysfs"
+	nodev = set.New()
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields = strings.Fields(line); len(fields) == 2 && fields[0] == "nodev" {
+			nodev.Add(fields[1])
+		}
+	}
+
+	if data, err = ioutil.ReadFile(path.Join(_MOUNT_POINT, "mounts")); err != nil {
+		return errors.New(FILE_READ, "mounts: "+err.Error())
+	}
+
+	h.mounts = make(map[string]mount)
+	h.fs = make(map[string]map[string]uint64)
+	devices := set.New()
+
+	// example: "/dev/sda1 / ext4 rw,relatime 0 0"
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields = strings.Fields(line); len(fields) < 3 {
+			continue
+		}
+		device, mountpoint, fstype := fields[0], unescapeMount(fields[1]), fields[2]
+
+		// same device can be mounted multiple times (e.g. bind mounts)
+		if nodev.Has(fstype) || devices.Has(device) {
+			continue
+		}
+
+		values, err := statfs(mountpoint)
+		if err != nil {
+			logging.Get().Debug().Msgf("skip filesystem [%s]: %v", mountpoint, err)
+			continue
+		}
+		if values["size"] == 0 {
+			continue
+		}
+		devices.Add(device)
+		h.mounts[mountpoint] = mount{device: device, fstype: fstype}
+		h.fs[mountpoint] = values
+	}
+	return nil
+}
+
+// spaces and other special characters are octal escaped in /proc/mounts
+func unescapeMount(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// read IO stats of block devices from /proc/diskstats, example:
+// 8       0 sda 24469 8383 1507498 12843 41255 38210 2317640 73513 0 53736 89244
+// sectors are always 512 bytes, times are in milliseconds
+func (h *Host) loadDiskstats() error {
+
+	data, err := ioutil.ReadFile(path.Join(_MOUNT_POINT, "diskstats"))
+	if err != nil {
+		return errors.New(FILE_READ, "diskstats: "+err.Error())
+	}
+
+	h.disks = make(map[string]map[string]uint64)
+
+	for _, line := range strings.Split(string(data), "\n") {
+
+		fields := strings.Fields(line)
+		if len(fields) < 14 || isSkippedDisk(fields[2]) {
+			continue
+		}
+
+		values := make(map[string]uint64)
+		for i, label := range diskLabels {
+			num, err := strconv.ParseUint(fields[i+3], 10, 64)
+			if err != nil {
+				return errors.New(FIELD_VALUE, "/proc/diskstats: "+label+" ["+fields[i+3]+"]")
+			}
+			if label == "read_bytes" || label == "write_bytes" {
+				num *= 512
+			}
+			values[label] = num
+		}
+
+		// device was never used
+		if values["reads"] == 0 && values["writes"] == 0 {
+			continue
+		}
+		h.disks[fields[2]] = values
+	}
+	return nil
+}
+
+func isSkippedDisk(name string) bool {
+	for _, prefix := range skipDisks {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// read traffic of network interfaces from /proc/net/dev
+func (h *Host) loadNetDev() error {
+	data, err := ioutil.ReadFile(path.Join(_MOUNT_POINT, "net", "dev"))
+	if err != nil {
+		return errors.New(FILE_READ, "net/dev: "+err.Error())
+	}
+	h.nets = parseNetDev(data)
+	return nil
+}
+
+// parseNetDev parses the content of a net/dev file into counters of each interface
+func parseNetDev(data []byte) map[string]map[string]uint64 {
+
+	var (
+		labels, raw, legend, fields, lines []string
+		num                                uint64
+		err                                error
+	)
+
+	nets := make(map[string]map[string]uint64)
+
+	// extract counter labels from the second line of the header, example:
+	//  face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets ...
+	if lines = strings.Split(string(data), "\n"); len(lines) > 2 {
+		if legend = strings.Split(lines[1], "|"); len(legend) > 2 {
+
+			raw = strings.Fields(legend[1])
+			for _, label := range raw {
+				labels = append(labels, "rcv_"+strings.TrimSpace(label))
+			}
+
+			raw = strings.Fields(legend[2])
+			for _, label := range raw {
+				labels = append(labels, "sent_"+strings.TrimSpace(label))
+			}
+		}
+
+		// extract counter values, interface name is followed by a colon
+		// and might not be separated by space from the first value
+		for _, line := range lines[2:] {
+			x := strings.SplitN(line, ":", 2)
+			if len(x) != 2 {
+				continue
+			}
+			if fields = strings.Fields(x[1]); len(fields) == len(labels) {
+				values := make(map[string]uint64)
+				for i, value := range fields {
+					if num, err = strconv.ParseUint(value, 10, 64); err == nil {
+						values[labels[i]] = num
+					}
+				}
+				nets[strings.TrimSpace(x[0])] = values
+			}
+		}
+	}
+
+	return nets
+}
+
+// loadHostMetrics - initialize metrics of the host object from the counters in the template
+func (me *Unix) loadHostMetrics(counters *node.Node) error {
+
+	var (
+		metric matrix.Metric
+		err    error
+	)
+
+	me.host = NewHost()
+	me.hostKinds = set.New()
+	me.histogramLabels = make(map[string][]string)
+
+	for _, cnt := range counters.GetChildren() {
+
+		name, display := parseMetricName(cnt.GetNameS())
+		if cnt.GetNameS() == "" {
+			name, display = parseMetricName(cnt.GetContentS())
+		}
+
+		counter, ok := _HOST_COUNTERS[name]
+		if !ok {
+			me.Logger.Warn().Msgf("(%s) skipped unknown metric", name)
+			continue
+		}
+
+		me.hostKinds.Add(counter.kind)
+
+		// counter is scalar metric
+		if counter.labels == nil {
+			if metric, err = me.Matrix.NewMetricType(name, counter.dtype); err != nil {
+				return err
+			}
+			metric.SetName(display)
+			me.Logger.Debug().Msgf("(%s) added metric (%s)", name, display)
+			continue
+		}
+
+		// counter is histogram, if template defines labels, only collect those
+		wanted := counter.labels
+		if len(cnt.GetChildren()) != 0 {
+			wanted = cnt.GetAllChildContentS()
+		}
+
+		labels := set.NewFrom(counter.labels)
+		me.histogramLabels[name] = make([]string, 0, len(wanted))
+
+		for _, w := range wanted {
+			label, ldisplay := parseMetricName(w)
+			if !labels.Has(label) {
+				me.Logger.Warn().Msgf("invalid histogram metric [%s]", label)
+				continue
+			}
+			if metric, err = me.Matrix.NewMetricType(name+"."+label, counter.dtype); err != nil {
+				return err
+			}
+			metric.SetName(display)
+			metric.SetLabel("metric", ldisplay)
+			me.histogramLabels[name] = append(me.histogramLabels[name], label)
+		}
+		me.Logger.Debug().Msgf("(%s) added histogram (%s) with %d submetrics", name, display, len(me.histogramLabels[name]))
+	}
+
+	me.Logger.Debug().Msgf("initialized cache with %d metrics", len(me.Matrix.GetMetrics()))
+	return nil
+}
+
+// pollHostInstance - update instance cache with the system and its
+// filesystems, disks and network interfaces
+func (me *Unix) pollHostInstance() (*matrix.Matrix, error) {
+
+	if err := me.host.Reload(me.hostKinds); err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]map[string]string)
+
+	if me.hostKinds.Has("system") {
+		wanted["system"] = map[string]string{"kind": "system", "name": me.Options.Hostname}
+	}
+
+	for mp, m := range me.host.mounts {
+		wanted["fs:"+mp] = map[string]string{"kind": "fs", "name": mp, "device": m.device, "fstype": m.fstype}
+	}
+
+	for name := range me.host.disks {
+		wanted["disk:"+name] = map[string]string{"kind": "disk", "name": name}
+	}
+
+	for name := range me.host.nets {
+		wanted["net:"+name] = map[string]string{"kind": "net", "name": name}
+	}
+
+	oldInstances := set.NewFrom(me.Matrix.GetInstanceKeys())
+
+	for key, labels := range wanted {
+		instance := me.Matrix.GetInstance(key)
+		if instance == nil {
+			var err error
+			if instance, err = me.Matrix.NewInstance(key); err != nil {
+				return nil, err
+			}
+			me.Logger.Debug().Msgf("add instance (%s)", key)
+		}
+		oldInstances.Delete(key)
+		for k, v := range labels {
+			instance.SetLabel(k, v)
+		}
+	}
+
+	me.removeInstances(oldInstances)
+	me.Logger.Debug().Msgf("removed %d, total instances %d", oldInstances.Size(), len(me.Matrix.GetInstances()))
+
+	return nil, nil
+}
+
+// pollHostData - update data cache of the host object
+func (me *Unix) pollHostData() (*matrix.Matrix, error) {
+
+	var count uint64
+
+	me.Matrix.Reset()
+
+	if me.hostKinds.Has("system") {
+		if err := me.system.Reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := me.host.Reload(me.hostKinds); err != nil {
+		return nil, err
+	}
+
+	for _, instance := range me.Matrix.GetInstances() {
+
+		kind := instance.GetLabel("kind")
+		name := instance.GetLabel("name")
+
+		for key, counter := range _HOST_COUNTERS {
+			if counter.kind != kind {
+				continue
+			}
+
+			// scalar metrics
+			if counter.labels == nil {
+				if metric := me.Matrix.GetMetric(key); metric != nil {
+					if value, ok := me.hostScalar(key, name); ok {
+						if err := metric.SetValueFloat64(instance, value); err != nil {
+							me.Logger.Error().Stack().Err(err).Msgf("set metric [%s]", key)
+						} else {
+							count++
+						}
+					}
+				}
+				continue
+			}
+
+			// histograms
+			for _, label := range me.histogramLabels[key] {
+				if metric := me.Matrix.GetMetric(key + "." + label); metric != nil {
+					if me.setHostHistogram(metric, key, label, name, instance) {
+						count++
+					}
+				}
+			}
+		}
+	}
+
+	me.AddCollectCount(count)
+	me.Logger.Debug().Msgf("poll complete, added %d data points", count)
+	return me.Matrix, nil
+}
+
+func (me *Unix) hostScalar(key, name string) (float64, bool) {
+	switch key {
+	case "cpu_percent":
+		return me.system.cpuBusy, true
+	case "memory_percent":
+		if me.system.memTotal != 0 {
+			return float64(me.system.mem["used"]) / float64(me.system.memTotal) * 100, true
+		}
+	case "fs_percent":
+		// same as df(1): available space excludes space reserved for root
+		if v, ok := me.host.fs[name]; ok && v["used"]+v["available"] != 0 {
+			return float64(v["used"]) / float64(v["used"]+v["available"]) * 100, true
+		}
+	}
+	return 0, false
+}
+
+func (me *Unix) setHostHistogram(m matrix.Metric, key, label, name string, i *matrix.Instance) bool {
+
+	var (
+		fvalues map[string]float64
+		values  map[string]uint64
+		err     error
+	)
+
+	switch key {
+	case "cpu":
+		fvalues = me.system.cpu
+	case "load":
+		fvalues = me.system.load
+	case "memory":
+		values = me.system.mem
+	case "swap":
+		values = me.system.swap
+	case "fs":
+		values = me.host.fs[name]
+	case "disk":
+		values = me.host.disks[name]
+	case "net":
+		values = me.host.nets[name]
+	}
+
+	if value, ok := fvalues[label]; ok {
+		err = m.SetValueFloat64(i, value)
+	} else if value, ok := values[label]; ok {
+		err = m.SetValueUint64(i, value)
+	} else {
+		return false
+	}
+
+	if err != nil {
+		me.Logger.Error().Stack().Err(err).Msgf("set metric [%s.%s]", key, label)
+		return false
+	}
+	return true
+}
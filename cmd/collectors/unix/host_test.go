@@ -0,0 +1,60 @@
+package unix
+
+import (
+	"goharvest2/pkg/set"
+	"testing"
+)
+
+func TestHostReload(t *testing.T) {
+	_MOUNT_POINT = "testdata/proc"
+	_CLK_TCK = 100
+	defer func() { _MOUNT_POINT = "/proc" }()
+
+	s, err := NewSystem()
+	if err != nil {
+		t.Fatalf("NewSystem() error = %v", err)
+	}
+
+	h := NewHost()
+	if err = h.Reload(set.NewFrom([]string{"disk", "net"})); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	type test struct {
+		name string
+		got  float64
+		want float64
+	}
+
+	tests := []test{
+		{"boot_time", s.bootTime, 1062191376},
+		{"cpu_user", s.cpu["user"], 85364.93},
+		{"cpu_softirq", s.cpu["softirq"], 2626.35},
+		{"load_5m", s.load["5m"], 0.58},
+		{"memory_total", float64(s.mem["total"]), 7707284},
+		{"memory_used", float64(s.mem["used"]), 7707284 - 905628 - 320112 - 3792032},
+		{"swap_used", float64(s.swap["used"]), 0},
+		{"disks", float64(len(h.disks)), 2}, // loop0 and unused sdb are skipped
+		{"disk_read_bytes", float64(h.disks["sda"]["read_bytes"]), 1507498 * 512},
+		{"disk_io_time", float64(h.disks["sda1"]["io_time"]), 53632},
+		{"nets", float64(len(h.nets)), 2},
+		{"net_rcv_bytes", float64(h.nets["eth0"]["rcv_bytes"]), 123456789012},
+		{"net_rcv_multicast", float64(h.nets["eth0"]["rcv_multicast"]), 100},
+		{"net_sent_drop", float64(h.nets["eth0"]["sent_drop"]), 4},
+		{"net_lo_sent_packets", float64(h.nets["lo"]["sent_packets"]), 158340},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnescapeMount(t *testing.T) {
+	if got := unescapeMount(`/mnt/my\040disk`); got != "/mnt/my disk" {
+		t.Errorf("unescapeMount() = %s, want /mnt/my disk", got)
+	}
+}
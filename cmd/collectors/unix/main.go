@@ -46,6 +46,11 @@ var _HISTOGRAMS = map[string]func(matrix.Metric, string, *matrix.Instance, *Proc
 	"io":     setIo,
 	"net":    setNet,
 	"ctx":    setCtx,
+	// usage and limits of the cgroup of the process
+	"cgroup_cpu":      setCgroupCpu,
+	"cgroup_memory":   setCgroupMemory,
+	"cgroup_pressure": setCgroupPressure,
+	"cgroup_io":       setCgroupIo,
 }
 
 // list of (scalar) metrics
@@ -58,16 +63,20 @@ var _METRICS = map[string]func(matrix.Metric, *matrix.Instance, *Process, *Syste
 }
 
 var _DTYPES = map[string]string{
-	"cpu":            "float64",
-	"memory":         "uint64",
-	"io":             "uint64",
-	"net":            "uint64",
-	"ctx":            "uint64",
-	"start_time":     "float64",
-	"cpu_percent":    "float64",
-	"memory_percent": "float64",
-	"threads":        "uint64",
-	"fds":            "uint64",
+	"cpu":             "float64",
+	"memory":          "uint64",
+	"io":              "uint64",
+	"net":             "uint64",
+	"ctx":             "uint64",
+	"start_time":      "float64",
+	"cpu_percent":     "float64",
+	"memory_percent":  "float64",
+	"threads":         "uint64",
+	"fds":             "uint64",
+	"cgroup_cpu":      "float64",
+	"cgroup_memory":   "uint64",
+	"cgroup_pressure": "float64",
+	"cgroup_io":       "uint64",
 }
 
 func init() {
@@ -104,7 +113,9 @@ func getHistogramLabels(p *Process, name string) []string {
 
 	// dirty fast solution
 
-	if name == "cpu" {
+	if x, ok := cgroupLabels[name]; ok {
+		labels = append(labels, x...)
+	} else if name == "cpu" {
 		for key := range p.cpu {
 			labels = append(labels, key)
 		}
@@ -129,11 +140,15 @@ func getHistogramLabels(p *Process, name string) []string {
 	return labels
 }
 
-// Unix - collector providing basic stats about harvest pollers
-// @TODO - extend to monitor any user-defined process
+// Unix - collector providing basic stats about harvest pollers (object "poller"),
+// user-defined process groups (object "process") and about the system where
+// Harvest is running (object "host")
 type Unix struct {
 	*collector.AbstractCollector
 	system          *System
+	host            *Host
+	hostKinds       *set.Set // kinds of host instances with at least one counter
+	groups          []*ProcessGroup
 	histogramLabels map[string][]string
 	processes       map[string]*Process
 }
@@ -147,6 +162,10 @@ func (me *Unix) Init(a *collector.AbstractCollector) error {
 		return errors.New(errors.ERR_IMPLEMENT, "platform not supported")
 	}
 
+	if err = me.loadSubTemplate(); err != nil {
+		return err
+	}
+
 	if err = collector.Init(me); err != nil {
 		return err
 	}
@@ -156,19 +175,39 @@ func (me *Unix) Init(a *collector.AbstractCollector) error {
 		_MOUNT_POINT = mp
 	}
 
+	if mp := me.Params.GetChildContentS("cgroup_mount_point"); mp != "" {
+		_CGROUP_ROOT = mp
+	}
+
 	// assert fs is available
 	if fi, err := os.Stat(_MOUNT_POINT); err != nil || !fi.IsDir() {
 		return errors.New(errors.ERR_IMPLEMENT, "filesystem ["+_MOUNT_POINT+"] not available")
 	}
 
 	// load list of counters from template
-	if counters := me.Params.GetChildS("counters"); counters != nil {
-		if err = me.loadMetrics(counters); err != nil {
+	if counters := me.Params.GetChildS("counters"); counters == nil {
+		return errors.New(errors.MISSING_PARAM, "counters")
+	} else if me.Object == "host" {
+		if err = me.loadHostMetrics(counters); err != nil {
 			me.Logger.Error().Stack().Err(err).Msg("load metrics")
 			return err
 		}
 	} else {
-		return errors.New(errors.MISSING_PARAM, "counters")
+		if err = me.loadMetrics(counters); err != nil {
+			me.Logger.Error().Stack().Err(err).Msg("load metrics")
+			return err
+		}
+	}
+
+	if me.Object == "process" {
+		if groups := me.Params.GetChildS("groups"); groups == nil {
+			return errors.New(errors.MISSING_PARAM, "groups")
+		} else if err = me.loadGroups(groups); err != nil {
+			return err
+		}
+		if _, err = me.Matrix.NewMetricUint64("processes"); err != nil {
+			return err
+		}
 	}
 
 	getClockTicks()
@@ -184,6 +223,59 @@ func (me *Unix) Init(a *collector.AbstractCollector) error {
 	return nil
 }
 
+// parameters that belong to an object, not to the collector
+var _OBJECT_PARAMS = []string{"counters", "export_options"}
+
+// loadSubTemplate - if the template lists objects, merge the subtemplate of
+// our object into the parameters. Templates that define a single object
+// include the counters and are used as they are.
+//
+// Parameters of the subtemplate take precedence over those of the collector
+// template. Before objects were introduced, counters and export_options of
+// the poller object were top-level parameters, a custom.yaml with this
+// layout is still merged into the subtemplate of the poller object.
+func (me *Unix) loadSubTemplate() error {
+
+	objects := me.Params.GetChildS("objects")
+	if objects == nil {
+		return nil
+	}
+
+	fn := objects.GetChildContentS(me.Object)
+	if fn == "" {
+		return errors.New(errors.MISSING_PARAM, "subtemplate of object "+me.Object)
+	}
+
+	template, err := collector.ImportTemplate(me.Options.HomePath, fn, me.Name)
+	if err != nil {
+		me.Logger.Error().Stack().Err(err).Msgf("Error importing subtemplate: %s", fn)
+		return err
+	}
+
+	for _, name := range _OBJECT_PARAMS {
+		legacy := me.Params.PopChildS(name)
+		if legacy == nil {
+			continue
+		}
+		if me.Object != "poller" {
+			me.Logger.Warn().Msgf("top-level %s in custom.yaml is deprecated and ignored by object %s, move it to a subtemplate of object poller", name, me.Object)
+			continue
+		}
+		me.Logger.Warn().Msgf("top-level %s in custom.yaml is deprecated, move it to a subtemplate of object poller", name)
+		if x := template.GetChildS(name); x != nil {
+			x.Merge(legacy)
+		} else {
+			template.AddChild(legacy)
+		}
+	}
+
+	for _, child := range template.GetChildren() {
+		me.Params.PopChildS(child.GetNameS())
+		me.Params.AddChild(child)
+	}
+	return nil
+}
+
 func (me *Unix) loadMetrics(counters *node.Node) error {
 	var (
 		proc           *Process
@@ -229,6 +321,10 @@ func (me *Unix) loadMetrics(counters *node.Node) error {
 
 			labels = set.NewFrom(getHistogramLabels(proc, name))
 
+			if _, has = cgroupLabels[name]; has {
+				_CGROUP = true
+			}
+
 			me.histogramLabels[name] = make([]string, 0)
 
 			// if template defines labels, only collect those
@@ -278,6 +374,14 @@ func (me *Unix) loadMetrics(counters *node.Node) error {
 // PollInstance - update instance cache with running pollers
 func (me *Unix) PollInstance() (*matrix.Matrix, error) {
 
+	if me.host != nil {
+		return me.pollHostInstance()
+	}
+
+	if me.groups != nil {
+		return me.pollGroupInstance()
+	}
+
 	currInstances := set.NewFrom(me.Matrix.GetInstanceKeys())
 	currSize := currInstances.Size()
 
@@ -306,19 +410,7 @@ func (me *Unix) PollInstance() (*matrix.Matrix, error) {
 			me.Logger.Debug().Msgf("update instance (%s) with PID (%s)", name, pid)
 		}
 	}
-	rewriteIndexes := currInstances.Size() > 0
-	for name := range currInstances.Iter() {
-		me.Matrix.RemoveInstance(name)
-		me.Logger.Debug().Msgf("remove instance (%s)", name)
-	}
-	// If there were removals, the indexes need to be rewritten since gaps were created
-	if rewriteIndexes {
-		newMatrix := me.Matrix.Clone(false, true, false)
-		for key := range me.Matrix.GetInstances() {
-			_, _ = newMatrix.NewInstance(key)
-		}
-		me.Matrix = newMatrix
-	}
+	me.removeInstances(currInstances)
 	t := len(me.Matrix.GetInstances())
 	r := currInstances.Size()
 	a := t - (currSize - r)
@@ -327,9 +419,39 @@ func (me *Unix) PollInstance() (*matrix.Matrix, error) {
 	return nil, nil
 }
 
+// removeInstances - remove instances from the cache
+func (me *Unix) removeInstances(names *set.Set) {
+
+	if names.Size() == 0 {
+		return
+	}
+
+	for name := range names.Iter() {
+		me.Matrix.RemoveInstance(name)
+		me.Logger.Debug().Msgf("remove instance (%s)", name)
+	}
+
+	// indexes need to be rewritten since gaps were created
+	newMatrix := me.Matrix.Clone(false, true, false)
+	for key, instance := range me.Matrix.GetInstances() {
+		if newInstance, err := newMatrix.NewInstance(key); err == nil {
+			newInstance.SetLabels(instance.GetLabels())
+		}
+	}
+	me.Matrix = newMatrix
+}
+
 // PollData - update data cache
 func (me *Unix) PollData() (*matrix.Matrix, error) {
 
+	if me.host != nil {
+		return me.pollHostData()
+	}
+
+	if me.groups != nil {
+		return me.pollGroupData()
+	}
+
 	var (
 		pid   int
 		count uint64
@@ -391,32 +513,44 @@ func (me *Unix) PollData() (*matrix.Matrix, error) {
 
 		me.Logger.Debug().Msgf("populating instance [%s]: PID (%d) with [%s]\n", key, pid, cmd)
 
-		// process scalar metrics
-		for key, foo := range _METRICS {
-			if metric := me.Matrix.GetMetric(key); metric != nil {
-				foo(metric, instance, proc, me.system)
-				//logger.Trace(me.Prefix, "+ (%s) [%f]", key, value)
-				count++
-			}
+		count += me.setProcessMetrics(instance, proc)
+	}
+
+	me.AddCollectCount(count)
+	me.Logger.Debug().Msgf("poll complete, added %d data points", count)
+	return me.Matrix, nil
+}
+
+// setProcessMetrics - set metrics of instance from the resource usage of proc,
+// returns the number of data points that were set
+func (me *Unix) setProcessMetrics(instance *matrix.Instance, proc *Process) uint64 {
+
+	var count uint64
+
+	if proc.cgroup != nil && proc.cgroup.ContainerId() != "" {
+		instance.SetLabel("container_id", proc.cgroup.ContainerId())
+	}
+
+	// process scalar metrics
+	for key, foo := range _METRICS {
+		if metric := me.Matrix.GetMetric(key); metric != nil {
+			foo(metric, instance, proc, me.system)
+			count++
 		}
+	}
 
-		// process histograms
-		for key, foo := range _HISTOGRAMS {
-			if labels, ok := me.histogramLabels[key]; ok {
-				//logger.Trace(me.Prefix, "+++ (%s) [%v]", key, values)
-				for _, label := range labels {
-					if metric := me.Matrix.GetMetric(key + "." + label); metric != nil {
-						foo(metric, label, instance, proc)
-						count++
-					}
+	// process histograms
+	for key, foo := range _HISTOGRAMS {
+		if labels, ok := me.histogramLabels[key]; ok {
+			for _, label := range labels {
+				if metric := me.Matrix.GetMetric(key + "." + label); metric != nil {
+					foo(metric, label, instance, proc)
+					count++
 				}
 			}
 		}
 	}
-
-	me.AddCollectCount(count)
-	me.Logger.Debug().Msgf("poll complete, added %d data points", count)
-	return me.Matrix, nil
+	return count
 }
 
 func setStartTime(m matrix.Metric, i *matrix.Instance, p *Process, s *System) {
@@ -506,6 +640,54 @@ func setCtx(m matrix.Metric, l string, i *matrix.Instance, p *Process) {
 	}
 }
 
+func setCgroupCpu(m matrix.Metric, l string, i *matrix.Instance, p *Process) {
+	if p.cgroup == nil {
+		return
+	}
+	if value, ok := p.cgroup.cpu[l]; ok {
+		err := m.SetValueFloat64(i, value)
+		if err != nil {
+			logging.Get().Error().Stack().Err(err).Msg("error")
+		}
+	}
+}
+
+func setCgroupMemory(m matrix.Metric, l string, i *matrix.Instance, p *Process) {
+	if p.cgroup == nil {
+		return
+	}
+	if value, ok := p.cgroup.mem[l]; ok {
+		err := m.SetValueUint64(i, value)
+		if err != nil {
+			logging.Get().Error().Stack().Err(err).Msg("error")
+		}
+	}
+}
+
+func setCgroupPressure(m matrix.Metric, l string, i *matrix.Instance, p *Process) {
+	if p.cgroup == nil {
+		return
+	}
+	if value, ok := p.cgroup.pressure[l]; ok {
+		err := m.SetValueFloat64(i, value)
+		if err != nil {
+			logging.Get().Error().Stack().Err(err).Msg("error")
+		}
+	}
+}
+
+func setCgroupIo(m matrix.Metric, l string, i *matrix.Instance, p *Process) {
+	if p.cgroup == nil {
+		return
+	}
+	if value, ok := p.cgroup.io[l]; ok {
+		err := m.SetValueUint64(i, value)
+		if err != nil {
+			logging.Get().Error().Stack().Err(err).Msg("error")
+		}
+	}
+}
+
 // Interface guards
 var (
 	_ collector.Collector = (*Unix)(nil)
@@ -0,0 +1,93 @@
+package unix
+
+import (
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/tree"
+	"testing"
+)
+
+func TestLoadSubTemplate(t *testing.T) {
+
+	// custom.yaml with the top-level layout of old versions
+	legacy := `
+objects:
+  host: host.yaml
+counters:
+  - start_time
+export_options:
+  instance_labels:
+    - pid
+`
+	// custom.yaml only listing objects
+	current := `
+objects:
+  host: host.yaml
+`
+
+	type test struct {
+		name     string
+		custom   string
+		object   string
+		counter  string // first counter
+		keys     int    // number of instance_keys
+		labels   string // first instance label
+		counters int
+	}
+
+	tests := []test{
+		{"legacy_poller", legacy, "poller", "start_time", 2, "container_id", 10},
+		{"legacy_host", legacy, "host", "cpu", 2, "device", 0},
+		{"current_poller", current, "poller", "start_time", 2, "container_id", 10},
+		{"current_host", current, "host", "cpu", 2, "device", 0},
+	}
+
+	opts := &options.Options{HomePath: "../../.."}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := collector.ImportTemplate(opts.HomePath, "default.yaml", "Unix")
+			if err != nil {
+				t.Fatalf("ImportTemplate() error = %v", err)
+			}
+			custom, err := tree.LoadYaml([]byte(tt.custom))
+			if err != nil {
+				t.Fatalf("LoadYaml() error = %v", err)
+			}
+			template.Merge(custom)
+
+			me := &Unix{AbstractCollector: collector.New("Unix", tt.object, opts, template)}
+			if err = me.loadSubTemplate(); err != nil {
+				t.Fatalf("loadSubTemplate() error = %v", err)
+			}
+
+			counters := me.Params.GetChildS("counters")
+			if counters == nil || len(counters.GetChildren()) == 0 {
+				t.Fatalf("no counters")
+			}
+			if x := counters.GetChildren()[0].GetContentS(); x != tt.counter {
+				t.Errorf("counters[0] = [%s], want [%s]", x, tt.counter)
+			}
+			if tt.counters != 0 && len(counters.GetChildren()) != tt.counters {
+				t.Errorf("counters = %d, want %d", len(counters.GetChildren()), tt.counters)
+			}
+
+			var keys int
+			var labels string
+			if x := me.Params.GetChildS("export_options"); x != nil {
+				if y := x.GetChildS("instance_keys"); y != nil {
+					keys = len(y.GetChildren())
+				}
+				if y := x.GetChildS("instance_labels"); y != nil && len(y.GetChildren()) != 0 {
+					labels = y.GetChildren()[0].GetContentS()
+				}
+			}
+			if keys != tt.keys {
+				t.Errorf("instance_keys = %d, want %d", keys, tt.keys)
+			}
+			if labels != tt.labels {
+				t.Errorf("instance_labels[0] = [%s], want [%s]", labels, tt.labels)
+			}
+		})
+	}
+}
@@ -35,6 +35,7 @@ type Process struct {
 	io          map[string]uint64
 	net         map[string]uint64
 	ctx         map[string]uint64
+	cgroup      *Cgroup
 }
 
 // NewProcess - returns an initialzed instance of Process
@@ -69,7 +70,7 @@ func (me *Process) Reload() error {
 
 	var err error
 
-	me.dirpath = path.Join("/proc", strconv.Itoa(me.pid)+"/")
+	me.dirpath = path.Join(_MOUNT_POINT, strconv.Itoa(me.pid)+"/")
 
 	if s, err := os.Stat(me.dirpath); err != nil || !s.IsDir() {
 		if err == nil {
@@ -107,6 +108,10 @@ func (me *Process) Reload() error {
 		return err
 	}
 
+	if _CGROUP {
+		me.loadCgroup()
+	}
+
 	ts := float64(time.Now().Unix())
 	if me.timestamp != 0 {
 		me.elapsedTime = ts - me.timestamp
@@ -279,51 +284,31 @@ func (me *Process) loadIo() error {
 
 func (me *Process) loadNetDev() error {
 
-	var (
-		data                               []byte
-		label, line, value                 string
-		lines, labels, raw, legend, fields []string
-		i                                  int
-		num                                uint64
-		err                                error
-	)
-
-	if data, err = ioutil.ReadFile(path.Join(me.dirpath, "net", "dev")); err != nil {
+	data, err := ioutil.ReadFile(path.Join(me.dirpath, "net", "dev"))
+	if err != nil {
 		return errors.New(FILE_READ, "net/dev: "+err.Error())
 	}
 
+	// sum of all network interfaces in the namespace of the process
 	me.net = make(map[string]uint64)
-
-	labels = make([]string, 0)
-
-	// extract counter labels
-	if lines = strings.Split(string(data), "\n"); len(lines) > 2 {
-		if legend = strings.Split(lines[1], "|"); len(legend) > 2 {
-			raw = strings.Fields(legend[len(legend)-1])
-
-			for _, label = range raw {
-				labels = append(labels, "rcv_"+strings.TrimSpace(label))
-			}
-
-			for _, label = range raw {
-				labels = append(labels, "sent_"+strings.TrimSpace(label))
-			}
-		}
-
-		// extract counter values
-		for _, line = range lines[2:] {
-			if fields = strings.Fields(line); len(fields) == len(labels)+1 {
-				for i, value = range fields[1:] {
-					if num, err = strconv.ParseUint(value, 10, 64); err == nil {
-						me.net[labels[i]] += num
-					}
-				}
-			}
+	for _, values := range parseNetDev(data) {
+		for label, num := range values {
+			me.net[label] += num
 		}
 	}
-
 	return nil
+}
 
+// loadCgroup - processes without cgroup (e.g. not on Linux) are not considered an error
+func (me *Process) loadCgroup() {
+	if me.cgroup == nil {
+		cgroup, err := NewCgroup(me.dirpath)
+		if err != nil {
+			return
+		}
+		me.cgroup = cgroup
+	}
+	me.cgroup.Reload()
 }
 
 func (me *Process) loadFdinfo() error {
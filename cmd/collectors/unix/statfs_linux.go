@@ -0,0 +1,29 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package unix
+
+import (
+	"syscall"
+)
+
+// statfs returns size and usage of the filesystem mounted at mountpoint,
+// sizes are in bytes
+func statfs(mountpoint string) (map[string]uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mountpoint, &st); err != nil {
+		return nil, err
+	}
+	bsize := uint64(st.Frsize)
+	if bsize == 0 {
+		bsize = uint64(st.Bsize)
+	}
+	return map[string]uint64{
+		"size":       st.Blocks * bsize,
+		"used":       (st.Blocks - st.Bfree) * bsize,
+		"available":  st.Bavail * bsize,
+		"files":      st.Files,
+		"files_free": st.Ffree,
+	}, nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package unix
+
+import (
+	"goharvest2/pkg/errors"
+	"runtime"
+)
+
+// statfs is only implemented on Linux, filesystems are not reported on other platforms
+func statfs(_ string) (map[string]uint64, error) {
+	return nil, errors.New(errors.ERR_IMPLEMENT, "statfs on "+runtime.GOOS)
+}
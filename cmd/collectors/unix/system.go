@@ -12,16 +12,29 @@ import (
 	"strings"
 )
 
-// System - provides memory size and boot time of the system
+// modes of CPU time in the summary line of /proc/stat, in the order they appear
+var cpuModes = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal"}
+
+// System - provides memory size, boot time and system-wide usage of CPU and memory
 type System struct {
-	memTotal uint64
-	//cpu_total float64
-	bootTime float64
+	memTotal  uint64
+	bootTime  float64
+	cpu       map[string]float64 // seconds spent in each CPU mode since boot
+	cpuBusy   float64            // percent of CPU time not idle since last reload
+	prevBusy  float64
+	prevTotal float64
+	load      map[string]float64
+	mem       map[string]uint64 // kB
+	swap      map[string]uint64 // kB
 }
 
 // NewSystem - creates an initialized instance of System
 func NewSystem() (*System, error) {
 	s := &System{}
+	s.cpu = make(map[string]float64)
+	s.load = make(map[string]float64)
+	s.mem = make(map[string]uint64)
+	s.swap = make(map[string]uint64)
 	return s, s.Reload()
 }
 
@@ -32,50 +45,54 @@ func (s *System) Reload() error {
 		return err
 	}
 
+	if err := s.loadLoadavg(); err != nil {
+		return err
+	}
+
 	return s.loadMeminfo()
 }
 
-// read values from /proc/stat - system boot time
+// read values from /proc/stat - system boot time and CPU times
 func (s *System) loadStat() error {
 
 	var (
-		//cpu float64
 		num           int64
 		data          []byte
 		lines, fields []string
+		busy, total   float64
 		err           error
 	)
 
-	if data, err = ioutil.ReadFile(path.Join("/proc", "stat")); err != nil {
-		return err
+	if data, err = ioutil.ReadFile(path.Join(_MOUNT_POINT, "stat")); err != nil {
+		return errors.New(FILE_READ, "stat: "+err.Error())
 	}
 
 	// first line should contain summary of CPU times, example:
 	// cpu  8536493 101888 2291762 23315526 39855 674853 262635 0 0 0
+	// guest times (last two fields) are included in user and nice
 	lines = strings.Split(string(data), "\n")
 
-	/* Not used currently
-
-	fields = strings.Fields(lines[0])
-	if len(fields) < 5 || strings.ToLower(fields[0]) != "cpu" {
-		return errors.New("cpu sum found")
-	}
-
-	// user time
-	if num, err = strconv.ParseInt(fields[1], 10, 64); err == nil {
-		cpu += float64(num) / _CLK_TCK
-	}
-
-	// system time
-	if num, err = strconv.ParseInt(fields[3], 10, 64); err == nil {
-		cpu +=  float64(num) / _CLK_TCK
+	if fields = strings.Fields(lines[0]); len(fields) > 4 && fields[0] == "cpu" {
+		for i, mode := range cpuModes {
+			if i+1 >= len(fields) {
+				break
+			}
+			if num, err = strconv.ParseInt(fields[i+1], 10, 64); err == nil {
+				s.cpu[mode] = float64(num) / _CLK_TCK
+				total += s.cpu[mode]
+				if mode != "idle" && mode != "iowait" {
+					busy += s.cpu[mode]
+				}
+			}
+		}
+		if total > s.prevTotal && s.prevTotal != 0 {
+			s.cpuBusy = (busy - s.prevBusy) / (total - s.prevTotal) * 100
+		} else if total != 0 {
+			s.cpuBusy = busy / total * 100
+		}
+		s.prevBusy, s.prevTotal = busy, total
 	}
 
-	// gives total cpu times since last poll
-	// if this is the first time we poll, it's total cpu times since boot time
-	s.cpu_total = cpu - s.cpu_total
-	*/
-
 	// extract system boot time, since file contains lines for each cpu
 	// there is no way to know the exact line we need
 	for _, line := range lines[1:] {
@@ -92,28 +109,69 @@ func (s *System) loadStat() error {
 	return errors.New(FIELD_NOT_FOUND, "/proc/stat: btime")
 }
 
-// read values from /proc/meminfo - system memory size
+// read values from /proc/loadavg - system load average, example:
+// 0.52 0.58 0.59 2/1071 40813
+func (s *System) loadLoadavg() error {
+
+	data, err := ioutil.ReadFile(path.Join(_MOUNT_POINT, "loadavg"))
+	if err != nil {
+		return errors.New(FILE_READ, "loadavg: "+err.Error())
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return errors.New(FIELD_NOT_FOUND, "/proc/loadavg")
+	}
+
+	for i, label := range []string{"1m", "5m", "15m"} {
+		if num, err := strconv.ParseFloat(fields[i], 64); err == nil {
+			s.load[label] = num
+		} else {
+			return errors.New(FIELD_VALUE, "/proc/loadavg: ["+fields[i]+"]")
+		}
+	}
+	return nil
+}
+
+// read values from /proc/meminfo - system memory and swap size and usage,
+// all values are in kB, example:
+// MemTotal:        7707284 kB
 func (s *System) loadMeminfo() error {
 
-	data, err := ioutil.ReadFile(path.Join("/proc", "meminfo"))
+	data, err := ioutil.ReadFile(path.Join(_MOUNT_POINT, "meminfo"))
 	if err != nil {
-		return err
+		return errors.New(FILE_READ, "meminfo: "+err.Error())
 	}
 
-	// First line contains total memory in Kb, example
-	// MemTotal:        7707284 kB
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
 		if fields := strings.Fields(line); len(fields) > 1 {
-			if strings.HasPrefix(strings.ToLower(fields[0]), "memtotal") {
-				if num, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
-					s.memTotal = num
-					return nil
-				}
-				break
+			if num, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				values[strings.TrimSuffix(fields[0], ":")] = num
 			}
 		}
 	}
 
-	return errors.New(FIELD_NOT_FOUND, "/proc/meminfo: MemTotal")
+	var ok bool
+	if s.memTotal, ok = values["MemTotal"]; !ok {
+		return errors.New(FIELD_NOT_FOUND, "/proc/meminfo: MemTotal")
+	}
+
+	s.mem["total"] = s.memTotal
+	s.mem["free"] = values["MemFree"]
+	s.mem["available"] = values["MemAvailable"]
+	s.mem["buffers"] = values["Buffers"]
+	s.mem["cached"] = values["Cached"]
+	s.mem["used"] = 0
+	if free := values["MemFree"] + values["Buffers"] + values["Cached"]; free < s.memTotal {
+		s.mem["used"] = s.memTotal - free
+	}
+
+	s.swap["total"] = values["SwapTotal"]
+	s.swap["free"] = values["SwapFree"]
+	s.swap["used"] = 0
+	if values["SwapFree"] < values["SwapTotal"] {
+		s.swap["used"] = values["SwapTotal"] - values["SwapFree"]
+	}
+	return nil
 }
@@ -0,0 +1,138 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package zapi
+
+import (
+	client "goharvest2/pkg/api/ontapi/zapi"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/tree/node"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// default parameter values
+	BatchSizeMin  = 100
+	BatchSizeMax  = 2000
+	BatchMaxTime  = 5 * time.Second
+	BatchMaxBytes = 8 * 1024 * 1024
+	// factors by which batch size is changed
+	batchGrow    = 1.25
+	batchShrink  = 0.75
+	batchBackoff = 0.5
+)
+
+// Batch - adaptive batch size, i.e. max-records of Zapi requests or instances per
+// ZapiPerf request. The size grows while responses are fast and small and shrinks
+// when they are slow, large or ONTAP rejects them, bounded by min and max.
+type Batch struct {
+	size     int
+	min      int
+	max      int
+	maxTime  time.Duration // max response time
+	maxBytes int           // max size of response
+}
+
+// NewBatch - create batch from parameters batch_size (initial size, defaults to
+// defaultSize), batch_size_min, batch_size_max, batch_max_time and batch_max_bytes
+func NewBatch(params *node.Node, defaultSize int) (*Batch, error) {
+
+	var err error
+
+	b := &Batch{size: defaultSize, maxTime: BatchMaxTime, maxBytes: BatchMaxBytes}
+
+	if b.size, err = loadInt(params, "batch_size", defaultSize); err != nil {
+		return nil, err
+	}
+	// only raise default if batch_size is larger
+	maxSize := BatchSizeMax
+	if b.size > maxSize {
+		maxSize = b.size
+	}
+	if b.max, err = loadInt(params, "batch_size_max", maxSize); err != nil {
+		return nil, err
+	}
+	// only lower default if batch_size is smaller
+	minSize := BatchSizeMin
+	if b.size < minSize {
+		minSize = b.size
+	}
+	if b.min, err = loadInt(params, "batch_size_min", minSize); err != nil {
+		return nil, err
+	}
+	if b.min > b.size || b.size > b.max {
+		return nil, errors.New(errors.INVALID_PARAM, "batch_size: expected batch_size_min <= batch_size <= batch_size_max")
+	}
+
+	if x := params.GetChildContentS("batch_max_time"); x != "" {
+		if b.maxTime, err = time.ParseDuration(x); err != nil || b.maxTime <= 0 {
+			return nil, errors.New(errors.INVALID_PARAM, "batch_max_time: "+x)
+		}
+	}
+	if b.maxBytes, err = loadInt(params, "batch_max_bytes", BatchMaxBytes); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func loadInt(params *node.Node, name string, defaultValue int) (int, error) {
+	x := params.GetChildContentS(name)
+	if x == "" {
+		return defaultValue, nil
+	}
+	if n, err := strconv.Atoi(x); err == nil && n > 0 {
+		return n, nil
+	}
+	return 0, errors.New(errors.INVALID_PARAM, name+": "+x)
+}
+
+// Size - current batch size
+func (b *Batch) Size() int {
+	return b.size
+}
+
+// Update batch size from the outcome of a request: number of records received,
+// response time, size of response and error. Returns true if size changed.
+func (b *Batch) Update(records int, apiT time.Duration, length int, err error) bool {
+
+	old := b.size
+
+	if err != nil {
+		if isLimitError(err) {
+			b.resize(batchBackoff)
+		}
+	} else if apiT > b.maxTime || length > b.maxBytes {
+		b.resize(batchShrink)
+	} else if records >= b.size && apiT < b.maxTime/2 && length < b.maxBytes/2 {
+		// batch was full, so there are probably more records to fetch
+		b.resize(batchGrow)
+	}
+
+	return b.size != old
+}
+
+func (b *Batch) resize(factor float64) {
+	size := int(float64(b.size) * factor)
+	if size == b.size && factor > 1 {
+		size++
+	}
+	if size < b.min {
+		size = b.min
+	} else if size > b.max {
+		size = b.max
+	}
+	b.size = size
+}
+
+// errors of ONTAP or the client, that might be solved with smaller batches
+func isLimitError(err error) bool {
+	msg := err.Error()
+	if e, ok := client.AsError(err); ok && e.IsTimeout() {
+		return true
+	}
+	return strings.Contains(msg, "resource limit exceeded") || strings.Contains(msg, "Client.Timeout exceeded")
+}
@@ -0,0 +1,102 @@
+package zapi
+
+import (
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/tree"
+	"testing"
+	"time"
+)
+
+func TestNewBatch(t *testing.T) {
+
+	tests := []struct {
+		params         string
+		size, min, max int
+		wantErr        bool
+	}{
+		{"name: test\n", 500, 100, 2000, false},
+		{"batch_size: 200\nbatch_size_max: 1000\n", 200, 100, 1000, false},
+		{"batch_size: 50\n", 50, 50, 2000, false},
+		{"batch_size: 5000\n", 5000, 100, 5000, false},
+		{"batch_size: 500\nbatch_size_min: 600\n", 0, 0, 0, true},
+		{"batch_size: 500\nbatch_size_max: 400\n", 0, 0, 0, true},
+		{"batch_size: many\n", 0, 0, 0, true},
+		{"batch_max_time: 0s\n", 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		params, err := tree.LoadYaml([]byte(tt.params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := NewBatch(params, BatchSize)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.params, err)
+		} else if b.size != tt.size || b.min != tt.min || b.max != tt.max {
+			t.Errorf("%q: got size=%d min=%d max=%d", tt.params, b.size, b.min, b.max)
+		}
+	}
+}
+
+func TestBatchUpdate(t *testing.T) {
+
+	b := &Batch{size: 400, min: 100, max: 1000, maxTime: 4 * time.Second, maxBytes: 1000}
+
+	limit := errors.New(errors.API_REQ_REJECTED, "resource limit exceeded")
+	other := errors.New(errors.API_REQ_REJECTED, "invalid object")
+
+	steps := []struct {
+		name    string
+		records int
+		apiT    time.Duration
+		length  int
+		err     error
+		want    int
+	}{
+		{"fast and full", 400, time.Second, 100, nil, 500},
+		{"fast, but not full", 10, time.Second, 100, nil, 500},
+		{"fast, but large", 500, time.Second, 600, nil, 500},
+		{"slow", 500, 5 * time.Second, 100, nil, 375},
+		{"too large", 375, time.Second, 2000, nil, 281},
+		{"rejected", 0, 0, 0, limit, 140},
+		{"rejected at min", 0, 0, 0, limit, 100},
+		{"still rejected", 0, 0, 0, limit, 100},
+		{"other error", 0, 0, 0, other, 100},
+		{"grow again", 100, time.Second, 100, nil, 125},
+	}
+
+	for _, s := range steps {
+		changed := b.Update(s.records, s.apiT, s.length, s.err)
+		if b.Size() != s.want {
+			t.Fatalf("%s: size = %d, want %d", s.name, b.Size(), s.want)
+		}
+		if s.name == "still rejected" && changed {
+			t.Errorf("%s: changed should be false", s.name)
+		}
+	}
+
+	b = &Batch{size: 900, min: 100, max: 1000, maxTime: time.Second, maxBytes: 1000}
+	b.Update(900, 0, 0, nil)
+	if b.Size() != 1000 {
+		t.Errorf("size = %d, want max 1000", b.Size())
+	}
+
+	// with default parameters, batch grows until BatchSizeMax
+	params, _ := tree.LoadYaml([]byte("name: test\n"))
+	b, err := NewBatch(params, BatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		b.Update(b.Size(), time.Second, 1000, nil)
+	}
+	if b.Size() != BatchSizeMax {
+		t.Errorf("size = %d, want default max %d", b.Size(), BatchSizeMax)
+	}
+}
@@ -5,6 +5,7 @@ package zapi
 
 import (
 	"goharvest2/pkg/color"
+	"goharvest2/pkg/errors"
 	"goharvest2/pkg/matrix"
 	"goharvest2/pkg/tree/node"
 	"goharvest2/pkg/util"
@@ -125,7 +126,7 @@ func (me *Zapi) HandleCounter(path []string, content string) string {
 	} else {
 		metric, err := me.Matrix.NewMetricUint64(key)
 		if err != nil {
-			me.Logger.Error().Stack().Err(err).Msgf("add as metric (%s) [%s]: %v", key, display)
+			me.Logger.Error().Stack().Err(err).Msgf("add as metric (%s) [%s]", key, display)
 		} else {
 			metric.SetName(display)
 			me.Logger.Trace().Msgf("%sadd as metric (%s) [%s]%s => %v", color.Blue, key, display, color.End, fullPath)
@@ -164,3 +165,58 @@ func ParseDisplay(obj string, path []string) string {
 	}
 	return strings.Join(words, "_")
 }
+
+// ParseFilter builds the <query> element of a request from the filter of
+// a template, e.g. "volume-id-attributes/owning-vserver-name: svm_prod*".
+// Paths are relative to the attributes element of the object (root), which
+// is also the root of desired-attributes. Values can use ZAPI query syntax,
+// e.g. "svm1|svm2" or ">100".
+func ParseFilter(filter *node.Node, root string) (*node.Node, error) {
+
+	if root == "" {
+		return nil, errors.New(errors.INVALID_PARAM, "filter: no attributes element")
+	}
+
+	query := node.NewXmlS("query")
+	attributes := query.NewChildS(root, "")
+
+	for _, f := range filter.GetChildren() {
+
+		path := strings.Split(f.GetNameS(), "/")
+		if path[0] == root && len(path) > 1 {
+			path = path[1:]
+		}
+		for _, name := range path {
+			if name == "" {
+				return nil, errors.New(errors.INVALID_PARAM, "filter "+f.GetNameS()+": invalid path")
+			}
+		}
+
+		value := f.GetContentS()
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if value == "" || len(f.GetChildren()) != 0 {
+			return nil, errors.New(errors.INVALID_PARAM, "filter "+f.GetNameS()+": expected value")
+		}
+
+		parent := attributes
+		for _, name := range path[:len(path)-1] {
+			child := parent.GetChildS(name)
+			if child == nil {
+				child = parent.NewChildS(name, "")
+			}
+			parent = child
+		}
+		leaf := path[len(path)-1]
+		if parent.GetChildS(leaf) != nil {
+			return nil, errors.New(errors.INVALID_PARAM, "filter "+f.GetNameS()+": duplicate path")
+		}
+		parent.NewChildS(leaf, value)
+	}
+
+	if len(attributes.GetChildren()) == 0 {
+		return nil, errors.New(errors.INVALID_PARAM, "filter: empty")
+	}
+	return query, nil
+}
@@ -0,0 +1,78 @@
+package zapi
+
+import (
+	"goharvest2/pkg/tree"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		filter string
+		want   string
+	}{
+		{
+			"nested paths",
+			`
+filter:
+  volume-id-attributes/owning-vserver-name: svm_prod*
+  volume-id-attributes/style-extended: flexvol
+  volume-state-attributes/state: online
+`,
+			"<query><volume-attributes><volume-id-attributes><owning-vserver-name>svm_prod*</owning-vserver-name>" +
+				"<style-extended>flexvol</style-extended></volume-id-attributes>" +
+				"<volume-state-attributes><state>online</state></volume-state-attributes></volume-attributes></query>",
+		},
+		{
+			"root in path and quoted value",
+			`
+filter:
+  volume-attributes/volume-id-attributes/name: "vol1|vol2"
+`,
+			"<query><volume-attributes><volume-id-attributes><name>vol1|vol2</name></volume-id-attributes></volume-attributes></query>",
+		},
+	}
+
+	for _, tt := range tests {
+		template, err := tree.LoadYaml([]byte(tt.filter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, err := ParseFilter(template.GetChildS("filter"), "volume-attributes")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, err := tree.DumpXml(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+
+	invalid := []string{
+		"filter:\n  volume-id-attributes/name:\n",
+		"filter:\n  volume-id-attributes/name: a\n  volume-id-attributes/name: b\n",
+		"filter:\n  volume-id-attributes/: a\n",
+	}
+	for _, f := range invalid {
+		template, err := tree.LoadYaml([]byte(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ParseFilter(template.GetChildS("filter"), "volume-attributes"); err == nil {
+			t.Errorf("%q: expected error", f)
+		}
+	}
+
+	template, err := tree.LoadYaml([]byte("filter:\n  name: a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseFilter(template.GetChildS("filter"), ""); err == nil {
+		t.Error("expected error without root")
+	}
+}
@@ -24,7 +24,7 @@ import (
 	client "goharvest2/pkg/api/ontapi/zapi"
 )
 
-const BatchSize = "500"
+const BatchSize = 500
 
 type Zapi struct {
 	*collector.AbstractCollector
@@ -33,8 +33,9 @@ type Zapi struct {
 	Query              string
 	TemplateFn         string
 	TemplateType       string
-	batchSize          string
+	Batch              *Batch // nil if max-records is not used
 	desiredAttributes  *node.Node
+	filter             *node.Node // <query> element, nil if no filter
 	instanceKeyPaths   [][]string
 	instanceLabelPaths map[string]string
 	shortestPathPrefix []string
@@ -51,8 +52,15 @@ func (Zapi) HarvestModule() plugin.ModuleInfo {
 	}
 }
 
-func (me *Zapi) Init(a *collector.AbstractCollector) error {
+func (me *Zapi) Init(a *collector.AbstractCollector) (err error) {
 	me.AbstractCollector = a
+	// poller drops collectors that fail to initialize, release their connection
+	defer func() {
+		if err != nil && me.Client != nil {
+			me.Client.Close()
+		}
+	}()
+
 	if err := me.InitVars(); err != nil {
 		return err
 	}
@@ -78,7 +86,7 @@ func (me *Zapi) InitVars() error {
 
 	var err error
 
-	if me.Client, err = client.New(me.Params); err != nil { // convert to connection error, so poller aborts
+	if me.Client, err = client.NewShared(me.Params); err != nil { // convert to connection error, so poller aborts
 		return errors.New(errors.ERR_CONNECTION, err.Error())
 	}
 
@@ -135,19 +143,12 @@ func (me *Zapi) LoadPlugin(kind string, abc *plugin.AbstractPlugin) plugin.Plugi
 
 func (me *Zapi) InitCache() error {
 
-	if me.Client.IsClustered() {
-		if b := me.Params.GetChildContentS("batch_size"); b != "" {
-			if _, err := strconv.Atoi(b); err == nil {
-				me.Logger.Trace().Msgf("using batch-size [%s]", me.batchSize)
-				me.batchSize = b
-			}
-		}
-		if me.batchSize == "" && me.Params.GetChildContentS("no_max_records") != "true" {
-			me.Logger.Trace().Msgf("using default batch-size [%s]", BatchSize)
-			me.batchSize = BatchSize
-		} else {
-			me.Logger.Trace().Msg("using default no batch-size")
+	if me.Client.IsClustered() && me.Params.GetChildContentS("no_max_records") != "true" {
+		if err := me.InitBatch(BatchSize); err != nil {
+			return err
 		}
+	} else {
+		me.Logger.Trace().Msg("using default no batch-size")
 	}
 
 	me.instanceLabelPaths = make(map[string]string)
@@ -174,6 +175,10 @@ func (me *Zapi) InitCache() error {
 		return errors.New(errors.MISSING_PARAM, "no instance keys indicated")
 	}
 
+	if err := me.InitFilter(); err != nil {
+		return err
+	}
+
 	// @TODO validate
 	me.shortestPathPrefix = ParseShortestPath(me.Matrix, me.instanceLabelPaths)
 	me.Logger.Debug().Msgf("Parsed Instance Keys: %v", me.instanceKeyPaths)
@@ -182,6 +187,40 @@ func (me *Zapi) InitCache() error {
 
 }
 
+// InitFilter - build the ZAPI query from the filter of the template, or the
+// filter of the object in zapi_filters of the poller, which takes precedence
+func (me *Zapi) InitFilter() error {
+
+	filter := me.Params.GetChildS("filter")
+	if filters := me.Params.GetChildS("zapi_filters"); filters != nil {
+		if f := filters.GetChildS(me.Object); f != nil {
+			me.Logger.Debug().Msg("using filter of poller")
+			filter = f
+		}
+	}
+
+	if filter == nil || len(filter.GetChildren()) == 0 {
+		return nil
+	}
+
+	if !me.Client.IsClustered() {
+		me.Logger.Warn().Msg("ignoring filter, not supported on 7-mode systems")
+		return nil
+	}
+
+	var root string
+	if attributes := me.desiredAttributes.GetChildren(); len(attributes) != 0 {
+		root = attributes[0].GetNameS()
+	}
+
+	var err error
+	if me.filter, err = ParseFilter(filter, root); err != nil {
+		return err
+	}
+	me.Logger.Debug().Msgf("using filter with %d conditions", len(filter.GetChildren()))
+	return nil
+}
+
 func (me *Zapi) InitMatrix() error {
 	// overwrite from abstract collector
 	//me.Matrix.Collector = me.Matrix.Collector + ":" + me.Matrix.Object
@@ -224,15 +263,18 @@ func (me *Zapi) PollInstance() (*matrix.Matrix, error) {
 		count = 1
 	} else {
 		request = node.NewXmlS(me.Query)
-		if me.Client.IsClustered() && me.batchSize != "" {
-			request.NewChildS("max-records", me.batchSize)
+		if me.Client.IsClustered() && me.Batch != nil {
+			request.NewChildS("max-records", strconv.Itoa(me.Batch.Size()))
+		}
+		if me.filter != nil {
+			request.AddChild(me.filter)
 		}
 
 		tag = "initial"
 
 		for {
 
-			response, tag, err = me.Client.InvokeBatchRequest(request, tag)
+			response, tag, _, _, err = me.invokeBatchWithTimers(request, tag, "instance", nil, nil)
 
 			if err != nil {
 				return nil, err
@@ -339,45 +381,54 @@ func (me *Zapi) PollData() (*matrix.Matrix, error) {
 		if me.Params.GetChildContentS("no_desired_attributes") != "true" {
 			request.AddChild(me.desiredAttributes)
 		}
-		if me.batchSize != "" {
-			request.NewChildS("max-records", me.batchSize)
+		if me.Batch != nil {
+			request.NewChildS("max-records", strconv.Itoa(me.Batch.Size()))
+		}
+		if me.filter != nil {
+			request.AddChild(me.filter)
 		}
 	}
 
-	tag = "initial"
-
-	for {
-		response, tag, ad, pd, err = me.Client.InvokeBatchWithTimers(request, tag)
+	onlyCluster := me.Params.GetChildContentS("only_cluster_instance") == "true"
+	elements := 0 // instance elements in current batch
 
-		if err != nil {
-			return nil, err
-		}
+	// instances and counts before current batch, restored if the batch is retried
+	var (
+		touched                  []*matrix.Instance
+		batchCount, batchSkipped uint64
+	)
 
-		if response == nil {
-			break
+	rollback := func() {
+		for _, instance := range touched {
+			for _, metric := range me.Matrix.GetMetrics() {
+				metric.SetValueNAN(instance)
+			}
 		}
+		touched = touched[:0]
+		count, skipped = batchCount, batchSkipped
+		elements = 0
+	}
 
-		apiT += ad
-		parseT += pd
-
-		instances := response.SearchChildren(me.shortestPathPrefix)
-
-		if len(instances) == 0 {
-			return nil, errors.New(errors.ERR_NO_INSTANCE, "")
-		}
+	// instance elements are handled while the response is streamed, elements
+	// of "attributes-list" are passed one by one, remaining ones with the results
+	handle := func(elem *node.Node) error {
+		for _, instanceElem := range elem.SearchChildren(me.shortestPathPrefix) {
 
-		me.Logger.Debug().Msgf("fetched %d instance elements", len(instances))
+			elements++
 
-		if me.Params.GetChildContentS("only_cluster_instance") == "true" {
-			if instance := me.Matrix.GetInstance("cluster"); instance != nil {
-				fetch(instance, instances[0], make([]string, 0))
-			} else {
-				me.Logger.Error().Stack().Err(nil).Msg("cluster instance not found in cache")
+			if onlyCluster {
+				if elements > 1 {
+					break
+				}
+				if instance := me.Matrix.GetInstance("cluster"); instance != nil {
+					touched = append(touched, instance)
+					fetch(instance, instanceElem, make([]string, 0))
+				} else {
+					me.Logger.Error().Stack().Err(nil).Msg("cluster instance not found in cache")
+				}
+				continue
 			}
-			break
-		}
 
-		for _, instanceElem := range instances {
 			//c.logger.Printf(c.Prefix, "Handling instance element <%v> [%s]", &instance, instance.GetName())
 			keys, found := instanceElem.SearchContent(me.shortestPathPrefix, me.instanceKeyPaths)
 			//logger.Debug(me.Prefix, "Fetched instance keys: %s", strings.Join(keys, "."))
@@ -393,8 +444,43 @@ func (me *Zapi) PollData() (*matrix.Matrix, error) {
 				me.Logger.Error().Stack().Err(nil).Msgf("skipped instance [%s]: not found in cache", strings.Join(keys, "."))
 				continue
 			}
+			touched = append(touched, instance)
 			fetch(instance, instanceElem, make([]string, 0))
 		}
+		return nil
+	}
+
+	tag = "initial"
+
+	for {
+		elements = 0
+		touched = touched[:0]
+		batchCount, batchSkipped = count, skipped
+
+		response, tag, ad, pd, err = me.invokeBatchWithTimers(request, tag, "data", handle, rollback)
+
+		apiT += ad
+		parseT += pd
+
+		if err != nil {
+			return nil, err
+		}
+
+		if response == nil {
+			break
+		}
+
+		handle(response)
+
+		if elements == 0 {
+			return nil, errors.New(errors.ERR_NO_INSTANCE, "")
+		}
+
+		me.Logger.Debug().Msgf("fetched %d instance elements", elements)
+
+		if onlyCluster {
+			break
+		}
 	}
 
 	// update metadata
@@ -406,6 +492,83 @@ func (me *Zapi) PollData() (*matrix.Matrix, error) {
 	return me.Matrix, nil
 }
 
+// InitBatch - initialize adaptive batch size, the chosen size is reported as
+// "batch_size" in metadata
+func (me *Zapi) InitBatch(defaultSize int) error {
+	var err error
+	if me.Batch, err = NewBatch(me.Params, defaultSize); err != nil {
+		return err
+	}
+	if _, err = me.Metadata.NewMetricInt64("batch_size"); err != nil {
+		return err
+	}
+	me.Logger.Debug().Msgf("using batch-size [%d] (min=%d, max=%d)", me.Batch.size, me.Batch.min, me.Batch.max)
+	return nil
+}
+
+// UpdateBatch - update batch size from outcome of a request of task, see Batch.Update
+func (me *Zapi) UpdateBatch(task string, records int, apiT time.Duration, length int, err error) bool {
+	changed := me.Batch.Update(records, apiT, length, err)
+	if changed {
+		me.Logger.Info().Msgf("changed batch-size to [%d]", me.Batch.Size())
+	}
+	_ = me.Metadata.LazySetValueInt64("batch_size", task, int64(me.Batch.Size()))
+	return changed
+}
+
+// invoke batch request of task (instance or data) and update batch size,
+// if the request fails because the batch is too large, retry with the smaller size.
+// If stream is not nil, elements of "attributes-list" are passed to stream while
+// the response is decoded and are not part of the returned response. Since elements
+// of a failed attempt might already be passed, rollback is called before a retry.
+func (me *Zapi) invokeBatchWithTimers(request *node.Node, tag, task string, stream func(*node.Node) error, rollback func()) (*node.Node, string, time.Duration, time.Duration, error) {
+
+	var (
+		apiT, parseT time.Duration
+		response     *node.Node
+		nextTag      string
+		length       int
+		rd, pd       time.Duration
+		err          error
+	)
+
+	for {
+		if stream != nil {
+			response, nextTag, length, rd, pd, err = me.Client.InvokeBatchStream(me.Context(), request, tag, "attributes-list", stream)
+		} else {
+			response, nextTag, length, rd, pd, err = me.Client.InvokeBatchContext(me.Context(), request, tag)
+		}
+		apiT += rd
+		parseT += pd
+
+		if me.Batch == nil || tag == "" {
+			return response, nextTag, apiT, parseT, err
+		}
+
+		records := 0
+		if response != nil {
+			records, _ = strconv.Atoi(response.GetChildContentS("num-records"))
+		}
+
+		// when streamed, parse time includes receiving the response body
+		if stream != nil {
+			rd += pd
+		}
+
+		if me.UpdateBatch(task, records, rd, length, err) {
+			request.SetChildContentS("max-records", strconv.Itoa(me.Batch.Size()))
+			if err != nil {
+				me.Logger.Warn().Msgf("retry with smaller batch-size: %v", err)
+				if rollback != nil {
+					rollback()
+				}
+				continue
+			}
+		}
+		return response, nextTag, apiT, parseT, err
+	}
+}
+
 // Interface guards
 var (
 	_ collector.Collector = (*Zapi)(nil)
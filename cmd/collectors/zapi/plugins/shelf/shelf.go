@@ -35,12 +35,13 @@ func (my *Shelf) Init() error {
 		return err
 	}
 
-	if my.client, err = zapi.New(my.ParentParams); err != nil {
+	if my.client, err = zapi.NewShared(my.ParentParams); err != nil {
 		my.Logger.Error().Stack().Err(err).Msg("connecting")
 		return err
 	}
 
 	if err = my.client.Init(5); err != nil {
+		my.client.Close()
 		return err
 	}
 
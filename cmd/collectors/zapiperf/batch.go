@@ -0,0 +1,112 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package zapiperf
+
+import (
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/tree/node"
+	"sync"
+	"time"
+)
+
+// default parameter value, batches are requested one by one
+const concurrentBatches = 1
+
+// errStopped - aborts streaming a batch response, once the poll stopped
+var errStopped = errors.New(errors.ERR_CONNECTION, "poll stopped")
+
+// batchResult - response to a batch request
+type batchResult struct {
+	records   int // number of instances requested
+	instances int // number of instances received
+	length    int // size of response
+	apiT      time.Duration
+	parseT    time.Duration
+	err       error
+}
+
+// fetchBatches - request counter data of instanceKeys in batches of current batch size.
+// Each batch holds one of the concurrent_batches slots of the cluster while it is requested,
+// so batches of all objects of the cluster are limited together. Since each batch is
+// also one request of the shared client, max_concurrent_requests limits them as well.
+// Responses are streamed and each instance element is passed to parse, together with
+// the timestamp of the batch, as soon as it is decoded. Results are sent in the order
+// requests complete and the channel is closed when all requests are done. Once stop
+// is closed, no new requests are issued and pending requests are aborted.
+func (me *ZapiPerf) fetchBatches(request *node.Node, keyName string, instanceKeys []string, parse func(*node.Node, float64), stop <-chan struct{}) <-chan batchResult {
+
+	var wg sync.WaitGroup
+
+	batchSize := me.Batch.Size()
+
+	// buffered for all batches, so requests never block, even if caller stops reading
+	results := make(chan batchResult, (len(instanceKeys)+batchSize-1)/batchSize)
+
+	go func() {
+
+		defer close(results)
+
+		// batch indices
+		startIndex := 0
+		endIndex := 0
+
+		for endIndex < len(instanceKeys) {
+
+			select {
+			case <-stop:
+				wg.Wait()
+				return
+			case me.batches <- struct{}{}:
+			}
+
+			// update batch indices
+			endIndex += batchSize
+			if endIndex > len(instanceKeys) {
+				endIndex = len(instanceKeys)
+			}
+
+			me.Logger.Debug().Msgf("starting batch poll for instances [%d:%d]", startIndex, endIndex)
+
+			batch := request.Copy()
+			requestInstances := batch.NewChildS(keyName+"s", "")
+			for _, key := range instanceKeys[startIndex:endIndex] {
+				requestInstances.NewChildS(keyName, key)
+			}
+			records := endIndex - startIndex
+
+			startIndex = endIndex
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-me.batches }()
+				var (
+					instances int
+					ts        float64
+				)
+				_, length, rd, pd, err := me.Client.InvokeStream(me.Context(), batch, "instances", func(instance *node.Node) error {
+					select {
+					case <-stop:
+						return errStopped
+					default:
+					}
+					if instances == 0 {
+						// ignore timestamp from ZAPI which is always integer
+						// we want float, since our poll interval can be float
+						ts = float64(time.Now().UnixNano()) / BILLION
+					}
+					instances++
+					parse(instance, ts)
+					return nil
+				})
+				results <- batchResult{records: records, instances: instances, length: length, apiT: rd, parseT: pd, err: err}
+			}()
+		}
+
+		wg.Wait()
+	}()
+
+	return results
+}
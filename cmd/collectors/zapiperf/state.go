@@ -0,0 +1,203 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package zapiperf
+
+import (
+	"encoding/gob"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/set"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// default parameter values
+	cacheMaxAge = 10 * time.Minute
+	// how often the cache is persisted, additionally to poller shutdown
+	stateSaveInterval = 5 * time.Minute
+)
+
+// cacheState - raw values of the data cache, persisted between poller restarts,
+// so metrics can be calculated from the first poll after a restart
+type cacheState struct {
+	Saved     time.Time
+	Query     string
+	Instances []string
+	Metrics   []string
+	Values    map[string]map[string]float64 // metric => instance => raw value
+}
+
+// InitState - set filepath of the state file, unless disabled with persist_cache
+func (me *ZapiPerf) InitState() {
+
+	me.cacheMaxAge = cacheMaxAge
+
+	if x := me.Params.GetChildContentS("persist_cache"); x != "" {
+		if enabled, err := strconv.ParseBool(x); err != nil {
+			me.Logger.Warn().Msgf("invalid parameter persist_cache = [%s] (expected bool)", x)
+		} else if !enabled {
+			return
+		}
+	}
+
+	if x := me.Params.GetChildContentS("cache_max_age"); x != "" {
+		if d, err := time.ParseDuration(x); err == nil && d > 0 {
+			me.cacheMaxAge = d
+		} else {
+			me.Logger.Warn().Msgf("invalid parameter cache_max_age = [%s] (expected duration)", x)
+		}
+	}
+
+	dir := me.Options.StatePath
+	if dir == "" {
+		return
+	}
+	name := strings.ToLower(me.Name+"_"+me.Object) + ".gob"
+	me.statePath = filepath.Join(dir, me.Options.Poller, name)
+	me.Logger.Debug().Msgf("using state file [%s], max age %s", me.statePath, me.cacheMaxAge.String())
+}
+
+// SaveState - persist the raw values of the data cache, called by the poller before exiting
+func (me *ZapiPerf) SaveState() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.saveState()
+}
+
+// saveStateIfDue - called after each data poll, errors are only logged
+func (me *ZapiPerf) saveStateIfDue() {
+	if me.statePath == "" || time.Since(me.stateSaved) < stateSaveInterval {
+		return
+	}
+	if err := me.saveState(); err != nil {
+		// most likely state directory is not writable, don't try again
+		me.Logger.Warn().Msgf("disabled persisting cache: %v", err)
+		me.statePath = ""
+	}
+}
+
+func (me *ZapiPerf) saveState() error {
+
+	// don't overwrite previous state with empty cache
+	if me.statePath == "" || me.isCacheEmpty {
+		return nil
+	}
+
+	state := cacheState{
+		Saved:     time.Now(),
+		Query:     me.Query,
+		Instances: me.Matrix.GetInstanceKeys(),
+		Metrics:   make([]string, 0, len(me.Matrix.GetMetrics())),
+		Values:    make(map[string]map[string]float64),
+	}
+
+	for key, metric := range me.Matrix.GetMetrics() {
+		state.Metrics = append(state.Metrics, key)
+		values := make(map[string]float64)
+		for ikey, instance := range me.Matrix.GetInstances() {
+			if v, ok := metric.GetValueFloat64(instance); ok {
+				values[ikey] = v
+			}
+		}
+		state.Values[key] = values
+	}
+
+	if err := os.MkdirAll(filepath.Dir(me.statePath), 0755); err != nil {
+		return err
+	}
+
+	// write to temporary file first, so we never leave a partial state
+	tmp := me.statePath + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err = gob.NewEncoder(file).Encode(&state); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, me.statePath); err != nil {
+		return err
+	}
+
+	me.stateSaved = state.Saved
+	me.Logger.Debug().Msgf("saved cache state with %d instances and %d metrics", len(state.Instances), len(state.Metrics))
+	return nil
+}
+
+// restoreState - load raw values persisted before the last restart into the data cache.
+// The state is only used if it's not older than cacheMaxAge and has the same instances
+// and metrics as the cache.
+func (me *ZapiPerf) restoreState() error {
+
+	var state cacheState
+
+	file, err := os.Open(me.statePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = gob.NewDecoder(file).Decode(&state); err != nil {
+		return err
+	}
+
+	if age := time.Since(state.Saved); age > me.cacheMaxAge {
+		return errors.New(errors.ERR_CONFIG, "state is too old ("+age.Round(time.Second).String()+")")
+	}
+
+	if state.Query != me.Query {
+		return errors.New(errors.ERR_CONFIG, "state is from object "+state.Query)
+	}
+
+	if !sameKeys(state.Instances, me.Matrix.GetInstanceKeys()) {
+		return errors.New(errors.ERR_CONFIG, "instances changed")
+	}
+
+	metrics := make([]string, 0, len(me.Matrix.GetMetrics()))
+	for key := range me.Matrix.GetMetrics() {
+		metrics = append(metrics, key)
+	}
+	if !sameKeys(state.Metrics, metrics) {
+		return errors.New(errors.ERR_CONFIG, "metrics changed")
+	}
+
+	for key, values := range state.Values {
+		metric := me.Matrix.GetMetric(key)
+		for ikey, v := range values {
+			if err = metric.SetValueFloat64(me.Matrix.GetInstance(ikey), v); err != nil {
+				return err
+			}
+		}
+	}
+
+	me.Logger.Info().Msgf("restored cache state with %d instances and %d metrics (saved %s)", len(state.Instances), len(state.Metrics), state.Saved.Format(time.RFC3339))
+	return nil
+}
+
+// sameKeys - true if a and b contain the same keys
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	s := set.NewFrom(a)
+	for _, key := range b {
+		if !s.Has(key) {
+			return false
+		}
+	}
+	return true
+}
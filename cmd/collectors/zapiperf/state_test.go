@@ -0,0 +1,96 @@
+package zapiperf
+
+import (
+	zapi "goharvest2/cmd/collectors/zapi/collector"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree/node"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newStateCollector(t *testing.T, dir string) *ZapiPerf {
+
+	me := &ZapiPerf{
+		Zapi: &zapi.Zapi{AbstractCollector: collector.New("ZapiPerf", "Volume", &options.Options{Poller: "cluster-01", StatePath: dir}, node.NewS(""))},
+		mu:   &sync.Mutex{},
+	}
+	me.Query = "volume"
+	me.InitState()
+
+	me.Matrix = matrix.New("test", "volume")
+	for _, key := range []string{"timestamp", "total_ops", "avg_latency"} {
+		if _, err := me.Matrix.NewMetricFloat64(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"vol1", "vol2"} {
+		if _, err := me.Matrix.NewInstance(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return me
+}
+
+func TestPersistState(t *testing.T) {
+
+	dir := t.TempDir()
+
+	me := newStateCollector(t, dir)
+	if want := filepath.Join(dir, "cluster-01", "zapiperf_volume.gob"); me.statePath != want {
+		t.Fatalf("state path = %s, want %s", me.statePath, want)
+	}
+
+	vol1 := me.Matrix.GetInstance("vol1")
+	_ = me.Matrix.GetMetric("timestamp").SetValueFloat64(vol1, 1622540000.5)
+	_ = me.Matrix.GetMetric("total_ops").SetValueFloat64(vol1, 1000)
+
+	// nothing to save before first poll
+	me.isCacheEmpty = true
+	if err := me.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := newStateCollector(t, dir).restoreState(); err == nil {
+		t.Fatal("expected error, no state saved")
+	}
+
+	me.isCacheEmpty = false
+	if err := me.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newStateCollector(t, dir)
+	if err := restored.restoreState(); err != nil {
+		t.Fatal(err)
+	}
+
+	vol1 = restored.Matrix.GetInstance("vol1")
+	if v, ok := restored.Matrix.GetMetric("timestamp").GetValueFloat64(vol1); !ok || v != 1622540000.5 {
+		t.Errorf("timestamp of vol1 = %v (%v)", v, ok)
+	}
+	if v, ok := restored.Matrix.GetMetric("total_ops").GetValueFloat64(vol1); !ok || v != 1000 {
+		t.Errorf("total_ops of vol1 = %v (%v)", v, ok)
+	}
+	if _, ok := restored.Matrix.GetMetric("avg_latency").GetValueFloat64(vol1); ok {
+		t.Error("avg_latency of vol1 should be absent")
+	}
+
+	// instances changed since state was saved
+	changed := newStateCollector(t, dir)
+	if _, err := changed.Matrix.NewInstance("vol3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := changed.restoreState(); err == nil {
+		t.Error("expected error, instances changed")
+	}
+
+	// state too old
+	old := newStateCollector(t, dir)
+	old.cacheMaxAge = time.Nanosecond
+	if err := old.restoreState(); err == nil {
+		t.Error("expected error, state too old")
+	}
+}
@@ -0,0 +1,468 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package zapiperf
+
+import (
+	"goharvest2/pkg/color"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/set"
+	"goharvest2/pkg/tree/node"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// default parameter values of top_n
+	topNBy      = "ops"
+	topNRefresh = 10 * time.Minute
+	// key of the instance that aggregates workloads not in the top N
+	othersKey = "others"
+)
+
+// counters collected for all workloads, so the top N can be ranked
+// and the "others" instance can be aggregated
+var defaultTopNCounters = []string{
+	"ops",
+	"read_ops",
+	"write_ops",
+	"total_data",
+	"read_data",
+	"write_data",
+	"latency",
+	"read_latency",
+	"write_latency",
+}
+
+// topN - selection of workloads for which all counters are collected
+type topN struct {
+	size     int           // number of workloads to select
+	by       string        // counter by which workloads are ranked
+	refresh  time.Duration // how often selection is refreshed
+	counters []string      // collected for all workloads
+	selected *set.Set      // keys of top N workloads
+	polled   *set.Set      // keys of workloads polled with all counters in the last poll
+	updated  time.Time     // last time selection was refreshed
+}
+
+// parseTopN - parse the top_n parameter of the template, e.g.:
+//
+//	top_n:
+//	  size: 50
+//	  by: latency
+//	  refresh: 600s
+//
+// by is one of ops (default), latency or throughput. Optionally, the
+// counters collected for all workloads can be set with "counters".
+func parseTopN(params *node.Node, query string) (*topN, error) {
+
+	var err error
+
+	t := &topN{
+		by:       topNBy,
+		refresh:  topNRefresh,
+		counters: defaultTopNCounters,
+		selected: set.New(),
+		polled:   set.New(),
+	}
+
+	if query != objWorkload && query != objWorkloadVolume && query != objWorkloadDetail && query != objWorkloadDetailVolume {
+		return nil, errors.New(errors.INVALID_PARAM, "top_n: not supported by object "+query)
+	}
+
+	x := params.GetChildContentS("size")
+	if x == "" {
+		return nil, errors.New(errors.MISSING_PARAM, "top_n: size")
+	}
+	if t.size, err = strconv.Atoi(x); err != nil || t.size <= 0 {
+		return nil, errors.New(errors.INVALID_PARAM, "top_n: size: "+x)
+	}
+
+	switch x = params.GetChildContentS("by"); x {
+	case "":
+	case "ops", "latency":
+		t.by = x
+	case "throughput":
+		t.by = "total_data"
+	default:
+		return nil, errors.New(errors.INVALID_PARAM, "top_n: by: "+x+" (expected ops, latency or throughput)")
+	}
+
+	// detail objects only collect ops of the parent workload
+	if (query == objWorkloadDetail || query == objWorkloadDetailVolume) && t.by != "ops" {
+		return nil, errors.New(errors.INVALID_PARAM, "top_n: by: "+x+" (only ops supported by "+query+")")
+	}
+
+	if x = params.GetChildContentS("refresh"); x != "" {
+		if t.refresh, err = time.ParseDuration(x); err != nil || t.refresh <= 0 {
+			return nil, errors.New(errors.INVALID_PARAM, "top_n: refresh: "+x)
+		}
+	}
+
+	if counters := params.GetChildS("counters"); counters != nil {
+		t.counters = counters.GetAllChildContentS()
+	}
+
+	return t, nil
+}
+
+// split - keys of instances polled with all counters and the remaining keys
+func (t *topN) split(keys []string) ([]string, []string) {
+	selected := make([]string, 0, t.size)
+	others := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if t.selected.Has(key) {
+			selected = append(selected, key)
+		} else {
+			others = append(others, key)
+		}
+	}
+	return selected, others
+}
+
+// isDue - true if the selection should be refreshed
+func (t *topN) isDue() bool {
+	return t.selected.IsEmpty() || time.Since(t.updated) >= t.refresh
+}
+
+// rank - select the top N instances by the calculated value of
+// metric. For latency, instances with too little IO are skipped.
+func (t *topN) rank(data *matrix.Matrix, metric matrix.Metric, minIo float64) []string {
+
+	type entry struct {
+		key   string
+		value float64
+	}
+
+	var base, timestamp matrix.Metric
+
+	if metric.GetProperty() == "average" {
+		base = data.GetMetric(metric.GetComment())
+		timestamp = data.GetMetric("timestamp")
+	}
+
+	entries := make([]entry, 0, len(data.GetInstances()))
+
+	for key, instance := range data.GetInstances() {
+		if key == othersKey {
+			continue
+		}
+		value, ok := metric.GetValueFloat64(instance)
+		if !ok || math.IsNaN(value) {
+			continue
+		}
+		if base != nil && baseDelta(base, timestamp, instance) < minIo {
+			continue
+		}
+		entries = append(entries, entry{key, value})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].value == entries[j].value {
+			return entries[i].key < entries[j].key
+		}
+		return entries[i].value > entries[j].value
+	})
+
+	if len(entries) > t.size {
+		entries = entries[:t.size]
+	}
+
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.key
+	}
+	return keys
+}
+
+// baseDelta - delta of base counter, if base is a rate, it is converted back
+// by the elapsed time (timestamp). Returns 0 if value is missing.
+func baseDelta(base, timestamp matrix.Metric, instance *matrix.Instance) float64 {
+	value, ok := base.GetValueFloat64(instance)
+	if !ok || math.IsNaN(value) {
+		return 0
+	}
+	if base.GetProperty() == "rate" && timestamp != nil {
+		if elapsed, ok := timestamp.GetValueFloat64(instance); ok {
+			return value * elapsed
+		}
+	}
+	return value
+}
+
+// topNRequestCounters - counters requested for workloads not in the top N, including
+// base counters, that exist in the data cache
+func (me *ZapiPerf) topNRequestCounters(data *matrix.Matrix) []string {
+
+	counters := set.New()
+
+	names := make([]string, 0, len(me.topN.counters)+1)
+	names = append(names, me.topN.counters...)
+	names = append(names, me.topN.by)
+
+	for _, name := range names {
+		if metric := data.GetMetric(name); metric != nil {
+			counters.Add(name)
+			if base := metric.GetComment(); base != "" && data.GetMetric(base) != nil {
+				counters.Add(base)
+			}
+		} else {
+			me.Logger.Trace().Msgf("top_n: counter (%s) not in cache", name)
+		}
+	}
+
+	return counters.Values()
+}
+
+// Poll only the top_n counters for instances that are not in the top N.
+// Similar to getParentOpsCounters, values are stored in data.
+func (me *ZapiPerf) getTopNCounters(data *matrix.Matrix, keyName string, instanceKeys []string) (uint64, time.Duration, time.Duration, error) {
+
+	var (
+		count        uint64
+		apiT, parseT time.Duration
+	)
+
+	timestamp := data.GetMetric("timestamp")
+	counters := me.topNRequestCounters(data)
+
+	if len(counters) == 0 {
+		return count, apiT, parseT, errors.New(errors.ERR_CONFIG, "top_n: no counters in cache")
+	}
+
+	me.Logger.Debug().Msgf("(%s) polling %d counters for %d instances not in top %d", me.Query, len(counters), len(instanceKeys), me.topN.size)
+
+	// build ZAPI request
+	request := node.NewXmlS("perf-object-get-instances")
+	request.NewChildS("objectname", me.Query)
+
+	requestCounters := request.NewChildS("counters", "")
+	for _, name := range counters {
+		requestCounters.NewChildS("counter", name)
+	}
+
+	// batch indices
+	startIndex := 0
+	endIndex := 0
+
+	for endIndex < len(instanceKeys) {
+
+		// update batch indices
+		endIndex += me.Batch.Size()
+		if endIndex > len(instanceKeys) {
+			endIndex = len(instanceKeys)
+		}
+
+		me.Logger.Debug().Msgf("starting batch poll for instances [%d:%d]", startIndex, endIndex)
+
+		request.PopChildS(keyName + "s")
+		requestInstances := request.NewChildS(keyName+"s", "")
+		for _, key := range instanceKeys[startIndex:endIndex] {
+			requestInstances.NewChildS(keyName, key)
+		}
+
+		startIndex = endIndex
+
+		response, _, rt, pt, err := me.Client.InvokeContextWithTimers(me.Context(), request)
+		if err != nil {
+			return count, apiT, parseT, err
+		}
+
+		apiT += rt
+		parseT += pt
+
+		// fetch instances
+		instances := response.GetChildS("instances")
+		if instances == nil || len(instances.GetChildren()) == 0 {
+			return count, apiT, parseT, nil
+		}
+
+		ts := float64(time.Now().UnixNano()) / BILLION
+
+		for _, i := range instances.GetChildren() {
+
+			key := i.GetChildContentS(me.instanceKey)
+
+			instance := data.GetInstance(key)
+			if instance == nil {
+				me.Logger.Debug().Msgf("skip instance [%s], not found in cache", key)
+				continue
+			}
+
+			counters := i.GetChildS("counters")
+			if counters == nil {
+				me.Logger.Debug().Msgf("skip instance [%s], no data counters", key)
+				continue
+			}
+
+			if err = timestamp.SetValueFloat64(instance, ts); err != nil {
+				me.Logger.Error().Stack().Err(err).Msg("set timestamp value: ")
+			}
+
+			for _, cnt := range counters.GetChildren() {
+
+				name := cnt.GetChildContentS("name")
+				value := cnt.GetChildContentS("value")
+
+				if metric := data.GetMetric(name); metric != nil {
+					if err = metric.SetValueString(instance, value); err != nil {
+						me.Logger.Error().Stack().Err(err).Msgf("set metric (%s) value [%s]", name, value)
+					} else {
+						me.Logger.Trace().Msgf("+ metric (%s) = [%s%s%s]", name, color.Cyan, value, color.End)
+						count++
+					}
+				} else {
+					me.Logger.Warn().Msgf("counter (%s) [%s] not found in cache", name, value)
+				}
+			}
+		}
+	}
+
+	return count, apiT, parseT, nil
+}
+
+// Mask values of instances that were not polled with these counters in the previous
+// poll, e.g. workloads that just entered the top N. Otherwise the delta calculations
+// would leave raw values. Values are compared with the cache (prev).
+func maskNewValues(data, prev *matrix.Matrix) {
+	for key, instance := range data.GetInstances() {
+		prevInstance := prev.GetInstance(key)
+		for name, metric := range data.GetMetrics() {
+			if metric.GetProperty() == "raw" {
+				continue
+			}
+			if _, ok := metric.GetValueFloat64(instance); !ok {
+				continue
+			}
+			prevMetric := prev.GetMetric(name)
+			if prevInstance == nil || prevMetric == nil {
+				metric.SetValueNAN(instance)
+			} else if _, ok := prevMetric.GetValueFloat64(prevInstance); !ok {
+				metric.SetValueNAN(instance)
+			}
+		}
+	}
+}
+
+// Add instance "others" with counters aggregated from instances that are not in
+// polled. Counters that are rate, delta or raw are summed, averages and percents
+// are weighted by their base counter.
+func (me *ZapiPerf) addOthersInstance(data *matrix.Matrix, polled *set.Set) error {
+
+	var (
+		others *matrix.Instance
+		err    error
+	)
+
+	if others, err = data.NewInstance(othersKey); err != nil {
+		return err
+	}
+
+	for label, display := range me.qosLabels {
+		if label == "wid" || label == "policy-group" {
+			others.SetLabel(display, othersKey)
+		}
+	}
+
+	timestamp := data.GetMetric("timestamp")
+
+	for name, metric := range data.GetMetrics() {
+
+		if !metric.IsExportable() {
+			continue
+		}
+
+		var base matrix.Metric
+		property := metric.GetProperty()
+		isLatency := strings.HasSuffix(metric.GetName(), "latency")
+
+		if property == "average" || property == "percent" {
+			if base = data.GetMetric(metric.GetComment()); base == nil {
+				me.Logger.Debug().Msgf("(%s) skip aggregating, base counter (%s) missing", name, metric.GetComment())
+				continue
+			}
+		}
+
+		sum := 0.0
+		weights := 0.0
+		count := 0
+
+		for key, instance := range data.GetInstances() {
+
+			if key == othersKey || polled.Has(key) {
+				continue
+			}
+
+			value, ok := metric.GetValueFloat64(instance)
+			if !ok || math.IsNaN(value) {
+				continue
+			}
+
+			if base == nil {
+				sum += value
+				count++
+				continue
+			}
+
+			// same threshold as in the delta calculations
+			delta := baseDelta(base, timestamp, instance)
+			if isLatency && delta < float64(me.latencyIoReqd) {
+				continue
+			}
+			sum += value * delta
+			weights += delta
+			count++
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		if base != nil {
+			if weights == 0 {
+				continue
+			}
+			sum /= weights
+		}
+
+		if err = metric.SetValueFloat64(others, sum); err != nil {
+			me.Logger.Error().Stack().Err(err).Msgf("(%s) set value of others", name)
+		}
+	}
+
+	return nil
+}
+
+// Update the top N after the calculations of a poll: aggregate others,
+// export only the polled instances and refresh the selection if it's due
+func (me *ZapiPerf) updateTopN(data *matrix.Matrix) {
+
+	polled := me.topN.polled
+
+	if err := me.addOthersInstance(data, polled); err != nil {
+		me.Logger.Error().Stack().Err(err).Msg("add others instance")
+	}
+
+	for key, instance := range data.GetInstances() {
+		instance.SetExportable(key == othersKey || polled.Has(key))
+	}
+
+	if !me.topN.isDue() {
+		return
+	}
+
+	metric := data.GetMetric(me.topN.by)
+	if metric == nil {
+		me.Logger.Warn().Msgf("top_n: counter (%s) not in cache, keeping selection", me.topN.by)
+		return
+	}
+
+	me.topN.selected = set.NewFrom(me.topN.rank(data, metric, float64(me.latencyIoReqd)))
+	me.topN.updated = time.Now()
+	me.Logger.Debug().Msgf("top_n: selected %d instances by (%s)", me.topN.selected.Size(), me.topN.by)
+}
@@ -0,0 +1,154 @@
+package zapiperf
+
+import (
+	zapi "goharvest2/cmd/collectors/zapi/collector"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/set"
+	"goharvest2/pkg/tree"
+	"goharvest2/pkg/tree/node"
+	"testing"
+)
+
+func TestParseTopN(t *testing.T) {
+
+	tests := []struct {
+		query   string
+		params  string
+		by      string
+		wantErr bool
+	}{
+		{objWorkload, "top_n:\n  size: 50\n", "ops", false},
+		{objWorkloadVolume, "top_n:\n  size: 10\n  by: throughput\n  refresh: 1h\n", "total_data", false},
+		{objWorkloadDetail, "top_n:\n  size: 10\n  by: ops\n", "ops", false},
+		{objWorkloadDetail, "top_n:\n  size: 10\n  by: latency\n", "", true},
+		{objWorkload, "top_n:\n  by: latency\n", "", true},
+		{objWorkload, "top_n:\n  size: 0\n", "", true},
+		{objWorkload, "top_n:\n  size: 10\n  by: iops\n", "", true},
+		{objWorkload, "top_n:\n  size: 10\n  refresh: often\n", "", true},
+		{"volume", "top_n:\n  size: 10\n", "", true},
+	}
+
+	for _, tt := range tests {
+		params, err := tree.LoadYaml([]byte(tt.params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		topN, err := parseTopN(params.GetChildS("top_n"), tt.query)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s %q: expected error", tt.query, tt.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.query, tt.params, err)
+		} else if topN.by != tt.by {
+			t.Errorf("%s %q: by = %s, want %s", tt.query, tt.params, topN.by, tt.by)
+		}
+	}
+}
+
+// newWorkloads - calculated data of 4 workloads polled over 10 seconds
+func newWorkloads(t *testing.T) *matrix.Matrix {
+
+	data := matrix.New("test", "qos")
+
+	timestamp, _ := data.NewMetricFloat64("timestamp")
+	timestamp.SetProperty("raw")
+	ops, _ := data.NewMetricFloat64("ops")
+	ops.SetProperty("rate")
+	latency, _ := data.NewMetricFloat64("latency")
+	latency.SetProperty("average")
+	latency.SetComment("ops")
+
+	values := []struct {
+		key          string
+		ops, latency float64
+	}{
+		{"a", 10, 100},
+		{"b", 50, 200},
+		{"c", 100, 300},
+		{"d", 0.5, 9000}, // too little IO for latency
+	}
+
+	for _, v := range values {
+		instance, err := data.NewInstance(v.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = timestamp.SetValueFloat64(instance, 10)
+		_ = ops.SetValueFloat64(instance, v.ops)
+		_ = latency.SetValueFloat64(instance, v.latency)
+	}
+	return data
+}
+
+func TestRankTopN(t *testing.T) {
+
+	data := newWorkloads(t)
+	topN := &topN{size: 2}
+
+	tests := []struct {
+		by   string
+		want []string
+	}{
+		{"ops", []string{"c", "b"}},
+		{"latency", []string{"c", "b"}},
+	}
+
+	for _, tt := range tests {
+		got := topN.rank(data, data.GetMetric(tt.by), latencyIoReqd)
+		if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("by %s: got %v, want %v", tt.by, got, tt.want)
+		}
+	}
+
+	// d would rank first by latency if not skipped
+	topN.size = 4
+	if got := topN.rank(data, data.GetMetric("latency"), latencyIoReqd); len(got) != 3 {
+		t.Errorf("by latency: got %v, want 3 instances", got)
+	}
+}
+
+func TestUpdateTopN(t *testing.T) {
+
+	params := node.NewS("")
+	me := &ZapiPerf{
+		Zapi:          &zapi.Zapi{AbstractCollector: collector.New("ZapiPerf", "Workload", &options.Options{}, params)},
+		latencyIoReqd: latencyIoReqd,
+		qosLabels:     map[string]string{"wid": "wid", "policy-group": "policy_group", "volume": "volume"},
+		topN:          &topN{size: 2, by: "ops", refresh: topNRefresh, selected: set.New(), polled: set.NewFrom([]string{"c"})},
+	}
+
+	data := newWorkloads(t)
+	me.updateTopN(data)
+
+	others := data.GetInstance(othersKey)
+	if others == nil {
+		t.Fatal("missing instance others")
+	}
+	if others.GetLabel("wid") != othersKey || others.GetLabel("policy_group") != othersKey || others.GetLabel("volume") != "" {
+		t.Errorf("labels of others: %v", others.GetLabels())
+	}
+
+	// sum of a, b and d
+	if v, ok := data.GetMetric("ops").GetValueFloat64(others); !ok || v != 60.5 {
+		t.Errorf("ops of others = %v (%v), want 60.5", v, ok)
+	}
+	// weighted by ops of a and b, d is below the IO threshold
+	if v, ok := data.GetMetric("latency").GetValueFloat64(others); !ok || v != (10*100+50*200)/60.0 {
+		t.Errorf("latency of others = %v (%v), want %v", v, ok, (10*100+50*200)/60.0)
+	}
+
+	for key, instance := range data.GetInstances() {
+		if want := key == "c" || key == othersKey; instance.IsExportable() != want {
+			t.Errorf("instance %s exportable = %v, want %v", key, instance.IsExportable(), want)
+		}
+	}
+
+	if me.topN.selected.Size() != 2 || !me.topN.selected.Has("c") || !me.topN.selected.Has("b") {
+		t.Errorf("selected = %v, want [b c]", me.topN.selected.Values())
+	}
+}
@@ -32,8 +32,10 @@ import (
 	"goharvest2/pkg/matrix"
 	"goharvest2/pkg/set"
 	"goharvest2/pkg/tree/node"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	zapi "goharvest2/cmd/collectors/zapi/collector"
@@ -56,7 +58,6 @@ const BILLION = 1000000000
 type ZapiPerf struct {
 	*zapi.Zapi      // provides: AbstractCollector, Client, Object, Query, TemplateFn, TemplateType
 	object          string
-	batchSize       int
 	latencyIoReqd   int
 	instanceKey     string
 	instanceLabels  map[string]string
@@ -64,6 +65,12 @@ type ZapiPerf struct {
 	scalarCounters  []string
 	qosLabels       map[string]string
 	isCacheEmpty    bool
+	topN            *topN       // only for workload objects, nil if all instances are collected
+	mu              *sync.Mutex // guards cache while state is saved on shutdown
+	statePath       string      // empty if cache is not persisted
+	stateSaved      time.Time
+	cacheMaxAge     time.Duration
+	batches         chan struct{} // slots of in-flight batch requests, shared by all objects of the cluster
 }
 
 func init() {
@@ -77,8 +84,15 @@ func (ZapiPerf) HarvestModule() plugin.ModuleInfo {
 	}
 }
 
-func (me *ZapiPerf) Init(a *collector.AbstractCollector) error {
+func (me *ZapiPerf) Init(a *collector.AbstractCollector) (err error) {
 	me.Zapi = &zapi.Zapi{AbstractCollector: a}
+	me.mu = &sync.Mutex{}
+	// poller drops collectors that fail to initialize, release their connection
+	defer func() {
+		if err != nil && me.Client != nil {
+			me.Client.Close()
+		}
+	}()
 
 	if err := me.InitVars(); err != nil {
 		return err
@@ -93,6 +107,11 @@ func (me *ZapiPerf) Init(a *collector.AbstractCollector) error {
 		return err
 	}
 
+	// samples skipped due to counter resets
+	if _, err := me.Metadata.NewMetricUint64("skips"); err != nil {
+		return err
+	}
+
 	if err := me.InitCache(); err != nil {
 		return err
 	}
@@ -105,8 +124,18 @@ func (me *ZapiPerf) InitCache() error {
 	me.histogramLabels = make(map[string][]string)
 	me.instanceLabels = make(map[string]string)
 	me.instanceKey = me.loadParamStr("instance_key", instanceKey)
-	me.batchSize = me.loadParamInt("batch_size", batchSize)
+	if err := me.InitBatch(batchSize); err != nil {
+		return err
+	}
 	me.latencyIoReqd = me.loadParamInt("latency_io_reqd", latencyIoReqd)
+	if n := me.loadParamInt("concurrent_batches", concurrentBatches); n > 0 {
+		me.batches = me.Client.BatchSlots(n)
+		if cap(me.batches) != n {
+			me.Logger.Warn().Msgf("using concurrent_batches = [%d] of cluster, instead of [%d]", cap(me.batches), n)
+		}
+	} else {
+		return errors.New(errors.INVALID_PARAM, "concurrent_batches: "+strconv.Itoa(n))
+	}
 	me.isCacheEmpty = true
 	me.object = me.loadParamStr("object", "")
 	// hack to override from AbstractCollector
@@ -116,6 +145,16 @@ func (me *ZapiPerf) InitCache() error {
 	}
 	me.Matrix.Object = me.object
 	me.Logger.Debug().Msgf("object= %s --> %s", me.Object, me.object)
+
+	if x := me.Params.GetChildS("top_n"); x != nil {
+		var err error
+		if me.topN, err = parseTopN(x, me.Query); err != nil {
+			return err
+		}
+		me.Logger.Debug().Msgf("using top_n = [%d] by [%s], refresh every %s", me.topN.size, me.topN.by, me.topN.refresh.String())
+	}
+
+	me.InitState()
 	return nil
 }
 
@@ -158,13 +197,25 @@ func (me *ZapiPerf) loadParamInt(name string, defaultValue int) int {
 func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 
 	var (
-		instanceKeys    []string
-		resourceLatency matrix.Metric // for workload* objects
-		err             error
+		instanceKeys []string
+		topNKeys     []string // instances not in the top N
+		err          error
 	)
 
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
 	me.Logger.Debug().Msg("updating data cache")
 
+	// after a restart, use cache persisted before, if still valid
+	if me.isCacheEmpty && me.statePath != "" {
+		if err = me.restoreState(); err == nil {
+			me.isCacheEmpty = false
+		} else if !os.IsNotExist(err) {
+			me.Logger.Info().Msgf("not using persisted cache state: %v", err)
+		}
+	}
+
 	// clone matrix without numeric data
 	newData := me.Matrix.Clone(false, true, true)
 	newData.Reset()
@@ -193,12 +244,21 @@ func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 			return nil, errors.New(errors.MISSING_PARAM, "resource_map")
 		} else {
 			instanceKeys = make([]string, 0)
+			keys := newData.GetInstanceKeys()
+			// ops of all instances are collected by getParentOpsCounters
+			if me.topN != nil {
+				keys, _ = me.topN.split(keys)
+				me.topN.polled = set.NewFrom(keys)
+			}
 			for _, layer := range resourceMap.GetAllChildNamesS() {
-				for key := range me.Matrix.GetInstances() {
+				for _, key := range keys {
 					instanceKeys = append(instanceKeys, key+"."+layer)
 				}
 			}
 		}
+	} else if me.topN != nil {
+		instanceKeys, topNKeys = me.topN.split(newData.GetInstanceKeys())
+		me.topN.polled = set.NewFrom(instanceKeys)
 	} else {
 		instanceKeys = newData.GetInstanceKeys()
 	}
@@ -222,197 +282,85 @@ func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 		requestCounters.NewChildS("counter", key)
 	}
 
-	// batch indices
-	startIndex := 0
-	endIndex := 0
-
-	for endIndex < len(instanceKeys) {
-
-		// update batch indices
-		endIndex += me.batchSize
-		if endIndex > len(instanceKeys) {
-			endIndex = len(instanceKeys)
-		}
-
-		me.Logger.Debug().Msgf("starting batch poll for instances [%d:%d]", startIndex, endIndex)
+	// instances are parsed while batch responses are streamed, responses
+	// of concurrent batches are parsed one at a time
+	var parseMux sync.Mutex
+	parse := func(i *node.Node, ts float64) {
+		parseMux.Lock()
+		count += me.parseInstance(newData, timestamp, i, ts)
+		parseMux.Unlock()
+	}
 
-		request.PopChildS(keyName + "s")
-		requestInstances := request.NewChildS(keyName+"s", "")
-		for _, key := range instanceKeys[startIndex:endIndex] {
-			requestInstances.NewChildS(keyName, key)
+	// stop requesting batches and wait for pending ones if we return early
+	var stopOnce sync.Once
+	stop := make(chan struct{})
+	batches := me.fetchBatches(request, keyName, instanceKeys, parse, stop)
+	halt := func() {
+		stopOnce.Do(func() { close(stop) })
+		for range batches {
 		}
+	}
+	defer halt()
 
-		startIndex = endIndex
+	// slowest and largest batch, to adapt batch size for next poll
+	var (
+		maxRecords, maxLength int
+		maxApiT               time.Duration
+	)
 
-		if err = me.Client.BuildRequest(request); err != nil {
-			me.Logger.Error().Stack().Err(err).Msg("build request: ")
-			return nil, err
-		}
+	for batch := range batches {
 
-		response, rd, pd, err := me.Client.InvokeWithTimers()
-		if err != nil {
+		if err = batch.err; err != nil {
 			// if ONTAP complains about batch size, use a smaller batch size
-			if strings.Contains(err.Error(), "resource limit exceeded") && me.batchSize > 100 {
-				me.Logger.Error().Stack().Err(err)
-				me.Logger.Info().Msgf("changed batch_size from [%d] to [%d]", me.batchSize, me.batchSize-100)
-				me.batchSize -= 100
+			if me.UpdateBatch("data", batch.records, batch.apiT, batch.length, err) {
+				me.Logger.Error().Stack().Err(err).Msg("skip poll")
 				return nil, nil
 			}
 			return nil, err
 		}
 
-		apiT += rd
-		parseT += pd
+		apiT += batch.apiT
+		parseT += batch.parseT
 		batchCount++
 
+		if batch.records > maxRecords {
+			maxRecords = batch.records
+		}
+		if batch.length > maxLength {
+			maxLength = batch.length
+		}
+		// parse time includes receiving the response body
+		if t := batch.apiT + batch.parseT; t > maxApiT {
+			maxApiT = t
+		}
+
 		// fetch instances
-		instances := response.GetChildS("instances")
-		if instances == nil || len(instances.GetChildren()) == 0 {
+		if batch.instances == 0 {
 			err = errors.New(errors.ERR_NO_INSTANCE, "")
 			break
 		}
 
-		me.Logger.Debug().Msgf("fetched batch with %d instances", len(instances.GetChildren()))
-
-		// timestamp for batch instances
-		// ignore timestamp from ZAPI which is always integer
-		// we want float, since our poll interval can be float
-		ts := float64(time.Now().UnixNano()) / BILLION
-
-		for _, i := range instances.GetChildren() {
-
-			key := i.GetChildContentS(me.instanceKey)
-
-			// special case for these two objects
-			// we need to process each latency layer for each instance/counter
-			if me.Query == objWorkloadDetail || me.Query == objWorkloadDetailVolume {
-
-				layer := "" // latency layer (resource) for workloads
-
-				if x := strings.Split(key, "."); len(x) == 2 {
-					key = x[0]
-					layer = x[1]
-				} else {
-					me.Logger.Warn().Msgf("instance name [%s] has unexpected format", key)
-					continue
-				}
-
-				if resourceLatency = newData.GetMetric(layer); resourceLatency == nil {
-					me.Logger.Warn().Msgf("resource-latency metric [%s] missing in cache", layer)
-					continue
-				}
-			}
-
-			if key == "" {
-				me.Logger.Debug().Msgf("skip instance, no key [%s] (name=%s, uuid=%s)", me.instanceKey, i.GetChildContentS("name"), i.GetChildContentS("uuid"))
-				continue
-			}
-
-			instance := newData.GetInstance(key)
-			if instance == nil {
-				me.Logger.Debug().Msgf("skip instance [%s], not found in cache", key)
-				continue
-			}
-
-			counters := i.GetChildS("counters")
-			if counters == nil {
-				me.Logger.Debug().Msgf("skip instance [%s], no data counters", key)
-				continue
-			}
-
-			me.Logger.Debug().Msgf("fetching data of instance [%s]", key)
-
-			// add batch timestamp as custom counter
-			if err := timestamp.SetValueFloat64(instance, ts); err != nil {
-				me.Logger.Error().Stack().Err(err).Msg("set timestamp value: ")
-			}
-
-			for _, cnt := range counters.GetChildren() {
-
-				name := cnt.GetChildContentS("name")
-				value := cnt.GetChildContentS("value")
-
-				// sanity check
-				// @TODO - redundant
-				if name == "" || value == "" {
-					me.Logger.Debug().Msgf("skipping incomplete counter [%s] with value [%s]", name, value)
-					continue
-				}
-
-				me.Logger.Trace().Msgf("(%s%s%s) parsing counter (%s) = %v", color.Grey, key, color.End, name, value)
-
-				// ZAPI counter for us is either instance label (string)
-				// or numeric metric (scalar or histogram)
-
-				// store as instance label
-				if display, has := me.instanceLabels[name]; has {
-					instance.SetLabel(display, value)
-					me.Logger.Trace().Msgf("+ label (%s) = [%s%s%s]", display, color.Yellow, value, color.End)
-					continue
-				}
-
-				// store as array counter / histogram
-				if labels, has := me.histogramLabels[name]; has {
-
-					values := strings.Split(value, ",")
-
-					if len(labels) != len(values) {
-						// warn & skip
-						me.Logger.Error().Stack().Err(nil).Msgf("histogram (%s) labels don't match with parsed values [%s]", name, value)
-						continue
-					}
-
-					for i, label := range labels {
-						if metric := newData.GetMetric(name + "." + label); metric != nil {
-							if err = metric.SetValueString(instance, values[i]); err != nil {
-								me.Logger.Error().Stack().Err(err).Msgf("set histogram (%s.%s) value [%s]: ", name, label, values[i])
-							} else {
-								me.Logger.Trace().Msgf("+ histogram (%s.%s) = [%s%s%s]", name, label, color.Pink, values[i], color.End)
-								count++
-							}
-						} else {
-							me.Logger.Warn().Msgf("histogram (%s.%s) = [%s] not in cache", name, label, value)
-						}
-					}
-					continue
-				}
-
-				// special case for workload_detail
-				if me.Query == objWorkloadDetail || me.Query == objWorkloadDetailVolume {
-					if name == "wait_time" || name == "service_time" {
-						if err := resourceLatency.AddValueString(instance, value); err != nil {
-							me.Logger.Error().Stack().Err(err).Msgf("add resource-latency (%s) value [%s]: %v", name, value, err)
-						} else {
-							me.Logger.Trace().Msgf("++ resource-latency (%s) = [%s%s%s]", name, color.Blue, value, color.End)
-							count++
-						}
-						continue
-					}
-					// "visits" are ignored
-					if name == "visits" {
-						continue
-					}
-				}
-
-				// store as scalar metric
-				if metric := newData.GetMetric(name); metric != nil {
-					if err = metric.SetValueString(instance, value); err != nil {
-						me.Logger.Error().Stack().Err(err).Msgf("set metric (%s) value [%s]", name, value)
-					} else {
-						me.Logger.Trace().Msgf("+ metric (%s) = [%s%s%s]", name, color.Cyan, value, color.End)
-						count++
-					}
-					continue
-				}
+		me.Logger.Debug().Msgf("fetched batch with %d instances", batch.instances)
+	} // end batch request
 
-				me.Logger.Warn().Msgf("counter (%s) [%s] not found in cache", name, value)
+	// batches still parsing, if we stopped early
+	halt()
 
-			} // end loop over counters
+	me.Logger.Debug().Msgf("collected %d data points in %d batch polls", count, batchCount)
 
-		} // end loop over instances
-	} // end batch request
+	if batchCount != 0 {
+		me.UpdateBatch("data", maxRecords, maxApiT, maxLength, nil)
+	}
 
-	me.Logger.Debug().Msgf("collected %d data points in %d batch polls", count, batchCount)
+	if len(topNKeys) != 0 {
+		if n, rd, pd, err := me.getTopNCounters(newData, keyName, topNKeys); err == nil {
+			count += n
+			apiT += rd
+			parseT += pd
+		} else {
+			return nil, err
+		}
+	}
 
 	if me.Query == objWorkloadDetail || me.Query == objWorkloadDetailVolume {
 		if rd, pd, err := me.getParentOpsCounters(newData, keyName); err == nil {
@@ -422,6 +370,17 @@ func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 			// no point to continue as we can't calculate the other counters
 			return nil, err
 		}
+		// instances not in the top N only have ops, use time of this poll
+		if me.topN != nil {
+			ts := float64(time.Now().UnixNano()) / BILLION
+			for _, instance := range newData.GetInstances() {
+				if _, ok := timestamp.GetValueFloat64(instance); !ok {
+					if err = timestamp.SetValueFloat64(instance, ts); err != nil {
+						me.Logger.Error().Stack().Err(err).Msg("set timestamp value: ")
+					}
+				}
+			}
+		}
 	}
 
 	// update metadata
@@ -435,6 +394,7 @@ func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 		me.Logger.Debug().Msg("skip postprocessing until next poll (previous cache empty)")
 		me.Matrix = newData
 		me.isCacheEmpty = false
+		me.saveStateIfDue()
 		return nil, nil
 	}
 
@@ -445,6 +405,11 @@ func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 	// cache raw data for next poll
 	cachedData := newData.Clone(true, true, true) // @TODO implement copy data
 
+	// instances that entered the top N have no previous values of most counters
+	if me.topN != nil {
+		maskNewValues(newData, me.Matrix)
+	}
+
 	// order metrics, such that those requiring base counters are processed last
 	orderedMetrics := make([]matrix.Metric, 0, len(newData.GetMetrics()))
 	orderedKeys := make([]string, 0, len(orderedMetrics))
@@ -469,6 +434,10 @@ func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 		// @TODO terminate since other counters will be incorrect
 	}
 
+	// instances that were reset since previous poll, none of their samples are valid
+	resets := me.getResetInstances(newData, timestamp)
+	skips := uint64(0)
+
 	var base matrix.Metric
 
 	for i, metric := range orderedMetrics {
@@ -487,6 +456,9 @@ func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 			continue
 		}
 
+		// skip negative deltas, caused by counter resets or wraparounds
+		skips += skipResets(newData, metric, nil, resets)
+
 		// DELTA - subtract previous value from current
 		if property == "delta" {
 			// already done
@@ -517,6 +489,9 @@ func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 		// special case for latency counter: apply minimum number of iops as threshold
 		if property == "average" || property == "percent" {
 
+			// base counter was skipped, value would be exported as delta
+			skips += skipResets(newData, metric, base, resets)
+
 			if strings.HasSuffix(metric.GetName(), "latency") {
 				err = metric.DivideWithThreshold(base, me.latencyIoReqd)
 			} else {
@@ -551,14 +526,227 @@ func (me *ZapiPerf) PollData() (*matrix.Matrix, error) {
 		}
 	}
 
+	if me.topN != nil {
+		me.updateTopN(newData)
+	}
+
+	if skips != 0 {
+		me.Logger.Debug().Msgf("skipped %d samples of %d reset instances or counters", skips, resets.Size())
+	}
+
 	me.Metadata.LazySetValueInt64("calc_time", "data", time.Since(calcStart).Microseconds())
+	me.Metadata.LazySetValueUint64("skips", "data", skips)
 
 	// store cache for next poll
 	me.Matrix = cachedData
+	me.saveStateIfDue()
 
 	return newData, nil
 }
 
+// parseInstance - parse counter data of the instance element i of a perf-object-get-instances
+// response into data, ts is the timestamp of the batch. Returns number of data points parsed.
+func (me *ZapiPerf) parseInstance(data *matrix.Matrix, timestamp matrix.Metric, i *node.Node, ts float64) uint64 {
+
+	var (
+		resourceLatency matrix.Metric // for workload* objects
+		count           uint64
+		err             error
+	)
+
+	key := i.GetChildContentS(me.instanceKey)
+
+	// special case for these two objects
+	// we need to process each latency layer for each instance/counter
+	if me.Query == objWorkloadDetail || me.Query == objWorkloadDetailVolume {
+
+		layer := "" // latency layer (resource) for workloads
+
+		if x := strings.Split(key, "."); len(x) == 2 {
+			key = x[0]
+			layer = x[1]
+		} else {
+			me.Logger.Warn().Msgf("instance name [%s] has unexpected format", key)
+			return 0
+		}
+
+		if resourceLatency = data.GetMetric(layer); resourceLatency == nil {
+			me.Logger.Warn().Msgf("resource-latency metric [%s] missing in cache", layer)
+			return 0
+		}
+	}
+
+	if key == "" {
+		me.Logger.Debug().Msgf("skip instance, no key [%s] (name=%s, uuid=%s)", me.instanceKey, i.GetChildContentS("name"), i.GetChildContentS("uuid"))
+		return 0
+	}
+
+	instance := data.GetInstance(key)
+	if instance == nil {
+		me.Logger.Debug().Msgf("skip instance [%s], not found in cache", key)
+		return 0
+	}
+
+	counters := i.GetChildS("counters")
+	if counters == nil {
+		me.Logger.Debug().Msgf("skip instance [%s], no data counters", key)
+		return 0
+	}
+
+	me.Logger.Debug().Msgf("fetching data of instance [%s]", key)
+
+	// add batch timestamp as custom counter
+	if err := timestamp.SetValueFloat64(instance, ts); err != nil {
+		me.Logger.Error().Stack().Err(err).Msg("set timestamp value: ")
+	}
+
+	for _, cnt := range counters.GetChildren() {
+
+		name := cnt.GetChildContentS("name")
+		value := cnt.GetChildContentS("value")
+
+		// sanity check
+		// @TODO - redundant
+		if name == "" || value == "" {
+			me.Logger.Debug().Msgf("skipping incomplete counter [%s] with value [%s]", name, value)
+			continue
+		}
+
+		me.Logger.Trace().Msgf("(%s%s%s) parsing counter (%s) = %v", color.Grey, key, color.End, name, value)
+
+		// ZAPI counter for us is either instance label (string)
+		// or numeric metric (scalar or histogram)
+
+		// store as instance label
+		if display, has := me.instanceLabels[name]; has {
+			instance.SetLabel(display, value)
+			me.Logger.Trace().Msgf("+ label (%s) = [%s%s%s]", display, color.Yellow, value, color.End)
+			continue
+		}
+
+		// store as array counter / histogram
+		if labels, has := me.histogramLabels[name]; has {
+
+			values := strings.Split(value, ",")
+
+			if len(labels) != len(values) {
+				// warn & skip
+				me.Logger.Error().Stack().Err(nil).Msgf("histogram (%s) labels don't match with parsed values [%s]", name, value)
+				continue
+			}
+
+			for i, label := range labels {
+				if metric := data.GetMetric(name + "." + label); metric != nil {
+					if err = metric.SetValueString(instance, values[i]); err != nil {
+						me.Logger.Error().Stack().Err(err).Msgf("set histogram (%s.%s) value [%s]: ", name, label, values[i])
+					} else {
+						me.Logger.Trace().Msgf("+ histogram (%s.%s) = [%s%s%s]", name, label, color.Pink, values[i], color.End)
+						count++
+					}
+				} else {
+					me.Logger.Warn().Msgf("histogram (%s.%s) = [%s] not in cache", name, label, value)
+				}
+			}
+			continue
+		}
+
+		// special case for workload_detail
+		if me.Query == objWorkloadDetail || me.Query == objWorkloadDetailVolume {
+			if name == "wait_time" || name == "service_time" {
+				if err := resourceLatency.AddValueString(instance, value); err != nil {
+					me.Logger.Error().Stack().Err(err).Msgf("add resource-latency (%s) value [%s]: %v", name, value, err)
+				} else {
+					me.Logger.Trace().Msgf("++ resource-latency (%s) = [%s%s%s]", name, color.Blue, value, color.End)
+					count++
+				}
+				continue
+			}
+			// "visits" are ignored
+			if name == "visits" {
+				continue
+			}
+		}
+
+		// store as scalar metric
+		if metric := data.GetMetric(name); metric != nil {
+			if err = metric.SetValueString(instance, value); err != nil {
+				me.Logger.Error().Stack().Err(err).Msgf("set metric (%s) value [%s]", name, value)
+			} else {
+				me.Logger.Trace().Msgf("+ metric (%s) = [%s%s%s]", name, color.Cyan, value, color.End)
+				count++
+			}
+			continue
+		}
+
+		me.Logger.Warn().Msgf("counter (%s) [%s] not found in cache", name, value)
+
+	} // end loop over counters
+
+	return count
+}
+
+// Instances of which counters were reset since the previous poll: instance uuid
+// changed (e.g. after takeover, if instance key is name) or the timestamp
+// went backwards. Should be called after calculating the delta of timestamp.
+func (me *ZapiPerf) getResetInstances(data *matrix.Matrix, timestamp matrix.Metric) *set.Set {
+
+	resets := set.New()
+	uuidLabel := me.instanceLabels["instance_uuid"]
+
+	for key, instance := range data.GetInstances() {
+
+		if elapsed, ok := timestamp.GetValueFloat64(instance); ok && elapsed <= 0 {
+			me.Logger.Debug().Msgf("(%s) [%s] timestamp went backwards (%f s)", me.Query, key, elapsed)
+			resets.Add(key)
+			continue
+		}
+
+		if uuidLabel == "" {
+			continue
+		}
+
+		if prev := me.Matrix.GetInstance(key); prev != nil {
+			uuid := instance.GetLabel(uuidLabel)
+			prevUuid := prev.GetLabel(uuidLabel)
+			if uuid != "" && prevUuid != "" && uuid != prevUuid {
+				me.Logger.Debug().Msgf("(%s) [%s] uuid changed from [%s] to [%s]", me.Query, key, prevUuid, uuid)
+				resets.Add(key)
+			}
+		}
+	}
+
+	return resets
+}
+
+// Mark samples of metric as absent if the delta is negative, the instance was reset
+// or base (optional) is absent. Returns number of skipped samples.
+func skipResets(data *matrix.Matrix, metric, base matrix.Metric, resets *set.Set) uint64 {
+
+	var count uint64
+
+	for key, instance := range data.GetInstances() {
+
+		value, ok := metric.GetValueFloat64(instance)
+		if !ok {
+			continue
+		}
+
+		skip := value < 0 || resets.Has(key)
+
+		if !skip && base != nil {
+			_, ok = base.GetValueFloat64(instance)
+			skip = !ok
+		}
+
+		if skip {
+			metric.SetValueNAN(instance)
+			count++
+		}
+	}
+
+	return count
+}
+
 // Poll counter "ops" of the related/parent object, required for objects
 // workload_detail and workload_detail_volume. This counter is already
 // collected by the other ZapiPerf collectors, so this poll is redundant
@@ -570,7 +758,6 @@ func (me *ZapiPerf) getParentOpsCounters(data *matrix.Matrix, KeyAttr string) (t
 		object       string
 		instanceKeys []string
 		apiT, parseT time.Duration
-		err          error
 	)
 
 	if me.Query == objWorkloadDetail {
@@ -607,7 +794,7 @@ func (me *ZapiPerf) getParentOpsCounters(data *matrix.Matrix, KeyAttr string) (t
 	for endIndex < len(instanceKeys) {
 
 		// update batch indices
-		endIndex += me.batchSize
+		endIndex += me.Batch.Size()
 		if endIndex > len(instanceKeys) {
 			endIndex = len(instanceKeys)
 		}
@@ -622,11 +809,7 @@ func (me *ZapiPerf) getParentOpsCounters(data *matrix.Matrix, KeyAttr string) (t
 
 		startIndex = endIndex
 
-		if err = me.Client.BuildRequest(request); err != nil {
-			return apiT, parseT, err
-		}
-
-		response, rt, pt, err := me.Client.InvokeWithTimers()
+		response, _, rt, pt, err := me.Client.InvokeContextWithTimers(me.Context(), request)
 		if err != nil {
 			return apiT, parseT, err
 		}
@@ -696,6 +879,9 @@ func (me *ZapiPerf) PollCounter() (*matrix.Matrix, error) {
 		counters                                 map[string]*node.Node
 	)
 
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
 	me.scalarCounters = make([]string, 0)
 	counters = make(map[string]*node.Node)
 	oldMetrics = set.New() // current set of metrics, so we can remove from matrix if not updated
@@ -1035,6 +1221,7 @@ func (me *ZapiPerf) addCounter(counter *node.Node, name, display string, enabled
 			m.SetName(display)
 			m.SetProperty(property)
 			m.SetComment(baseKey)
+			m.SetUnit(unit)
 			m.SetExportable(enabled)
 
 			if x := strings.Split(label, "."); len(x) == 2 {
@@ -1063,6 +1250,7 @@ func (me *ZapiPerf) addCounter(counter *node.Node, name, display string, enabled
 		m.SetName(display)
 		m.SetProperty(property)
 		m.SetComment(baseCounter)
+		m.SetUnit(unit)
 		m.SetExportable(enabled)
 
 	}
@@ -1115,6 +1303,9 @@ func (me *ZapiPerf) PollInstance() (*matrix.Matrix, error) {
 		keyAttr, instancesAttr, nameAttr, uuidAttr string
 	)
 
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
 	oldInstances = set.New()
 	for key := range me.Matrix.GetInstances() {
 		oldInstances.Add(key)
@@ -1159,7 +1350,7 @@ func (me *ZapiPerf) PollInstance() (*matrix.Matrix, error) {
 	}
 
 	if me.Client.IsClustered() {
-		request.NewChildS("max-records", strconv.Itoa(me.batchSize))
+		request.NewChildS("max-records", strconv.Itoa(me.Batch.Size()))
 	}
 
 	batchTag := "initial"
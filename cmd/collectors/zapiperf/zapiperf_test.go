@@ -0,0 +1,109 @@
+package zapiperf
+
+import (
+	zapi "goharvest2/cmd/collectors/zapi/collector"
+	"goharvest2/cmd/poller/collector"
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree/node"
+	"testing"
+)
+
+func TestSkipResets(t *testing.T) {
+
+	// raw values of previous poll
+	prev := matrix.New("test", "volume")
+
+	timestamp, _ := prev.NewMetricFloat64("timestamp")
+	timestamp.SetProperty("raw")
+	ops, _ := prev.NewMetricFloat64("total_ops")
+	ops.SetProperty("rate")
+	latency, _ := prev.NewMetricFloat64("avg_latency")
+	latency.SetProperty("average")
+	latency.SetComment("total_ops")
+
+	values := []struct {
+		key, uuid        string
+		ts, ops, latency float64
+	}{
+		{"vol1", "u1", 100, 1000, 5000},
+		{"vol2", "u2", 100, 1000, 5000},
+		{"vol3", "u3", 100, 1000, 5000},
+		{"vol4", "u4", 100, 1000, 5000},
+	}
+
+	for _, v := range values {
+		instance, _ := prev.NewInstance(v.key)
+		instance.SetLabel("uuid", v.uuid)
+		_ = timestamp.SetValueFloat64(instance, v.ts)
+		_ = ops.SetValueFloat64(instance, v.ops)
+		_ = latency.SetValueFloat64(instance, v.latency)
+	}
+
+	data := prev.Clone(true, true, true)
+
+	update := []struct {
+		key, uuid        string
+		ts, ops, latency float64
+	}{
+		{"vol1", "u1", 160, 1600, 6200}, // valid
+		{"vol2", "u2", 160, 10, 6000},   // ops reset, e.g. reboot
+		{"vol3", "u9", 160, 1600, 6000}, // new uuid, e.g. takeover
+		{"vol4", "u4", 90, 1600, 6000},  // timestamp went backwards
+	}
+
+	for _, v := range update {
+		instance := data.GetInstance(v.key)
+		instance.SetLabel("uuid", v.uuid)
+		_ = data.GetMetric("timestamp").SetValueFloat64(instance, v.ts)
+		_ = data.GetMetric("total_ops").SetValueFloat64(instance, v.ops)
+		_ = data.GetMetric("avg_latency").SetValueFloat64(instance, v.latency)
+	}
+
+	me := &ZapiPerf{
+		Zapi:           &zapi.Zapi{AbstractCollector: collector.New("ZapiPerf", "Volume", &options.Options{}, node.NewS(""))},
+		instanceLabels: map[string]string{"instance_uuid": "uuid"},
+	}
+	me.Matrix = prev
+
+	ts := data.GetMetric("timestamp")
+	if err := ts.Delta(prev.GetMetric("timestamp")); err != nil {
+		t.Fatal(err)
+	}
+
+	resets := me.getResetInstances(data, ts)
+	if resets.Size() != 2 || !resets.Has("vol3") || !resets.Has("vol4") {
+		t.Errorf("resets = %v, want [vol3 vol4]", resets.Values())
+	}
+
+	skips := uint64(0)
+	for _, key := range []string{"total_ops", "avg_latency"} {
+		metric := data.GetMetric(key)
+		if err := metric.Delta(prev.GetMetric(key)); err != nil {
+			t.Fatal(err)
+		}
+		skips += skipResets(data, metric, data.GetMetric(metric.GetComment()), resets)
+	}
+
+	// vol2: negative ops and latency without base, vol3 and vol4: both counters
+	if skips != 6 {
+		t.Errorf("skips = %d, want 6", skips)
+	}
+
+	vol1 := data.GetInstance("vol1")
+	if v, ok := data.GetMetric("total_ops").GetValueFloat64(vol1); !ok || v != 600 {
+		t.Errorf("total_ops of vol1 = %v (%v), want 600", v, ok)
+	}
+	if v, ok := data.GetMetric("avg_latency").GetValueFloat64(vol1); !ok || v != 1200 {
+		t.Errorf("avg_latency of vol1 = %v (%v), want 1200", v, ok)
+	}
+
+	for _, key := range []string{"vol2", "vol3", "vol4"} {
+		instance := data.GetInstance(key)
+		for _, metric := range []string{"total_ops", "avg_latency"} {
+			if v, ok := data.GetMetric(metric).GetValueFloat64(instance); ok {
+				t.Errorf("%s of %s = %v, should be skipped", metric, key, v)
+			}
+		}
+	}
+}
@@ -16,6 +16,7 @@
 package collector
 
 import (
+	"context"
 	"goharvest2/pkg/conf"
 	"goharvest2/pkg/logging"
 	"reflect"
@@ -60,6 +61,13 @@ type Collector interface {
 	LinkExporter(exporter.Exporter)
 	LoadPlugins(*node.Node, Collector) error
 	LoadPlugin(string, *plugin.AbstractPlugin) plugin.Plugin
+	Cancel()
+}
+
+// Stateful is implemented by collectors that can persist their
+// state (e.g. cached counter values) when the poller stops
+type Stateful interface {
+	SaveState() error
 }
 
 // Status defines the possible states of a collector
@@ -93,6 +101,8 @@ type AbstractCollector struct {
 	// this is different from what the collector will have in its metadata, since this variable
 	// holds count independent of the poll interval of the collector, used to give stats to Poller
 	countMux *sync.Mutex // used for atomic access to collectCount
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
 // New creates an AbstractCollector with the given arguments:
@@ -109,6 +119,7 @@ func New(name, object string, options *options.Options, params *node.Node) *Abst
 		Params:   params,
 		countMux: &sync.Mutex{},
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 	return &c
 }
 
@@ -398,6 +409,22 @@ func (me *AbstractCollector) GetObject() string {
 	return me.Object
 }
 
+// Context returns the context of the collector, which is done
+// when the poller stops. Use it to abort API requests on shutdown.
+func (me *AbstractCollector) Context() context.Context {
+	if me.ctx == nil {
+		return context.Background()
+	}
+	return me.ctx
+}
+
+// Cancel aborts in-flight requests that use the context of the collector
+func (me *AbstractCollector) Cancel() {
+	if me.cancel != nil {
+		me.cancel()
+	}
+}
+
 // GetCollectCount retrieves and resets count of collected data
 // this and next method are only to report the poller
 // how much data we have collected (independent of poll interval)
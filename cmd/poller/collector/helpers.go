@@ -13,16 +13,19 @@ package collector
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
 	"regexp"
-	"strconv"
 	"strings"
 
+	"github.com/hashicorp/go-version"
+
 	"goharvest2/cmd/poller/plugin"
 	"goharvest2/cmd/poller/plugin/aggregator"
 	"goharvest2/cmd/poller/plugin/label_agent"
-	"goharvest2/pkg/tree"
+	"goharvest2/cmd/poller/plugin/unit_converter"
 	"goharvest2/pkg/tree/node"
 )
 
@@ -32,7 +35,7 @@ import (
 // @collectorName	- name of the collector
 func ImportTemplate(confPath, confFn, collectorName string) (*node.Node, error) {
 	fp := path.Join(confPath, "conf/", strings.ToLower(collectorName), confFn)
-	return tree.Import("yaml", fp)
+	return ImportTemplateFile(fp)
 }
 
 // ImportSubTemplate retrieves the best matching subtemplate of a collector object.
@@ -40,71 +43,229 @@ func ImportTemplate(confPath, confFn, collectorName string) (*node.Node, error)
 // This method is only applicable to the Zapi/ZapiPerf collectors which have
 // multiple objects and each object is forked as a separate collector.
 // The subtemplates are sorted in subdirectories that serve as "tag" for the
-// matching ONTAP version. ImportSubTemplate will attempt to choose the subtemplate
-// with closest matching ONTAP version.
+// matching ONTAP version. A subdirectory is either a single version (e.g. "9.8.0")
+// or an inclusive range of versions (e.g. "9.8.0-9.10.1"). ImportSubTemplate will
+// choose the subtemplate in the following order:
+//   - the narrowest range that includes the ONTAP version
+//   - the newest version that is older than the ONTAP version
+//   - the oldest version that is newer than the ONTAP version
+//
+// Counters of the subtemplate that are annotated with a version constraint that
+// doesn't match the ONTAP version are removed (see FilterCounters).
 //
 // Arguments:
 // @model		- ONTAP model, either cdot or 7mode
 // @filename	- name of the subtemplate
 // @version		- ONTAP version triple (generation, major, minor)
-//
-// BUG: ImportSubTemplate will break if ONTAP version is higher than 9.9.0.
-func (c *AbstractCollector) ImportSubTemplate(model, filename string, version [3]int) (*node.Node, error) {
+func (c *AbstractCollector) ImportSubTemplate(model, filename string, ver [3]int) (*node.Node, error) {
 
 	var (
-		selectedVersion, pathPrefix, subTemplateFp string
-		availableVersions                          map[string]bool
-		versionDecimal                             int
+		selected, pathPrefix, subTemplateFp string
+		ontapVersion                        *version.Version
+		available                           []versionRange
+		template                            *node.Node
+		err                                 error
 	)
 
+	if ontapVersion, err = version.NewVersion(fmt.Sprintf("%d.%d.%d", ver[0], ver[1], ver[2])); err != nil {
+		return nil, err
+	}
+
 	pathPrefix = path.Join(c.Options.HomePath, "conf/", strings.ToLower(c.Name), model)
 	c.Logger.Debug().Msgf("Looking for best-fitting template in [%s]", pathPrefix)
 
 	// check for available versions, those are the subdirectories that include filename
-	availableVersions = make(map[string]bool)
 	if files, err := ioutil.ReadDir(pathPrefix); err == nil {
 		for _, file := range files {
-			if match, _ := regexp.MatchString(`\d+\.\d+\.\d+`, file.Name()); match == true && file.IsDir() {
-				if templates, err := ioutil.ReadDir(path.Join(pathPrefix, file.Name())); err == nil {
-					for _, t := range templates {
-						if t.Name() == filename {
-							c.Logger.Trace().Msgf("available version dir: [%s]", file.Name())
-							availableVersions[file.Name()] = true
-							break
-						}
-					}
-				}
+			if !file.IsDir() {
+				continue
+			}
+			r, ok := parseVersionRange(file.Name())
+			if !ok {
+				continue
+			}
+			if _, err := os.Stat(path.Join(pathPrefix, file.Name(), filename)); err == nil {
+				c.Logger.Trace().Msgf("available version dir: [%s]", file.Name())
+				available = append(available, r)
 			}
 		}
 	} else {
 		return nil, err
 	}
-	c.Logger.Trace().Msgf("checking for %d available versions: %v", len(availableVersions), availableVersions)
+	c.Logger.Trace().Msgf("checking for %d available versions", len(available))
 
-	versionDecimal = version[0]*100 + version[1]*10 + version[2]
+	if selected = selectVersion(available, ontapVersion); selected == "" {
+		return nil, errors.New("No best-fitting subtemplate version found")
+	}
 
-	for max := 0; max <= 100; max++ {
-		// check older version
-		str := strings.Join(strings.Split(strconv.Itoa(versionDecimal-max), ""), ".")
-		if _, exists := availableVersions[str]; exists == true {
-			selectedVersion = str
-			break
+	subTemplateFp = path.Join(pathPrefix, selected, filename)
+	c.Logger.Debug().Msgf("selected best-fitting subtemplate [%s]", subTemplateFp)
+
+	if template, err = ImportTemplateFile(subTemplateFp); err != nil {
+		return nil, err
+	}
+
+	if counters := template.GetChildS("counters"); counters != nil {
+		if removed, err := FilterCounters(counters, ontapVersion); err != nil {
+			return nil, err
+		} else if removed != 0 {
+			c.Logger.Debug().Msgf("removed %d counters not available in ONTAP version %s", removed, ontapVersion.String())
 		}
-		// check newer version
-		str = strings.Join(strings.Split(strconv.Itoa(versionDecimal+max), ""), ".")
-		if _, exists := availableVersions[str]; exists == true {
-			selectedVersion = str
-			break
+	}
+
+	return template, nil
+}
+
+// versionRange - version subdirectory of subtemplates, min and max are equal,
+// unless the subdirectory is a range
+type versionRange struct {
+	dir      string
+	min, max *version.Version
+}
+
+var (
+	versionDirRegex    = regexp.MustCompile(`^(\d+\.\d+\.\d+)(?:-(\d+\.\d+\.\d+))?$`)
+	annotationRegex    = regexp.MustCompile(`\s*\[([^\[\]]*)\]\s*$`)
+	annotationSepRegex = regexp.MustCompile(`[\s,]+`)
+)
+
+// parseVersionRange parses directory name as version (e.g. "9.8.0") or
+// range of versions (e.g. "9.8.0-9.10.1")
+func parseVersionRange(dir string) (versionRange, bool) {
+
+	var err error
+
+	r := versionRange{dir: dir}
+
+	match := versionDirRegex.FindStringSubmatch(dir)
+	if match == nil {
+		return r, false
+	}
+	if r.min, err = version.NewVersion(match[1]); err != nil {
+		return r, false
+	}
+	r.max = r.min
+	if match[2] != "" {
+		if r.max, err = version.NewVersion(match[2]); err != nil || r.max.LessThan(r.min) {
+			return r, false
 		}
 	}
+	return r, true
+}
 
-	if selectedVersion == "" {
-		return nil, errors.New("No best-fitting subtemplate version found")
+// selectVersion returns the directory that best matches v, or empty string if there is none
+func selectVersion(available []versionRange, v *version.Version) string {
+
+	var including, older, newer *versionRange
+
+	for i := range available {
+		r := &available[i]
+		if !v.LessThan(r.min) && !v.GreaterThan(r.max) {
+			if including == nil || r.min.GreaterThan(including.min) || (r.min.Equal(including.min) && r.max.LessThan(including.max)) {
+				including = r
+			}
+		} else if r.max.LessThan(v) {
+			if older == nil || r.max.GreaterThan(older.max) {
+				older = r
+			}
+		} else if newer == nil || r.min.LessThan(newer.min) {
+			newer = r
+		}
 	}
 
-	subTemplateFp = path.Join(pathPrefix, selectedVersion, filename)
-	c.Logger.Debug().Msgf("selected best-fitting subtemplate [%s]", subTemplateFp)
-	return tree.Import("yaml", subTemplateFp)
+	for _, r := range []*versionRange{including, older, newer} {
+		if r != nil {
+			return r.dir
+		}
+	}
+	return ""
+}
+
+// FilterCounters removes counters that are not available in ONTAP version v.
+// Counters (or parent elements of counters) can be annotated with a version
+// constraint in square brackets, e.g.:
+//   - nfs42_ops => ops [min_version=9.8.0]
+//   - volume-qos-attributes [min_version=9.8.0, max_version=9.10.1]:
+//
+// Both bounds are inclusive. Annotations are removed from counters that are kept.
+// Returns the number of removed elements.
+func FilterCounters(counters *node.Node, v *version.Version) (int, error) {
+
+	var removed int
+
+	children := make([]*node.Node, 0, len(counters.Children))
+
+	for _, child := range counters.Children {
+
+		var (
+			keep bool
+			err  error
+			name string
+		)
+
+		// annotation is either in the key (if element has children) or in the value
+		if name, keep, err = matchAnnotation(child.GetNameS(), v); err != nil {
+			return removed, err
+		} else if keep {
+			child.SetNameS(name)
+			var content string
+			if content, keep, err = matchAnnotation(child.GetContentS(), v); err != nil {
+				return removed, err
+			}
+			child.SetContentS(content)
+		}
+
+		if !keep {
+			removed++
+			continue
+		}
+
+		n, err := FilterCounters(child, v)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+		children = append(children, child)
+	}
+
+	counters.Children = children
+	return removed, nil
+}
+
+// matchAnnotation strips version annotation from s and checks if v matches it
+func matchAnnotation(s string, v *version.Version) (string, bool, error) {
+
+	match := annotationRegex.FindStringSubmatchIndex(s)
+	if match == nil {
+		return s, true, nil
+	}
+
+	stripped := s[:match[0]]
+	keep := true
+
+	for _, field := range annotationSepRegex.Split(strings.TrimSpace(s[match[2]:match[3]]), -1) {
+		if field == "" {
+			continue
+		}
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) != 2 {
+			return s, false, errors.New("invalid version annotation: " + s)
+		}
+		bound, err := version.NewVersion(pair[1])
+		if err != nil {
+			return s, false, errors.New("invalid version annotation: " + s)
+		}
+		switch pair[0] {
+		case "min_version":
+			keep = keep && !v.LessThan(bound)
+		case "max_version":
+			keep = keep && !v.GreaterThan(bound)
+		default:
+			return s, false, errors.New("invalid version annotation: " + s)
+		}
+	}
+
+	return stripped, keep, nil
 }
 
 // ParseMetricName parses display name from the raw name of the metric as defined in (sub)template.
@@ -126,6 +287,54 @@ func ParseMetricName(raw string) (string, string) {
 	return name, display
 }
 
+// MetricDef is the definition of a metric in a (sub)template, as parsed by ParseMetricDef
+type MetricDef struct {
+	Name     string // raw name, e.g. path or OID of the metric
+	Display  string // display name, empty if the metric is not renamed
+	Property string // content of trailing parentheses, empty if there are none
+	IsLabel  bool   // metric is collected as label ("^" or "^^")
+	IsKey    bool   // label is also an instance key ("^^")
+}
+
+// ParseMetricDef parses the definition of a metric such as "^^uuid => id" or
+// "read_ops => reads (rate)". Like in ParseMetricName, "=>" renames the metric.
+// Additionally leading "^" marks a label, leading "^^" a label that is also
+// an instance key and trailing parentheses hold a property that is up to the
+// collector, e.g. the type or "rate". Default display name and property are
+// left to the collector as well.
+func ParseMetricDef(raw string) (*MetricDef, error) {
+
+	def := &MetricDef{}
+	s := strings.TrimSpace(raw)
+
+	if strings.HasPrefix(s, "^^") {
+		def.IsKey = true
+		def.IsLabel = true
+	} else if strings.HasPrefix(s, "^") {
+		def.IsLabel = true
+	}
+	s = strings.TrimLeft(s, "^")
+
+	if i := strings.LastIndex(s, "("); i != -1 && strings.HasSuffix(s, ")") {
+		def.Property = strings.TrimSpace(s[i+1 : len(s)-1])
+		s = strings.TrimSpace(s[:i])
+	}
+
+	if x := strings.Split(s, "=>"); len(x) == 2 {
+		s = strings.TrimSpace(x[0])
+		if def.Display = strings.TrimSpace(x[1]); def.Display == "" {
+			return nil, errors.New("empty display name: " + raw)
+		}
+	} else if len(x) > 2 {
+		return nil, errors.New("more than one \"=>\": " + raw)
+	}
+
+	if def.Name = s; def.Name == "" {
+		return nil, errors.New("empty metric name: " + raw)
+	}
+	return def, nil
+}
+
 // getBuiltinPlugin returns built-in plugin with name if it exists, otherwise nil
 func getBuiltinPlugin(name string, abc *plugin.AbstractPlugin) plugin.Plugin {
 
@@ -143,5 +352,9 @@ func getBuiltinPlugin(name string, abc *plugin.AbstractPlugin) plugin.Plugin {
 		return label_agent.New(abc)
 	}
 
+	if name == "UnitConverter" {
+		return unit_converter.New(abc)
+	}
+
 	return nil
 }
@@ -0,0 +1,216 @@
+package collector
+
+import (
+	"goharvest2/cmd/poller/options"
+	"goharvest2/pkg/tree"
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+)
+
+func TestSelectVersion(t *testing.T) {
+
+	var available []versionRange
+	for _, dir := range []string{"9.6.0", "9.8.0", "9.8.0-9.10.1", "9.9.0-9.9.1", "10.1.0", "9.11.0-9.10.0"} {
+		if r, ok := parseVersionRange(dir); ok {
+			available = append(available, r)
+		}
+	}
+	if len(available) != 5 {
+		t.Fatalf("parsed %d version dirs, want 5", len(available))
+	}
+
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"9.5.0", "9.6.0"},
+		{"9.7.0", "9.6.0"},
+		{"9.8.0", "9.8.0"},
+		{"9.9.1", "9.9.0-9.9.1"},
+		{"9.10.1", "9.8.0-9.10.1"},
+		{"9.11.0", "9.8.0-9.10.1"},
+		{"10.2.0", "10.1.0"},
+	}
+
+	for _, tt := range tests {
+		if got := selectVersion(available, version.Must(version.NewVersion(tt.version))); got != tt.want {
+			t.Errorf("%s: selected %s, want %s", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestSelectVersionFallback(t *testing.T) {
+
+	// no directory includes the version, the newest older version
+	// is preferred, even if a newer version is closer
+	tests := []struct {
+		dirs []string
+		want string
+	}{
+		{[]string{"9.6.0", "9.7.1"}, "9.6.0"},
+		{[]string{"9.5.0-9.6.0", "9.7.1-9.8.0"}, "9.5.0-9.6.0"},
+		{[]string{"9.6.0", "9.6.1", "9.7.1", "9.8.0"}, "9.6.1"},
+		{[]string{"9.8.0", "9.7.1"}, "9.7.1"},
+	}
+
+	v := version.Must(version.NewVersion("9.7.0"))
+
+	for _, tt := range tests {
+		var available []versionRange
+		for _, dir := range tt.dirs {
+			r, _ := parseVersionRange(dir)
+			available = append(available, r)
+		}
+		if got := selectVersion(available, v); got != tt.want {
+			t.Errorf("%v: selected %s, want %s", tt.dirs, got, tt.want)
+		}
+	}
+}
+
+func TestFilterCounters(t *testing.T) {
+
+	template := []byte(`
+counters:
+  - instance_name
+  - read_ops
+  - nfs42_ops => nfs_ops [min_version=9.9.0]
+  - nfs3_ops [max_version=9.8.0]
+  - volume-qos-attributes [min_version=9.8.0, max_version=9.10.1]:
+    - ^policy-group-name => policy_group
+    - ^^uuid [min_version=9.11.0]
+`)
+
+	tests := []struct {
+		version string
+		want    []string
+		removed int
+	}{
+		{"9.7.0", []string{"instance_name", "read_ops", "nfs3_ops"}, 2},
+		{"9.8.0", []string{"instance_name", "read_ops", "nfs3_ops", "volume-qos-attributes", "^policy-group-name => policy_group"}, 2},
+		{"9.10.1", []string{"instance_name", "read_ops", "nfs42_ops => nfs_ops", "volume-qos-attributes", "^policy-group-name => policy_group"}, 2},
+		{"9.11.0", []string{"instance_name", "read_ops", "nfs42_ops => nfs_ops"}, 2},
+	}
+
+	for _, tt := range tests {
+		root, err := tree.LoadYaml(template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counters := root.GetChildS("counters")
+		removed, err := FilterCounters(counters, version.Must(version.NewVersion(tt.version)))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.version, err)
+		}
+		got := flatten(counters)
+		if removed != tt.removed || !equal(got, tt.want) {
+			t.Errorf("%s: got %v (removed %d), want %v (removed %d)", tt.version, got, removed, tt.want, tt.removed)
+		}
+	}
+
+	root, _ := tree.LoadYaml([]byte("counters:\n  - read_ops [since=9.8.0]\n"))
+	if _, err := FilterCounters(root.GetChildS("counters"), version.Must(version.NewVersion("9.8.0"))); err == nil {
+		t.Error("expected error for invalid annotation")
+	}
+}
+
+func TestParseMetricDef(t *testing.T) {
+
+	tests := []struct {
+		raw  string
+		want MetricDef
+		err  bool
+	}{
+		{"read_ops", MetricDef{Name: "read_ops"}, false},
+		{" ^name => volume ", MetricDef{Name: "name", Display: "volume", IsLabel: true}, false},
+		{"^^uuid", MetricDef{Name: "uuid", IsLabel: true, IsKey: true}, false},
+		{"space.used => used (uint64)", MetricDef{Name: "space.used", Display: "used", Property: "uint64"}, false},
+		{"readOps (rate)", MetricDef{Name: "readOps", Property: "rate"}, false},
+		{"a => b => c", MetricDef{}, true},
+		{"a =>", MetricDef{}, true},
+		{"^^ => id", MetricDef{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMetricDef(tt.raw)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.raw, err)
+		} else if *got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.raw, *got, tt.want)
+		}
+	}
+}
+
+func TestImportSubTemplate(t *testing.T) {
+
+	home := t.TempDir()
+	for _, dir := range []string{"9.8.0", "9.9.0-9.10.1", "9.12.0"} {
+		fp := filepath.Join(home, "conf", "zapiperf", "cdot", dir)
+		if err := os.MkdirAll(fp, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if dir == "9.12.0" {
+			continue // no subtemplate
+		}
+		if err := ioutil.WriteFile(filepath.Join(fp, "volume.yaml"), []byte("name: "+dir+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := New("ZapiPerf", "Volume", &options.Options{HomePath: home}, node.NewS(""))
+
+	tests := []struct {
+		version [3]int
+		want    string
+	}{
+		{[3]int{9, 8, 0}, "9.8.0"},
+		{[3]int{9, 10, 1}, "9.9.0-9.10.1"},
+		{[3]int{9, 13, 1}, "9.9.0-9.10.1"},
+	}
+
+	for _, tt := range tests {
+		template, err := c.ImportSubTemplate("cdot", "volume.yaml", tt.version)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.version, err)
+		}
+		if got := template.GetChildContentS("name"); got != tt.want {
+			t.Errorf("%v: selected %s, want %s", tt.version, got, tt.want)
+		}
+	}
+}
+
+// content of counters, or name if counter has children
+func flatten(n *node.Node) []string {
+	var names []string
+	for _, child := range n.GetChildren() {
+		if len(child.GetChildren()) != 0 {
+			names = append(names, child.GetNameS())
+			names = append(names, flatten(child)...)
+		} else {
+			names = append(names, child.GetContentS())
+		}
+	}
+	return names
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
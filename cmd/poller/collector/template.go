@@ -0,0 +1,214 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package collector
+
+import (
+	"errors"
+	"goharvest2/pkg/tree"
+	"goharvest2/pkg/tree/node"
+	"path/filepath"
+	"strings"
+)
+
+// sections of a template that can be changed with "add" and "remove"
+var overlaySections = []string{"counters", "plugins", "export_options"}
+
+// ImportTemplateFile loads the template in file fp and resolves its parent
+// templates. A template can extend another template with:
+//
+//	extends: volume.yaml        # relative to the directory of the template
+//	add:
+//	  counters:
+//	    - nfs_ops
+//	remove:
+//	  counters:
+//	    - read_latency_hist
+//	  plugins:
+//	    - Volume
+//
+// The parent template is loaded first, other top-level parameters of the
+// template replace those of the parent. Then elements in "add" are merged
+// into and elements in "remove" are removed from the sections counters,
+// plugins and export_options of the parent.
+func ImportTemplateFile(fp string) (*node.Node, error) {
+	return importTemplateFile(fp, make(map[string]bool))
+}
+
+func importTemplateFile(fp string, visited map[string]bool) (*node.Node, error) {
+
+	var (
+		template, parent *node.Node
+		err              error
+	)
+
+	if fp, err = filepath.Abs(fp); err != nil {
+		return nil, err
+	}
+
+	if visited[fp] {
+		return nil, errors.New("circular extends: " + fp)
+	}
+	visited[fp] = true
+
+	if template, err = tree.Import("yaml", fp); err != nil {
+		return nil, err
+	}
+
+	extends := template.PopChildS("extends")
+	if extends == nil {
+		return template, nil
+	}
+
+	parentFp := extends.GetContentS()
+	if parentFp == "" {
+		return nil, errors.New("extends: missing filename in " + fp)
+	}
+	if !filepath.IsAbs(parentFp) {
+		parentFp = filepath.Join(filepath.Dir(fp), parentFp)
+	}
+
+	if parent, err = importTemplateFile(parentFp, visited); err != nil {
+		return nil, err
+	}
+
+	add := template.PopChildS("add")
+	remove := template.PopChildS("remove")
+
+	// top-level parameters of template override parent
+	for _, child := range template.GetChildren() {
+		parent.PopChildS(child.GetNameS())
+		parent.AddChild(child)
+	}
+
+	if err = applyOverlay(parent, add, remove); err != nil {
+		return nil, errors.New(err.Error() + " in " + fp)
+	}
+	return parent, nil
+}
+
+func applyOverlay(template, add, remove *node.Node) error {
+
+	for _, x := range []*node.Node{add, remove} {
+		if x == nil {
+			continue
+		}
+		for _, section := range x.GetChildren() {
+			if !isOverlaySection(section.GetNameS()) {
+				return errors.New("can't add or remove elements of section [" + section.GetNameS() + "]")
+			}
+		}
+	}
+
+	for _, name := range overlaySections {
+		if add != nil {
+			if source := add.GetChildS(name); source != nil {
+				target := template.GetChildS(name)
+				if target == nil {
+					target = template.NewChildS(name, "")
+				}
+				mergeElements(target, source)
+			}
+		}
+		if remove != nil {
+			if source := remove.GetChildS(name); source != nil {
+				if target := template.GetChildS(name); target != nil {
+					removeElements(target, source)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func isOverlaySection(name string) bool {
+	for _, s := range overlaySections {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeElements adds children of source to target. Named children (e.g. an attribute
+// of a Zapi counter tree) are merged recursively, list elements are appended unless
+// they already exist.
+func mergeElements(target, source *node.Node) {
+	for _, child := range source.GetChildren() {
+		name := child.GetNameS()
+		if name == "" {
+			if !hasElement(target, child.GetContentS()) {
+				target.AddChild(child)
+			}
+		} else if mine := target.GetChildS(name); mine == nil {
+			target.AddChild(child)
+		} else {
+			if content := child.GetContentS(); content != "" {
+				mine.SetContentS(content)
+			}
+			mergeElements(mine, child)
+		}
+	}
+}
+
+// removeElements removes children of target that match children of source.
+// A list element matches list elements with the same counter or display name
+// (regardless of label prefix and version annotation) and named children with that
+// name. Named children of source with children are removed recursively, otherwise
+// the named child of target is removed entirely.
+func removeElements(target, source *node.Node) {
+	for _, child := range source.GetChildren() {
+
+		if name := child.GetNameS(); name != "" && len(child.GetChildren()) != 0 {
+			if mine := target.GetChildS(name); mine != nil {
+				removeElements(mine, child)
+			}
+			continue
+		}
+
+		match := child.GetNameS()
+		if match == "" {
+			if match = counterName(child.GetContentS()); match == "" {
+				continue
+			}
+		}
+
+		kept := make([]*node.Node, 0, len(target.Children))
+		for _, mine := range target.Children {
+			if mine.GetNameS() != "" {
+				if counterName(mine.GetNameS()) == match {
+					continue
+				}
+			} else if name, display := parseCounter(mine.GetContentS()); name == match || display == match {
+				continue
+			}
+			kept = append(kept, mine)
+		}
+		target.Children = kept
+	}
+}
+
+func hasElement(n *node.Node, content string) bool {
+	for _, child := range n.GetChildren() {
+		if child.GetNameS() == "" && child.GetContentS() == content {
+			return true
+		}
+	}
+	return false
+}
+
+// counterName returns the raw name of a counter, e.g. "vserver_name" for "^vserver_name => svm"
+func counterName(s string) string {
+	name, _ := parseCounter(s)
+	return name
+}
+
+// parseCounter returns raw and display name of a counter
+func parseCounter(s string) (string, string) {
+	if s == "" {
+		return "", ""
+	}
+	name, display := ParseMetricName(annotationRegex.ReplaceAllString(s, ""))
+	return strings.TrimSpace(name), strings.TrimSpace(display)
+}
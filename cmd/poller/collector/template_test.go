@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const baseTemplate = `
+name:                     Volume
+query:                    volume
+object:                   volume
+
+counters:
+  - instance_name
+  - vserver_name          => svm
+  - read_ops
+  - read_latency_hist
+  - nfs42_ops             [min_version=9.8.0]
+
+plugins:
+  - Volume
+  - LabelAgent:
+    - exclude_equals: vol_type flexgroup
+
+export_options:
+  instance_keys:
+    - volume
+    - svm
+`
+
+const zapiTemplate = `
+name:             Volume
+query:            volume-get-iter
+object:           volume
+
+counters:
+  volume-attributes:
+    - volume-id-attributes:
+      - ^^name          => volume
+      - ^owning-vserver-name => svm
+    - volume-space-attributes:
+      - size-used
+      - size-total
+`
+
+func writeTemplates(t *testing.T, templates map[string]string) string {
+	dir := t.TempDir()
+	for fn, content := range templates {
+		if err := ioutil.WriteFile(filepath.Join(dir, fn), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImportTemplateFile(t *testing.T) {
+
+	dir := writeTemplates(t, map[string]string{
+		"volume.yaml": baseTemplate,
+		"custom_volume.yaml": `
+extends: volume.yaml
+name:    CustomVolume
+add:
+  counters:
+    - write_ops
+    - read_ops
+  export_options:
+    instance_keys:
+      - node
+remove:
+  counters:
+    - read_latency_hist
+    - svm
+    - nfs42_ops
+  plugins:
+    - Volume
+    - LabelAgent
+  export_options:
+    instance_keys:
+      - svm
+`,
+		"nested.yaml": `
+extends: custom_volume.yaml
+query:   volume_nested
+add:
+  plugins:
+    - UnitConverter
+`,
+	})
+
+	template, err := ImportTemplateFile(filepath.Join(dir, "nested.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := template.GetChildContentS("name"); name != "CustomVolume" {
+		t.Errorf("name = %s, want CustomVolume", name)
+	}
+	if query := template.GetChildContentS("query"); query != "volume_nested" {
+		t.Errorf("query = %s, want volume_nested", query)
+	}
+	if template.GetChildS("extends") != nil || template.GetChildS("add") != nil || template.GetChildS("remove") != nil {
+		t.Error("extends, add and remove should be resolved")
+	}
+
+	tests := []struct {
+		section *node.Node
+		want    []string
+	}{
+		{template.GetChildS("counters"), []string{"instance_name", "read_ops", "write_ops"}},
+		{template.GetChildS("plugins"), []string{"UnitConverter"}},
+		{template.GetChildS("export_options").GetChildS("instance_keys"), []string{"volume", "node"}},
+	}
+
+	for _, tt := range tests {
+		if got := flatten(tt.section); !equal(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.section.GetNameS(), got, tt.want)
+		}
+	}
+}
+
+func TestImportZapiTemplateFile(t *testing.T) {
+
+	dir := writeTemplates(t, map[string]string{
+		"volume.yaml": zapiTemplate,
+		"custom_volume.yaml": `
+extends: volume.yaml
+add:
+  counters:
+    volume-attributes:
+      - volume-space-attributes:
+        - size-available
+      - volume-state-attributes:
+        - ^state
+remove:
+  counters:
+    volume-attributes:
+      - volume-space-attributes:
+        - size-total
+`,
+	})
+
+	template, err := ImportTemplateFile(filepath.Join(dir, "custom_volume.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"volume-attributes",
+		"volume-id-attributes", "^^name          => volume", "^owning-vserver-name => svm",
+		"volume-space-attributes", "size-used", "size-available",
+		"volume-state-attributes", "^state",
+	}
+	if got := flatten(template.GetChildS("counters")); !equal(got, want) {
+		t.Errorf("counters = %v, want %v", got, want)
+	}
+}
+
+func TestImportTemplateFileErrors(t *testing.T) {
+
+	dir := writeTemplates(t, map[string]string{
+		"a.yaml":       "extends: b.yaml\n",
+		"b.yaml":       "extends: a.yaml\n",
+		"volume.yaml":  baseTemplate,
+		"invalid.yaml": "extends: volume.yaml\nremove:\n  query:\n    - volume\n",
+		"missing.yaml": "extends: nothing.yaml\n",
+	})
+
+	for _, fn := range []string{"a.yaml", "invalid.yaml", "missing.yaml"} {
+		if _, err := ImportTemplateFile(filepath.Join(dir, fn)); err == nil {
+			t.Errorf("%s: expected error", fn)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package unit_converter
+
+import (
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/matrix"
+	"strings"
+)
+
+// conversion - factor by which values are multiplied to get base unit
+type conversion struct {
+	factor float64
+	unit   string
+}
+
+// units of ONTAP counters and their conversion to base units, i.e. bytes and seconds.
+// Units not listed here (e.g. "per_sec", "percent" or "none") are left unchanged.
+var conversions = map[string]conversion{
+	"b":          {1, "b"},
+	"kb":         {1024, "b"},
+	"mb":         {1024 * 1024, "b"},
+	"gb":         {1024 * 1024 * 1024, "b"},
+	"b_per_sec":  {1, "b_per_sec"},
+	"kb_per_sec": {1024, "b_per_sec"},
+	"mb_per_sec": {1024 * 1024, "b_per_sec"},
+	"gb_per_sec": {1024 * 1024 * 1024, "b_per_sec"},
+	"nanosec":    {1e-9, "sec"},
+	"microsec":   {1e-6, "sec"},
+	"millisec":   {1e-3, "sec"},
+	"sec":        {1, "sec"},
+}
+
+type UnitConverter struct {
+	*plugin.AbstractPlugin
+	units map[string]string // metric key or name => unit
+}
+
+func New(p *plugin.AbstractPlugin) plugin.Plugin {
+	return &UnitConverter{AbstractPlugin: p}
+}
+
+// Init - parse unit overrides, each rule has the syntax "METRIC UNIT",
+// where METRIC is the key or display name of a metric
+func (me *UnitConverter) Init() error {
+
+	if err := me.AbstractPlugin.Init(); err != nil {
+		return err
+	}
+
+	me.units = make(map[string]string)
+
+	for _, x := range me.Params.GetChildren() {
+		rule := strings.Fields(x.GetContentS())
+		if len(rule) != 2 {
+			return errors.New(errors.INVALID_PARAM, "rule: "+x.GetContentS())
+		}
+		if _, ok := conversions[rule[1]]; !ok {
+			return errors.New(errors.INVALID_PARAM, "unknown unit: "+rule[1])
+		}
+		me.units[rule[0]] = rule[1]
+		me.Logger.Debug().Msgf("parsed rule: metric [%s] has unit [%s]", rule[0], rule[1])
+	}
+
+	return nil
+}
+
+// Run - convert values of metrics with a known unit to base units. Unit of metric is
+// taken from the rules, or from the metric itself (e.g. set by ZapiPerf from counter
+// metadata). After conversion, unit of metric is updated to the base unit.
+func (me *UnitConverter) Run(data *matrix.Matrix) ([]*matrix.Matrix, error) {
+
+	// metrics might be replaced, so don't range over the matrix itself
+	metrics := make(map[string]matrix.Metric, len(data.GetMetrics()))
+	for key, metric := range data.GetMetrics() {
+		metrics[key] = metric
+	}
+
+	for key, metric := range metrics {
+
+		unit, ok := me.units[key]
+		if !ok {
+			if unit, ok = me.units[metric.GetName()]; !ok {
+				unit = metric.GetUnit()
+			}
+		}
+
+		conv, ok := conversions[unit]
+		if !ok {
+			continue
+		}
+
+		if conv.factor != 1 {
+			var err error
+			if metric, err = me.convert(data, key, metric, conv.factor); err != nil {
+				return nil, err
+			}
+		}
+		metric.SetUnit(conv.unit)
+	}
+
+	return nil, nil
+}
+
+// multiply values of metric by factor. Integer metrics are changed to float64
+// if factor is fractional, so small values don't become zero.
+func (me *UnitConverter) convert(data *matrix.Matrix, key string, metric matrix.Metric, factor float64) (matrix.Metric, error) {
+
+	instances := data.GetInstances()
+	values := make(map[string]float64, len(instances))
+
+	for ikey, instance := range instances {
+		if v, ok := metric.GetValueFloat64(instance); ok {
+			values[ikey] = v * factor
+		}
+	}
+
+	if factor < 1 && metric.GetType() != "float64" && metric.GetType() != "float32" {
+		me.Logger.Debug().Msgf("changing type of metric [%s] from %s to float64", key, metric.GetType())
+		converted, err := data.ChangeMetricType(key, "float64")
+		if err != nil {
+			return nil, err
+		}
+		converted.SetName(metric.GetName())
+		converted.SetLabels(metric.GetLabels())
+		converted.SetProperty(metric.GetProperty())
+		converted.SetComment(metric.GetComment())
+		converted.SetUnit(metric.GetUnit())
+		converted.SetExportable(metric.IsExportable())
+		metric = converted
+	}
+
+	for ikey, v := range values {
+		if err := metric.SetValueFloat64(instances[ikey], v); err != nil {
+			return nil, err
+		}
+	}
+
+	return metric, nil
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package unit_converter
+
+import (
+	"goharvest2/cmd/poller/plugin"
+	"goharvest2/pkg/matrix"
+	"goharvest2/pkg/tree/node"
+	"testing"
+)
+
+func newUnitConverter(t *testing.T, rules ...string) *UnitConverter {
+	params := node.NewS("UnitConverter")
+	for _, r := range rules {
+		params.NewChildS("", r)
+	}
+	p := &UnitConverter{AbstractPlugin: plugin.New("Test", nil, params, nil)}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestInitRules(t *testing.T) {
+
+	for _, rule := range []string{"size_total", "size_total parsec"} {
+		params := node.NewS("UnitConverter")
+		params.NewChildS("", rule)
+		p := &UnitConverter{AbstractPlugin: plugin.New("Test", nil, params, nil)}
+		if err := p.Init(); err == nil {
+			t.Errorf("rule %q: expected error", rule)
+		}
+	}
+}
+
+func TestConvertUnits(t *testing.T) {
+
+	p := newUnitConverter(t, "space_total kb", "volume.lag-time millisec")
+
+	data := matrix.New("test", "volume")
+	instance, _ := data.NewInstance("vol1")
+
+	tests := []struct {
+		key, name, unit string
+		uint            bool
+		value           float64
+		want            float64
+		wantUnit        string
+	}{
+		{"read_data", "read_data", "kb_per_sec", false, 2, 2048, "b_per_sec"},
+		{"avg_latency", "avg_latency", "microsec", false, 1500, 0.0015, "sec"},
+		{"total_ops", "total_ops", "per_sec", false, 10, 10, "per_sec"},
+		{"space.size-total", "space_total", "", true, 3, 3072, "b"},
+		{"volume.lag-time", "lag_time", "", true, 2500, 2.5, "sec"},
+		{"files_used", "files_used", "", true, 7, 7, ""},
+	}
+
+	for _, tt := range tests {
+		var m matrix.Metric
+		if tt.uint {
+			m, _ = data.NewMetricUint64(tt.key)
+		} else {
+			m, _ = data.NewMetricFloat64(tt.key)
+		}
+		m.SetName(tt.name)
+		m.SetUnit(tt.unit)
+		_ = m.SetValueFloat64(instance, tt.value)
+	}
+
+	if _, err := p.Run(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		m := data.GetMetric(tt.key)
+		if v, ok := m.GetValueFloat64(instance); !ok || v != tt.want {
+			t.Errorf("%s: value = %v (%v), want %v", tt.key, v, ok, tt.want)
+		}
+		if m.GetUnit() != tt.wantUnit {
+			t.Errorf("%s: unit = %q, want %q", tt.key, m.GetUnit(), tt.wantUnit)
+		}
+		if m.GetName() != tt.name {
+			t.Errorf("%s: name = %q, want %q", tt.key, m.GetName(), tt.name)
+		}
+	}
+
+	if m := data.GetMetric("volume.lag-time"); m.GetType() != "float64" {
+		t.Errorf("volume.lag-time: type = %s, want float64", m.GetType())
+	}
+}
@@ -26,6 +26,14 @@ package main
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	_ "goharvest2/cmd/collectors/exec"
+	_ "goharvest2/cmd/collectors/http"
+	_ "goharvest2/cmd/collectors/nfs"
+	_ "goharvest2/cmd/collectors/promscrape"
+	_ "goharvest2/cmd/collectors/snmp"
+	_ "goharvest2/cmd/collectors/solidfire"
+	_ "goharvest2/cmd/collectors/storagegrid"
+	_ "goharvest2/cmd/collectors/syslog"
 	_ "goharvest2/cmd/collectors/unix"
 	_ "goharvest2/cmd/collectors/zapi/collector"
 	_ "goharvest2/cmd/collectors/zapiperf"
@@ -417,6 +425,17 @@ func (p *Poller) Run() {
 // Stop gracefully exits the program by closing zeroLog
 func (p *Poller) Stop() {
 	logger.Info().Msgf("cleaning up and stopping [pid=%d]", os.Getpid())
+	// abort in-flight polls first, so collectors release their cache
+	for _, c := range p.collectors {
+		c.Cancel()
+	}
+	for _, c := range p.collectors {
+		if s, ok := c.(collector.Stateful); ok {
+			if err := s.SaveState(); err != nil {
+				logger.Warn().Msgf("(%s:%s) save state: %v", c.GetName(), c.GetObject(), err)
+			}
+		}
+	}
 }
 
 // set up signal disposition
@@ -611,13 +630,16 @@ func Union2(hNode *node.Node, poller *conf.Poller) {
 					valNode := rootContent.Content[index+1]
 					//fmt.Printf("node type=%s val=%s %s\n", yNode.Value, valNode.Tag, valNode.Value)
 					switch valNode.Tag {
-					case "!!str", "!!bool":
+					case "!!str", "!!bool", "!!int":
 						newNode.Content = []byte(valNode.Value)
 					case "!!seq":
 						// the poller node that's missing is a sequence so add all the children of the sequence
 						for _, seqNode := range valNode.Content {
 							newNode.NewChildS(seqNode.Value, seqNode.Value)
 						}
+					case "!!map":
+						// nested mapping, e.g. zapi_filters of each object
+						unionMap(newNode, valNode)
 					}
 					hNode.AddChild(newNode)
 				}
@@ -626,6 +648,21 @@ func Union2(hNode *node.Node, poller *conf.Poller) {
 	}
 }
 
+// unionMap copies the keys and values of a yaml mapping node into hNode.
+func unionMap(hNode *node.Node, yNode *yaml.Node) {
+	for i := 0; i+1 < len(yNode.Content); i += 2 {
+		key, valNode := yNode.Content[i], yNode.Content[i+1]
+		newNode := node.NewS(key.Value)
+		switch valNode.Kind {
+		case yaml.MappingNode:
+			unionMap(newNode, valNode)
+		case yaml.ScalarNode:
+			newNode.Content = []byte(valNode.Value)
+		}
+		hNode.AddChild(newNode)
+	}
+}
+
 func (p *Poller) newCollector(class string, object string, template *node.Node) (collector.Collector, error) {
 	name := "harvest.collector." + strings.ToLower(class)
 	mod, err := plugin.GetModule(name)
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"goharvest2/pkg/api/ontapi/zapi"
+	"goharvest2/pkg/conf"
+	"goharvest2/pkg/tree/node"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestUnion2(t *testing.T) {
+
+	harvestYml := `
+Defaults:
+  username: admin
+  password: secret
+  max_concurrent_requests: 4
+Pollers:
+  cluster-01:
+    addr: union2-01
+    max_concurrent_requests: 2
+    log_max_files: 3
+  cluster-02:
+    addr: union2-02
+`
+	config := conf.HarvestConfig{}
+	if err := yaml.Unmarshal([]byte(harvestYml), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		poller string
+		limit  int
+	}{
+		{"cluster-01", 2},
+		{"cluster-02", 4}, // from Defaults
+	}
+
+	for _, tt := range tests {
+		poller := (*config.Pollers)[tt.poller]
+		poller.Union(config.Defaults)
+
+		template := node.NewS("template")
+		template.NewChildS("client_timeout", "10")
+		Union2(template, &poller)
+
+		client, err := zapi.NewShared(template)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.poller, err)
+		}
+		if client.MaxConcurrent() != tt.limit {
+			t.Errorf("%s: max_concurrent_requests = %d, want %d", tt.poller, client.MaxConcurrent(), tt.limit)
+		}
+	}
+
+	// ints other than max_concurrent_requests are copied as well
+	poller := (*config.Pollers)["cluster-01"]
+	template := node.NewS("template")
+	Union2(template, &poller)
+	if x := template.GetChildContentS("log_max_files"); x != "3" {
+		t.Errorf("log_max_files = [%s], want [3]", x)
+	}
+}
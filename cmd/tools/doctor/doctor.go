@@ -3,12 +3,15 @@ package doctor
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"goharvest2/pkg/api/ontapi/zapi"
 	"goharvest2/pkg/color"
 	"goharvest2/pkg/conf"
 	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
+	"time"
 )
 
 type options struct {
@@ -68,6 +71,7 @@ func checkAll(path string, contents []byte) {
 	anyFailed := false
 	anyFailed = !checkUniquePromPorts(*harvestConfig).isValid || anyFailed
 	anyFailed = !checkExporterTypes(*harvestConfig).isValid || anyFailed
+	anyFailed = !checkZapiClientParams(*harvestConfig).isValid || anyFailed
 
 	if anyFailed {
 		os.Exit(1)
@@ -111,6 +115,68 @@ func checkExporterTypes(config conf.HarvestConfig) validation {
 	return valid
 }
 
+// checkZapiClientParams validates the TLS, proxy and retry parameters of
+// all pollers, including those inherited from Defaults
+func checkZapiClientParams(config conf.HarvestConfig) validation {
+	if config.Pollers == nil {
+		return validation{}
+	}
+
+	names := make([]string, 0, len(*config.Pollers))
+	for name := range *config.Pollers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	valid := validation{isValid: true}
+
+	for _, name := range names {
+		poller := (*config.Pollers)[name]
+		if config.Defaults != nil {
+			poller.Union(config.Defaults)
+		}
+		var errs []string
+		if poller.CaCert != nil {
+			if _, err := zapi.LoadCACert(*poller.CaCert); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if poller.TlsMinVersion != nil {
+			if _, err := zapi.ParseTLSVersion(*poller.TlsMinVersion); err != nil {
+				errs = append(errs, err.Error()+" (expected one of 1.0, 1.1, 1.2, 1.3)")
+			}
+		}
+		if poller.ProxyUrl != nil {
+			if _, err := zapi.ParseProxyURL(*poller.ProxyUrl); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if poller.Retries != nil && *poller.Retries < 0 {
+			errs = append(errs, fmt.Sprintf("retries: %d (expected 0 or more)", *poller.Retries))
+		}
+		if poller.RetryBackoff != nil {
+			if d, err := time.ParseDuration(*poller.RetryBackoff); err != nil || d <= 0 {
+				errs = append(errs, "retry_backoff: "+*poller.RetryBackoff+" (expected positive duration, e.g. 1s)")
+			}
+		}
+		if len(errs) == 0 {
+			continue
+		}
+		if valid.isValid {
+			fmt.Printf("%s: Invalid client parameters\n", color.Colorize("Error", color.Red))
+		}
+		valid.isValid = false
+		for _, e := range errs {
+			valid.invalid = append(valid.invalid, name)
+			fmt.Printf("  poller: [%s] %s\n", color.Colorize(name, color.Red), color.Colorize(e, color.Yellow))
+		}
+	}
+	if !valid.isValid {
+		fmt.Println()
+	}
+	return valid
+}
+
 // checkUniquePromPorts checks that all Prometheus exporters
 // that specify a port, do so uniquely
 func checkUniquePromPorts(config conf.HarvestConfig) validation {
@@ -107,3 +107,24 @@ func TestExporterTypesAreValid(t *testing.T) {
 		t.Fatalf(`expected invalid exporter of type Foo, actual was %+v`, valid)
 	}
 }
+
+func TestZapiClientParams(t *testing.T) {
+	path := "testdata/testConfig.yml"
+	err := conf.LoadHarvestConfig(path)
+	if err != nil {
+		panic(err)
+	}
+	valid := checkZapiClientParams(conf.Config)
+	if valid.isValid {
+		t.Fatalf(`expected isValid to be false since cluster-03 has invalid client parameters, actual was %+v`, valid)
+	}
+	// all parameters except server_name are invalid
+	if len(valid.invalid) != 5 {
+		t.Fatalf(`expected 5 invalid parameters, actual was %+v`, valid.invalid)
+	}
+	for _, name := range valid.invalid {
+		if name != "cluster-03" {
+			t.Fatalf(`expected only cluster-03 to be invalid, actual was %+v`, valid.invalid)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package simulator
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+type options struct {
+	Config string
+	Listen string
+}
+
+var opts = &options{}
+
+var Cmd = &cobra.Command{
+	Use:   "simulator",
+	Short: "Simulate an ONTAP cluster",
+	Long:  "Simulator - Serve ZAPI requests from a simulated ONTAP cluster for testing pollers",
+	Run:   doSimulator,
+}
+
+func doSimulator(_ *cobra.Command, _ []string) {
+	var (
+		config *Config
+		sim    *Simulator
+		err    error
+	)
+
+	if opts.Config != "" {
+		if config, err = LoadConfig(opts.Config); err != nil {
+			fmt.Printf("config [%s]: %v\n", opts.Config, err)
+			os.Exit(1)
+		}
+	} else {
+		config = DefaultConfig()
+	}
+
+	if opts.Listen != "" {
+		config.Listen = opts.Listen
+	}
+
+	if sim, err = New(config); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err = sim.ListenAndServe(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	Cmd.Flags().StringVarP(&opts.Config, "config", "c", "", "simulator configuration file (YAML)")
+	Cmd.Flags().StringVarP(&opts.Listen, "listen", "l", "", "address to listen on, overrides config")
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package simulator
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"time"
+)
+
+// Config describes the simulated cluster and the faults the simulator injects.
+// All fields are optional, see DefaultConfig for the values used when missing.
+type Config struct {
+	Listen     string  `yaml:"listen,omitempty"`
+	SslCert    string  `yaml:"ssl_cert,omitempty"`
+	SslKey     string  `yaml:"ssl_key,omitempty"`
+	Username   string  `yaml:"username,omitempty"`
+	Password   string  `yaml:"password,omitempty"`
+	Cluster    string  `yaml:"cluster,omitempty"`
+	Serial     string  `yaml:"serial,omitempty"`
+	Version    string  `yaml:"version,omitempty"`
+	Clustered  *bool   `yaml:"clustered,omitempty"`
+	Nodes      int     `yaml:"nodes,omitempty"`
+	Vservers   int     `yaml:"vservers,omitempty"`
+	Volumes    int     `yaml:"volumes,omitempty"`
+	Workloads  int     `yaml:"workloads,omitempty"`
+	Latency    string  `yaml:"latency,omitempty"`
+	Perf       Perf    `yaml:"perf,omitempty"`
+	Errors     []Fault `yaml:"errors,omitempty"`
+	latency    time.Duration
+	versionTpl [3]int
+}
+
+// Perf holds limits of the simulated perf APIs
+type Perf struct {
+	// MaxInstances is the highest number of instances accepted by
+	// perf-object-get-instances before it fails with "resource limit exceeded"
+	MaxInstances int `yaml:"max_instances,omitempty"`
+}
+
+// Fault describes an error that is injected into the responses of an API
+type Fault struct {
+	Api    string `yaml:"api"`              // name of the API, e.g. "perf-object-get-instances", "*" matches all
+	Object string `yaml:"object,omitempty"` // optional, objectname of a perf API
+	Kind   string `yaml:"kind"`             // one of: resource_limit, zapi, timeout, http, auth
+	Reason string `yaml:"reason,omitempty"` // reason of a failed ZAPI (kind zapi)
+	Errno  string `yaml:"errno,omitempty"`  // errno of a failed ZAPI (kinds zapi and resource_limit)
+	Status int    `yaml:"status,omitempty"` // HTTP status code (kind http)
+	Delay  string `yaml:"delay,omitempty"`  // time to hold back the response (kind timeout)
+	After  int    `yaml:"after,omitempty"`  // number of matching calls that pass before the fault starts
+	Every  int    `yaml:"every,omitempty"`  // inject on every n-th matching call
+	Count  int    `yaml:"count,omitempty"`  // stop after n injected faults, 0 means never stop
+	delay  time.Duration
+}
+
+// DefaultConfig returns the configuration of a small two-node cluster without faults
+func DefaultConfig() *Config {
+	clustered := true
+	return &Config{
+		Listen:    "localhost:8443",
+		Cluster:   "sim-cluster",
+		Serial:    "1-80-000011",
+		Version:   "9.8.0",
+		Clustered: &clustered,
+		Nodes:     2,
+		Vservers:  2,
+		Volumes:   10,
+	}
+}
+
+// LoadConfig reads the simulator configuration from the YAML file at path
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, c.validate()
+}
+
+// validate checks the configuration and fills parsed fields
+func (c *Config) validate() error {
+	var err error
+
+	defaults := DefaultConfig()
+	if c.Listen == "" {
+		c.Listen = defaults.Listen
+	}
+	if c.Cluster == "" {
+		c.Cluster = defaults.Cluster
+	}
+	if c.Serial == "" {
+		c.Serial = defaults.Serial
+	}
+	if c.Version == "" {
+		c.Version = defaults.Version
+	}
+	if c.Clustered == nil {
+		c.Clustered = defaults.Clustered
+	}
+	if c.Nodes < 1 {
+		c.Nodes = 1
+	}
+	if c.Vservers < 1 {
+		c.Vservers = 1
+	}
+
+	if _, err = fmt.Sscanf(c.Version, "%d.%d.%d", &c.versionTpl[0], &c.versionTpl[1], &c.versionTpl[2]); err != nil {
+		return fmt.Errorf("version [%s]: %v", c.Version, err)
+	}
+
+	if c.Latency != "" {
+		if c.latency, err = time.ParseDuration(c.Latency); err != nil {
+			return fmt.Errorf("latency [%s]: %v", c.Latency, err)
+		}
+	}
+
+	for i := range c.Errors {
+		f := &c.Errors[i]
+		switch f.Kind {
+		case "resource_limit", "zapi", "http", "auth":
+		case "timeout":
+			if f.Delay == "" {
+				f.Delay = "30s"
+			}
+			if f.delay, err = time.ParseDuration(f.Delay); err != nil {
+				return fmt.Errorf("errors[%d] delay [%s]: %v", i, f.Delay, err)
+			}
+		default:
+			return fmt.Errorf("errors[%d]: unknown kind [%s]", i, f.Kind)
+		}
+		if f.Api == "" {
+			return fmt.Errorf("errors[%d]: missing api", i)
+		}
+		if f.Every < 1 {
+			f.Every = 1
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"goharvest2/cmd/tools/simulator"
+)
+
+func main() {
+	cobra.CheckErr(simulator.Cmd.Execute())
+}
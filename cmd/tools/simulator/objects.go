@@ -0,0 +1,258 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package simulator
+
+import (
+	"fmt"
+	"goharvest2/pkg/tree/node"
+	"strconv"
+)
+
+const gib = 1024 * 1024 * 1024
+
+// iterApi describes a simulated *-get-iter API
+type iterApi struct {
+	count  func(*Simulator, *node.Node) int
+	record func(*Simulator, *node.Node, int) *node.Node
+}
+
+var iterApis = map[string]iterApi{
+	"system-node-get-iter": {
+		count:  func(s *Simulator, _ *node.Node) int { return s.config.Nodes },
+		record: nodeRecord,
+	},
+	"aggr-get-iter": {
+		count:  func(s *Simulator, _ *node.Node) int { return s.config.Nodes },
+		record: aggrRecord,
+	},
+	"vserver-get-iter": {
+		count:  func(s *Simulator, _ *node.Node) int { return s.config.Vservers },
+		record: vserverRecord,
+	},
+	"volume-get-iter": {
+		count:  func(s *Simulator, _ *node.Node) int { return s.config.Volumes },
+		record: volumeRecord,
+	},
+	"qos-workload-get-iter": {
+		count:  workloadCount,
+		record: workloadRecord,
+	},
+}
+
+// names and identifiers of simulated objects
+
+func uuid(kind, i int) string {
+	return fmt.Sprintf("%08x-%04x-4000-8000-%012x", i, kind, i)
+}
+
+func (s *Simulator) nodeName(i int) string {
+	return fmt.Sprintf("%s-%02d", s.config.Cluster, i%s.config.Nodes+1)
+}
+
+func (s *Simulator) aggrName(i int) string {
+	return fmt.Sprintf("aggr%d_%s", i%s.config.Nodes+1, s.nodeName(i))
+}
+
+func (s *Simulator) vserverName(i int) string {
+	return fmt.Sprintf("svm%d", i%s.config.Vservers+1)
+}
+
+func (s *Simulator) volumeName(i int) string {
+	return fmt.Sprintf("vol%d", i)
+}
+
+func (s *Simulator) workloadName(i int) string {
+	return fmt.Sprintf("wl%d", i)
+}
+
+// system identity
+
+func systemGetVersion(s *Simulator, _ *node.Node) (*node.Node, error) {
+	v := s.config.versionTpl
+	results := node.NewXmlS("results")
+	results.NewChildS("version", fmt.Sprintf("NetApp Release %d.%d.%d: Simulated", v[0], v[1], v[2]))
+	results.NewChildS("is-clustered", strconv.FormatBool(*s.config.Clustered))
+	tuple := results.NewChildS("version-tuple", "").NewChildS("system-version-tuple", "")
+	tuple.NewChildS("generation", strconv.Itoa(v[0]))
+	tuple.NewChildS("major", strconv.Itoa(v[1]))
+	tuple.NewChildS("minor", strconv.Itoa(v[2]))
+	return results, nil
+}
+
+func clusterIdentityGet(s *Simulator, _ *node.Node) (*node.Node, error) {
+	if !*s.config.Clustered {
+		return nil, &zapiError{reason: "Unable to find API: cluster-identity-get", errno: "13005"}
+	}
+	results := node.NewXmlS("results")
+	info := results.NewChildS("attributes", "").NewChildS("cluster-identity-info", "")
+	info.NewChildS("cluster-name", s.config.Cluster)
+	info.NewChildS("cluster-serial-number", s.config.Serial)
+	info.NewChildS("cluster-uuid", uuid(0, 0))
+	return results, nil
+}
+
+func systemGetInfo(s *Simulator, _ *node.Node) (*node.Node, error) {
+	results := node.NewXmlS("results")
+	info := results.NewChildS("system-info", "")
+	info.NewChildS("system-name", s.config.Cluster)
+	info.NewChildS("system-serial-number", s.config.Serial)
+	info.NewChildS("system-model", "SIMBOX")
+	return results, nil
+}
+
+// getIter handles all APIs in iterApis, records are paged by max-records and tag
+func getIter(s *Simulator, request *node.Node) (*node.Node, error) {
+
+	api := iterApis[request.GetNameS()]
+	total := api.count(s, request)
+
+	start, end, next, err := page(request, total)
+	if err != nil {
+		return nil, err
+	}
+
+	results := node.NewXmlS("results")
+	if end > start {
+		list := results.NewChildS("attributes-list", "")
+		for i := start; i < end; i++ {
+			list.AddChild(api.record(s, request, i))
+		}
+	}
+	results.NewChildS("num-records", strconv.Itoa(end-start))
+	if next != "" {
+		results.NewChildS("next-tag", next)
+	}
+	return results, nil
+}
+
+// page returns the range of records requested by max-records and tag
+// and the tag of the next page (empty if this is the last page)
+func page(request *node.Node, total int) (int, int, string, error) {
+	var (
+		start, end, max int
+		err             error
+	)
+
+	if tag := request.GetChildContentS("tag"); tag != "" {
+		if start, err = strconv.Atoi(tag); err != nil || start < 0 {
+			return 0, 0, "", &zapiError{reason: "invalid tag: " + tag, errno: "13115"}
+		}
+	}
+
+	end = total
+	if x := request.GetChildContentS("max-records"); x != "" {
+		if max, err = strconv.Atoi(x); err != nil || max < 1 {
+			return 0, 0, "", &zapiError{reason: "invalid max-records: " + x, errno: "13115"}
+		}
+		end = start + max
+	}
+
+	if start > total {
+		start = total
+	}
+	if end >= total {
+		return start, total, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
+
+// records of *-get-iter APIs
+
+func nodeRecord(s *Simulator, _ *node.Node, i int) *node.Node {
+	info := node.NewXmlS("node-details-info")
+	info.NewChildS("node", s.nodeName(i))
+	info.NewChildS("node-uuid", uuid(1, i))
+	info.NewChildS("node-model", "SIMBOX")
+	info.NewChildS("node-serial-number", fmt.Sprintf("%s%02d", s.config.Serial, i))
+	info.NewChildS("node-location", "simulator")
+	info.NewChildS("node-uptime", strconv.FormatInt(int64(s.now().Sub(s.start).Seconds())+86400, 10))
+	info.NewChildS("is-node-healthy", "true")
+	info.NewChildS("env-over-temperature", "false")
+	return info
+}
+
+func aggrRecord(s *Simulator, _ *node.Node, i int) *node.Node {
+	size := int64(10*gib) * int64(i%4+1) * 1024
+	info := node.NewXmlS("aggr-attributes")
+	info.NewChildS("aggregate-name", s.aggrName(i))
+	info.NewChildS("aggregate-uuid", uuid(2, i))
+	owner := info.NewChildS("aggr-ownership-attributes", "")
+	owner.NewChildS("home-name", s.nodeName(i))
+	owner.NewChildS("owner-name", s.nodeName(i))
+	raid := info.NewChildS("aggr-raid-attributes", "")
+	raid.NewChildS("disk-count", "24")
+	raid.NewChildS("raid-type", "raid_dp")
+	raid.NewChildS("state", "online")
+	space := info.NewChildS("aggr-space-attributes", "")
+	space.NewChildS("size-total", strconv.FormatInt(size, 10))
+	space.NewChildS("size-used", strconv.FormatInt(size/2, 10))
+	space.NewChildS("size-available", strconv.FormatInt(size-size/2, 10))
+	return info
+}
+
+func vserverRecord(s *Simulator, _ *node.Node, i int) *node.Node {
+	info := node.NewXmlS("vserver-info")
+	info.NewChildS("vserver-name", s.vserverName(i))
+	info.NewChildS("uuid", uuid(3, i))
+	info.NewChildS("vserver-type", "data")
+	info.NewChildS("state", "running")
+	return info
+}
+
+func volumeRecord(s *Simulator, _ *node.Node, i int) *node.Node {
+	size := int64(100*gib) * int64(i%5+1)
+	used := size / int64(i%10+2)
+	info := node.NewXmlS("volume-attributes")
+	id := info.NewChildS("volume-id-attributes", "")
+	id.NewChildS("name", s.volumeName(i))
+	id.NewChildS("instance-uuid", uuid(4, i))
+	id.NewChildS("owning-vserver-name", s.vserverName(i))
+	id.NewChildS("node", s.nodeName(i))
+	id.NewChildS("containing-aggregate-name", s.aggrName(i))
+	id.NewChildS("style-extended", "flexvol")
+	space := info.NewChildS("volume-space-attributes", "")
+	space.NewChildS("size", strconv.FormatInt(size, 10))
+	space.NewChildS("size-used", strconv.FormatInt(used, 10))
+	space.NewChildS("size-available", strconv.FormatInt(size-used, 10))
+	space.NewChildS("percentage-size-used", strconv.FormatInt(used*100/size, 10))
+	info.NewChildS("volume-state-attributes", "").NewChildS("state", "online")
+	return info
+}
+
+// isAutovolume is true if qos-workload-get-iter asks for the workloads
+// that ONTAP creates automatically for each volume
+func isAutovolume(request *node.Node) bool {
+	if query := request.GetChildS("query"); query != nil {
+		if info := query.GetChildS("qos-workload-info"); info != nil {
+			return info.GetChildContentS("workload-class") == "autovolume"
+		}
+	}
+	return false
+}
+
+func workloadCount(s *Simulator, request *node.Node) int {
+	if isAutovolume(request) {
+		return s.config.Volumes
+	}
+	return s.config.Workloads
+}
+
+func workloadRecord(s *Simulator, request *node.Node, i int) *node.Node {
+	info := node.NewXmlS("qos-workload-info")
+	info.NewChildS("vserver", s.vserverName(i))
+	info.NewChildS("volume", s.volumeName(i))
+	if isAutovolume(request) {
+		info.NewChildS("workload-name", s.volumeName(i)+"-wid"+strconv.Itoa(i+1000))
+		info.NewChildS("workload-uuid", uuid(6, i))
+		info.NewChildS("workload-class", "autovolume")
+		info.NewChildS("policy-group", "_Performance_Monitor_volume_default")
+	} else {
+		info.NewChildS("workload-name", s.workloadName(i))
+		info.NewChildS("workload-uuid", uuid(5, i))
+		info.NewChildS("workload-class", "user-defined")
+		info.NewChildS("policy-group", "pg_"+s.workloadName(i))
+	}
+	return info
+}
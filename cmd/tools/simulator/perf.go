@@ -0,0 +1,347 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package simulator
+
+import (
+	"fmt"
+	"goharvest2/pkg/tree/node"
+	"strconv"
+	"strings"
+)
+
+// uptime of the simulated cluster when the simulator starts, in seconds,
+// so that counters are not zero in the first poll
+const uptime = 3600
+
+// perfCounter is the metadata of a simulated perf counter. The value of
+// numeric counters increases by rate (per instance weight) each second.
+type perfCounter struct {
+	name       string
+	properties string
+	unit       string
+	base       string
+	labels     []string // labels of array counters
+	rate       float64
+}
+
+// perfInstance is an instance of a simulated perf object
+type perfInstance struct {
+	name   string
+	uuid   string
+	weight float64
+	labels map[string]string // values of string counters
+}
+
+type perfObject struct {
+	instances func(*Simulator) []*perfInstance
+	counters  []*perfCounter
+}
+
+func (o *perfObject) counter(name string) *perfCounter {
+	for _, c := range o.counters {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+var perfObjects = map[string]*perfObject{
+	"system:node": {
+		instances: nodeInstances,
+		counters: []*perfCounter{
+			{name: "node_name", properties: "string", unit: "none"},
+			{name: "cpu_elapsed_time", properties: "delta", unit: "microsec", rate: 1000000},
+			{name: "cpu_busy", properties: "percent", unit: "percent", base: "cpu_elapsed_time", rate: 350000},
+			{name: "domain_busy", properties: "percent", unit: "percent", base: "cpu_elapsed_time", rate: 100000,
+				labels: []string{"idle", "kahuna", "storage", "exempt", "raid", "target", "dnscache", "cifs", "wafl_exempt", "wafl_xcleaner", "sm_exempt", "cluster", "protocol", "nwk_exclusive", "nwk_exempt", "nwk_legacy", "hostOS", "ssan_exempt"}},
+			{name: "total_ops", properties: "rate", unit: "per_sec", rate: 2000},
+			{name: "read_ops", properties: "rate", unit: "per_sec", rate: 1200},
+			{name: "write_ops", properties: "rate", unit: "per_sec", rate: 800},
+			{name: "read_latency", properties: "average", unit: "microsec", base: "read_ops", rate: 1200 * 250},
+			{name: "write_latency", properties: "average", unit: "microsec", base: "write_ops", rate: 800 * 400},
+		},
+	},
+	"volume": {
+		instances: volumeInstances,
+		counters: []*perfCounter{
+			{name: "instance_name", properties: "string", unit: "none"},
+			{name: "vserver_name", properties: "string", unit: "none"},
+			{name: "node_name", properties: "string", unit: "none"},
+			{name: "total_ops", properties: "rate", unit: "per_sec", rate: 100},
+			{name: "read_ops", properties: "rate", unit: "per_sec", rate: 60},
+			{name: "write_ops", properties: "rate", unit: "per_sec", rate: 30},
+			{name: "other_ops", properties: "rate", unit: "per_sec", rate: 10},
+			{name: "read_data", properties: "rate", unit: "b_per_sec", rate: 60 * 8192},
+			{name: "write_data", properties: "rate", unit: "b_per_sec", rate: 30 * 32768},
+			{name: "avg_latency", properties: "average", unit: "microsec", base: "total_ops", rate: 100 * 300},
+			{name: "read_latency", properties: "average", unit: "microsec", base: "read_ops", rate: 60 * 250},
+			{name: "write_latency", properties: "average", unit: "microsec", base: "write_ops", rate: 30 * 450},
+		},
+	},
+	"lif": {
+		instances: lifInstances,
+		counters: []*perfCounter{
+			{name: "instance_name", properties: "string", unit: "none"},
+			{name: "vserver_name", properties: "string", unit: "none"},
+			{name: "node_name", properties: "string", unit: "none"},
+			{name: "recv_data", properties: "rate", unit: "b_per_sec", rate: 5000000},
+			{name: "sent_data", properties: "rate", unit: "b_per_sec", rate: 3000000},
+			{name: "recv_packet", properties: "rate", unit: "per_sec", rate: 4000},
+			{name: "sent_packet", properties: "rate", unit: "per_sec", rate: 2500},
+			{name: "recv_errors", properties: "rate", unit: "per_sec", rate: 0.1},
+			{name: "sent_errors", properties: "rate", unit: "per_sec", rate: 0.1},
+		},
+	},
+	"workload": {
+		instances: workloadInstances,
+		counters:  workloadCounters,
+	},
+	"workload_volume": {
+		instances: workloadVolumeInstances,
+		counters:  workloadCounters,
+	},
+}
+
+var workloadCounters = []*perfCounter{
+	{name: "ops", properties: "rate", unit: "per_sec", rate: 50},
+	{name: "read_ops", properties: "rate", unit: "per_sec", rate: 30},
+	{name: "write_ops", properties: "rate", unit: "per_sec", rate: 20},
+	{name: "total_data", properties: "rate", unit: "b_per_sec", rate: 50 * 16384},
+	{name: "read_data", properties: "rate", unit: "b_per_sec", rate: 30 * 16384},
+	{name: "write_data", properties: "rate", unit: "b_per_sec", rate: 20 * 16384},
+	{name: "latency", properties: "average", unit: "microsec", base: "ops", rate: 50 * 500},
+	{name: "read_latency", properties: "average", unit: "microsec", base: "read_ops", rate: 30 * 400},
+	{name: "write_latency", properties: "average", unit: "microsec", base: "write_ops", rate: 20 * 650},
+}
+
+func nodeInstances(s *Simulator) []*perfInstance {
+	instances := make([]*perfInstance, 0, s.config.Nodes)
+	for i := 0; i < s.config.Nodes; i++ {
+		name := s.nodeName(i)
+		instances = append(instances, &perfInstance{
+			name:   name,
+			uuid:   name + ":kernel:" + name,
+			weight: 1,
+			labels: map[string]string{"node_name": name},
+		})
+	}
+	return instances
+}
+
+func volumeInstances(s *Simulator) []*perfInstance {
+	instances := make([]*perfInstance, 0, s.config.Volumes)
+	for i := 0; i < s.config.Volumes; i++ {
+		name := s.volumeName(i)
+		instances = append(instances, &perfInstance{
+			name:   name,
+			uuid:   uuid(4, i),
+			weight: float64(i%7 + 1),
+			labels: map[string]string{"instance_name": name, "vserver_name": s.vserverName(i), "node_name": s.nodeName(i)},
+		})
+	}
+	return instances
+}
+
+func lifInstances(s *Simulator) []*perfInstance {
+	n := s.config.Vservers * s.config.Nodes
+	instances := make([]*perfInstance, 0, n)
+	for i := 0; i < n; i++ {
+		vserver := s.vserverName(i / s.config.Nodes)
+		name := fmt.Sprintf("%s:lif%d", vserver, i%s.config.Nodes+1)
+		instances = append(instances, &perfInstance{
+			name:   name,
+			uuid:   fmt.Sprintf("%s:%d", s.nodeName(i), 1024+i),
+			weight: float64(i%3 + 1),
+			labels: map[string]string{"instance_name": name, "vserver_name": vserver, "node_name": s.nodeName(i)},
+		})
+	}
+	return instances
+}
+
+func workloadInstances(s *Simulator) []*perfInstance {
+	instances := make([]*perfInstance, 0, s.config.Workloads)
+	for i := 0; i < s.config.Workloads; i++ {
+		instances = append(instances, &perfInstance{name: s.workloadName(i), uuid: uuid(5, i), weight: float64(i%11 + 1)})
+	}
+	return instances
+}
+
+func workloadVolumeInstances(s *Simulator) []*perfInstance {
+	instances := make([]*perfInstance, 0, s.config.Volumes)
+	for i := 0; i < s.config.Volumes; i++ {
+		name := s.volumeName(i) + "-wid" + strconv.Itoa(i+1000)
+		instances = append(instances, &perfInstance{name: name, uuid: uuid(6, i), weight: float64(i%7 + 1)})
+	}
+	return instances
+}
+
+func getPerfObject(request *node.Node) (*perfObject, error) {
+	name := request.GetChildContentS("objectname")
+	if o, ok := perfObjects[name]; ok {
+		return o, nil
+	}
+	return nil, &zapiError{reason: fmt.Sprintf("Object \"%s\" was not found.", name), errno: "13001"}
+}
+
+// value returns the current value of counter c of instance i
+func (s *Simulator) value(c *perfCounter, i *perfInstance, elapsed float64, tick uint64) string {
+	if c.properties == "string" {
+		return i.labels[c.name]
+	}
+	if c.properties == "raw" {
+		return strconv.FormatFloat(c.rate*i.weight, 'f', -1, 64)
+	}
+	if len(c.labels) != 0 {
+		values := make([]string, len(c.labels))
+		for j := range c.labels {
+			share := float64(len(c.labels)-j) / float64(len(c.labels))
+			values[j] = strconv.FormatUint(uint64(c.rate*i.weight*share*elapsed)+tick, 10)
+		}
+		return strings.Join(values, ",")
+	}
+	return strconv.FormatUint(uint64(c.rate*i.weight*elapsed)+tick, 10)
+}
+
+func perfObjectCounterListInfo(_ *Simulator, request *node.Node) (*node.Node, error) {
+	object, err := getPerfObject(request)
+	if err != nil {
+		return nil, err
+	}
+	results := node.NewXmlS("results")
+	counters := results.NewChildS("counters", "")
+	for _, c := range object.counters {
+		info := counters.NewChildS("counter-info", "")
+		info.NewChildS("name", c.name)
+		info.NewChildS("desc", "simulated counter")
+		info.NewChildS("privilege-level", "basic")
+		info.NewChildS("properties", c.properties)
+		info.NewChildS("unit", c.unit)
+		if c.base != "" {
+			info.NewChildS("base-counter", c.base)
+		}
+		if len(c.labels) != 0 {
+			info.NewChildS("type", "array")
+			info.NewChildS("labels", "").NewChildS("label-info", strings.Join(c.labels, ","))
+		}
+	}
+	return results, nil
+}
+
+func perfObjectInstanceListInfoIter(s *Simulator, request *node.Node) (*node.Node, error) {
+	object, err := getPerfObject(request)
+	if err != nil {
+		return nil, err
+	}
+	instances := object.instances(s)
+	start, end, next, err := page(request, len(instances))
+	if err != nil {
+		return nil, err
+	}
+	results := node.NewXmlS("results")
+	if end > start {
+		list := results.NewChildS("attributes-list", "")
+		for _, i := range instances[start:end] {
+			info := list.NewChildS("instance-info", "")
+			info.NewChildS("name", i.name)
+			info.NewChildS("uuid", i.uuid)
+		}
+	}
+	results.NewChildS("num-records", strconv.Itoa(end-start))
+	if next != "" {
+		results.NewChildS("next-tag", next)
+	}
+	return results, nil
+}
+
+// perfObjectInstanceListInfo is the 7-mode flavor of the instance list API, without paging
+func perfObjectInstanceListInfo(s *Simulator, request *node.Node) (*node.Node, error) {
+	object, err := getPerfObject(request)
+	if err != nil {
+		return nil, err
+	}
+	results := node.NewXmlS("results")
+	list := results.NewChildS("instances", "")
+	for _, i := range object.instances(s) {
+		list.NewChildS("instance-info", "").NewChildS("name", i.name)
+	}
+	return results, nil
+}
+
+func perfObjectGetInstances(s *Simulator, request *node.Node) (*node.Node, error) {
+	object, err := getPerfObject(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// instances are requested either by uuid or by name, otherwise all are returned
+	var (
+		keys   []string
+		byName bool
+	)
+	if x := request.GetChildS("instance-uuids"); x != nil {
+		keys = x.GetAllChildContentS()
+	} else if x := request.GetChildS("instances"); x != nil {
+		keys = x.GetAllChildContentS()
+		byName = true
+	}
+
+	if s.config.Perf.MaxInstances != 0 && len(keys) > s.config.Perf.MaxInstances {
+		return nil, resourceLimit("")
+	}
+
+	all := object.instances(s)
+	selected := all
+	if keys != nil {
+		index := make(map[string]*perfInstance, len(all))
+		for _, i := range all {
+			if byName {
+				index[i.name] = i
+			} else {
+				index[i.uuid] = i
+			}
+		}
+		selected = make([]*perfInstance, 0, len(keys))
+		for _, k := range keys {
+			if i, ok := index[k]; ok {
+				selected = append(selected, i)
+			}
+		}
+	}
+
+	counters := object.counters
+	if x := request.GetChildS("counters"); x != nil {
+		counters = make([]*perfCounter, 0)
+		for _, name := range x.GetAllChildContentS() {
+			if c := object.counter(name); c != nil {
+				counters = append(counters, c)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.ticks++
+	tick := s.ticks
+	s.mu.Unlock()
+
+	now := s.now()
+	elapsed := now.Sub(s.start).Seconds() + uptime
+
+	results := node.NewXmlS("results")
+	list := results.NewChildS("instances", "")
+	for _, i := range selected {
+		data := list.NewChildS("instance-data", "")
+		data.NewChildS("name", i.name)
+		data.NewChildS("uuid", i.uuid)
+		values := data.NewChildS("counters", "")
+		for _, c := range counters {
+			cd := values.NewChildS("counter-data", "")
+			cd.NewChildS("name", c.name)
+			cd.NewChildS("value", s.value(c, i, elapsed, tick))
+		}
+	}
+	results.NewChildS("timestamp", strconv.FormatInt(now.Unix(), 10))
+	return results, nil
+}
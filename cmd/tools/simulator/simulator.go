@@ -0,0 +1,293 @@
+/*
+Copyright NetApp Inc, 2021 All rights reserved
+
+Package simulator implements a small ONTAP ZAPI server that can be used to
+test pollers end to end without a real cluster.
+
+The simulator answers the system identity APIs, a number of *-get-iter
+APIs (with max-records/tag paging) and the perf APIs used by ZapiPerf.
+Perf counters increase monotonically with time, so the delta calculations
+of ZapiPerf yield stable rates. Size of the cluster and faults (failed
+ZAPIs, resource limits, timeouts, HTTP errors) are configured in YAML,
+see simulator.yml for an example.
+*/
+package simulator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"goharvest2/pkg/logging"
+	"goharvest2/pkg/tree"
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ApiPath is the URL path where ONTAP serves ZAPI requests
+const ApiPath = "/servlets/netapp.servlets.admin.XMLrequest_filer"
+
+// zapiError is returned by API handlers when the ZAPI request should fail
+type zapiError struct {
+	reason string
+	errno  string
+}
+
+func (e *zapiError) Error() string {
+	return e.reason
+}
+
+type handler func(*Simulator, *node.Node) (*node.Node, error)
+
+// Simulator serves ZAPI requests from a simulated cluster
+type Simulator struct {
+	config   *Config
+	Logger   *logging.Logger
+	start    time.Time
+	now      func() time.Time
+	mu       sync.Mutex
+	ticks    uint64      // incremented with each perf request, keeps counters strictly increasing
+	calls    map[int]int // number of calls that matched a fault
+	injected map[int]int // number of times a fault was injected
+	handlers map[string]handler
+}
+
+// New creates a simulator from config
+func New(config *Config) (*Simulator, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	s := &Simulator{
+		config:   config,
+		Logger:   logging.SubLogger("Simulator", config.Cluster),
+		now:      time.Now,
+		calls:    make(map[int]int),
+		injected: make(map[int]int),
+	}
+	s.start = s.now()
+	s.handlers = map[string]handler{
+		"system-get-version":                  systemGetVersion,
+		"system-get-info":                     systemGetInfo,
+		"cluster-identity-get":                clusterIdentityGet,
+		"perf-object-counter-list-info":       perfObjectCounterListInfo,
+		"perf-object-instance-list-info-iter": perfObjectInstanceListInfoIter,
+		"perf-object-instance-list-info":      perfObjectInstanceListInfo,
+		"perf-object-get-instances":           perfObjectGetInstances,
+	}
+	for api := range iterApis {
+		s.handlers[api] = getIter
+	}
+	return s, nil
+}
+
+// ListenAndServe serves HTTPS on the configured address until it fails
+func (s *Simulator) ListenAndServe() error {
+	var (
+		cert tls.Certificate
+		err  error
+	)
+
+	if s.config.SslCert != "" {
+		cert, err = tls.LoadX509KeyPair(s.config.SslCert, s.config.SslKey)
+	} else {
+		s.Logger.Info().Msg("no ssl_cert configured, using self-signed certificate")
+		cert, err = selfSignedCert(s.config.Cluster)
+	}
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      s.config.Listen,
+		Handler:   s,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	s.Logger.Info().Msgf("simulating cluster [%s] on [https://%s%s]", s.config.Cluster, s.config.Listen, ApiPath)
+	return server.ListenAndServeTLS("", "")
+}
+
+// ServeHTTP handles a single ZAPI request
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path != ApiPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.config.Username != "" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != s.config.Username || pass != s.config.Password {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	root, err := tree.LoadXml(body)
+	if err != nil || len(root.GetChildren()) == 0 {
+		http.Error(w, "invalid ZAPI request", http.StatusBadRequest)
+		return
+	}
+
+	request := root.GetChildren()[0]
+	api := request.GetNameS()
+	s.Logger.Debug().Msgf("request [%s]", api)
+
+	if s.config.latency != 0 && !s.sleep(r, s.config.latency) {
+		return
+	}
+
+	if f := s.fault(api, request.GetChildContentS("objectname")); f != nil {
+		s.Logger.Info().Msgf("injecting fault [%s] into [%s]", f.Kind, api)
+		switch f.Kind {
+		case "auth":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		case "http":
+			status := f.Status
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		case "timeout":
+			if !s.sleep(r, f.delay) {
+				return
+			}
+		case "resource_limit":
+			s.reply(w, failed(resourceLimit(f.Errno)))
+			return
+		case "zapi":
+			reason := f.Reason
+			if reason == "" {
+				reason = "simulated failure"
+			}
+			s.reply(w, failed(&zapiError{reason: reason, errno: f.Errno}))
+			return
+		}
+	}
+
+	var results *node.Node
+
+	if h, ok := s.handlers[api]; !ok {
+		results = failed(&zapiError{reason: "Unable to find API: " + api, errno: "13005"})
+	} else if results, err = h(s, request); err != nil {
+		ze, ok := err.(*zapiError)
+		if !ok {
+			ze = &zapiError{reason: err.Error(), errno: "13001"}
+		}
+		results = failed(ze)
+	} else {
+		results.NewAttrS("status", "passed")
+	}
+	s.reply(w, results)
+}
+
+// sleep holds back the response for d, returns false if the client has gone away
+func (s *Simulator) sleep(r *http.Request, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// fault returns the first fault that should be injected into this call of api, if any
+func (s *Simulator) fault(api, object string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.config.Errors {
+		f := &s.config.Errors[i]
+		if f.Api != api && f.Api != "*" {
+			continue
+		}
+		if f.Object != "" && f.Object != object {
+			continue
+		}
+		s.calls[i]++
+		n := s.calls[i] - f.After
+		if n <= 0 || n%f.Every != 0 {
+			continue
+		}
+		if f.Count != 0 && s.injected[i] >= f.Count {
+			continue
+		}
+		s.injected[i]++
+		return f
+	}
+	return nil
+}
+
+func (s *Simulator) reply(w http.ResponseWriter, results *node.Node) {
+	root := node.NewXmlS("netapp")
+	root.NewAttrS("xmlns", "http://www.netapp.com/filer/admin")
+	root.NewAttrS("version", "1.180")
+	root.AddChild(results)
+
+	data, err := tree.DumpXml(root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, _ = w.Write(data)
+}
+
+func failed(e *zapiError) *node.Node {
+	results := node.NewXmlS("results")
+	results.NewAttrS("status", "failed")
+	results.NewAttrS("reason", e.reason)
+	if e.errno != "" {
+		results.NewAttrS("errno", e.errno)
+	}
+	return results
+}
+
+func resourceLimit(errno string) *zapiError {
+	if errno == "" {
+		errno = "13001"
+	}
+	return &zapiError{reason: "resource limit exceeded", errno: errno}
+}
+
+func selfSignedCert(name string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost", name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
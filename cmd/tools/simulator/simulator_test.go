@@ -0,0 +1,189 @@
+package simulator
+
+import (
+	"goharvest2/pkg/tree"
+	"goharvest2/pkg/tree/node"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func invoke(t *testing.T, s *Simulator, request *node.Node) (*node.Node, int) {
+	t.Helper()
+	root := node.NewXmlS("netapp")
+	root.AddChild(request)
+	body, err := tree.DumpXml(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, ApiPath, strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		return nil, w.Code
+	}
+	response, err := tree.LoadXml(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.GetChildS("results"), w.Code
+}
+
+func newSimulator(t *testing.T, config *Config) *Simulator {
+	t.Helper()
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPaging(t *testing.T) {
+	config := DefaultConfig()
+	config.Volumes = 25
+	s := newSimulator(t, config)
+
+	type test struct {
+		name       string
+		maxRecords string
+		wantPages  int
+	}
+
+	tests := []test{
+		{"all_records", "", 1},
+		{"exact_pages", "5", 5},
+		{"partial_last_page", "10", 3},
+		{"one_page", "100", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				tag   string
+				pages int
+				names []string
+			)
+			for {
+				request := node.NewXmlS("volume-get-iter")
+				if tt.maxRecords != "" {
+					request.NewChildS("max-records", tt.maxRecords)
+				}
+				if tag != "" {
+					request.NewChildS("tag", tag)
+				}
+				results, _ := invoke(t, s, request)
+				if status, _ := results.GetAttrValueS("status"); status != "passed" {
+					reason, _ := results.GetAttrValueS("reason")
+					t.Fatalf("status = %s, reason = %s", status, reason)
+				}
+				pages++
+				if list := results.GetChildS("attributes-list"); list != nil {
+					for _, v := range list.GetChildren() {
+						names = append(names, v.GetChildS("volume-id-attributes").GetChildContentS("name"))
+					}
+				}
+				if tag = results.GetChildContentS("next-tag"); tag == "" {
+					break
+				}
+			}
+			if pages != tt.wantPages {
+				t.Errorf("pages = %d, want %d", pages, tt.wantPages)
+			}
+			if len(names) != config.Volumes {
+				t.Errorf("records = %d, want %d", len(names), config.Volumes)
+			}
+			if names[len(names)-1] != "vol24" {
+				t.Errorf("last record = %s, want vol24", names[len(names)-1])
+			}
+		})
+	}
+}
+
+func TestCountersIncrease(t *testing.T) {
+	s := newSimulator(t, DefaultConfig())
+
+	poll := func() map[string]uint64 {
+		request := node.NewXmlS("perf-object-get-instances")
+		request.NewChildS("objectname", "volume")
+		counters := request.NewChildS("counters", "")
+		counters.NewChildS("counter", "read_ops")
+		counters.NewChildS("counter", "read_latency")
+		results, _ := invoke(t, s, request)
+		values := make(map[string]uint64)
+		for _, i := range results.GetChildS("instances").GetChildren() {
+			for _, c := range i.GetChildS("counters").GetChildren() {
+				v, err := strconv.ParseUint(c.GetChildContentS("value"), 10, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				values[i.GetChildContentS("uuid")+"."+c.GetChildContentS("name")] = v
+			}
+		}
+		return values
+	}
+
+	first := poll()
+	if len(first) != 2*DefaultConfig().Volumes {
+		t.Fatalf("values = %d, want %d", len(first), 2*DefaultConfig().Volumes)
+	}
+	second := poll()
+	for k, v := range first {
+		if second[k] <= v {
+			t.Errorf("%s did not increase: %d => %d", k, v, second[k])
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	config := DefaultConfig()
+	config.Perf.MaxInstances = 5
+	config.Errors = []Fault{
+		{Api: "system-get-version", Kind: "zapi", Reason: "boom", Errno: "13001", After: 1, Count: 1},
+		{Api: "aggr-get-iter", Kind: "http", Status: http.StatusServiceUnavailable},
+	}
+	s := newSimulator(t, config)
+
+	type test struct {
+		name       string
+		request    *node.Node
+		wantCode   int
+		wantStatus string
+		wantReason string
+	}
+
+	perfRequest := node.NewXmlS("perf-object-get-instances")
+	perfRequest.NewChildS("objectname", "volume")
+	uuids := perfRequest.NewChildS("instance-uuids", "")
+	for i := 0; i < 6; i++ {
+		uuids.NewChildS("instance-uuid", uuid(4, i))
+	}
+
+	tests := []test{
+		{"before_fault", node.NewXmlS("system-get-version"), http.StatusOK, "passed", ""},
+		{"zapi_fault", node.NewXmlS("system-get-version"), http.StatusOK, "failed", "boom"},
+		{"fault_count_reached", node.NewXmlS("system-get-version"), http.StatusOK, "passed", ""},
+		{"http_fault", node.NewXmlS("aggr-get-iter"), http.StatusServiceUnavailable, "", ""},
+		{"resource_limit", perfRequest, http.StatusOK, "failed", "resource limit exceeded"},
+		{"unknown_api", node.NewXmlS("foo-get-iter"), http.StatusOK, "failed", "Unable to find API: foo-get-iter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, code := invoke(t, s, tt.request)
+			if code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", code, tt.wantCode)
+			}
+			if results == nil {
+				return
+			}
+			if status, _ := results.GetAttrValueS("status"); status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", status, tt.wantStatus)
+			}
+			if reason, _ := results.GetAttrValueS("reason"); reason != tt.wantReason {
+				t.Errorf("reason = %s, want %s", reason, tt.wantReason)
+			}
+		})
+	}
+}
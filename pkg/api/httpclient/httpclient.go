@@ -0,0 +1,64 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package httpclient creates the HTTP clients of collectors that poll HTTP
+// APIs from the parameters of the poller and template.
+package httpclient
+
+import (
+	"crypto/tls"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/tree/node"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultTimeout - client timeout in seconds, if client_timeout is not set
+const DefaultTimeout = 10
+
+// New - create client from parameters: use_insecure_tls and client_timeout.
+// tlsConfig can be nil, otherwise it is used with InsecureSkipVerify set
+// from use_insecure_tls, e.g. to authenticate with a certificate.
+func New(config *node.Node, tlsConfig *tls.Config) (*http.Client, error) {
+
+	var err error
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if x := config.GetChildContentS("use_insecure_tls"); x != "" {
+		if tlsConfig.InsecureSkipVerify, err = strconv.ParseBool(x); err != nil {
+			return nil, errors.New(errors.INVALID_PARAM, "use_insecure_tls: "+x)
+		}
+	}
+
+	timeout := DefaultTimeout
+	if x := config.GetChildContentS("client_timeout"); x != "" {
+		if timeout, err = strconv.Atoi(x); err != nil || timeout <= 0 {
+			return nil, errors.New(errors.INVALID_PARAM, "client_timeout: "+x)
+		}
+	}
+
+	return &http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// BaseURL - URL of the host from parameters: addr and scheme (https if not
+// set, tests use http), e.g. "https://cluster.example.com"
+func BaseURL(config *node.Node) (string, error) {
+
+	addr := config.GetChildContentS("addr")
+	if addr == "" {
+		return "", errors.New(errors.MISSING_PARAM, "addr")
+	}
+
+	scheme := config.GetChildContentS("scheme")
+	if scheme == "" {
+		scheme = "https"
+	}
+	return scheme + "://" + addr, nil
+}
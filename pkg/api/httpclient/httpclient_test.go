@@ -0,0 +1,77 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"goharvest2/pkg/tree/node"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		params   map[string]string
+		timeout  time.Duration
+		insecure bool
+		err      bool
+	}{
+		{"defaults", nil, DefaultTimeout * time.Second, false, false},
+		{"insecure", map[string]string{"use_insecure_tls": "true", "client_timeout": "30"}, 30 * time.Second, true, false},
+		{"invalid_tls", map[string]string{"use_insecure_tls": "yes please"}, 0, false, true},
+		{"invalid_timeout", map[string]string{"client_timeout": "10s"}, 0, false, true},
+		{"zero_timeout", map[string]string{"client_timeout": "0"}, 0, false, true},
+	}
+
+	for _, tt := range tests {
+		config := node.NewS("config")
+		for k, v := range tt.params {
+			config.NewChildS(k, v)
+		}
+		client, err := New(config, nil)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if client.Timeout != tt.timeout {
+			t.Errorf("%s: timeout = %v, want %v", tt.name, client.Timeout, tt.timeout)
+		}
+		if x := client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify; x != tt.insecure {
+			t.Errorf("%s: insecure = %v, want %v", tt.name, x, tt.insecure)
+		}
+	}
+
+	// certificates of the TLS config are kept
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{{}}}
+	client, err := New(node.NewS("config"), tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Transport.(*http.Transport).TLSClientConfig != tlsConfig {
+		t.Error("TLS config not used")
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+
+	config := node.NewS("config")
+	if _, err := BaseURL(config); err == nil {
+		t.Error("expected error for missing addr")
+	}
+
+	config.NewChildS("addr", "grid.example.com")
+	if x, _ := BaseURL(config); x != "https://grid.example.com" {
+		t.Errorf("got [%s], want [https://grid.example.com]", x)
+	}
+
+	config.NewChildS("scheme", "http")
+	if x, _ := BaseURL(config); x != "http://grid.example.com" {
+		t.Errorf("got [%s], want [http://grid.example.com]", x)
+	}
+}
@@ -2,11 +2,19 @@
 
 // Package zapi provides type Client for connecting to a C-dot or 7-mode
 // ONTAP cluster and sending API requests using the ZAPI protocol.
+//
+// The methods that take a context (InvokeContext, InvokeContextWithTimers,
+// InvokeBatchContext and their streaming variants InvokeStream and
+// InvokeBatchStream) build their own HTTP request and are safe for concurrent
+// use. The other Invoke methods send the request built with BuildRequest and
+// should only be used by one goroutine.
 package zapi
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/xml"
 	"fmt"
 	"goharvest2/pkg/errors"
 	"goharvest2/pkg/logging"
@@ -20,28 +28,32 @@ import (
 )
 
 const (
-	DefaultApiVersion = "1.3"
-	DefaultTimeout    = 10
+	DefaultApiVersion   = "1.3"
+	DefaultTimeout      = 10
+	DefaultRetryBackoff = time.Second
 )
 
 type Client struct {
 	client     *http.Client
-	request    *http.Request
-	buffer     *bytes.Buffer
+	request    *http.Request // template of requests: URL, headers and credentials
+	body       []byte        // body of request built with BuildRequest
+	retry      bool          // request built with BuildRequest can be retried
+	timeout    time.Duration // default timeout of requests
+	retries    int           // max retries of idempotent requests
+	backoff    time.Duration // wait before first retry, doubled for every retry
 	system     *system
 	apiVersion string
 	vfiler     string
 	Logger     *logging.Logger // logger used for logging
+	conn       *connection     // nil, unless client is shared
 }
 
 func New(config *node.Node) (*Client, error) {
 	var (
 		client         Client
-		httpclient     *http.Client
 		request        *http.Request
 		transport      *http.Transport
 		cert           tls.Certificate
-		timeout        time.Duration
 		url, addr      string
 		useInsecureTLS bool
 		err            error
@@ -69,7 +81,7 @@ func New(config *node.Node) (*Client, error) {
 	} else {
 		url = "https://" + addr + ":443/servlets/netapp.servlets.admin.XMLrequest_filer"
 	}
-	// create a request object that will be used for later requests
+	// create a request object that will be copied for later requests
 	if request, err = http.NewRequest("POST", url, nil); err != nil {
 		return nil, err
 	}
@@ -86,6 +98,22 @@ func New(config *node.Node) (*Client, error) {
 		useInsecureTLS = false
 	}
 
+	tlsConfig := &tls.Config{InsecureSkipVerify: useInsecureTLS}
+
+	// trust certificates of a custom CA, instead of the system CAs
+	if x := config.GetChildContentS("ca_cert"); x != "" {
+		if tlsConfig.RootCAs, err = LoadCACert(x); err != nil {
+			return nil, err
+		}
+	}
+	if x := config.GetChildContentS("tls_min_version"); x != "" {
+		if tlsConfig.MinVersion, err = ParseTLSVersion(x); err != nil {
+			return nil, err
+		}
+	}
+	// name to verify the server certificate with, if it doesn't match addr
+	tlsConfig.ServerName = config.GetChildContentS("server_name")
+
 	// set authentication method
 	if config.GetChildContentS("auth_style") == "certificate_auth" {
 
@@ -100,11 +128,7 @@ func New(config *node.Node) (*Client, error) {
 			return nil, err
 		}
 
-		transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				Certificates:       []tls.Certificate{cert},
-				InsecureSkipVerify: useInsecureTLS},
-		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	} else {
 
 		username := config.GetChildContentS("username")
@@ -117,36 +141,59 @@ func New(config *node.Node) (*Client, error) {
 		}
 
 		request.SetBasicAuth(username, password)
-		transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: useInsecureTLS}}
 	}
+	transport = &http.Transport{TLSClientConfig: tlsConfig}
+
+	if x := config.GetChildContentS("proxy_url"); x != "" {
+		proxy, err := ParseProxyURL(x)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+		client.Logger.Debug().Msgf("using proxy [%s]", proxy.Redacted())
+	}
+
+	// retry requests that only read data, if they fail because of connection errors
+	if x := config.GetChildContentS("retries"); x != "" {
+		if client.retries, err = strconv.Atoi(x); err != nil || client.retries < 0 {
+			return nil, errors.New(errors.INVALID_PARAM, "retries: "+x)
+		}
+	}
+	client.backoff = DefaultRetryBackoff
+	if x := config.GetChildContentS("retry_backoff"); x != "" {
+		if client.backoff, err = time.ParseDuration(x); err != nil || client.backoff <= 0 {
+			return nil, errors.New(errors.INVALID_PARAM, "retry_backoff: "+x)
+		}
+	}
+
 	client.request = request
 
-	// initialize http client
+	// default timeout of requests, unless the context of a request has a deadline
 	if t, err := strconv.Atoi(config.GetChildContentS("client_timeout")); err == nil {
-		timeout = time.Duration(t) * time.Second
+		client.timeout = time.Duration(t) * time.Second
 		client.Logger.Debug().Msgf("using timeout [%d] s", t)
 	} else {
-		// default timeout
-		timeout = time.Duration(DefaultTimeout) * time.Second
+		client.timeout = time.Duration(DefaultTimeout) * time.Second
 		client.Logger.Debug().Msgf("using default timeout [%d] s", DefaultTimeout)
 	}
 
-	httpclient = &http.Client{Transport: transport, Timeout: timeout}
-
-	client.client = httpclient
-
-	// ensure that we can change body dynamically
-	request.GetBody = func() (io.ReadCloser, error) {
-		r := bytes.NewReader(client.buffer.Bytes())
-		return ioutil.NopCloser(r), nil
-	}
+	// initialize http client, timeouts are set per request
+	client.client = &http.Client{Transport: transport}
 
 	return &client, nil
 }
 
 // Init connects to the cluster and retrieves system info
-// it will give up after retries
+// it will give up after retries. Shared clients only connect
+// if no other client has retrieved system info yet.
 func (c *Client) Init(retries int) error {
+	if c.conn != nil {
+		return c.conn.init(c, retries)
+	}
+	return c.fetchSystem(retries)
+}
+
+func (c *Client) fetchSystem(retries int) error {
 	var err error
 	for i := 0; i < retries; i++ {
 		if err = c.getSystem(); err == nil {
@@ -212,14 +259,19 @@ func (my *Client) buildRequestString(request string, forceCluster bool) error {
 
 // build API request from the given node object.
 func (c *Client) buildRequest(query *node.Node, forceCluster bool) error {
-	var (
-		request *node.Node
-		buffer  *bytes.Buffer
-		data    []byte
-		err     error
-	)
+	data, err := c.buildBody(query, forceCluster)
+	if err != nil {
+		return err
+	}
+	c.body = data
+	c.retry = isIdempotent(query.GetNameS())
+	return nil
+}
 
-	request = node.NewXmlS("netapp")
+// build XML body of an API request from the given node object
+func (c *Client) buildBody(query *node.Node, forceCluster bool) ([]byte, error) {
+
+	request := node.NewXmlS("netapp")
 	request.NewAttrS("xmlns", "http://www.netapp.com/filer/admin")
 	request.NewAttrS("version", c.apiVersion)
 	// optionally use fviler-tunneling, this option is never used in Harvest
@@ -228,15 +280,7 @@ func (c *Client) buildRequest(query *node.Node, forceCluster bool) error {
 	}
 	request.AddChild(query)
 
-	if data, err = tree.DumpXml(request); err != nil {
-		return err
-	}
-
-	buffer = bytes.NewBuffer(data)
-	c.buffer = buffer
-	c.request.Body = ioutil.NopCloser(buffer)
-	c.request.ContentLength = int64(buffer.Len())
-	return nil
+	return tree.DumpXml(request)
 }
 
 // Invoke will issue the API request and return server response
@@ -250,36 +294,54 @@ func (c *Client) Invoke() (*node.Node, error) {
 // are no more instances returned by the server, returned results will be nil
 // Use the returned tag for subsequent calls to this method
 func (c *Client) InvokeBatchRequest(request *node.Node, tag string) (*node.Node, string, error) {
-	// wasteful of course, need to rewrite later @TODO
-	results, tag, _, _, err := c.InvokeBatchWithTimers(request, tag)
+	results, tag, _, _, _, err := c.InvokeBatchContext(context.Background(), request, tag)
 	return results, tag, err
 }
 
 // InvokeBatchWithTimers does the same as InvokeBatchRequest, but it also
 // returns API time and XML parse time
 func (c *Client) InvokeBatchWithTimers(request *node.Node, tag string) (*node.Node, string, time.Duration, time.Duration, error) {
+	results, tag, _, rd, pd, err := c.InvokeBatchContext(context.Background(), request, tag)
+	return results, tag, rd, pd, err
+}
+
+// InvokeBatchContext issues the batch request with tag, like InvokeBatchRequest, and returns
+// results, the tag of the next batch, size of the response in bytes, API time and XML parse time.
+// The request is aborted when ctx is done.
+func (c *Client) InvokeBatchContext(ctx context.Context, request *node.Node, tag string) (*node.Node, string, int, time.Duration, time.Duration, error) {
+	return c.invokeBatch(request, tag, func() (*node.Node, int, time.Duration, time.Duration, error) {
+		return c.InvokeContextWithTimers(ctx, request)
+	})
+}
+
+// InvokeBatchStream does the same as InvokeBatchContext, but streams the elements
+// of collection to fn, see InvokeStream
+func (c *Client) InvokeBatchStream(ctx context.Context, request *node.Node, tag, collection string, fn func(*node.Node) error) (*node.Node, string, int, time.Duration, time.Duration, error) {
+	return c.invokeBatch(request, tag, func() (*node.Node, int, time.Duration, time.Duration, error) {
+		return c.InvokeStream(ctx, request, collection, fn)
+	})
+}
+
+func (c *Client) invokeBatch(request *node.Node, tag string, invoke func() (*node.Node, int, time.Duration, time.Duration, error)) (*node.Node, string, int, time.Duration, time.Duration, error) {
 
 	var (
 		results *node.Node
 		nextTag string
+		length  int
 		rd, pd  time.Duration // response time, parse time
 		err     error
 	)
 
 	if tag == "" {
-		return nil, "", rd, pd, nil
+		return nil, "", length, rd, pd, nil
 	}
 
 	if tag != "initial" {
 		request.SetChildContentS("tag", tag)
 	}
 
-	if err = c.BuildRequest(request); err != nil {
-		return nil, "", rd, pd, err
-	}
-
-	if results, rd, pd, err = c.invoke(true); err != nil {
-		return nil, "", rd, pd, err
+	if results, length, rd, pd, err = invoke(); err != nil {
+		return nil, "", length, rd, pd, err
 	}
 
 	// avoid ZAPI bug, see:
@@ -288,26 +350,17 @@ func (c *Client) InvokeBatchWithTimers(request *node.Node, tag string) (*node.No
 		nextTag = ""
 	}
 
-	return results, nextTag, rd, pd, nil
+	return results, nextTag, length, rd, pd, nil
 }
 
 // InvokeRequestString builds a request from request and invokes it
 func (me *Client) InvokeRequestString(request string) (*node.Node, error) {
-	if err := me.BuildRequestString(request); err != nil {
-		return nil, err
-	}
-	return me.Invoke()
+	return me.InvokeContext(context.Background(), node.NewXmlS(request))
 }
 
 // InvokeRequest builds a request from request and invokes it
 func (c *Client) InvokeRequest(request *node.Node) (*node.Node, error) {
-
-	var err error
-
-	if err = c.BuildRequest(request); err == nil {
-		return c.Invoke()
-	}
-	return nil, err
+	return c.InvokeContext(context.Background(), request)
 }
 
 // InvokeWithTimers invokes the request and returns parsed XML response and timers:
@@ -317,93 +370,254 @@ func (c *Client) InvokeWithTimers() (*node.Node, time.Duration, time.Duration, e
 	return c.invoke(true)
 }
 
-// InvokeRaw invokes the request and returns the raw server response
-// This method should only be called after building the request
-func (c *Client) InvokeRaw() ([]byte, error) {
+// InvokeContext builds a request from query and invokes it. The request is aborted
+// when ctx is done. If ctx has no deadline, the client timeout is used.
+func (c *Client) InvokeContext(ctx context.Context, query *node.Node) (*node.Node, error) {
+	result, _, _, _, err := c.invokeContext(ctx, query, false)
+	return result, err
+}
+
+// InvokeContextWithTimers does the same as InvokeContext, but also returns the size
+// of the response in bytes, API time and XML parse time
+func (c *Client) InvokeContextWithTimers(ctx context.Context, query *node.Node) (*node.Node, int, time.Duration, time.Duration, error) {
+	return c.invokeContext(ctx, query, true)
+}
+
+// InvokeStream invokes the request like InvokeContextWithTimers, but decodes the response
+// while it is received, instead of loading it into memory first. Each child element of
+// results/collection (e.g. the "instances" of perf-object-get-instances) is passed to fn
+// and is not included in the returned results. API time only covers the time until the
+// response header is received, parse time includes reading the body and calling fn.
+// If fn returns an error, the request is aborted and the error is returned as is.
+func (c *Client) InvokeStream(ctx context.Context, query *node.Node, collection string, fn func(*node.Node) error) (*node.Node, int, time.Duration, time.Duration, error) {
+
 	var (
-		response *http.Response
-		body     []byte
-		err      error
+		root              *node.Node
+		length            countingReader
+		responseT, parseT time.Duration
+		fnErr             error
 	)
 
-	if response, err = c.client.Do(c.request); err != nil {
-		return body, errors.New(errors.ERR_CONNECTION, err.Error())
+	data, err := c.buildBody(query, false)
+	if err != nil {
+		return nil, 0, 0, 0, err
 	}
 
-	if response.StatusCode != 200 {
-		return body, errors.New(errors.API_RESPONSE, response.Status)
+	path := []string{"netapp", "results", collection}
+	stream := func(instance *node.Node) error {
+		fnErr = fn(instance)
+		return fnErr
 	}
 
-	return ioutil.ReadAll(response.Body)
+	responseT, parseT, err = c.receive(ctx, data, isIdempotent(query.GetNameS()), func(body io.Reader) error {
+		var err error
+		length.r = body
+		if root, err = tree.StreamXml(&length, path, stream); err != nil {
+			if fnErr != nil {
+				return fnErr
+			}
+			if _, ok := err.(*xml.SyntaxError); ok {
+				return &Error{Class: errors.API_RESPONSE, StatusCode: http.StatusOK, Reason: "parse response", Err: err}
+			}
+			return &Error{Class: errors.ERR_CONNECTION, StatusCode: http.StatusOK, Reason: "read response", Err: err}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, length.n, responseT, parseT, err
+	}
+
+	result, err := checkResults(root)
+	return result, length.n, responseT, parseT, err
+}
+
+// countingReader - counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+func (c *Client) invokeContext(ctx context.Context, query *node.Node, withTimers bool) (*node.Node, int, time.Duration, time.Duration, error) {
+	data, err := c.buildBody(query, false)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return c.send(ctx, data, isIdempotent(query.GetNameS()), withTimers)
+}
+
+// InvokeRaw invokes the request and returns the raw server response
+// This method should only be called after building the request
+func (c *Client) InvokeRaw() ([]byte, error) {
+	body, _, err := c.receiveAll(context.Background(), c.body, c.retry)
+	return body, err
 }
 
 // invokes the request that has been built with one of the BuildRequest* methods
 func (c *Client) invoke(withTimers bool) (*node.Node, time.Duration, time.Duration, error) {
+	result, _, responseT, parseT, err := c.send(context.Background(), c.body, c.retry, withTimers)
+	return result, responseT, parseT, err
+}
+
+// send request with body to server, parse the response and check its status,
+// returns response, its size in bytes and timers
+func (c *Client) send(ctx context.Context, data []byte, retry, withTimers bool) (*node.Node, int, time.Duration, time.Duration, error) {
 
 	var (
 		root, result      *node.Node
-		response          *http.Response
 		start             time.Time
 		responseT, parseT time.Duration
+		length            int
 		body              []byte
-		status, reason    string
-		found             bool
 		err               error
 	)
 
-	defer c.request.Body.Close()
-	defer c.buffer.Reset()
-
 	// issue request to server
+	if body, responseT, err = c.receiveAll(ctx, data, retry); err != nil {
+		return result, length, responseT, parseT, err
+	}
+	length = len(body)
+
+	// parse xml
 	if withTimers {
 		start = time.Now()
 	}
-	if response, err = c.client.Do(c.request); err != nil {
-		return result, responseT, parseT, errors.New(errors.ERR_CONNECTION, err.Error())
+	if root, err = tree.LoadXml(body); err != nil {
+		return result, length, responseT, parseT, &Error{Class: errors.API_RESPONSE, StatusCode: http.StatusOK, Reason: "parse response", Err: err}
 	}
 	if withTimers {
-		responseT = time.Since(start)
+		parseT = time.Since(start)
 	}
 
-	if response.StatusCode != 200 {
-		return result, responseT, parseT, errors.New(errors.API_RESPONSE, response.Status)
+	result, err = checkResults(root)
+	return result, length, responseT, parseT, err
+}
+
+// checkResults returns the "results" element of the response, or error if request failed
+func checkResults(root *node.Node) (*node.Node, error) {
+
+	result := root.GetChildS("results")
+	if result == nil {
+		return nil, &Error{Class: errors.API_RESPONSE, StatusCode: http.StatusOK, Reason: "missing \"results\""}
 	}
 
-	// read response body
-	defer response.Body.Close()
+	status, found := result.GetAttrValueS("status")
+	if !found {
+		return result, &Error{Class: errors.API_RESPONSE, StatusCode: http.StatusOK, Reason: "missing status attribute"}
+	}
 
-	if body, err = ioutil.ReadAll(response.Body); err != nil {
-		return result, responseT, parseT, err
+	if status != "passed" {
+		err := &Error{Class: errors.API_REQ_REJECTED, StatusCode: http.StatusOK, Reason: "no reason"}
+		if reason, ok := result.GetAttrValueS("reason"); ok {
+			err.Reason = reason
+		}
+		if errno, ok := result.GetAttrValueS("errno"); ok {
+			err.Errno, _ = strconv.Atoi(errno)
+		}
+		return result, err
 	}
 
-	// parse xml
-	if withTimers {
-		start = time.Now()
+	return result, nil
+}
+
+// receiveAll sends request with body and returns the response body and response time,
+// which includes reading the body
+func (c *Client) receiveAll(ctx context.Context, data []byte, retry bool) ([]byte, time.Duration, error) {
+	var body []byte
+	headerT, readT, err := c.receive(ctx, data, retry, func(r io.Reader) error {
+		var err error
+		if body, err = ioutil.ReadAll(r); err != nil {
+			return &Error{Class: errors.ERR_CONNECTION, StatusCode: http.StatusOK, Reason: "read response", Err: err}
+		}
+		return nil
+	})
+	return body, headerT + readT, err
+}
+
+// receive sends request with body and passes the response body to read, returns the
+// time until the response header was received and the time read took. Shared clients
+// wait until the number of in-flight requests to the cluster is below the limit, waiting
+// is not included in the times. If retry is true, the request is sent again after a
+// backoff when it fails before a response is received or the server is unavailable
+// (HTTP status 502, 503 or 504), up to the configured number of retries.
+func (c *Client) receive(ctx context.Context, data []byte, retry bool, read func(io.Reader) error) (time.Duration, time.Duration, error) {
+
+	backoff := c.backoff
+
+	for i := 0; ; i++ {
+
+		headerT, readT, retryable, err := c.attempt(ctx, data, read)
+
+		if err == nil || !retry || !retryable || i >= c.retries || ctx.Err() != nil {
+			return headerT, readT, err
+		}
+
+		c.Logger.Debug().Msgf("retry %d/%d in %s: %v", i+1, c.retries, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return headerT, readT, &Error{Class: errors.ERR_CONNECTION, Reason: "waiting to retry", Err: ctx.Err()}
+		}
+		backoff *= 2
 	}
-	if root, err = tree.LoadXml(body); err != nil {
-		return result, responseT, parseT, err
+}
+
+// attempt - send request once, also returns if the error is worth retrying
+func (c *Client) attempt(ctx context.Context, data []byte, read func(io.Reader) error) (time.Duration, time.Duration, bool, error) {
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
 	}
-	if withTimers {
-		parseT = time.Since(start)
+
+	request := c.request.Clone(ctx)
+	request.Body = ioutil.NopCloser(bytes.NewReader(data))
+	request.ContentLength = int64(len(data))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
 	}
 
-	// check if request was successful
-	if result = root.GetChildS("results"); result == nil {
-		return result, responseT, parseT, errors.New(errors.API_RESPONSE, "missing \"results\"")
+	if err := c.conn.acquire(ctx); err != nil {
+		return 0, 0, false, &Error{Class: errors.ERR_CONNECTION, Reason: "waiting for connection", Err: err}
 	}
+	defer c.conn.release()
 
-	if status, found = result.GetAttrValueS("status"); !found {
-		return result, responseT, parseT, errors.New(errors.API_RESPONSE, "missing status attribute")
+	start := time.Now()
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		// report timeout or cancellation instead of the wrapped error of the HTTP client
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return time.Since(start), 0, true, &Error{Class: errors.ERR_CONNECTION, Reason: "send request", Err: err}
 	}
+	defer response.Body.Close()
 
-	if status != "passed" {
-		if reason, found = result.GetAttrValueS("reason"); !found {
-			err = errors.New(errors.API_REQ_REJECTED, "no reason")
-		} else {
-			err = errors.New(errors.API_REQ_REJECTED, reason)
+	headerT := time.Since(start)
+
+	if response.StatusCode != http.StatusOK {
+		switch response.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return headerT, 0, true, newHTTPError(response)
 		}
-		return result, responseT, parseT, err
+		return headerT, 0, false, newHTTPError(response)
 	}
 
-	return result, responseT, parseT, nil
+	// once reading started, the response might be partially consumed, so never retry
+	start = time.Now()
+	if err = read(response.Body); err != nil && ctx.Err() != nil {
+		// connection was closed, because request was canceled or timed out
+		if _, ok := AsError(err); ok {
+			err = &Error{Class: errors.ERR_CONNECTION, StatusCode: response.StatusCode, Reason: "read response", Err: ctx.Err()}
+		}
+	}
+	return headerT, time.Since(start), false, err
 }
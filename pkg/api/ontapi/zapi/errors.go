@@ -0,0 +1,73 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package zapi
+
+import (
+	"context"
+	"goharvest2/pkg/errors"
+	"net/http"
+	"strconv"
+)
+
+// Error - error of an API request. Class is one of the error classes of package
+// goharvest2/pkg/errors, so errors.IsErr and errors.GetClass work as for other errors:
+//   - errors.ERR_CONNECTION: request not sent or no response, including timeouts and cancellation
+//   - errors.API_AUTH: authentication failed (HTTP status 401 or 403)
+//   - errors.API_RESPONSE: unexpected HTTP status or invalid response
+//   - errors.API_REQ_REJECTED: ONTAP rejected the request, Errno is the ONTAP error number
+type Error struct {
+	Class      string
+	StatusCode int    // HTTP status, 0 if there was no response
+	Errno      int    // ONTAP errno, 0 if request was not rejected
+	Reason     string // reason of ONTAP or description of what failed
+	Err        error  // underlying error, e.g. of the HTTP client
+}
+
+func (e *Error) Error() string {
+	msg := e.Reason
+	if e.Errno != 0 {
+		msg += " (errno " + strconv.Itoa(e.Errno) + ")"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return e.Class + " => " + msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsCanceled returns true if the request was aborted, because its context was canceled
+func (e *Error) IsCanceled() bool {
+	return e.Err == context.Canceled
+}
+
+// IsTimeout returns true if no response was received before the deadline of the request
+func (e *Error) IsTimeout() bool {
+	return e.Err == context.DeadlineExceeded
+}
+
+func newHTTPError(response *http.Response) *Error {
+	class := errors.API_RESPONSE
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+		class = errors.API_AUTH
+	}
+	return &Error{Class: class, StatusCode: response.StatusCode, Reason: response.Status}
+}
+
+// AsError returns err as *Error, if it's an error of an API request
+func AsError(err error) (*Error, bool) {
+	e, ok := err.(*Error)
+	return e, ok
+}
+
+// Errno returns the ONTAP error number if err is a rejected API request, otherwise 0
+func Errno(err error) int {
+	if e, ok := AsError(err); ok {
+		return e.Errno
+	}
+	return 0
+}
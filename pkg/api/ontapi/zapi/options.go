@@ -0,0 +1,70 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package zapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"goharvest2/pkg/errors"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+)
+
+// tlsVersions - accepted values of tls_min_version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// APIs that only read data, so they can be safely retried
+var idempotentRegex = regexp.MustCompile(`-(get|list-info)(-|$)`)
+
+// LoadCACert loads the PEM encoded CA certificates of the file at path (parameter ca_cert)
+func LoadCACert(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(errors.INVALID_PARAM, "ca_cert: "+err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New(errors.INVALID_PARAM, "ca_cert: no PEM certificates in "+path)
+	}
+	return pool, nil
+}
+
+// ParseTLSVersion parses the TLS version v (parameter tls_min_version), one of
+// "1.0", "1.1", "1.2" or "1.3"
+func ParseTLSVersion(v string) (uint16, error) {
+	if version, ok := tlsVersions[v]; ok {
+		return version, nil
+	}
+	return 0, errors.New(errors.INVALID_PARAM, "tls_min_version: "+v)
+}
+
+// ParseProxyURL parses the URL of an HTTP, HTTPS or SOCKS5 proxy (parameter proxy_url)
+func ParseProxyURL(x string) (*url.URL, error) {
+	proxy, err := url.Parse(x)
+	if err != nil {
+		return nil, errors.New(errors.INVALID_PARAM, "proxy_url: "+err.Error())
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.New(errors.INVALID_PARAM, "proxy_url: expected scheme http, https or socks5")
+	}
+	if proxy.Host == "" {
+		return nil, errors.New(errors.INVALID_PARAM, "proxy_url: missing host")
+	}
+	return proxy, nil
+}
+
+// isIdempotent - true if the API with name only reads data, e.g. "volume-get-iter",
+// "system-get-version" or "perf-object-counter-list-info"
+func isIdempotent(name string) bool {
+	return idempotentRegex.MatchString(name)
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package zapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/tree/node"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxConcurrent - default limit of in-flight requests to a cluster, shared
+// by all clients of the poller that connect to the same cluster
+const DefaultMaxConcurrent = 8
+
+// connection - state shared by clients connecting to the same cluster
+// with the same credentials
+type connection struct {
+	key       string
+	transport http.RoundTripper
+	system    *system
+	sem       chan struct{} // nil if there is no limit
+	batches   chan struct{} // slots of batch requests, nil until first requested
+	clients   int           // clients that are not closed yet
+	mu        sync.Mutex    // only one client fetches system info
+}
+
+var (
+	connections    = make(map[string]*connection)
+	connectionsMux sync.Mutex
+)
+
+// NewShared creates a client like New, but the client shares its transport
+// (and thereby keep-alive connections), system info and the limit of
+// in-flight requests with other clients of the poller connecting to the
+// same cluster. The parameter max_concurrent_requests of the first client
+// determines the limit, 0 means there is no limit. The connection is shared
+// until all of its clients are closed (see Close), so system info is fetched
+// again by the next client.
+func NewShared(config *node.Node) (*Client, error) {
+
+	var (
+		client *Client
+		err    error
+	)
+
+	if client, err = New(config); err != nil {
+		return nil, err
+	}
+
+	limit := DefaultMaxConcurrent
+	if x := config.GetChildContentS("max_concurrent_requests"); x != "" {
+		if limit, err = strconv.Atoi(x); err != nil || limit < 0 {
+			return nil, errors.New(errors.INVALID_PARAM, "max_concurrent_requests: "+x)
+		}
+	}
+
+	key := connectionKey(config)
+
+	connectionsMux.Lock()
+	defer connectionsMux.Unlock()
+
+	if conn, has := connections[key]; has {
+		if cap(conn.sem) != limit {
+			client.Logger.Warn().Msgf("using max_concurrent_requests = [%d] of shared connection to [%s], instead of [%d]", cap(conn.sem), config.GetChildContentS("addr"), limit)
+		}
+		client.client.Transport = conn.transport
+		client.conn = conn
+		conn.clients++
+		return client, nil
+	}
+
+	conn := &connection{key: key, transport: client.client.Transport, clients: 1}
+	if limit != 0 {
+		conn.sem = make(chan struct{}, limit)
+		if t, ok := conn.transport.(*http.Transport); ok {
+			t.MaxIdleConnsPerHost = limit
+		}
+	}
+
+	connections[key] = conn
+	client.conn = conn
+	client.Logger.Debug().Msgf("created shared connection to [%s] with max %d concurrent requests", config.GetChildContentS("addr"), limit)
+	return client, nil
+}
+
+// Close releases the shared connection of the client, the last client
+// closes idle connections and removes it. Client should not be used after.
+// Has no effect if the client is not shared or already closed.
+func (c *Client) Close() {
+	if c.conn == nil {
+		return
+	}
+	connectionsMux.Lock()
+	defer connectionsMux.Unlock()
+	if c.conn.clients--; c.conn.clients == 0 {
+		if connections[c.conn.key] == c.conn {
+			delete(connections, c.conn.key)
+		}
+		if t, ok := c.conn.transport.(*http.Transport); ok {
+			t.CloseIdleConnections()
+		}
+		c.Logger.Debug().Msg("closed shared connection")
+	}
+	c.conn = nil
+}
+
+// MaxConcurrent returns the limit of in-flight requests that the client shares with
+// other clients of the same cluster, 0 if there is no limit or the client is not shared
+func (c *Client) MaxConcurrent() int {
+	if c.conn == nil || c.conn.sem == nil {
+		return 0
+	}
+	return cap(c.conn.sem)
+}
+
+// BatchSlots returns the slots of in-flight batch requests that the client
+// shares with other clients of the same cluster, a request should hold a slot
+// while it's sent and its response is read. Requests are additionally limited
+// by max_concurrent_requests. The first caller determines the number of slots,
+// clients that are not shared get slots of their own.
+func (c *Client) BatchSlots(n int) chan struct{} {
+	if c.conn == nil {
+		return make(chan struct{}, n)
+	}
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	if c.conn.batches == nil {
+		c.conn.batches = make(chan struct{}, n)
+	}
+	return c.conn.batches
+}
+
+// parameters that determine the transport and the identity of a cluster,
+// the password is included as a hash, so clients with different passwords
+// of the same user (e.g. after the password was changed) are not shared
+func connectionKey(config *node.Node) string {
+	var values []string
+	for _, name := range []string{"addr", "is_kfs", "auth_style", "username", "ssl_cert", "ssl_key", "use_insecure_tls",
+		"ca_cert", "tls_min_version", "server_name", "proxy_url"} {
+		values = append(values, config.GetChildContentS(name))
+	}
+	hash := sha256.Sum256([]byte(config.GetChildContentS("password")))
+	values = append(values, hex.EncodeToString(hash[:]))
+	return strings.Join(values, "|")
+}
+
+// init - fetch system info, unless another client already did
+func (conn *connection) init(c *Client, retries int) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.system == nil {
+		if err := c.fetchSystem(retries); err != nil {
+			return err
+		}
+		conn.system = c.system
+	}
+	c.system = conn.system
+	return nil
+}
+
+// acquire - wait for a free slot to send a request to the cluster,
+// returns error if ctx is done before
+func (conn *connection) acquire(ctx context.Context) error {
+	if conn == nil || conn.sem == nil {
+		return nil
+	}
+	select {
+	case conn.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (conn *connection) release() {
+	if conn != nil && conn.sem != nil {
+		<-conn.sem
+	}
+}
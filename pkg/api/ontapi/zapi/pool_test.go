@@ -0,0 +1,167 @@
+package zapi
+
+import (
+	"context"
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingTransport - responds to every request after a delay and records
+// the max number of in-flight requests
+type countingTransport struct {
+	mu            sync.Mutex
+	inFlight, max int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.inFlight++
+	if t.inFlight > t.max {
+		t.max = t.inFlight
+	}
+	t.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	t.mu.Lock()
+	t.inFlight--
+	t.mu.Unlock()
+
+	body := `<netapp version="1.3"><results status="passed"></results></netapp>`
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body)), Request: r}, nil
+}
+
+func sharedConfig(addr, limit string) *node.Node {
+	config := node.NewS("test")
+	config.NewChildS("addr", addr)
+	config.NewChildS("username", "username")
+	config.NewChildS("password", "password")
+	if limit != "" {
+		config.NewChildS("max_concurrent_requests", limit)
+	}
+	return config
+}
+
+func TestNewShared(t *testing.T) {
+
+	a, err := NewShared(sharedConfig("shared-01", "2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewShared(sharedConfig("shared-01", "10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewShared(sharedConfig("shared-02", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.conn != b.conn || a.client.Transport != b.client.Transport {
+		t.Error("clients of the same cluster should share connection")
+	}
+	if a.conn == other.conn {
+		t.Error("clients of different clusters should not share connection")
+	}
+	if cap(b.conn.sem) != 2 {
+		t.Errorf("limit = %d, want 2 (from first client)", cap(b.conn.sem))
+	}
+	if cap(other.conn.sem) != DefaultMaxConcurrent {
+		t.Errorf("limit = %d, want default %d", cap(other.conn.sem), DefaultMaxConcurrent)
+	}
+
+	if _, err = NewShared(sharedConfig("shared-03", "many")); err == nil {
+		t.Error("expected error for invalid max_concurrent_requests")
+	}
+
+	// system info fetched by one client is reused without connecting
+	a.conn.system = &system{name: "cluster-01", clustered: true, version: [3]int{9, 8, 0}}
+	if err = b.Init(1); err != nil {
+		t.Fatal(err)
+	}
+	if b.Name() != "cluster-01" || !b.IsClustered() {
+		t.Errorf("system info not shared: %s", b.Info())
+	}
+
+	// requests of both clients are limited
+	transport := &countingTransport{}
+	a.client.Transport = transport
+	b.client.Transport = transport
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		c := a
+		if i%2 == 0 {
+			c = b
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, _, _, err := c.InvokeContextWithTimers(context.Background(), node.NewXmlS("system-get-version")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if transport.max != 2 {
+		t.Errorf("max in-flight requests = %d, want 2", transport.max)
+	}
+}
+
+func TestCloseShared(t *testing.T) {
+
+	a, err := NewShared(sharedConfig("shared-04", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewShared(sharedConfig("shared-04", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := a.conn
+
+	// batch slots are shared, the first caller determines their number
+	if x, y := a.BatchSlots(2), b.BatchSlots(4); x != y || cap(y) != 2 {
+		t.Errorf("batch slots not shared: %d, %d", cap(x), cap(y))
+	}
+
+	// a different password is a different connection
+	config := sharedConfig("shared-04", "")
+	config.SetChildContentS("password", "changed")
+	other, err := NewShared(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.conn == conn {
+		t.Error("clients with different passwords should not share connection")
+	}
+	other.Close()
+
+	conn.system = &system{name: "cluster-04"}
+
+	a.Close()
+	a.Close() // no effect
+	if connections[conn.key] != conn {
+		t.Fatal("connection removed while still used")
+	}
+	b.Close()
+	if _, has := connections[conn.key]; has {
+		t.Fatal("connection not removed after last client closed")
+	}
+
+	// a new client doesn't reuse the stale system info
+	c, err := NewShared(sharedConfig("shared-04", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.conn == conn || c.conn.system != nil {
+		t.Error("new client should create new connection")
+	}
+	c.Close()
+}
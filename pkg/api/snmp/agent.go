@@ -0,0 +1,278 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package snmp
+
+import (
+	"goharvest2/pkg/errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Agent - minimal SNMP agent that serves a static set of variables to Get,
+// GetNext and GetBulk requests, over SNMPv2c and SNMPv3 with one user. It's
+// meant as a stand-in for switches in tests, not as a complete implementation.
+type Agent struct {
+	community string
+	usm       *usm
+	boots     int32
+	start     time.Time
+	conn      net.PacketConn
+	mu        sync.Mutex
+	oids      []string // sorted
+	vars      map[string]Variable
+	wg        sync.WaitGroup
+}
+
+// NewAgent - create agent that accepts SNMPv2c requests with community and,
+// if user is not nil, SNMPv3 requests of user
+func NewAgent(community string, user *User) (*Agent, error) {
+
+	a := &Agent{community: community, boots: 1, start: time.Now(), vars: make(map[string]Variable)}
+
+	if user != nil {
+		u, err := newUsm(*user)
+		if err != nil {
+			return nil, err
+		}
+		u.setEngine([]byte("\x80\x00\x1f\x88\x04harvest"), a.boots, 0)
+		a.usm = u
+	}
+	return a, nil
+}
+
+// Set - add or replace variables
+func (a *Agent) Set(vars ...Variable) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, v := range vars {
+		if _, has := a.vars[v.OID]; !has {
+			a.oids = append(a.oids, v.OID)
+		}
+		a.vars[v.OID] = v
+	}
+	sort.Slice(a.oids, func(i, j int) bool { return CompareOID(a.oids[i], a.oids[j]) < 0 })
+}
+
+// Reboot - increment engine boots and reset engine time, so that
+// SNMPv3 requests are out of the time window until clients resync
+func (a *Agent) Reboot() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.boots++
+	a.start = time.Now()
+}
+
+// Start - listen on addr (e.g. "127.0.0.1:0") and serve requests until Stop
+// is called, returns the address the agent is listening on
+func (a *Agent) Start(addr string) (string, error) {
+
+	var err error
+
+	if a.conn, err = net.ListenPacket("udp", addr); err != nil {
+		return "", errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+
+	a.wg.Add(1)
+	go a.serve()
+	return a.conn.LocalAddr().String(), nil
+}
+
+func (a *Agent) Stop() {
+	if a.conn != nil {
+		a.conn.Close()
+		a.wg.Wait()
+	}
+}
+
+func (a *Agent) serve() {
+
+	defer a.wg.Done()
+	buffer := make([]byte, maxMessageSize)
+
+	for {
+		n, from, err := a.conn.ReadFrom(buffer)
+		if err != nil {
+			return // closed
+		}
+		if response := a.handle(buffer[:n]); response != nil {
+			_, _ = a.conn.WriteTo(response, from)
+		}
+	}
+}
+
+// handle - response to a message, nil if the message is dropped
+func (a *Agent) handle(data []byte) []byte {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	version, err := messageVersion(data)
+	if err != nil {
+		return nil
+	}
+
+	switch version {
+	case Version2c:
+		community, p, err := decodeV2c(data)
+		if err != nil || community != a.community {
+			return nil
+		}
+		response, err := encodeV2c(community, a.respond(p))
+		if err != nil {
+			return nil
+		}
+		return response
+	case Version3:
+		if a.usm == nil {
+			return nil
+		}
+		return a.handleV3(data)
+	}
+	return nil
+}
+
+func (a *Agent) handleV3(data []byte) []byte {
+
+	now := int32(time.Since(a.start).Seconds())
+
+	m, err := a.usm.decode(data)
+	if err != nil {
+		switch errors.GetClass(err) {
+		case ERR_AUTH:
+			return a.report(data, usmStatsWrongDigests, 0)
+		case ERR_PRIV:
+			return a.report(data, usmStatsDecryptionErrors, 0)
+		}
+		return nil
+	}
+
+	switch {
+	case len(m.engineID) == 0:
+		return a.report(data, usmStatsUnknownEngineIDs, 0)
+	case m.user != a.usm.user.Name:
+		return a.report(data, usmStatsUnknownUserNames, 0)
+	case m.flags&(flagAuth|flagPriv) != a.usm.flags():
+		// security level of the user is not negotiable
+		return a.report(data, usmStatsWrongDigests, 0)
+	case m.boots != a.boots || m.time < now-150 || m.time > now+150:
+		return a.report(data, usmStatsNotInTimeWindows, m.flags&flagAuth)
+	}
+
+	response := &message{
+		id:              m.id,
+		flags:           m.flags &^ flagReportable,
+		engineID:        a.usm.engineID,
+		boots:           a.boots,
+		time:            now,
+		user:            m.user,
+		contextEngineID: a.usm.engineID,
+		contextName:     m.contextName,
+		pdu:             a.respond(m.pdu),
+	}
+
+	b, err := a.usm.encode(response)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// report - report with one of the usmStats counters, flags are the
+// security level of the report, request IDs are copied from the request
+func (a *Agent) report(request []byte, oid string, flags byte) []byte {
+
+	// message ID of the request, without verifying it. The request ID is only known
+	// if the request is not encrypted, clients have to match reports by message ID
+	var id, requestID int32
+	if m, content, err := a.usm.decodeHeader(request); err == nil {
+		id = m.id
+		if m.flags&flagPriv == 0 {
+			if scoped, _, err := expectTLV(content, Sequence); err == nil {
+				if _, scoped, err = expectTLV(scoped, OctetString); err == nil {
+					if _, scoped, err = expectTLV(scoped, OctetString); err == nil {
+						if p, err := decodePDU(scoped); err == nil {
+							requestID = p.RequestID
+						}
+					}
+				}
+			}
+		}
+	}
+
+	response := &message{
+		id:       id,
+		flags:    flags,
+		engineID: a.usm.engineID,
+		boots:    a.boots,
+		time:     int32(time.Since(a.start).Seconds()),
+		pdu: &PDU{
+			Type:      Report,
+			RequestID: requestID,
+			Variables: []Variable{{OID: oid, Type: Counter32, Value: uint64(1)}},
+		},
+		contextEngineID: a.usm.engineID,
+	}
+	if flags&flagAuth != 0 {
+		response.user = a.usm.user.Name
+	}
+
+	b, err := a.usm.encode(response)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// respond - process Get, GetNext and GetBulk requests
+func (a *Agent) respond(request *PDU) *PDU {
+
+	response := &PDU{Type: GetResponse, RequestID: request.RequestID}
+
+	switch request.Type {
+	case GetRequest:
+		for _, v := range request.Variables {
+			if x, has := a.vars[v.OID]; has {
+				response.Variables = append(response.Variables, x)
+			} else {
+				response.Variables = append(response.Variables, Variable{OID: v.OID, Type: NoSuchInstance})
+			}
+		}
+	case GetNextRequest:
+		for _, v := range request.Variables {
+			response.Variables = append(response.Variables, a.next(v.OID))
+		}
+	case GetBulkRequest:
+		nonRepeaters, maxRepetitions := request.ErrorStatus, request.ErrorIndex
+		for i, v := range request.Variables {
+			if i < nonRepeaters {
+				response.Variables = append(response.Variables, a.next(v.OID))
+				continue
+			}
+			oid := v.OID
+			for r := 0; r < maxRepetitions; r++ {
+				x := a.next(oid)
+				response.Variables = append(response.Variables, x)
+				if x.Type == EndOfMibView {
+					break
+				}
+				oid = x.OID
+			}
+		}
+	default:
+		response.ErrorStatus = 5 // genErr
+	}
+	return response
+}
+
+// next - first variable after oid
+func (a *Agent) next(oid string) Variable {
+	i := sort.Search(len(a.oids), func(i int) bool { return CompareOID(a.oids[i], oid) > 0 })
+	if i == len(a.oids) {
+		return Variable{OID: oid, Type: EndOfMibView}
+	}
+	return a.vars[a.oids[i]]
+}
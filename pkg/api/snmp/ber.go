@@ -0,0 +1,255 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package snmp
+
+import (
+	"goharvest2/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// BER tags of the types used by SNMP
+const (
+	Integer        = 0x02
+	OctetString    = 0x04
+	Null           = 0x05
+	ObjectId       = 0x06
+	Sequence       = 0x30
+	IpAddress      = 0x40
+	Counter32      = 0x41
+	Gauge32        = 0x42
+	TimeTicks      = 0x43
+	Opaque         = 0x44
+	Counter64      = 0x46
+	NoSuchObject   = 0x80
+	NoSuchInstance = 0x81
+	EndOfMibView   = 0x82
+)
+
+// readTLV - read the first element of data, returns tag, content and the remaining data
+func readTLV(data []byte) (byte, []byte, []byte, error) {
+
+	if len(data) < 2 {
+		return 0, nil, nil, errors.New(BER, "truncated element")
+	}
+
+	tag := data[0]
+	length := int(data[1])
+	offset := 2
+
+	// long form, lower bits are the number of length bytes
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return 0, nil, nil, errors.New(BER, "invalid length")
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+
+	if length < 0 || len(data) < offset+length {
+		return 0, nil, nil, errors.New(BER, "truncated element")
+	}
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+// expectTLV - like readTLV, but fails if the tag is not the expected one
+func expectTLV(data []byte, tag byte) ([]byte, []byte, error) {
+	t, content, rest, err := readTLV(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != tag {
+		return nil, nil, errors.New(BER, "unexpected tag 0x"+strconv.FormatUint(uint64(t), 16)+", want 0x"+strconv.FormatUint(uint64(tag), 16))
+	}
+	return content, rest, nil
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func encodeTLV(tag byte, content ...[]byte) []byte {
+	size := 0
+	for _, c := range content {
+		size += len(c)
+	}
+	b := make([]byte, 0, size+6)
+	b = append(b, tag)
+	b = append(b, encodeLength(size)...)
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+// encodeInteger - signed integer in the minimal number of bytes (two's complement)
+func encodeInteger(tag byte, v int64) []byte {
+	b := []byte{byte(v)}
+	for v > 127 || v < -128 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return encodeTLV(tag, b)
+}
+
+// encodeUnsigned - unsigned integer, with a leading zero byte if the highest bit is set
+func encodeUnsigned(tag byte, v uint64) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return encodeTLV(tag, b)
+}
+
+func decodeInteger(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, errors.New(BER, "invalid integer")
+	}
+	v := int64(int8(content[0])) // sign extension
+	for _, b := range content[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
+
+func decodeUnsigned(content []byte) (uint64, error) {
+	if len(content) == 0 || len(content) > 9 || (len(content) == 9 && content[0] != 0) {
+		return 0, errors.New(BER, "invalid unsigned integer")
+	}
+	var v uint64
+	for _, b := range content {
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// parseOID - parse dotted OID, e.g. "1.3.6.1.2.1.1.5.0", a leading dot is ignored
+func parseOID(oid string) ([]uint32, error) {
+	fields := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(fields) < 2 {
+		return nil, errors.New(INVALID_OID, oid)
+	}
+	ids := make([]uint32, len(fields))
+	for i, f := range fields {
+		id, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, errors.New(INVALID_OID, oid)
+		}
+		ids[i] = uint32(id)
+	}
+	if ids[0] > 2 || (ids[0] < 2 && ids[1] >= 40) {
+		return nil, errors.New(INVALID_OID, oid)
+	}
+	return ids, nil
+}
+
+func encodeOID(oid string) ([]byte, error) {
+
+	ids, err := parseOID(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	// first two identifiers are encoded in one byte
+	b := encodeBase128(nil, ids[0]*40+ids[1])
+	for _, id := range ids[2:] {
+		b = encodeBase128(b, id)
+	}
+	return encodeTLV(ObjectId, b), nil
+}
+
+func encodeBase128(b []byte, v uint32) []byte {
+	var tmp []byte
+	tmp = append(tmp, byte(v&0x7f))
+	for v >>= 7; v > 0; v >>= 7 {
+		tmp = append([]byte{byte(v&0x7f) | 0x80}, tmp...)
+	}
+	return append(b, tmp...)
+}
+
+func decodeOID(content []byte) (string, error) {
+
+	var (
+		ids []string
+		v   uint64
+	)
+
+	if len(content) == 0 {
+		return "", errors.New(BER, "empty OID")
+	}
+
+	for i, b := range content {
+		v = v<<7 | uint64(b&0x7f)
+		if v > 0xffffffff {
+			return "", errors.New(BER, "OID identifier too large")
+		}
+		if b&0x80 != 0 {
+			if i == len(content)-1 {
+				return "", errors.New(BER, "truncated OID")
+			}
+			continue
+		}
+		if len(ids) == 0 {
+			first := v / 40
+			if first > 2 {
+				first = 2
+			}
+			ids = append(ids, strconv.FormatUint(first, 10), strconv.FormatUint(v-first*40, 10))
+		} else {
+			ids = append(ids, strconv.FormatUint(v, 10))
+		}
+		v = 0
+	}
+	return strings.Join(ids, "."), nil
+}
+
+// CompareOID - compare OIDs numerically, returns -1, 0 or 1
+func CompareOID(a, b string) int {
+	x := strings.Split(strings.TrimPrefix(a, "."), ".")
+	y := strings.Split(strings.TrimPrefix(b, "."), ".")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		u, _ := strconv.ParseUint(x[i], 10, 32)
+		v, _ := strconv.ParseUint(y[i], 10, 32)
+		if u < v {
+			return -1
+		} else if u > v {
+			return 1
+		}
+	}
+	switch {
+	case len(x) < len(y):
+		return -1
+	case len(x) > len(y):
+		return 1
+	}
+	return 0
+}
+
+// HasPrefix - true if oid is in the subtree of root
+func HasPrefix(oid, root string) bool {
+	oid = strings.TrimPrefix(oid, ".")
+	root = strings.TrimPrefix(root, ".")
+	return strings.HasPrefix(oid, root+".")
+}
+
+// ValidOID - true if oid is a valid dotted OID
+func ValidOID(oid string) bool {
+	_, err := parseOID(oid)
+	return err == nil
+}
@@ -0,0 +1,377 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package snmp provides type Client for polling SNMP agents with SNMPv2c
+// or SNMPv3 (user-based security model with MD5/SHA authentication and
+// DES/AES privacy) and a minimal agent that serves a static set of
+// variables, for testing.
+package snmp
+
+import (
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/logging"
+	"goharvest2/pkg/tree/node"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPort           = "161"
+	DefaultTimeout        = 5
+	DefaultRetries        = 2
+	DefaultMaxRepetitions = 25
+	maxWalk               = 100000 // safeguard against agents that don't end walks
+)
+
+type Client struct {
+	conn           net.Conn
+	addr           string
+	version        int
+	community      string
+	usm            *usm
+	context        string
+	synced         time.Time // when engine boots and time were last updated
+	timeout        time.Duration
+	retries        int
+	maxRepetitions int
+	requestID      int32
+	buffer         []byte
+	Logger         *logging.Logger
+}
+
+// New - create client from parameters: addr (host or host:port), snmp_version
+// (2c or 3), snmp_community (v2c), username, password, snmp_auth_protocol,
+// snmp_priv_protocol, snmp_priv_password, snmp_context (v3), client_timeout,
+// snmp_retries and snmp_max_repetitions
+func New(config *node.Node) (*Client, error) {
+
+	var err error
+
+	c := &Client{buffer: make([]byte, maxMessageSize)}
+	c.Logger = logging.SubLogger("Snmp", "Client")
+
+	if c.addr = config.GetChildContentS("addr"); c.addr == "" {
+		return nil, errors.New(errors.MISSING_PARAM, "addr")
+	}
+	if _, _, err = net.SplitHostPort(c.addr); err != nil {
+		c.addr = net.JoinHostPort(strings.Trim(c.addr, "[]"), DefaultPort)
+	}
+
+	switch v := config.GetChildContentS("snmp_version"); v {
+	case "", "2c", "v2c", "2":
+		c.version = Version2c
+		if c.community = config.GetChildContentS("snmp_community"); c.community == "" {
+			c.community = "public"
+		}
+	case "3", "v3":
+		c.version = Version3
+		user := User{
+			Name:         config.GetChildContentS("username"),
+			AuthProtocol: config.GetChildContentS("snmp_auth_protocol"),
+			AuthPassword: config.GetChildContentS("password"),
+			PrivProtocol: config.GetChildContentS("snmp_priv_protocol"),
+			PrivPassword: config.GetChildContentS("snmp_priv_password"),
+		}
+		if user.Name == "" {
+			return nil, errors.New(errors.MISSING_PARAM, "username")
+		}
+		if c.usm, err = newUsm(user); err != nil {
+			return nil, err
+		}
+		c.context = config.GetChildContentS("snmp_context")
+	default:
+		return nil, errors.New(errors.INVALID_PARAM, "snmp_version: "+v)
+	}
+
+	c.timeout = DefaultTimeout * time.Second
+	if x := config.GetChildContentS("client_timeout"); x != "" {
+		t, err := strconv.Atoi(x)
+		if err != nil {
+			return nil, errors.New(errors.INVALID_PARAM, "client_timeout: "+x)
+		}
+		c.timeout = time.Duration(t) * time.Second
+	}
+
+	c.retries = DefaultRetries
+	if x := config.GetChildContentS("snmp_retries"); x != "" {
+		if c.retries, err = strconv.Atoi(x); err != nil {
+			return nil, errors.New(errors.INVALID_PARAM, "snmp_retries: "+x)
+		}
+	}
+
+	c.maxRepetitions = DefaultMaxRepetitions
+	if x := config.GetChildContentS("snmp_max_repetitions"); x != "" {
+		if c.maxRepetitions, err = strconv.Atoi(x); err != nil || c.maxRepetitions <= 0 {
+			return nil, errors.New(errors.INVALID_PARAM, "snmp_max_repetitions: "+x)
+		}
+	}
+
+	return c, nil
+}
+
+// Connect - open the socket, for SNMPv3 also discover the engine of the agent
+func (c *Client) Connect() error {
+
+	var err error
+
+	if c.conn, err = net.Dial("udp", c.addr); err != nil {
+		return errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+
+	if c.version == Version3 {
+		if err = c.discover(); err != nil {
+			c.Close()
+			return err
+		}
+		c.Logger.Debug().Msgf("discovered engine (%x) boots=%d time=%d", c.usm.engineID, c.usm.boots, c.usm.time)
+	}
+	return nil
+}
+
+func (c *Client) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Info - address and version
+func (c *Client) Info() string {
+	if c.version == Version3 {
+		return c.addr + " (SNMPv3)"
+	}
+	return c.addr + " (SNMPv2c)"
+}
+
+// Get - values of the OIDs, missing OIDs are returned with an exception type
+func (c *Client) Get(oids ...string) ([]Variable, error) {
+
+	p := &PDU{Type: GetRequest}
+	for _, oid := range oids {
+		p.Variables = append(p.Variables, Variable{OID: oid, Type: Null})
+	}
+
+	response, err := c.exchange(p)
+	if err != nil {
+		return nil, err
+	}
+	return response.Variables, nil
+}
+
+// Walk - all variables in the subtree of root, retrieved with GetBulk requests
+func (c *Client) Walk(root string) ([]Variable, error) {
+
+	var vars []Variable
+
+	if _, err := parseOID(root); err != nil {
+		return nil, err
+	}
+
+	last := root
+	for len(vars) < maxWalk {
+
+		p := &PDU{
+			Type:       GetBulkRequest,
+			ErrorIndex: c.maxRepetitions,
+			Variables:  []Variable{{OID: last, Type: Null}},
+		}
+
+		response, err := c.exchange(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(response.Variables) == 0 {
+			return vars, nil
+		}
+
+		for _, v := range response.Variables {
+			if v.Type == EndOfMibView || !HasPrefix(v.OID, root) {
+				return vars, nil
+			}
+			// agent must return OIDs in increasing order, otherwise we'd loop forever
+			if CompareOID(v.OID, last) <= 0 {
+				return nil, errors.New(ERR_UNEXPECTED, "OID not increasing: "+v.OID)
+			}
+			vars = append(vars, v)
+			last = v.OID
+		}
+	}
+
+	c.Logger.Warn().Msgf("stopped walk of %s after %d variables", root, len(vars))
+	return vars, nil
+}
+
+// discover - learn engine ID, boots and time of the agent from the report
+// that it sends in response to a request with an empty engine ID
+func (c *Client) discover() error {
+	_, err := c.exchange(&PDU{Type: GetRequest})
+	return err
+}
+
+// exchange - send request and wait for the response, with retries if no response
+// arrived within timeout. Errors reported by the agent are returned as errors.
+func (c *Client) exchange(p *PDU) (*PDU, error) {
+
+	var (
+		response *PDU
+		err      error
+	)
+
+	if c.conn == nil {
+		return nil, errors.New(errors.ERR_CONNECTION, "not connected")
+	}
+
+	reports := 0
+
+	for attempt := 0; attempt <= c.retries; attempt++ {
+
+		c.requestID++
+		p.RequestID = c.requestID
+
+		var data []byte
+		if c.version == Version3 {
+			data, err = c.usm.encode(c.newMessage(p))
+		} else {
+			data, err = encodeV2c(c.community, p)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = c.conn.Write(data); err != nil {
+			return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+		}
+
+		var m *message
+		if response, m, err = c.receive(p.RequestID); err != nil {
+			if isTimeout(err) {
+				c.Logger.Debug().Msgf("no response from %s (attempt %d)", c.addr, attempt+1)
+				continue
+			}
+			return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+		}
+
+		if response.Type == Report {
+			if m == nil || len(response.Variables) == 0 || reports == 3 {
+				return nil, errors.New(ERR_UNEXPECTED, "report")
+			}
+			reports++
+			switch oid := response.Variables[0].OID; oid {
+			case usmStatsUnknownEngineIDs:
+				c.usm.setEngine(m.engineID, m.boots, m.time)
+				c.synced = time.Now()
+				if len(p.Variables) == 0 { // discovery
+					return response, nil
+				}
+			case usmStatsNotInTimeWindows:
+				c.usm.setEngine(m.engineID, m.boots, m.time)
+				c.synced = time.Now()
+			case usmStatsUnknownUserNames:
+				return nil, errors.New(ERR_AUTH, "unknown user "+c.usm.user.Name)
+			case usmStatsWrongDigests:
+				return nil, errors.New(ERR_AUTH, "wrong digest, check username, password and auth protocol")
+			case usmStatsDecryptionErrors:
+				return nil, errors.New(ERR_PRIV, "decryption error, check priv password and priv protocol")
+			default:
+				return nil, errors.New(ERR_UNEXPECTED, "report "+oid)
+			}
+			// resend with new engine parameters
+			attempt--
+			continue
+		}
+
+		if response.ErrorStatus != 0 {
+			return nil, errors.New(ERR_RESPONSE, errorStatus(response.ErrorStatus)+" (index "+strconv.Itoa(response.ErrorIndex)+")")
+		}
+		return response, nil
+	}
+
+	return nil, errors.New(errors.ERR_CONNECTION, "no response from "+c.addr+" after "+strconv.Itoa(c.retries+1)+" attempts")
+}
+
+func (c *Client) newMessage(p *PDU) *message {
+	m := &message{
+		id:              p.RequestID,
+		flags:           c.usm.flags() | flagReportable,
+		engineID:        c.usm.engineID,
+		boots:           c.usm.boots,
+		user:            c.usm.user.Name,
+		contextEngineID: c.usm.engineID,
+		contextName:     c.context,
+		pdu:             p,
+	}
+	if len(c.usm.engineID) == 0 {
+		// discovery request without security
+		m.flags = flagReportable
+		m.user = ""
+	} else {
+		m.time = c.usm.time + int32(time.Since(c.synced).Seconds())
+	}
+	return m
+}
+
+// receive - read messages until the response to request id
+// arrives, older responses (e.g. to retries) are dropped
+func (c *Client) receive(id int32) (*PDU, *message, error) {
+
+	deadline := time.Now().Add(c.timeout)
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return nil, nil, errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+
+	for {
+		n, err := c.conn.Read(c.buffer)
+		if err != nil {
+			return nil, nil, err
+		}
+		data := c.buffer[:n]
+
+		if c.version == Version3 {
+			m, err := c.usm.decode(data)
+			if err != nil {
+				c.Logger.Debug().Msgf("drop message: %v", err)
+				continue
+			}
+			// reports to requests that the agent couldn't decrypt have no request ID
+			if m.id == id {
+				return m.pdu, m, nil
+			}
+			continue
+		}
+
+		community, p, err := decodeV2c(data)
+		if err != nil {
+			c.Logger.Debug().Msgf("drop message: %v", err)
+			continue
+		}
+		if community == c.community && p.RequestID == id {
+			return p, nil, nil
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return true
+	}
+	// e.g. "connection refused" when no agent listens on the port, we retry
+	return strings.Contains(err.Error(), "refused")
+}
+
+var errorStatuses = []string{
+	"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr", "noAccess", "wrongType",
+	"wrongLength", "wrongEncoding", "wrongValue", "noCreation", "inconsistentValue",
+	"resourceUnavailable", "commitFailed", "undoFailed", "authorizationError", "notWritable",
+	"inconsistentName",
+}
+
+func errorStatus(status int) string {
+	if status >= 0 && status < len(errorStatuses) {
+		return errorStatuses[status]
+	}
+	return "error " + strconv.Itoa(status)
+}
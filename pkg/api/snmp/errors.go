@@ -0,0 +1,14 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package snmp
+
+const (
+	BER            = "ber encoding"
+	INVALID_OID    = "invalid oid"
+	ERR_AUTH       = "snmp authentication"
+	ERR_PRIV       = "snmp privacy"
+	ERR_RESPONSE   = "snmp error status"
+	ERR_UNEXPECTED = "unexpected snmp message"
+)
@@ -0,0 +1,297 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package snmp
+
+import (
+	"encoding/hex"
+	"goharvest2/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PDU types
+const (
+	GetRequest     = 0xa0
+	GetNextRequest = 0xa1
+	GetResponse    = 0xa2
+	GetBulkRequest = 0xa5
+	Report         = 0xa8
+)
+
+// SNMP versions, as encoded in messages
+const (
+	Version2c = 1
+	Version3  = 3
+)
+
+// Variable - variable binding of a request or response. Value is int64
+// (Integer), uint64 (Counter32, Gauge32, TimeTicks, Counter64), []byte
+// (OctetString, Opaque), string (ObjectId, IpAddress) or nil
+type Variable struct {
+	OID   string
+	Type  byte
+	Value interface{}
+}
+
+// Float64 - numeric value of the variable, false if it's not a number
+func (v Variable) Float64() (float64, bool) {
+	switch x := v.Value.(type) {
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case []byte:
+		// some agents report numbers as strings
+		if f, err := strconv.ParseFloat(strings.TrimSpace(string(x)), 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// String - value as text, octet strings that are not printable
+// (e.g. MAC addresses) are formatted as hex, e.g. "00:a0:98:01:02:03"
+func (v Variable) String() string {
+	switch x := v.Value.(type) {
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case uint64:
+		return strconv.FormatUint(x, 10)
+	case string:
+		return x
+	case []byte:
+		if isPrintable(x) {
+			return strings.TrimRight(string(x), "\x00")
+		}
+		s := hex.EncodeToString(x)
+		pairs := make([]string, 0, len(x))
+		for i := 0; i < len(s); i += 2 {
+			pairs = append(pairs, s[i:i+2])
+		}
+		return strings.Join(pairs, ":")
+	}
+	return ""
+}
+
+// IsException - true if variable is noSuchObject, noSuchInstance or endOfMibView
+func (v Variable) IsException() bool {
+	return v.Type == NoSuchObject || v.Type == NoSuchInstance || v.Type == EndOfMibView
+}
+
+func isPrintable(b []byte) bool {
+	s := strings.TrimRight(string(b), "\x00")
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (v Variable) encode() ([]byte, error) {
+
+	oid, err := encodeOID(v.OID)
+	if err != nil {
+		return nil, err
+	}
+
+	var value []byte
+
+	switch v.Type {
+	case Integer:
+		x, _ := v.Value.(int64)
+		value = encodeInteger(Integer, x)
+	case Counter32, Gauge32, TimeTicks, Counter64:
+		x, _ := v.Value.(uint64)
+		value = encodeUnsigned(v.Type, x)
+	case OctetString, Opaque:
+		x, _ := v.Value.([]byte)
+		value = encodeTLV(v.Type, x)
+	case ObjectId:
+		x, _ := v.Value.(string)
+		if value, err = encodeOID(x); err != nil {
+			return nil, err
+		}
+	case IpAddress:
+		x, _ := v.Value.(string)
+		ip := net.ParseIP(x).To4()
+		if ip == nil {
+			return nil, errors.New(BER, "invalid IP address "+x)
+		}
+		value = encodeTLV(IpAddress, ip)
+	case Null, NoSuchObject, NoSuchInstance, EndOfMibView:
+		value = encodeTLV(v.Type, nil)
+	default:
+		return nil, errors.New(BER, "unsupported type 0x"+strconv.FormatUint(uint64(v.Type), 16))
+	}
+	return encodeTLV(Sequence, oid, value), nil
+}
+
+func decodeVariable(data []byte) (Variable, error) {
+
+	var (
+		v       Variable
+		content []byte
+		err     error
+	)
+
+	if content, data, err = expectTLV(data, ObjectId); err != nil {
+		return v, err
+	}
+	if v.OID, err = decodeOID(content); err != nil {
+		return v, err
+	}
+
+	if v.Type, content, _, err = readTLV(data); err != nil {
+		return v, err
+	}
+
+	switch v.Type {
+	case Integer:
+		v.Value, err = decodeInteger(content)
+	case Counter32, Gauge32, TimeTicks, Counter64:
+		v.Value, err = decodeUnsigned(content)
+	case OctetString, Opaque:
+		v.Value = append([]byte{}, content...)
+	case ObjectId:
+		v.Value, err = decodeOID(content)
+	case IpAddress:
+		if len(content) != 4 {
+			return v, errors.New(BER, "invalid IP address")
+		}
+		v.Value = net.IP(content).String()
+	case Null, NoSuchObject, NoSuchInstance, EndOfMibView:
+	default:
+		return v, errors.New(BER, "unsupported type 0x"+strconv.FormatUint(uint64(v.Type), 16))
+	}
+	return v, err
+}
+
+// PDU - protocol data unit of a request or response. For GetBulkRequest,
+// ErrorStatus is the number of non-repeaters and ErrorIndex max-repetitions
+type PDU struct {
+	Type        byte
+	RequestID   int32
+	ErrorStatus int
+	ErrorIndex  int
+	Variables   []Variable
+}
+
+func (p *PDU) encode() ([]byte, error) {
+	vars := make([][]byte, 0, len(p.Variables))
+	for _, v := range p.Variables {
+		b, err := v.encode()
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, b)
+	}
+	return encodeTLV(p.Type,
+		encodeInteger(Integer, int64(p.RequestID)),
+		encodeInteger(Integer, int64(p.ErrorStatus)),
+		encodeInteger(Integer, int64(p.ErrorIndex)),
+		encodeTLV(Sequence, vars...),
+	), nil
+}
+
+func decodePDU(data []byte) (*PDU, error) {
+
+	var (
+		content, rest []byte
+		x             int64
+		err           error
+	)
+
+	p := &PDU{}
+
+	if p.Type, content, _, err = readTLV(data); err != nil {
+		return nil, err
+	}
+	if p.Type&0xe0 != 0xa0 {
+		return nil, errors.New(BER, "not a PDU")
+	}
+
+	ints := make([]int64, 3)
+	for i := range ints {
+		if rest, content, err = expectTLV(content, Integer); err != nil {
+			return nil, err
+		}
+		if x, err = decodeInteger(rest); err != nil {
+			return nil, err
+		}
+		ints[i] = x
+	}
+	p.RequestID, p.ErrorStatus, p.ErrorIndex = int32(ints[0]), int(ints[1]), int(ints[2])
+
+	if content, _, err = expectTLV(content, Sequence); err != nil {
+		return nil, err
+	}
+
+	for len(content) != 0 {
+		var binding []byte
+		if binding, content, err = expectTLV(content, Sequence); err != nil {
+			return nil, err
+		}
+		v, err := decodeVariable(binding)
+		if err != nil {
+			return nil, err
+		}
+		p.Variables = append(p.Variables, v)
+	}
+	return p, nil
+}
+
+// encodeV2c - community-based message
+func encodeV2c(community string, p *PDU) ([]byte, error) {
+	pdu, err := p.encode()
+	if err != nil {
+		return nil, err
+	}
+	return encodeTLV(Sequence, encodeInteger(Integer, Version2c), encodeTLV(OctetString, []byte(community)), pdu), nil
+}
+
+// decodeV2c - decode community-based message, returns community and PDU
+func decodeV2c(data []byte) (string, *PDU, error) {
+
+	content, _, err := expectTLV(data, Sequence)
+	if err != nil {
+		return "", nil, err
+	}
+
+	version, content, err := expectTLV(content, Integer)
+	if err != nil {
+		return "", nil, err
+	}
+	if v, _ := decodeInteger(version); v != Version2c {
+		return "", nil, errors.New(ERR_UNEXPECTED, "version "+strconv.FormatInt(v, 10))
+	}
+
+	community, content, err := expectTLV(content, OctetString)
+	if err != nil {
+		return "", nil, err
+	}
+
+	p, err := decodePDU(content)
+	return string(community), p, err
+}
+
+// messageVersion - version of an encoded message
+func messageVersion(data []byte) (int64, error) {
+	content, _, err := expectTLV(data, Sequence)
+	if err != nil {
+		return 0, err
+	}
+	version, _, err := expectTLV(content, Integer)
+	if err != nil {
+		return 0, err
+	}
+	return decodeInteger(version)
+}
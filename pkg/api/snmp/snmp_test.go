@@ -0,0 +1,191 @@
+package snmp
+
+import (
+	"encoding/hex"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/tree/node"
+	"testing"
+)
+
+func TestInteger(t *testing.T) {
+
+	tests := []struct {
+		value   int64
+		encoded string
+	}{
+		{0, "020100"},
+		{127, "02017f"},
+		{128, "02020080"},
+		{256, "02020100"},
+		{-1, "0201ff"},
+		{-128, "020180"},
+		{-129, "0202ff7f"},
+		{2147483647, "02047fffffff"},
+	}
+
+	for _, tt := range tests {
+		b := encodeInteger(Integer, tt.value)
+		if hex.EncodeToString(b) != tt.encoded {
+			t.Errorf("encode %d = %x, want %s", tt.value, b, tt.encoded)
+		}
+		_, content, _, err := readTLV(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := decodeInteger(content); err != nil || v != tt.value {
+			t.Errorf("decode %s = %d (%v), want %d", tt.encoded, v, err, tt.value)
+		}
+	}
+
+	// counters are unsigned and need a leading zero if the highest bit is set
+	b := encodeUnsigned(Counter64, 18446744073709551615)
+	if hex.EncodeToString(b) != "460900ffffffffffffffff" {
+		t.Errorf("encode max counter64 = %x", b)
+	}
+	_, content, _, _ := readTLV(b)
+	if v, err := decodeUnsigned(content); err != nil || v != 18446744073709551615 {
+		t.Errorf("decode max counter64 = %d (%v)", v, err)
+	}
+}
+
+func TestOID(t *testing.T) {
+
+	tests := []struct {
+		oid     string
+		encoded string
+	}{
+		{"1.3.6.1.2.1.1.5.0", "06082b06010201010500"},
+		{"1.3.6.1.4.1.9.9.13.1.3.1.3.1", "060d2b0601040109090d0103010301"},
+		{"1.3.6.1.2.1.31.1.1.1.6.100001", "060d2b060102011f01010106868d21"},
+		{"2.999.3", "0603883703"},
+	}
+
+	for _, tt := range tests {
+		b, err := encodeOID(tt.oid)
+		if err != nil || hex.EncodeToString(b) != tt.encoded {
+			t.Errorf("encode %s = %x (%v), want %s", tt.oid, b, err, tt.encoded)
+			continue
+		}
+		_, content, _, _ := readTLV(b)
+		if oid, err := decodeOID(content); err != nil || oid != tt.oid {
+			t.Errorf("decode %s = %s (%v)", tt.encoded, oid, err)
+		}
+	}
+
+	for _, invalid := range []string{"", "1", "1.3.x", "3.1", "1.40"} {
+		if _, err := encodeOID(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+
+	if CompareOID("1.3.6.1.2.1.2.2.1.10.2", "1.3.6.1.2.1.2.2.1.10.10") != -1 || CompareOID("1.3.6.1.2", "1.3.6.1") != 1 {
+		t.Errorf("OIDs are not compared numerically")
+	}
+}
+
+// test vectors of RFC 3414, A.3
+func TestLocalizeKey(t *testing.T) {
+
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+
+	tests := []struct {
+		protocol string
+		key      string
+	}{
+		{"MD5", "526f5eed9fcce26f8964c2930787d82b"},
+		{"SHA", "6695febc9288e36282235fc7151f128497b38f3f"},
+	}
+
+	for _, tt := range tests {
+		u, _ := newUsm(User{Name: "test", AuthProtocol: tt.protocol, AuthPassword: "maplesyrup"})
+		u.setEngine(engineID, 0, 0)
+		if key := hex.EncodeToString(u.authKey); key != tt.key {
+			t.Errorf("%s: got %s, want %s", tt.protocol, key, tt.key)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+
+	user := &User{Name: "harvest", AuthProtocol: "SHA", AuthPassword: "authpass123", PrivProtocol: "AES", PrivPassword: "privpass123"}
+
+	tests := []struct {
+		name   string
+		user   *User
+		params map[string]string
+		class  string // of expected error
+	}{
+		{"v2c", nil, map[string]string{"snmp_community": "secret"}, ""},
+		{"v2c wrong community", nil, map[string]string{"snmp_community": "public"}, errors.ERR_CONNECTION},
+		{"v3 MD5", &User{Name: "harvest", AuthProtocol: "MD5", AuthPassword: "authpass123"}, map[string]string{"snmp_auth_protocol": "MD5"}, ""},
+		{"v3 SHA AES", user, map[string]string{"snmp_auth_protocol": "SHA", "snmp_priv_protocol": "AES", "snmp_priv_password": "privpass123"}, ""},
+		{"v3 SHA DES", &User{Name: "harvest", AuthProtocol: "SHA", AuthPassword: "authpass123", PrivProtocol: "DES", PrivPassword: "privpass123"},
+			map[string]string{"snmp_auth_protocol": "SHA", "snmp_priv_protocol": "DES", "snmp_priv_password": "privpass123"}, ""},
+		{"v3 wrong auth protocol", user, map[string]string{"snmp_auth_protocol": "MD5", "snmp_priv_protocol": "AES", "snmp_priv_password": "privpass123"}, ERR_AUTH},
+		{"v3 wrong priv password", user, map[string]string{"snmp_auth_protocol": "SHA", "snmp_priv_protocol": "AES", "snmp_priv_password": "wrong"}, ERR_PRIV},
+	}
+
+	for _, tt := range tests {
+
+		agent, err := NewAgent("secret", tt.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		agent.Set(
+			Variable{OID: "1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("switch1")},
+			Variable{OID: "1.3.6.1.2.1.2.2.1.10.1", Type: Counter32, Value: uint64(100)},
+			Variable{OID: "1.3.6.1.2.1.2.2.1.10.2", Type: Counter32, Value: uint64(200)},
+			Variable{OID: "1.3.6.1.2.1.2.2.1.10.10", Type: Counter32, Value: uint64(1000)},
+			Variable{OID: "1.3.6.1.2.1.2.2.1.16.1", Type: Counter32, Value: uint64(5)},
+		)
+		addr, err := agent.Start("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params := node.NewS("")
+		params.NewChildS("addr", addr)
+		params.NewChildS("client_timeout", "1")
+		params.NewChildS("snmp_retries", "0")
+		params.NewChildS("snmp_max_repetitions", "2")
+		if tt.user != nil {
+			params.NewChildS("snmp_version", "3")
+			params.NewChildS("username", tt.user.Name)
+			params.NewChildS("password", tt.user.AuthPassword)
+		}
+		for k, v := range tt.params {
+			params.NewChildS(k, v)
+		}
+
+		client, err := New(params)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		err = client.Connect()
+		var vars []Variable
+		if err == nil {
+			vars, err = client.Walk("1.3.6.1.2.1.2.2.1.10")
+		}
+
+		if tt.class != "" {
+			if err == nil || !errors.IsErr(err, tt.class) {
+				t.Errorf("%s: got error %v, want %s", tt.name, err, tt.class)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if len(vars) != 3 || vars[2].OID != "1.3.6.1.2.1.2.2.1.10.10" || vars[2].String() != "1000" {
+			t.Errorf("%s: walk returned %v", tt.name, vars)
+		} else if tt.user != nil {
+			// client has to resync with the engine
+			agent.Reboot()
+			vars, err = client.Get("1.3.6.1.2.1.1.5.0", "1.3.6.1.2.1.1.6.0")
+			if err != nil || len(vars) != 2 || vars[0].String() != "switch1" || !vars[1].IsException() {
+				t.Errorf("%s: get after reboot returned %v (%v)", tt.name, vars, err)
+			}
+		}
+
+		client.Close()
+		agent.Stop()
+	}
+}
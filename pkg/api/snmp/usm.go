@@ -0,0 +1,429 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package snmp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"goharvest2/pkg/errors"
+	"hash"
+	"strings"
+	"sync/atomic"
+)
+
+// flags of v3 messages
+const (
+	flagAuth       = 0x01
+	flagPriv       = 0x02
+	flagReportable = 0x04
+)
+
+const (
+	securityModelUSM = 3
+	maxMessageSize   = 65507
+	authParamsLen    = 12 // HMAC-MD5-96 and HMAC-SHA-96 are truncated to 12 bytes
+)
+
+// counters of reports that tell why a request was rejected (RFC 3414)
+const (
+	usmStatsUnknownEngineIDs = "1.3.6.1.6.3.15.1.1.4.0"
+	usmStatsNotInTimeWindows = "1.3.6.1.6.3.15.1.1.2.0"
+	usmStatsUnknownUserNames = "1.3.6.1.6.3.15.1.1.3.0"
+	usmStatsWrongDigests     = "1.3.6.1.6.3.15.1.1.5.0"
+	usmStatsDecryptionErrors = "1.3.6.1.6.3.15.1.1.6.0"
+)
+
+// User - credentials of the user-based security model, protocols are
+// MD5 or SHA (authentication) and DES or AES (privacy, AES-128)
+type User struct {
+	Name         string
+	AuthProtocol string
+	AuthPassword string
+	PrivProtocol string
+	PrivPassword string
+}
+
+// usm - security state of one user with one engine
+type usm struct {
+	user     User
+	engineID []byte
+	boots    int32
+	time     int32
+	authKey  []byte
+	privKey  []byte
+	salt     uint64
+}
+
+func newUsm(user User) (*usm, error) {
+
+	user.AuthProtocol = strings.ToUpper(user.AuthProtocol)
+	user.PrivProtocol = strings.ToUpper(user.PrivProtocol)
+
+	switch user.AuthProtocol {
+	case "", "MD5", "SHA":
+	default:
+		return nil, errors.New(errors.INVALID_PARAM, "auth protocol "+user.AuthProtocol)
+	}
+
+	switch user.PrivProtocol {
+	case "":
+	case "DES", "AES":
+		if user.AuthProtocol == "" {
+			return nil, errors.New(errors.INVALID_PARAM, "privacy requires authentication")
+		}
+	default:
+		return nil, errors.New(errors.INVALID_PARAM, "priv protocol "+user.PrivProtocol)
+	}
+
+	return &usm{user: user}, nil
+}
+
+// flags - security level of the user
+func (u *usm) flags() byte {
+	var f byte
+	if u.user.AuthProtocol != "" {
+		f |= flagAuth
+	}
+	if u.user.PrivProtocol != "" {
+		f |= flagPriv
+	}
+	return f
+}
+
+func (u *usm) newHash() hash.Hash {
+	if u.user.AuthProtocol == "SHA" {
+		return sha1.New()
+	}
+	return md5.New()
+}
+
+// setEngine - set the authoritative engine and localize keys
+func (u *usm) setEngine(engineID []byte, boots, time int32) {
+	if !bytes.Equal(u.engineID, engineID) {
+		u.engineID = append([]byte{}, engineID...)
+		u.authKey = nil
+		u.privKey = nil
+		if u.user.AuthProtocol != "" {
+			u.authKey = localizeKey(u.newHash, passwordToKey(u.newHash, u.user.AuthPassword), engineID)
+		}
+		if u.user.PrivProtocol != "" {
+			u.privKey = localizeKey(u.newHash, passwordToKey(u.newHash, u.user.PrivPassword), engineID)
+		}
+	}
+	u.boots = boots
+	u.time = time
+}
+
+// passwordToKey - key from password (RFC 3414, A.2)
+func passwordToKey(newHash func() hash.Hash, password string) []byte {
+	h := newHash()
+	if password == "" {
+		return h.Sum(nil)
+	}
+	buf := make([]byte, 64)
+	pw := []byte(password)
+	for i, count := 0, 0; count < 1048576; count += 64 {
+		for j := range buf {
+			buf[j] = pw[i%len(pw)]
+			i++
+		}
+		h.Write(buf)
+	}
+	return h.Sum(nil)
+}
+
+// localizeKey - key of the engine (RFC 3414, A.2)
+func localizeKey(newHash func() hash.Hash, key, engineID []byte) []byte {
+	h := newHash()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// message - SNMPv3 message
+type message struct {
+	id              int32
+	flags           byte
+	engineID        []byte
+	boots           int32
+	time            int32
+	user            string
+	authParams      []byte
+	privParams      []byte
+	contextEngineID []byte
+	contextName     string
+	pdu             *PDU
+}
+
+// encode - encode message, encrypt and authenticate it according to the flags
+func (u *usm) encode(m *message) ([]byte, error) {
+
+	pdu, err := m.pdu.encode()
+	if err != nil {
+		return nil, err
+	}
+	scoped := encodeTLV(Sequence, encodeTLV(OctetString, m.contextEngineID), encodeTLV(OctetString, []byte(m.contextName)), pdu)
+
+	m.authParams = nil
+	m.privParams = nil
+
+	if m.flags&flagPriv != 0 {
+		if scoped, m.privParams, err = u.encrypt(scoped, m.boots, m.time); err != nil {
+			return nil, err
+		}
+		scoped = encodeTLV(OctetString, scoped)
+	}
+
+	if m.flags&flagAuth != 0 {
+		m.authParams = make([]byte, authParamsLen)
+	}
+
+	security := encodeTLV(Sequence,
+		encodeTLV(OctetString, m.engineID),
+		encodeInteger(Integer, int64(m.boots)),
+		encodeInteger(Integer, int64(m.time)),
+		encodeTLV(OctetString, []byte(m.user)),
+		encodeTLV(OctetString, m.authParams),
+		encodeTLV(OctetString, m.privParams),
+	)
+
+	global := encodeTLV(Sequence,
+		encodeInteger(Integer, int64(m.id)),
+		encodeInteger(Integer, maxMessageSize),
+		encodeTLV(OctetString, []byte{m.flags}),
+		encodeInteger(Integer, securityModelUSM),
+	)
+
+	data := encodeTLV(Sequence, encodeInteger(Integer, Version3), global, encodeTLV(OctetString, security), scoped)
+
+	// security parameters are placed before the scoped PDU, so the first
+	// occurrence of the placeholder is the one to replace with the digest
+	if m.flags&flagAuth != 0 {
+		i := bytes.Index(data, encodeTLV(OctetString, make([]byte, authParamsLen)))
+		if i == -1 {
+			return nil, errors.New(ERR_AUTH, "placeholder of digest not found")
+		}
+		copy(data[i+2:i+2+authParamsLen], u.digest(data))
+	}
+	return data, nil
+}
+
+// digest - truncated HMAC of a message, in which the authentication parameters are zero
+func (u *usm) digest(data []byte) []byte {
+	mac := hmac.New(u.newHash, u.authKey)
+	mac.Write(data)
+	return mac.Sum(nil)[:authParamsLen]
+}
+
+// decodeHeader - decode header and security parameters of a message,
+// returns the message without PDU and the (possibly encrypted) scoped PDU
+func (u *usm) decodeHeader(data []byte) (*message, []byte, error) {
+
+	var (
+		content, global, security, x []byte
+		v                            int64
+		err                          error
+	)
+
+	m := &message{}
+
+	if content, _, err = expectTLV(data, Sequence); err != nil {
+		return nil, nil, err
+	}
+	if x, content, err = expectTLV(content, Integer); err != nil {
+		return nil, nil, err
+	}
+	if v, _ = decodeInteger(x); v != Version3 {
+		return nil, nil, errors.New(ERR_UNEXPECTED, "not a v3 message")
+	}
+
+	// header data
+	if global, content, err = expectTLV(content, Sequence); err != nil {
+		return nil, nil, err
+	}
+	if x, global, err = expectTLV(global, Integer); err != nil {
+		return nil, nil, err
+	}
+	v, _ = decodeInteger(x)
+	m.id = int32(v)
+	if _, global, err = expectTLV(global, Integer); err != nil { // max size
+		return nil, nil, err
+	}
+	if x, _, err = expectTLV(global, OctetString); err != nil || len(x) != 1 {
+		return nil, nil, errors.New(BER, "invalid message flags")
+	}
+	m.flags = x[0]
+
+	// security parameters
+	if security, content, err = expectTLV(content, OctetString); err != nil {
+		return nil, nil, err
+	}
+	if security, _, err = expectTLV(security, Sequence); err != nil {
+		return nil, nil, err
+	}
+	fields := make([][]byte, 6)
+	for i, tag := range []byte{OctetString, Integer, Integer, OctetString, OctetString, OctetString} {
+		if fields[i], security, err = expectTLV(security, tag); err != nil {
+			return nil, nil, err
+		}
+	}
+	m.engineID = append([]byte{}, fields[0]...)
+	v, _ = decodeInteger(fields[1])
+	m.boots = int32(v)
+	v, _ = decodeInteger(fields[2])
+	m.time = int32(v)
+	m.user = string(fields[3])
+	m.authParams = fields[4]
+	m.privParams = fields[5]
+
+	return m, content, nil
+}
+
+// decode - decode message, verify and decrypt it if the flags require it.
+// Messages of unknown users or with wrong digests are rejected.
+func (u *usm) decode(data []byte) (*message, error) {
+
+	var scoped, x []byte
+
+	m, content, err := u.decodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.flags&flagAuth != 0 {
+		if m.user != u.user.Name || u.authKey == nil {
+			return nil, errors.New(ERR_AUTH, "unknown user "+m.user)
+		}
+		if len(m.authParams) != authParamsLen {
+			return nil, errors.New(ERR_AUTH, "invalid digest")
+		}
+		// digest is calculated with zeroed authentication parameters
+		zeroed := append([]byte{}, data...)
+		placeholder := encodeTLV(OctetString, m.authParams)
+		i := bytes.Index(zeroed, placeholder)
+		if i == -1 {
+			return nil, errors.New(ERR_AUTH, "digest not found")
+		}
+		copy(zeroed[i+2:i+2+authParamsLen], make([]byte, authParamsLen))
+		if !hmac.Equal(u.digest(zeroed), m.authParams) {
+			return nil, errors.New(ERR_AUTH, "wrong digest")
+		}
+	}
+
+	if m.flags&flagPriv != 0 {
+		if x, _, err = expectTLV(content, OctetString); err != nil {
+			return nil, err
+		}
+		if scoped, err = u.decrypt(x, m.privParams, m.boots, m.time); err != nil {
+			return nil, err
+		}
+	} else {
+		scoped = content
+	}
+
+	if scoped, _, err = expectTLV(scoped, Sequence); err != nil {
+		return nil, errors.New(ERR_PRIV, "invalid scoped PDU, wrong privacy password?")
+	}
+	if x, scoped, err = expectTLV(scoped, OctetString); err != nil {
+		return nil, err
+	}
+	m.contextEngineID = append([]byte{}, x...)
+	if x, scoped, err = expectTLV(scoped, OctetString); err != nil {
+		return nil, err
+	}
+	m.contextName = string(x)
+
+	if m.pdu, err = decodePDU(scoped); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// encrypt - encrypt scoped PDU, returns encrypted data and privacy parameters (the salt)
+func (u *usm) encrypt(data []byte, boots, time int32) ([]byte, []byte, error) {
+
+	salt := make([]byte, 8)
+
+	switch u.user.PrivProtocol {
+	case "DES":
+		// salt is engine boots and a local counter (RFC 3414, 8.1.1.1)
+		binary.BigEndian.PutUint32(salt, uint32(boots))
+		binary.BigEndian.PutUint32(salt[4:], uint32(atomic.AddUint64(&u.salt, 1)))
+		block, err := des.NewCipher(u.privKey[:8])
+		if err != nil {
+			return nil, nil, errors.New(ERR_PRIV, err.Error())
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = u.privKey[8+i] ^ salt[i]
+		}
+		if pad := len(data) % 8; pad != 0 {
+			data = append(data, make([]byte, 8-pad)...)
+		}
+		encrypted := make([]byte, len(data))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, data)
+		return encrypted, salt, nil
+	case "AES":
+		binary.BigEndian.PutUint64(salt, atomic.AddUint64(&u.salt, 1))
+		block, err := aes.NewCipher(u.privKey[:16])
+		if err != nil {
+			return nil, nil, errors.New(ERR_PRIV, err.Error())
+		}
+		encrypted := make([]byte, len(data))
+		cipher.NewCFBEncrypter(block, aesIV(boots, time, salt)).XORKeyStream(encrypted, data)
+		return encrypted, salt, nil
+	}
+	return nil, nil, errors.New(ERR_PRIV, "no privacy protocol")
+}
+
+func (u *usm) decrypt(data, salt []byte, boots, time int32) ([]byte, error) {
+
+	if len(salt) != 8 {
+		return nil, errors.New(ERR_PRIV, "invalid privacy parameters")
+	}
+
+	switch u.user.PrivProtocol {
+	case "DES":
+		if len(data)%8 != 0 {
+			return nil, errors.New(ERR_PRIV, "invalid length of encrypted data")
+		}
+		block, err := des.NewCipher(u.privKey[:8])
+		if err != nil {
+			return nil, errors.New(ERR_PRIV, err.Error())
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = u.privKey[8+i] ^ salt[i]
+		}
+		decrypted := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+		return decrypted, nil
+	case "AES":
+		block, err := aes.NewCipher(u.privKey[:16])
+		if err != nil {
+			return nil, errors.New(ERR_PRIV, err.Error())
+		}
+		decrypted := make([]byte, len(data))
+		cipher.NewCFBDecrypter(block, aesIV(boots, time, salt)).XORKeyStream(decrypted, data)
+		return decrypted, nil
+	}
+	return nil, errors.New(ERR_PRIV, "message is encrypted, but no privacy protocol is configured")
+}
+
+// aesIV - initialization vector of AES (RFC 3826, 3.1.2.1)
+func aesIV(boots, time int32, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(time))
+	copy(iv[8:], salt)
+	return iv
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package solidfire provides type Client for the JSON-RPC API of SolidFire
+// clusters running Element OS. Requests are authenticated with the username
+// and password of a cluster admin (basic auth).
+package solidfire
+
+import (
+	"bytes"
+	"encoding/json"
+	"goharvest2/pkg/api/httpclient"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/logging"
+	tree "goharvest2/pkg/tree/json"
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+)
+
+const DefaultApiVersion = "10.0"
+
+type Client struct {
+	client   *http.Client
+	url      string // e.g. https://mvip.example.com/json-rpc/10.0
+	username string
+	password string
+	id       uint64 // of the last request
+	Logger   *logging.Logger
+}
+
+// New - create client from parameters: addr (MVIP), username, password,
+// api_version and the parameters of httpclient.New and httpclient.BaseURL
+func New(config *node.Node) (*Client, error) {
+
+	c := &Client{Logger: logging.SubLogger("SolidFire", "Client")}
+
+	base, err := httpclient.BaseURL(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.username = config.GetChildContentS("username"); c.username == "" {
+		return nil, errors.New(errors.MISSING_PARAM, "username")
+	}
+	c.password = config.GetChildContentS("password")
+
+	version := config.GetChildContentS("api_version")
+	if version == "" {
+		version = DefaultApiVersion
+	}
+	c.url = base + "/json-rpc/" + version
+
+	if c.client, err = httpclient.New(config, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Call - invoke API method with params (can be nil) and return the result,
+// e.g. Call("ListVolumeStats", nil) returns the node with child "volumeStats"
+func (c *Client) Call(method string, params map[string]interface{}) (*node.Node, error) {
+
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	id := atomic.AddUint64(&c.id, 1)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"method": method,
+		"params": params,
+		"id":     id,
+	})
+	if err != nil {
+		return nil, errors.New(errors.INVALID_PARAM, method+": "+err.Error())
+	}
+
+	request, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.New(errors.INVALID_PARAM, err.Error())
+	}
+	request.SetBasicAuth(c.username, c.password)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, errors.New(errors.API_INSUF_PRIV, method+": "+response.Status)
+	default:
+		return nil, errors.New(errors.API_RESPONSE, method+": "+response.Status)
+	}
+
+	root, err := tree.Load(data)
+	if err != nil {
+		return nil, errors.New(errors.API_RESPONSE, method+": "+err.Error())
+	}
+
+	// e.g. {"error": {"code": 500, "name": "xUnknownAPIMethod", "message": "..."}, "id": 1}
+	if e := root.GetChildS("error"); e != nil {
+		class := errors.API_REQ_REJECTED
+		if e.GetChildContentS("name") == "xPermissionDenied" {
+			class = errors.API_INSUF_PRIV
+		}
+		return nil, errors.New(class, method+": "+e.GetChildContentS("name")+": "+e.GetChildContentS("message"))
+	}
+
+	result := root.GetChildS("result")
+	if result == nil {
+		return nil, errors.New(errors.API_RESPONSE, method+": missing result")
+	}
+	c.Logger.Trace().Msgf("%s (id=%d): %d bytes", method, id, len(data))
+	return result, nil
+}
+
+// Version - version of Element OS, e.g. "12.3.0.958"
+func (c *Client) Version() (string, error) {
+	result, err := c.Call("GetClusterVersionInfo", nil)
+	if err != nil {
+		return "", err
+	}
+	return result.GetChildContentS("clusterVersion"), nil
+}
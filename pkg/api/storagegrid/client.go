@@ -0,0 +1,205 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package storagegrid provides type Client for the grid management API of
+// StorageGRID. The client logs in with username and password and sends the
+// token it receives with each request. If the token expired, it logs in again.
+package storagegrid
+
+import (
+	"bytes"
+	"encoding/json"
+	"goharvest2/pkg/api/httpclient"
+	"goharvest2/pkg/errors"
+	"goharvest2/pkg/logging"
+	tree "goharvest2/pkg/tree/json"
+	"goharvest2/pkg/tree/node"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DefaultApiVersion = "3"
+
+type Client struct {
+	client   *http.Client
+	base     string // e.g. https://grid.example.com/api/v3
+	username string
+	password string
+	mu       sync.Mutex
+	token    string
+	Logger   *logging.Logger
+}
+
+// New - create client from parameters: username, password, api_version and
+// the parameters of httpclient.New and httpclient.BaseURL
+func New(config *node.Node) (*Client, error) {
+
+	c := &Client{Logger: logging.SubLogger("StorageGrid", "Client")}
+
+	base, err := httpclient.BaseURL(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.username = config.GetChildContentS("username"); c.username == "" {
+		return nil, errors.New(errors.MISSING_PARAM, "username")
+	}
+	c.password = config.GetChildContentS("password")
+
+	version := config.GetChildContentS("api_version")
+	if version == "" {
+		version = DefaultApiVersion
+	}
+	c.base = base + "/api/v" + version
+
+	if c.client, err = httpclient.New(config, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Login - request a new token
+func (c *Client) Login() error {
+
+	body, err := json.Marshal(map[string]interface{}{
+		"username":  c.username,
+		"password":  c.password,
+		"cookie":    false,
+		"csrfToken": false,
+	})
+	if err != nil {
+		return errors.New(ERR_LOGIN, err.Error())
+	}
+
+	request, err := http.NewRequest("POST", c.base+"/authorize", bytes.NewReader(body))
+	if err != nil {
+		return errors.New(errors.INVALID_PARAM, err.Error())
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, status, err := c.do(request)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return errors.New(ERR_LOGIN, "user "+c.username+": "+message(response, status))
+	}
+
+	token := response.GetChildContentS("data")
+	if token == "" {
+		return errors.New(ERR_LOGIN, "no token in response")
+	}
+
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+	c.Logger.Debug().Msgf("logged in as %s", c.username)
+	return nil
+}
+
+// Get - send GET request to the path relative to the API, e.g. "grid/accounts",
+// and return the response. Logs in first, or again if the token expired.
+func (c *Client) Get(path string) (*node.Node, error) {
+
+	for attempt := 0; ; attempt++ {
+
+		c.mu.Lock()
+		token := c.token
+		c.mu.Unlock()
+
+		if token == "" {
+			if err := c.Login(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		request, err := http.NewRequest("GET", c.base+"/"+strings.TrimPrefix(path, "/"), nil)
+		if err != nil {
+			return nil, errors.New(errors.INVALID_PARAM, err.Error())
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+
+		response, status, err := c.do(request)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case status == http.StatusUnauthorized && attempt == 0:
+			c.Logger.Debug().Msg("token expired, login again")
+			c.mu.Lock()
+			if c.token == token {
+				c.token = ""
+			}
+			c.mu.Unlock()
+			continue
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			return nil, errors.New(errors.API_INSUF_PRIV, path+": "+message(response, status))
+		case status != http.StatusOK:
+			return nil, errors.New(errors.API_RESPONSE, path+": "+message(response, status))
+		}
+		return response, nil
+	}
+}
+
+// Query - evaluate Prometheus expression at the current time, returns
+// the response with the samples at "data.result" (vector result type)
+func (c *Client) Query(expr string) (*node.Node, error) {
+	return c.Get("grid/metric-query?query=" + url.QueryEscape(expr))
+}
+
+// Version - product version of the grid, e.g. "11.5.0-20210420.1822.8f8a5d1"
+func (c *Client) Version() (string, error) {
+	response, err := c.Get("grid/config/product-version")
+	if err != nil {
+		return "", err
+	}
+	if data := response.GetChildS("data"); data != nil {
+		return data.GetChildContentS("productVersion"), nil
+	}
+	return "", nil
+}
+
+// do - send request and parse the JSON response, also if the status is not OK
+func (c *Client) do(request *http.Request) (*node.Node, int, error) {
+
+	request.Header.Set("Accept", "application/json")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, 0, errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, errors.New(errors.ERR_CONNECTION, err.Error())
+	}
+
+	root, err := tree.Load(body)
+	if err != nil {
+		if response.StatusCode != http.StatusOK {
+			return nil, response.StatusCode, nil
+		}
+		return nil, 0, errors.New(errors.API_RESPONSE, err.Error())
+	}
+	return root, response.StatusCode, nil
+}
+
+// message - error message of a response, e.g. {"status": "error", "message": {"text": "..."}}
+func message(response *node.Node, status int) string {
+	if response != nil {
+		if m := response.GetChildS("message"); m != nil {
+			if text := m.GetChildContentS("text"); text != "" {
+				return text
+			}
+		}
+	}
+	return strconv.Itoa(status) + " " + http.StatusText(status)
+}
@@ -220,6 +220,16 @@ func GetHarvestLogPath() string {
 	return logPath
 }
 
+// GetHarvestStatePath - directory where collectors can persist their state
+// between restarts (set with HARVEST_STATE)
+func GetHarvestStatePath() string {
+	var statePath string
+	if statePath = os.Getenv("HARVEST_STATE"); statePath == "" {
+		statePath = "/var/lib/harvest/"
+	}
+	return statePath
+}
+
 /*
 GetPrometheusExporterPorts returns port configured in prometheus exporter for given poller
 */
@@ -363,20 +373,35 @@ type Tools struct {
 }
 
 type Poller struct {
-	Datacenter     *string   `yaml:"datacenter,omitempty"`
-	Addr           *string   `yaml:"addr,omitempty"`
-	AuthStyle      *string   `yaml:"auth_style,omitempty"`
-	Username       *string   `yaml:"username,omitempty"`
-	Password       string    `yaml:"password,omitempty"`
-	UseInsecureTls *bool     `yaml:"use_insecure_tls,omitempty"`
-	SslCert        *string   `yaml:"ssl_cert,omitempty"`
-	SslKey         *string   `yaml:"ssl_key,omitempty"`
-	LogMaxBytes    *int64    `yaml:"log_max_bytes,omitempty"`
-	LogMaxFiles    *int      `yaml:"log_max_files,omitempty"`
-	Exporters      *[]string `yaml:"exporters,omitempty"`
-	Collectors     *[]string `yaml:"collectors,omitempty"`
-	IsKfs          *bool     `yaml:"is_kfs,omitempty"`
-	PollerSchedule *string   `yaml:"poller_schedule,omitempty"`
+	Datacenter            *string   `yaml:"datacenter,omitempty"`
+	Addr                  *string   `yaml:"addr,omitempty"`
+	AuthStyle             *string   `yaml:"auth_style,omitempty"`
+	Username              *string   `yaml:"username,omitempty"`
+	Password              string    `yaml:"password,omitempty"`
+	UseInsecureTls        *bool     `yaml:"use_insecure_tls,omitempty"`
+	SslCert               *string   `yaml:"ssl_cert,omitempty"`
+	SslKey                *string   `yaml:"ssl_key,omitempty"`
+	CaCert                *string   `yaml:"ca_cert,omitempty"`
+	TlsMinVersion         *string   `yaml:"tls_min_version,omitempty"`
+	ServerName            *string   `yaml:"server_name,omitempty"`
+	ProxyUrl              *string   `yaml:"proxy_url,omitempty"`
+	Retries               *int      `yaml:"retries,omitempty"`
+	RetryBackoff          *string   `yaml:"retry_backoff,omitempty"`
+	MaxConcurrentRequests *int      `yaml:"max_concurrent_requests,omitempty"`
+	LogMaxBytes           *int64    `yaml:"log_max_bytes,omitempty"`
+	LogMaxFiles           *int      `yaml:"log_max_files,omitempty"`
+	Exporters             *[]string `yaml:"exporters,omitempty"`
+	Collectors            *[]string `yaml:"collectors,omitempty"`
+	IsKfs                 *bool     `yaml:"is_kfs,omitempty"`
+	PollerSchedule        *string   `yaml:"poller_schedule,omitempty"`
+	SnmpVersion           *string   `yaml:"snmp_version,omitempty"`
+	SnmpCommunity         *string   `yaml:"snmp_community,omitempty"`
+	SnmpAuthProtocol      *string   `yaml:"snmp_auth_protocol,omitempty"`
+	SnmpPrivProtocol      *string   `yaml:"snmp_priv_protocol,omitempty"`
+	SnmpPrivPassword      string    `yaml:"snmp_priv_password,omitempty"`
+
+	// Zapi specific, filters (query) by object name
+	ZapiFilters map[string]map[string]string `yaml:"zapi_filters,omitempty"`
 }
 
 func (p *Poller) Union(defaults *Poller) {
@@ -404,6 +429,27 @@ func (p *Poller) Union(defaults *Poller) {
 	if p.SslKey == nil && defaults.SslKey != nil {
 		p.SslKey = defaults.SslKey
 	}
+	if p.CaCert == nil && defaults.CaCert != nil {
+		p.CaCert = defaults.CaCert
+	}
+	if p.TlsMinVersion == nil && defaults.TlsMinVersion != nil {
+		p.TlsMinVersion = defaults.TlsMinVersion
+	}
+	if p.ServerName == nil && defaults.ServerName != nil {
+		p.ServerName = defaults.ServerName
+	}
+	if p.ProxyUrl == nil && defaults.ProxyUrl != nil {
+		p.ProxyUrl = defaults.ProxyUrl
+	}
+	if p.Retries == nil && defaults.Retries != nil {
+		p.Retries = defaults.Retries
+	}
+	if p.RetryBackoff == nil && defaults.RetryBackoff != nil {
+		p.RetryBackoff = defaults.RetryBackoff
+	}
+	if p.MaxConcurrentRequests == nil && defaults.MaxConcurrentRequests != nil {
+		p.MaxConcurrentRequests = defaults.MaxConcurrentRequests
+	}
 	if p.LogMaxBytes == nil && defaults.LogMaxBytes != nil {
 		p.LogMaxBytes = defaults.LogMaxBytes
 	}
@@ -422,6 +468,24 @@ func (p *Poller) Union(defaults *Poller) {
 	if p.PollerSchedule == nil && defaults.PollerSchedule != nil {
 		p.PollerSchedule = defaults.PollerSchedule
 	}
+	if p.SnmpVersion == nil && defaults.SnmpVersion != nil {
+		p.SnmpVersion = defaults.SnmpVersion
+	}
+	if p.SnmpCommunity == nil && defaults.SnmpCommunity != nil {
+		p.SnmpCommunity = defaults.SnmpCommunity
+	}
+	if p.SnmpAuthProtocol == nil && defaults.SnmpAuthProtocol != nil {
+		p.SnmpAuthProtocol = defaults.SnmpAuthProtocol
+	}
+	if p.SnmpPrivProtocol == nil && defaults.SnmpPrivProtocol != nil {
+		p.SnmpPrivProtocol = defaults.SnmpPrivProtocol
+	}
+	if p.SnmpPrivPassword == "" && defaults.SnmpPrivPassword != "" {
+		p.SnmpPrivPassword = defaults.SnmpPrivPassword
+	}
+	if p.ZapiFilters == nil && defaults.ZapiFilters != nil {
+		p.ZapiFilters = defaults.ZapiFilters
+	}
 }
 
 type Exporter struct {
@@ -22,6 +22,7 @@ const (
 	MATRIX_PARSE_STR = "parse numeric value from string"
 	API_RESPONSE     = "error reading api response"
 	API_REQ_REJECTED = "api request rejected"
+	API_AUTH         = "api authentication failed"
 	// @TODO, implement: API response is something like
 	// Insufficient privileges: user 'harvest2-user' does not have write access to this resource
 	API_INSUF_PRIV   = "api insufficient priviliges"
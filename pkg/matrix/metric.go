@@ -35,6 +35,8 @@ type Metric interface {
 	SetProperty(string)
 	GetComment() string
 	SetComment(string)
+	GetUnit() string
+	SetUnit(string)
 	Clone(bool) Metric
 	// methods for resizing metric storage
 	Reset(int)
@@ -92,6 +94,7 @@ type AbstractMetric struct {
 	dtype      string
 	property   string
 	comment    string
+	unit       string
 	exportable bool
 	labels     *dict.Dict
 	record     []bool
@@ -103,6 +106,7 @@ func (me *AbstractMetric) Clone(deep bool) *AbstractMetric {
 		dtype:      me.dtype,
 		property:   me.property,
 		comment:    me.comment,
+		unit:       me.unit,
 		exportable: me.exportable,
 	}
 	if me.labels != nil {
@@ -155,6 +159,15 @@ func (me *AbstractMetric) SetComment(c string) {
 	me.comment = c
 }
 
+// GetUnit - unit of metric values, e.g. "kb_per_sec" or "microsec", empty if unknown
+func (me *AbstractMetric) GetUnit() string {
+	return me.unit
+}
+
+func (me *AbstractMetric) SetUnit(u string) {
+	me.unit = u
+}
+
 func (me *AbstractMetric) SetLabel(key, value string) {
 	if me.labels == nil {
 		me.labels = dict.New()
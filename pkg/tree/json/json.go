@@ -1,12 +1,24 @@
 /*
  * Copyright NetApp Inc, 2021 All rights reserved
  */
+
+// Package json converts JSON documents into a tree of nodes and back, so that
+// the node helpers (e.g. SearchChildren, SearchContent) work the same way on
+// JSON as on ZAPI responses.
+//
+// Object members become children named after their key, scalar values become
+// the content of the node. Like repeated XML elements, each element of an
+// array becomes a separate child named after the key of the array, i.e.
+// {"records":[{"id":1},{"id":2}]} is the same as <records><id>1</id></records>
+// <records><id>2</id></records>. Elements of a top-level array have no name.
 package json
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"goharvest2/pkg/tree/node"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -24,54 +36,97 @@ var (
 )
 
 func Load(data []byte) (*node.Node, error) {
+
 	root := node.New([]byte(""))
-	//data = bytes.ReplaceAll(data, []byte("\n"), EMPTY)
-	//data = bytes.ReplaceAll(data, []byte(`": `), []byte(`":`))
-	return root, parse(root, data)
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // keep numbers as they are, e.g. large integers
+
+	token, err := dec.Token()
+	if err != nil {
+		return root, errors.New("invalid json: " + err.Error())
+	}
+
+	if err = parse(dec, root, token); err != nil {
+		return root, err
+	}
+
+	if _, err = dec.Token(); err != io.EOF {
+		return root, errors.New("invalid json: unexpected data after top-level value")
+	}
+	return root, nil
 }
 
 func Dump(n *node.Node) []byte {
 	return dump(n)
 }
 
-func parse(n *node.Node, x []byte) error {
+// parse - parse the value that starts with token into n
+func parse(dec *json.Decoder, n *node.Node, token json.Token) error {
 
-	if bytes.HasPrefix(x, SQUARE_OPEN) && bytes.HasSuffix(x, SQUARE_CLOSE) {
-		// parse as children
-		x = bytes.TrimPrefix(x, SQUARE_OPEN)
-		x = bytes.TrimSuffix(x, SQUARE_CLOSE)
-		if elements := bytes.Split(x, []byte(`],[`)); len(elements) > 1 {
-			for _, e := range elements {
-				child := n.NewChildS("", "")
-				parse(child, e)
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return errors.New("invalid json: " + err.Error())
+				}
+				name, ok := key.(string)
+				if !ok {
+					return errors.New("invalid json: object key is not a string")
+				}
+				value, err := dec.Token()
+				if err != nil {
+					return errors.New("invalid json: " + err.Error())
+				}
+				if delim, ok := value.(json.Delim); ok && delim == '[' {
+					err = parseArray(dec, n, name)
+				} else {
+					err = parse(dec, n.NewChildS(name, ""), value)
+				}
+				if err != nil {
+					return err
+				}
 			}
-		} else {
-			return parse(n, elements[0])
+		case '[':
+			// array in array or top-level array
+			return parseArray(dec, n, n.GetNameS())
+		default:
+			return errors.New("invalid json: unexpected " + t.String())
 		}
-	} else if bytes.HasPrefix(x, CURLY_OPEN) && bytes.HasSuffix(x, CURLY_CLOSE) {
-		x = bytes.TrimPrefix(x, CURLY_OPEN)
-		x = bytes.TrimSuffix(x, CURLY_CLOSE)
-		if elements := bytes.Split(x, []byte(`},{`)); len(elements) > 1 {
-			for _, e := range elements {
-				child := n.NewChildS("", "")
-				parse(child, e)
-			}
-		} else {
-			return parse(n, elements[0])
-		}
-	} else if pairs := bytes.Split(x, []byte(`,"`)); len(pairs) > 1 {
-		for _, p := range pairs {
-
-			if values := bytes.Split(p, []byte(`":`)); len(values) == 2 {
-				key := bytes.TrimPrefix(values[0], QUOTE)
-				value := bytes.TrimPrefix(values[1], QUOTE)
-				value = bytes.TrimSuffix(value, QUOTE)
-				n.NewChild(bytes.TrimSpace(key), bytes.TrimSpace(value))
-			}
+		// closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return errors.New("invalid json: " + err.Error())
 		}
+	case string:
+		n.SetContentS(t)
+	case json.Number:
+		n.SetContentS(t.String())
+	case bool:
+		n.SetContentS(strconv.FormatBool(t))
+	case nil:
+		// null, node has no content
+	}
+	return nil
+}
 
-	} else {
-		return errors.New("invalid element: " + string(x))
+// parseArray - add each element of an array as a child with name to parent,
+// the opening bracket is already consumed
+func parseArray(dec *json.Decoder, parent *node.Node, name string) error {
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return errors.New("invalid json: " + err.Error())
+		}
+		if err = parse(dec, parent.NewChildS(name, ""), token); err != nil {
+			return err
+		}
+	}
+	// closing bracket
+	if _, err := dec.Token(); err != nil {
+		return errors.New("invalid json: " + err.Error())
 	}
 	return nil
 }
@@ -79,31 +134,81 @@ func parse(n *node.Node, x []byte) error {
 func dump(n *node.Node) []byte {
 
 	var key []byte
-	var child_values [][]byte
-	var value []byte
-	var err error
 
 	if len(n.GetName()) != 0 {
-		key = bytes.Join([][]byte{QUOTE, n.GetName(), QUOTE, COLON}, EMPTY)
+		key = bytes.Join([][]byte{quote(n.GetNameS()), COLON}, EMPTY)
 	}
+	return bytes.Join([][]byte{key, dumpValue(n)}, EMPTY)
+}
+
+// dumpValue - value of n without its key, children with the same name are
+// dumped as an array
+func dumpValue(n *node.Node) []byte {
+
+	var err error
 
 	if len(n.GetContent()) != 0 {
 		s := n.GetContentS()
 		if s == "true" || s == "false" || s == "null" {
-			value = n.GetContent()
+			return n.GetContent()
 		} else if _, err = strconv.ParseFloat(s, 64); err == nil {
-			value = n.GetContent()
+			return n.GetContent()
 		} else if strings.HasPrefix(s, `{`) {
-			value = n.GetContent()
-		} else {
-			value = bytes.Join([][]byte{QUOTE, n.GetContent(), QUOTE}, EMPTY)
+			return n.GetContent()
+		}
+		return quote(s)
+	}
+
+	children := n.GetChildren()
+	values := make([][]byte, 0, len(children))
+
+	// elements of a top-level array or of an array in array
+	if len(children) != 0 && allUnnamed(children) {
+		for _, ch := range children {
+			values = append(values, dumpValue(ch))
 		}
-		return bytes.Join([][]byte{key, value}, EMPTY)
+		return bytes.Join([][]byte{SQUARE_OPEN, bytes.Join(values, COMMA), SQUARE_CLOSE}, EMPTY)
 	}
 
-	for _, ch := range n.GetChildren() {
-		child_values = append(child_values, dump(ch))
+	// group children by name, keeping the order of first appearance
+	var names []string
+	groups := make(map[string][]*node.Node)
+	for _, ch := range children {
+		name := ch.GetNameS()
+		if _, has := groups[name]; !has {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], ch)
 	}
-	return bytes.Join([][]byte{key, CURLY_OPEN, bytes.Join(child_values, COMMA), CURLY_CLOSE}, EMPTY)
 
+	for _, name := range names {
+		group := groups[name]
+		if len(group) == 1 {
+			values = append(values, dump(group[0]))
+			continue
+		}
+		elements := make([][]byte, 0, len(group))
+		for _, ch := range group {
+			elements = append(elements, dumpValue(ch))
+		}
+		values = append(values, bytes.Join([][]byte{quote(name), COLON, SQUARE_OPEN, bytes.Join(elements, COMMA), SQUARE_CLOSE}, EMPTY))
+	}
+	return bytes.Join([][]byte{CURLY_OPEN, bytes.Join(values, COMMA), CURLY_CLOSE}, EMPTY)
+}
+
+func allUnnamed(nodes []*node.Node) bool {
+	for _, n := range nodes {
+		if len(n.GetName()) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// quote - JSON string with escaped special characters
+func quote(s string) []byte {
+	if b, err := json.Marshal(s); err == nil {
+		return b
+	}
+	return bytes.Join([][]byte{QUOTE, []byte(s), QUOTE}, EMPTY)
 }
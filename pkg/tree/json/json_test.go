@@ -5,6 +5,7 @@ package json
 
 import (
 	"goharvest2/pkg/tree/node"
+	"strings"
 	"testing"
 )
 
@@ -56,3 +57,91 @@ func TestDump(t *testing.T) {
 		t.Errorf("%-10s [%s]", "got:", string(dump))
 	}
 }
+
+func TestLoadNested(t *testing.T) {
+
+	data := []byte(`{
+		"data": [
+			{"id": "a1", "name": "vol \"1\"", "space": {"used": 1024, "total": 4096}, "tags": ["x", "y"], "online": true},
+			{"id": "a2", "name": "vol2", "space": {"used": 10, "total": 20}, "tags": [], "online": false, "owner": null}
+		],
+		"meta": {"next": "/api/v1/volumes?cursor=abc", "count": 2}
+	}`)
+
+	root, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := root.SearchChildren([]string{"data"})
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	keyPaths := [][]string{{"data", "id"}, {"data", "space", "used"}}
+
+	tests := []struct {
+		record *node.Node
+		keys   []string
+		name   string
+		online string
+	}{
+		{records[0], []string{"a1", "1024"}, `vol "1"`, "true"},
+		{records[1], []string{"a2", "10"}, "vol2", "false"},
+	}
+
+	for _, tt := range tests {
+		keys, found := tt.record.SearchContent([]string{"data"}, keyPaths)
+		if !found || strings.Join(keys, ",") != strings.Join(tt.keys, ",") {
+			t.Errorf("keys = %v (%v), want %v", keys, found, tt.keys)
+		}
+		if name := tt.record.GetChildContentS("name"); name != tt.name {
+			t.Errorf("name = %q, want %q", name, tt.name)
+		}
+		if online := tt.record.GetChildContentS("online"); online != tt.online {
+			t.Errorf("online = %q, want %q", online, tt.online)
+		}
+	}
+
+	if tags := records[0].SearchChildren([]string{"data", "tags"}); len(tags) != 2 {
+		t.Errorf("got %d tags, want 2", len(tags))
+	}
+
+	if next, _ := root.SearchContent([]string{"meta"}, [][]string{{"meta", "next"}}); len(next) != 1 || next[0] != "/api/v1/volumes?cursor=abc" {
+		t.Errorf("next = %v", next)
+	}
+
+	// top-level array
+	if root, err = Load([]byte(`[{"id":1},{"id":2},{"id":3}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(root.GetChildren()) != 3 || root.GetChildren()[2].GetChildContentS("id") != "3" {
+		t.Errorf("top-level array not parsed")
+		root.Print(0)
+	}
+
+	for _, invalid := range []string{`{"id":1`, `{"id":1}}`, `[1,2`, ``} {
+		if _, err = Load([]byte(invalid)); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestDumpArrays(t *testing.T) {
+
+	tests := []string{
+		`{"data":[{"id":"a1","used":1024},{"id":"a2","used":10}],"count":2}`,
+		`[{"id":1},{"id":2}]`,
+		`{"name":"say \"hi\""}`,
+	}
+
+	for _, data := range tests {
+		root, err := Load([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dump := string(Dump(root)); dump != data {
+			t.Errorf("got %s, want %s", dump, data)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"goharvest2/pkg/tree/node"
 	"goharvest2/pkg/tree/xml"
 	"goharvest2/pkg/tree/yaml"
+	"io"
 	"io/ioutil"
 )
 
@@ -73,3 +74,9 @@ func LoadXml(data []byte) (*node.Node, error) {
 func DumpXml(n *node.Node) ([]byte, error) {
 	return xml.Dump(n)
 }
+
+// StreamXml decodes XML read from r and passes children of the element at path
+// to fn, see xml.Stream
+func StreamXml(r io.Reader, path []string, fn func(*node.Node) error) (*node.Node, error) {
+	return xml.Stream(r, path, fn)
+}
@@ -6,6 +6,7 @@ package xml
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
 	"goharvest2/pkg/tree/node"
 	"io"
 )
@@ -29,3 +30,85 @@ func LoadFromReader(r io.Reader) (*node.Node, error) {
 func Dump(n *node.Node) ([]byte, error) {
 	return xml.Marshal(&n)
 }
+
+// Stream decodes the XML document read from r token by token. Children of the
+// element at path (names of elements, starting with the root) are passed to fn
+// once they are complete and are not added to the returned tree, so only one of
+// them is held in memory at a time. Unlike Load, only elements without children
+// keep their content, which is unescaped. Decoding stops if fn returns an error.
+func Stream(r io.Reader, path []string, fn func(*node.Node) error) (*node.Node, error) {
+
+	type frame struct {
+		n        *node.Node
+		isParent bool
+	}
+
+	var (
+		root    *node.Node
+		stack   []frame
+		matched int // number of elements in stack that match path
+	)
+
+	dec := xml.NewDecoder(r)
+
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+
+		case xml.StartElement:
+			n := &node.Node{XMLName: t.Name}
+			if len(t.Attr) != 0 {
+				n.Attrs = t.Attr
+			}
+			depth := len(stack)
+			if depth == 0 {
+				root = n
+			} else {
+				parent := &stack[depth-1]
+				parent.isParent = true
+				parent.n.Content = nil
+				// elements passed to fn are not added to their parent
+				if matched != len(path) || depth != len(path) {
+					parent.n.AddChild(n)
+				}
+			}
+			if matched == depth && depth < len(path) && t.Name.Local == path[depth] {
+				matched++
+			}
+			stack = append(stack, frame{n: n})
+
+		case xml.EndElement:
+			depth := len(stack) - 1
+			if depth < 0 {
+				return nil, errors.New("unexpected end element " + t.Name.Local)
+			}
+			n := stack[depth].n
+			stack = stack[:depth]
+			if matched > depth {
+				matched = depth
+			}
+			if matched == len(path) && depth == len(path) {
+				if err = fn(n); err != nil {
+					return nil, err
+				}
+			}
+
+		case xml.CharData:
+			// content of parents is only whitespace in ZAPI responses
+			if depth := len(stack) - 1; depth >= 0 && !stack[depth].isParent {
+				stack[depth].n.Content = append(stack[depth].n.Content, t...)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("empty document")
+	}
+	return root, nil
+}